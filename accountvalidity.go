@@ -0,0 +1,180 @@
+package ldapserver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// AccountValidity refuses the binds of accounts outside of their validity
+// window, as told by attributes of their entry: shadowExpire, and optional
+// attributes holding the GeneralizedTime the account becomes valid and
+// stops being valid.
+type AccountValidity struct {
+	// Lookup returns the entry of the account dn, with at least the
+	// validity attributes. A nil entry lets the bind through.
+	Lookup func(dn string) (*Entry, error)
+
+	// ShadowExpire enforces the shadowExpire attribute, the number of days
+	// since 1970-01-01 after which the account is disabled
+	ShadowExpire bool
+
+	// NotBeforeAttribute and NotAfterAttribute, if set, are the names of
+	// the attributes holding the validity window
+	NotBeforeAttribute string
+	NotAfterAttribute  string
+
+	// Now returns the current time, time.Now if nil
+	Now func() time.Time
+}
+
+// Check returns a *PasswordPolicyViolation with the AccountLocked error
+// when the account entry e is outside of its validity window
+func (v *AccountValidity) Check(e *Entry) error {
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+
+	if v.ShadowExpire {
+		if value := entryValue(e, "shadowExpire"); value != "" {
+			days, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid shadowExpire value %q", value)
+			}
+			if days >= 0 && !now.Before(time.Unix(days*86400, 0)) {
+				return &PasswordPolicyViolation{AccountLocked, "account has expired"}
+			}
+		}
+	}
+	if v.NotBeforeAttribute != "" {
+		if value := entryValue(e, v.NotBeforeAttribute); value != "" {
+			t, err := ParseGeneralizedTime(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s value %q: %s", v.NotBeforeAttribute, value, err)
+			}
+			if now.Before(t) {
+				return &PasswordPolicyViolation{AccountLocked, "account is not valid yet"}
+			}
+		}
+	}
+	if v.NotAfterAttribute != "" {
+		if value := entryValue(e, v.NotAfterAttribute); value != "" {
+			t, err := ParseGeneralizedTime(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s value %q: %s", v.NotAfterAttribute, value, err)
+			}
+			if !now.Before(t) {
+				return &PasswordPolicyViolation{AccountLocked, "account has expired"}
+			}
+		}
+	}
+	return nil
+}
+
+// Middleware returns a middleware checking the validity of the accounts
+// binding with a simple bind before the bind handler runs. Refused binds
+// are answered with invalidCredentials, and the AccountLocked password
+// policy error when the client sent the password policy request control.
+// Lookup errors and malformed validity attributes are logged and answered
+// with operationsError, without diagnostic message.
+func (v *AccountValidity) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w ResponseWriter, m *Message) {
+			r, ok := m.ProtocolOp().(ldap.BindRequest)
+			if !ok || string(r.Name()) == "" {
+				next(w, m)
+				return
+			}
+
+			e, err := v.Lookup(string(r.Name()))
+			if err == nil && e != nil {
+				err = v.Check(e)
+			}
+			if err == nil {
+				next(w, m)
+				return
+			}
+
+			// the reason is not disclosed to the client, which is not
+			// authenticated yet
+			var violation *PasswordPolicyViolation
+			if !errors.As(err, &violation) {
+				m.logAt(LogLevelError, "validity of %s not checked: %s", r.Name(), err)
+				w.Write(NewResponseForRequest(m.ProtocolOp(), LDAPResultOperationsError, ""))
+				return
+			}
+			m.logAt(LogLevelInfo, "bind of %s refused: %s", r.Name(), violation.DiagnosticMessage)
+			WritePasswordPolicyFailure(w, m, &PasswordPolicyViolation{violation.Code, ""})
+		}
+	}
+}
+
+// entryValue returns the first value of the attribute name of e
+func entryValue(e *Entry, name string) string {
+	for _, a := range e.Attributes {
+		if strings.EqualFold(a.Name, name) && len(a.Values) > 0 {
+			return string(a.Values[0])
+		}
+	}
+	return ""
+}
+
+// ParseGeneralizedTime parses a GeneralizedTime value (RFC 4517 section
+// 3.3.13), such as "20240131235959Z" or "202401312359.5+0100"
+func ParseGeneralizedTime(s string) (time.Time, error) {
+	// split the time zone from the date and time
+	i := strings.IndexAny(s, "Z+-")
+	if i < 0 {
+		return time.Time{}, errors.New("missing time zone")
+	}
+	value, zone := s[:i], s[i:]
+
+	loc := time.UTC
+	if zone != "Z" {
+		if len(zone) == 3 {
+			zone += "00"
+		}
+		hhmm, err := strconv.Atoi(zone[1:])
+		if err != nil || len(zone) != 5 || hhmm < 0 || hhmm/100 > 23 || hhmm%100 > 59 {
+			return time.Time{}, errors.New("invalid time zone")
+		}
+		offset := hhmm/100*3600 + hhmm%100*60
+		if zone[0] == '-' {
+			offset = -offset
+		}
+		loc = time.FixedZone("", offset)
+	}
+
+	// the fraction applies to the last element present
+	fraction := 0.0
+	if i := strings.IndexAny(value, ".,"); i >= 0 {
+		f, err := strconv.ParseFloat("0."+value[i+1:], 64)
+		if err != nil || i+1 == len(value) {
+			return time.Time{}, errors.New("invalid fraction")
+		}
+		fraction, value = f, value[:i]
+	}
+
+	var layout string
+	var unit time.Duration
+	switch len(value) {
+	case 10:
+		layout, unit = "2006010215", time.Hour
+	case 12:
+		layout, unit = "200601021504", time.Minute
+	case 14:
+		layout, unit = "20060102150405", time.Second
+	default:
+		return time.Time{}, errors.New("invalid date and time")
+	}
+	t, err := time.ParseInLocation(layout, value, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.Add(time.Duration(fraction * float64(unit))), nil
+}
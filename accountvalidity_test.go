@@ -0,0 +1,124 @@
+package ldapserver
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// testBindRequest returns a simple bind request of dn
+func testBindRequest(dn string, password string) []byte {
+	return berConstructedTLV(berClassApplication|berConstructed|ApplicationBindRequest,
+		berInteger(berTagInteger, 3),
+		berOctetString(berTagOctetString, []byte(dn)),
+		berOctetString(berClassContext|0, []byte(password)),
+	)
+}
+
+// diagnosticMessageOf returns the diagnostic message of the response m
+func diagnosticMessageOf(t *testing.T, m *ldap.LDAPMessage) string {
+	t.Helper()
+	data, err := m.Write()
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, _, err := berRead(data.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	children, err := berChildren(message.data)
+	if err != nil || len(children) < 2 {
+		t.Fatalf("invalid response: %v", err)
+	}
+	result, err := berChildren(children[1].data)
+	if err != nil || len(result) < 3 {
+		t.Fatalf("invalid result: %v", err)
+	}
+	return string(result[2].data)
+}
+
+func TestAccountValidityCheck(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	v := &AccountValidity{
+		ShadowExpire:       true,
+		NotBeforeAttribute: "validFrom",
+		NotAfterAttribute:  "validUntil",
+		Now:                func() time.Time { return now },
+	}
+	today := now.Unix() / 86400
+
+	tests := []struct {
+		name   string
+		entry  *Entry
+		locked bool
+		err    bool
+	}{
+		{"no attribute", NewEntry("uid=alice"), false, false},
+		{"shadowExpire later", NewEntry("uid=alice").AddInt("shadowExpire", today+1), false, false},
+		{"shadowExpire reached", NewEntry("uid=alice").AddInt("shadowExpire", today), true, false},
+		{"shadowExpire disabled", NewEntry("uid=alice").AddInt("shadowExpire", -1), false, false},
+		{"shadowExpire malformed", NewEntry("uid=alice").Add("shadowExpire", "soon"), false, true},
+		{"not valid yet", NewEntry("uid=alice").Add("validFrom", "20240601130000Z"), true, false},
+		{"valid", NewEntry("uid=alice").Add("validFrom", "2024060111Z").Add("validUntil", "202406011201Z"), false, false},
+		{"expired", NewEntry("uid=alice").Add("validUntil", "20240601140000+0200"), true, false},
+		{"time malformed", NewEntry("uid=alice").Add("validUntil", "tomorrow"), false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := v.Check(tt.entry)
+			var violation *PasswordPolicyViolation
+			locked := errors.As(err, &violation) && violation.Code == AccountLocked
+			if locked != tt.locked || (err != nil && !locked) != tt.err {
+				t.Errorf("Check() = %v, want locked %t, error %t", err, tt.locked, tt.err)
+			}
+		})
+	}
+}
+
+func TestAccountValidityMiddleware(t *testing.T) {
+	entries := map[string]*Entry{
+		"uid=valid":     NewEntry("uid=valid").AddInt("shadowExpire", 1<<20),
+		"uid=expired":   NewEntry("uid=expired").AddInt("shadowExpire", 1),
+		"uid=malformed": NewEntry("uid=malformed").Add("shadowExpire", "soon"),
+	}
+	v := &AccountValidity{
+		ShadowExpire: true,
+		Lookup: func(dn string) (*Entry, error) {
+			if dn == "uid=broken" {
+				return nil, errors.New("backend at 10.0.0.1 unreachable")
+			}
+			return entries[dn], nil
+		},
+	}
+	handler := v.Middleware()(func(w ResponseWriter, m *Message) {
+		w.Write(NewResponseForRequest(m.ProtocolOp(), LDAPResultSuccess, ""))
+	})
+
+	tests := []struct {
+		dn   string
+		code int
+	}{
+		{"", LDAPResultSuccess},
+		{"uid=unknown", LDAPResultSuccess},
+		{"uid=valid", LDAPResultSuccess},
+		{"uid=expired", LDAPResultInvalidCredentials},
+		{"uid=malformed", LDAPResultOperationsError},
+		{"uid=broken", LDAPResultOperationsError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dn, func(t *testing.T) {
+			w := NewResponseRecorder()
+			handler(w, testMessage(t, testBindRequest(tt.dn, "secret")))
+			if code := w.ResultCode(); code != tt.code {
+				t.Errorf("result code %d, want %d", code, tt.code)
+			}
+			// the refusal reasons are not sent to the client
+			messages := w.Messages()
+			if diagnostic := diagnosticMessageOf(t, messages[len(messages)-1]); diagnostic != "" {
+				t.Errorf("diagnostic message %q, want none", diagnostic)
+			}
+		})
+	}
+}
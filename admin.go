@@ -0,0 +1,164 @@
+package ldapserver
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// Administrative extended operations requestName, they live under the
+// arc of the package and are only answered when routed with RouteMux.Admin
+const (
+	AdminStats       ldap.LDAPOID = projectArc + ".1.1" // responseValue is the JSON encoded server Stats
+	AdminSetLogLevel ldap.LDAPOID = projectArc + ".1.2" // requestValue is the log level name (debug, info, warn, error, off)
+	AdminExportLDIF  ldap.LDAPOID = projectArc + ".1.3" // requestValue is the name of the file written in AdminOptions.ExportDir
+	AdminDrain       ldap.LDAPOID = projectArc + ".1.4" // the server stops gracefully after responding
+	AdminJournal     ldap.LDAPOID = projectArc + ".1.5" // responseValue is the JSON encoded server Journals
+)
+
+// AdminOptions configures the administrative extended operations
+type AdminOptions struct {
+	// Authorize reports whether the client sending m may run administrative
+	// operations. All operations are refused when nil.
+	Authorize func(m *Message) bool
+
+	// ExportLDIF, if non-nil, exports the directory content as LDIF to
+	// path, the file of ExportDir named by the request value. Names of
+	// files outside ExportDir are refused, and the export is not available
+	// without ExportDir.
+	ExportLDIF func(path string) error
+	ExportDir  string
+}
+
+// Admin routes the administrative extended operations. As routes are
+// matched in order, it must be called before registering a generic
+// Extended route.
+func (h *RouteMux) Admin(opts AdminOptions) {
+	admin := func(handler func(w ResponseWriter, m *Message)) HandlerFunc {
+		return func(w ResponseWriter, m *Message) {
+			if opts.Authorize == nil || !opts.Authorize(m) {
+				res := NewExtendedResponse(LDAPResultInsufficientAccessRights)
+				res.SetDiagnosticMessage("administrative operations are not allowed")
+				w.Write(res)
+				return
+			}
+			handler(w, m)
+		}
+	}
+
+	h.Extended(admin(handleAdminStats)).RequestName(AdminStats).Label("Admin - Stats")
+	h.Extended(admin(handleAdminSetLogLevel)).RequestName(AdminSetLogLevel).Label("Admin - SetLogLevel")
+	h.Extended(admin(func(w ResponseWriter, m *Message) {
+		handleAdminExportLDIF(w, m, opts.ExportLDIF, opts.ExportDir)
+	})).RequestName(AdminExportLDIF).Label("Admin - ExportLDIF")
+	h.Extended(admin(handleAdminDrain)).RequestName(AdminDrain).Label("Admin - Drain")
+	h.Extended(admin(handleAdminJournal)).RequestName(AdminJournal).Label("Admin - Journal")
+}
+
+func handleAdminStats(w ResponseWriter, m *Message) {
+	value, err := json.Marshal(m.Client.srv.Stats())
+	if err != nil {
+		res := NewExtendedResponse(LDAPResultOperationsError)
+		res.SetDiagnosticMessage(err.Error())
+		w.Write(res)
+		return
+	}
+
+	res := NewExtendedValueResponse(LDAPResultSuccess, value)
+	res.ResponseName = AdminStats
+	WriteRaw(w, res.Bytes())
+}
+
+func handleAdminJournal(w ResponseWriter, m *Message) {
+	value, err := json.Marshal(m.Client.srv.Journals())
+	if err != nil {
+		res := NewExtendedResponse(LDAPResultOperationsError)
+		res.SetDiagnosticMessage(err.Error())
+		w.Write(res)
+		return
+	}
+
+	res := NewExtendedValueResponse(LDAPResultSuccess, value)
+	res.ResponseName = AdminJournal
+	WriteRaw(w, res.Bytes())
+}
+
+func handleAdminSetLogLevel(w ResponseWriter, m *Message) {
+	r := m.GetExtendedRequest()
+
+	var level LogLevel
+	if r.RequestValue() == nil {
+		res := NewExtendedResponse(LDAPResultProtocolError)
+		res.SetDiagnosticMessage("missing log level")
+		w.Write(res)
+		return
+	}
+	if err := level.UnmarshalText([]byte(*r.RequestValue())); err != nil {
+		res := NewExtendedResponse(LDAPResultProtocolError)
+		res.SetDiagnosticMessage(err.Error())
+		w.Write(res)
+		return
+	}
+
+	m.logAt(LogLevelWarn, "log level set to %s", level)
+	m.Client.srv.Settings().Update(func(l *Limits) {
+		l.LogLevel = level
+	})
+	w.Write(NewExtendedResponse(LDAPResultSuccess))
+}
+
+func handleAdminExportLDIF(w ResponseWriter, m *Message, export func(path string) error, dir string) {
+	if export == nil || dir == "" {
+		res := NewExtendedResponse(LDAPResultUnwillingToPerform)
+		res.SetDiagnosticMessage("LDIF export is not available")
+		w.Write(res)
+		return
+	}
+
+	r := m.GetExtendedRequest()
+	var name string
+	if r.RequestValue() != nil {
+		name = string(*r.RequestValue())
+	}
+	path, err := exportPath(dir, name)
+	if err != nil {
+		res := NewExtendedResponse(LDAPResultUnwillingToPerform)
+		res.SetDiagnosticMessage(err.Error())
+		w.Write(res)
+		return
+	}
+
+	m.logAt(LogLevelInfo, "LDIF export to %q requested", path)
+	if err := export(path); err != nil {
+		res := NewExtendedResponse(LDAPResultOperationsError)
+		res.SetDiagnosticMessage("LDIF export failed: " + err.Error())
+		w.Write(res)
+		return
+	}
+	w.Write(NewExtendedResponse(LDAPResultSuccess))
+}
+
+// exportPath returns the path of the export file name of dir, name must
+// be a plain file name
+func exportPath(dir string, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || name != filepath.Base(name) || filepath.IsAbs(name) {
+		return "", errors.New("the LDIF export destination must be a file name")
+	}
+	return filepath.Join(dir, name), nil
+}
+
+func handleAdminDrain(w ResponseWriter, m *Message) {
+	srv := m.Client.srv
+	if !atomic.CompareAndSwapInt32(&srv.drainRequested, 0, 1) {
+		res := NewExtendedResponse(LDAPResultUnwillingToPerform)
+		res.SetDiagnosticMessage("a drain is already in progress")
+		w.Write(res)
+		return
+	}
+	m.logAt(LogLevelInfo, "graceful drain requested")
+	w.Write(NewExtendedResponse(LDAPResultSuccess))
+	go srv.Stop()
+}
@@ -0,0 +1,35 @@
+package ldapserver
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestExportPath(t *testing.T) {
+	dir := filepath.Join("var", "exports")
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "backup.ldif", want: filepath.Join(dir, "backup.ldif")},
+		{name: "", wantErr: true},
+		{name: ".", wantErr: true},
+		{name: "..", wantErr: true},
+		{name: "../backup.ldif", wantErr: true},
+		{name: "sub/backup.ldif", wantErr: true},
+		{name: "/etc/passwd", wantErr: true},
+	}
+	for _, tt := range tests {
+		path, err := exportPath(dir, tt.name)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("exportPath(%q) = %q, want an error", tt.name, path)
+			}
+			continue
+		}
+		if err != nil || path != tt.want {
+			t.Errorf("exportPath(%q) = %q, %v, want %q", tt.name, path, err, tt.want)
+		}
+	}
+}
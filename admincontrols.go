@@ -0,0 +1,95 @@
+package ldapserver
+
+import (
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// RelaxRules reports whether the write request m carries the Relax Rules
+// control: the backend may relax the constraints of its data model, to
+// set operational attributes when restoring entries for instance. The
+// server refuses it unless the Handler lists it in SupportedControls.
+func (m *Message) RelaxRules() bool {
+	if !isWriteRequest(m.ProtocolOp()) {
+		return false
+	}
+	_, ok := requestControl(m, ControlRelaxRules)
+	return ok
+}
+
+// NoOp reports whether the write request m carries the No-Op control: the
+// backend validates the change fully but does not apply it, and answers
+// with WriteNoOp when it would succeed. The server refuses it unless the
+// Handler lists it in SupportedControls.
+func (m *Message) NoOp() bool {
+	if !isWriteRequest(m.ProtocolOp()) {
+		return false
+	}
+	_, ok := requestControl(m, ControlNoOp)
+	return ok
+}
+
+// WriteNoOp answers the No-Op write request m which would have succeeded
+// with the noOperation result code
+func WriteNoOp(w ResponseWriter, m *Message) {
+	var tag byte
+	switch m.ProtocolOp().(type) {
+	case ldap.AddRequest:
+		tag = ApplicationAddResponse
+	case ldap.DelRequest:
+		tag = ApplicationDelResponse
+	case ldap.ModifyRequest:
+		tag = ApplicationModifyResponse
+	case ldap.ModifyDNRequest:
+		tag = ApplicationModifyDNResponse
+	default:
+		tag = ApplicationExtendedResponse
+	}
+	// goldap does not know the noOperation result code, the response is
+	// encoded here
+	WriteRaw(w, berConstructedTLV(berClassApplication|berConstructed|tag,
+		encodeLDAPResult(LDAPResultNoOperation, "", "the operation would have succeeded")...))
+}
+
+// controlLister is implemented by the handlers reporting the request
+// controls they honor, such as RouteMux. The Relax Rules and No-Op
+// controls are only accepted for the handlers listing them.
+type controlLister interface {
+	SupportedControls() []ldap.LDAPOID
+}
+
+// supportsControl reports whether handler lists the control oid
+func supportsControl(handler Handler, oid ldap.LDAPOID) bool {
+	lister, ok := handler.(controlLister)
+	if !ok {
+		return false
+	}
+	for _, supported := range lister.SupportedControls() {
+		if supported == oid {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAdminControls returns the response to a request with an invalid
+// Relax Rules or No-Op control: they must be critical, have no value, only
+// apply to write requests, and be supported by handler, or the write would
+// be applied as a regular one (RFC 4511 section 4.1.11)
+func checkAdminControls(handler Handler, m *Message) ldap.ProtocolOp {
+	for _, oid := range []ldap.LDAPOID{ControlRelaxRules, ControlNoOp} {
+		c, ok := requestControl(m, oid)
+		if !ok {
+			continue
+		}
+		if !c.Criticality() || c.ControlValue() != nil {
+			return NewResponseForRequest(m.ProtocolOp(), LDAPResultProtocolError, "control "+string(oid)+" must be critical and have no value")
+		}
+		if !isWriteRequest(m.ProtocolOp()) {
+			return NewResponseForRequest(m.ProtocolOp(), LDAPResultUnavailableCriticalExtension, "control "+string(oid)+" only applies to write operations")
+		}
+		if !supportsControl(handler, oid) {
+			return NewResponseForRequest(m.ProtocolOp(), LDAPResultUnavailableCriticalExtension, "control "+string(oid)+" is not supported")
+		}
+	}
+	return nil
+}
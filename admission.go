@@ -0,0 +1,108 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// Admission decides whether a new connection is served, by looking up the
+// reputation of its address in an external service for instance. A non-nil
+// error refuses the connection. Admit should return once ctx is done.
+type Admission interface {
+	Admit(ctx context.Context, addr net.Addr) error
+}
+
+// AdmissionFunc is an Admission calling f
+type AdmissionFunc func(ctx context.Context, addr net.Addr) error
+
+func (f AdmissionFunc) Admit(ctx context.Context, addr net.Addr) error {
+	return f(ctx, addr)
+}
+
+var errEarlyTalker = errors.New("client sent data that is not LDAP before admission")
+
+// admit holds the connection until the greeting delay has elapsed and the
+// Admission decision completed. With RejectEarlyTalkers, clients sending
+// data meanwhile that cannot start an LDAP message are refused, LDAP
+// clients may send their first request without waiting. LDAPS connections
+// are not watched, TLS clients speak first.
+func (c *client) admit() error {
+	s := c.srv
+	if s.Admission == nil && s.GreetingDelay == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if s.AdmissionTimeout != 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, s.AdmissionTimeout)
+		defer cancelTimeout()
+	}
+
+	decision := make(chan error, 1)
+	if s.Admission != nil {
+		go func() {
+			decision <- s.Admission.Admit(ctx, c.rwc.RemoteAddr())
+		}()
+	} else {
+		decision <- nil
+	}
+
+	var delay <-chan time.Time
+	if s.GreetingDelay > 0 {
+		timer := time.NewTimer(s.GreetingDelay)
+		defer timer.Stop()
+		delay = timer.C
+	}
+
+	var peeked chan error
+	if s.RejectEarlyTalkers && !c.isTLS() {
+		peeked = make(chan error, 1)
+		go func() {
+			b, err := c.br.Peek(1)
+			if err == nil && !c.startsMessage(b[0]) {
+				err = errEarlyTalker
+			}
+			peeked <- err
+		}()
+		defer func() {
+			if peeked != nil {
+				// unblock the pending read, the connection is read again
+				// once admitted
+				c.rwc.SetReadDeadline(time.Now())
+				<-peeked
+				c.rwc.SetReadDeadline(time.Time{})
+			}
+		}()
+	}
+
+	done := ctx.Done()
+	for decision != nil || delay != nil {
+		select {
+		case err := <-decision:
+			if err != nil {
+				return err
+			}
+			decision, done = nil, nil
+		case <-delay:
+			delay = nil
+		case err := <-peeked:
+			peeked = nil
+			if err != nil {
+				return err
+			}
+		case <-done:
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// startsMessage reports whether b can be the first byte sent by a client:
+// the tag of an LDAPMessage, or of a TLS ClientHello when it is detected
+func (c *client) startsMessage(b byte) bool {
+	return b == berTagSequence || b == tlsRecordTypeHandshake && c.srv.DetectTLS
+}
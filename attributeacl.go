@@ -0,0 +1,165 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// ReadAccess reports whether the client sending m may read the attribute
+// of the entry dn. The attribute name is given without its options, as
+// sent by the handler.
+type ReadAccess func(m *Message, dn string, attribute string) bool
+
+// ProtectAttributes returns a ReadAccess letting only the clients for which
+// allow returns true read the attributes, userPassword for instance. The
+// other attributes are readable by everyone.
+func ProtectAttributes(allow func(m *Message) bool, attributes ...string) ReadAccess {
+	protected := make(map[string]bool, len(attributes))
+	for _, a := range attributes {
+		protected[strings.ToLower(a)] = true
+	}
+	return func(m *Message, dn string, attribute string) bool {
+		return !protected[strings.ToLower(attribute)] || allow(m)
+	}
+}
+
+// filterReadable returns e without the attributes the client sending m may
+// not read. The attributes of e are not modified, they may be shared with
+// the handler.
+func filterReadable(m *Message, e *Entry, readAccess ReadAccess) *Entry {
+	attributes := make([]EntryAttribute, 0, len(e.Attributes))
+	for _, a := range e.Attributes {
+		name := a.Name
+		if i := strings.IndexByte(name, ';'); i >= 0 {
+			name = name[:i]
+		}
+		if readAccess(m, e.DN, name) {
+			attributes = append(attributes, a)
+		}
+	}
+	return &Entry{DN: e.DN, Attributes: attributes}
+}
+
+// errHiddenAttribute is the Undefined value of the filter components
+// asserting an attribute the client may not read
+var errHiddenAttribute = errors.New("attribute not readable")
+
+// filterAttribute returns the attribute type, without options, asserted by
+// the filter item f, empty for the other filters
+func filterAttribute(f ldap.Filter) string {
+	var description string
+	switch f := f.(type) {
+	case ldap.FilterPresent:
+		description = string(f)
+	case ldap.FilterEqualityMatch:
+		description = string(f.AttributeDesc())
+	case ldap.FilterApproxMatch:
+		description = string(f.AttributeDesc())
+	case ldap.FilterGreaterOrEqual:
+		description = string(f.AttributeDesc())
+	case ldap.FilterLessOrEqual:
+		description = string(f.AttributeDesc())
+	case ldap.FilterSubstrings:
+		description = string(f.Type_())
+	case ldap.FilterExtensibleMatch:
+		if f.Type_() != nil {
+			description = string(*f.Type_())
+		}
+	}
+	if i := strings.IndexByte(description, ';'); i >= 0 {
+		description = description[:i]
+	}
+	return description
+}
+
+// readableMatch reports whether an entry written by the handler in
+// response to the search m, readable once filtered, still matches its filter once the attributes the client
+// may not read are hidden: the filter components asserting them evaluate
+// to Undefined, so a search can not tell their values. Filters asserting
+// only readable attributes are not evaluated again.
+func readableMatch(m *Message, readable *Entry, readAccess ReadAccess) bool {
+	search, ok := m.ProtocolOp().(ldap.SearchRequest)
+	if !ok {
+		return true
+	}
+	hidden := func(attribute string) bool {
+		return !readAccess(m, readable.DN, attribute)
+	}
+	if !assertsAttribute(search.Filter(), hidden) {
+		return true
+	}
+	ok, err := evalFilter(readable, search.Filter(), hidden)
+	return ok && err == nil
+}
+
+// assertsAttribute reports whether a component of f asserts an attribute
+// for which match returns true
+func assertsAttribute(f ldap.Filter, match func(attribute string) bool) bool {
+	switch f := f.(type) {
+	case ldap.FilterAnd:
+		for _, child := range f {
+			if assertsAttribute(child, match) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterOr:
+		for _, child := range f {
+			if assertsAttribute(child, match) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return assertsAttribute(f.Filter, match)
+	}
+	attribute := filterAttribute(f)
+	return attribute != "" && match(attribute)
+}
+
+// checkCompareAccess answers the Compare requests asserting an attribute
+// the client may not read with noSuchAttribute, as if the entry had none
+func (s *Server) checkCompareAccess(m *Message) ldap.ProtocolOp {
+	r, ok := m.ProtocolOp().(ldap.CompareRequest)
+	if !ok || s.ReadAccess == nil {
+		return nil
+	}
+	attribute := string(r.Ava().AttributeDesc())
+	if i := strings.IndexByte(attribute, ';'); i >= 0 {
+		attribute = attribute[:i]
+	}
+	if s.ReadAccess(m, string(r.Entry()), attribute) {
+		return nil
+	}
+	return NewResponseForRequest(r, LDAPResultNoSuchAttribute, "")
+}
+
+// entryTransform returns the transform applied to the search result entries
+// written in response to m: EntryTransform, the Deduplicate policy, then
+// the ReadAccess filtering, so a transform can not add back an attribute
+// the client may not read nor keep an entry matched only through one, and
+// the SelectAttributes selection
+func (s *Server) entryTransform(m *Message) EntryTransform {
+	var dedup, readable EntryTransform
+	if _, ok := m.ProtocolOp().(ldap.SearchRequest); ok && s.Deduplicate != DedupNone {
+		dedup = dedupTransform(s.Deduplicate)
+	}
+	if s.ReadAccess != nil {
+		readAccess := s.ReadAccess
+		readable = func(ctx context.Context, e *Entry) (*Entry, error) {
+			filtered := filterReadable(m, e, readAccess)
+			if !readableMatch(m, filtered, readAccess) {
+				return nil, nil
+			}
+			return filtered, nil
+		}
+	}
+	var selected EntryTransform
+	if s.SelectAttributes {
+		selected = s.selectRequested(m)
+	}
+	return chainTransforms(s.EntryTransform, dedup, readable, selected)
+}
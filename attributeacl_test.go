@@ -0,0 +1,78 @@
+package ldapserver
+
+import (
+	"context"
+	"testing"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+func TestReadAccess(t *testing.T) {
+	s := &Server{ReadAccess: ProtectAttributes(func(m *Message) bool { return false }, "userPassword")}
+	entry := NewEntry("uid=alice,dc=example,dc=com").Add("cn", "alice").Add("userPassword", "secret")
+
+	tests := []struct {
+		filter string
+		kept   bool
+	}{
+		{"(cn=alice)", true},
+		{"(objectClass=*)", true},
+		{"(userPassword=secret)", false},
+		{"(userPassword=*)", false},
+		{"(userPassword;x-opt=secret)", false},
+		{"(!(userPassword=guess))", false},
+		{"(&(cn=alice)(userPassword=secret))", false},
+		{"(|(cn=alice)(userPassword=guess))", true},
+		{"(|(cn=bob)(userPassword=secret))", false},
+		{"(!(&(cn=bob)(userPassword=secret)))", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			r, err := NewSearchRequest(SearchParams{BaseDN: "dc=example,dc=com", Scope: SearchRequestHomeSubtree, Filter: tt.filter})
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := &Message{LDAPMessage: ldap.NewLDAPMessageWithProtocolOp(r)}
+			e, err := s.entryTransform(m)(context.Background(), entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept := e != nil; kept != tt.kept {
+				t.Fatalf("entry kept %t, want %t", kept, tt.kept)
+			}
+			if e != nil && len(e.values("userPassword")) != 0 {
+				t.Error("userPassword returned")
+			}
+		})
+	}
+}
+
+func TestCheckCompareAccess(t *testing.T) {
+	s := &Server{ReadAccess: ProtectAttributes(func(m *Message) bool { return false }, "userPassword")}
+	compare := func(attribute string) []byte {
+		return berConstructedTLV(berClassApplication|berConstructed|ApplicationCompareRequest,
+			berOctetString(berTagOctetString, []byte("uid=alice,dc=example,dc=com")),
+			berSequence(
+				berOctetString(berTagOctetString, []byte(attribute)),
+				berOctetString(berTagOctetString, []byte("secret")),
+			),
+		)
+	}
+	tests := []struct {
+		attribute string
+		refused   bool
+	}{
+		{"cn", false},
+		{"userPassword", true},
+		{"USERPASSWORD;x-opt", true},
+	}
+	for _, tt := range tests {
+		res := s.checkCompareAccess(testMessage(t, compare(tt.attribute)))
+		if refused := res != nil; refused != tt.refused {
+			t.Errorf("Compare of %s refused %t, want %t", tt.attribute, refused, tt.refused)
+		}
+	}
+	if res := (&Server{}).checkCompareAccess(testMessage(t, compare("userPassword"))); res != nil {
+		t.Error("Compare refused without ReadAccess")
+	}
+}
@@ -0,0 +1,122 @@
+package ldapserver
+
+import (
+	"context"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// OperationalAttributes are the lowercase names of the attribute types
+// SelectAttributes handles as operational, only returned when requested by
+// name or with "+", when the schema set with SetSchema does not define
+// them. Types may be added before the server is started.
+var OperationalAttributes = map[string]bool{
+	// RFC 4512
+	"createtimestamp":        true,
+	"modifytimestamp":        true,
+	"creatorsname":           true,
+	"modifiersname":          true,
+	"structuralobjectclass":  true,
+	"governingstructurerule": true,
+	"subschemasubentry":      true,
+	"attributetypes":         true,
+	"objectclasses":          true,
+	"ldapsyntaxes":           true,
+	"matchingrules":          true,
+	"matchingruleuse":        true,
+	"ditcontentrules":        true,
+	"ditstructurerules":      true,
+	"nameforms":              true,
+	// RFC 5020, RFC 4530, RFC 3045 and X.501
+	"entrydn":         true,
+	"entryuuid":       true,
+	"vendorname":      true,
+	"vendorversion":   true,
+	"hassubordinates": true,
+	// OpenLDAP, password policy and Active Directory
+	"numsubordinates":      true,
+	"entrycsn":             true,
+	"contextcsn":           true,
+	"pwdchangedtime":       true,
+	"pwdaccountlockedtime": true,
+	"pwdfailuretime":       true,
+	"pwdhistory":           true,
+	"pwdgraceusetime":      true,
+	"pwdreset":             true,
+	"pwdpolicysubentry":    true,
+	"memberof":             true,
+	"isdeleted":            true,
+}
+
+// operational reports whether the attribute name, lowercase without its
+// options, is operational: its usage in schema, which may be nil, is not
+// userApplications, or it is one of the OperationalAttributes
+func operational(schema *Schema, name string) bool {
+	if schema != nil {
+		if t, ok := schema.AttributeType(name); ok {
+			return t.Usage != "" && t.Usage != "userApplications"
+		}
+	}
+	return OperationalAttributes[name]
+}
+
+// selectRequested returns the EntryTransform reducing the entries written
+// in response to the search m to the attributes it requested, and to their
+// types with typesOnly, or nil for the other requests. The RootDSE keeps
+// the attributes selected when it is served with SetRootDSE.
+func (s *Server) selectRequested(m *Message) EntryTransform {
+	r, ok := m.ProtocolOp().(ldap.SearchRequest)
+	if !ok {
+		return nil
+	}
+	schema, _ := s.subschema()
+	isOperational := func(name string) bool { return operational(schema, name) }
+	return func(ctx context.Context, e *Entry) (*Entry, error) {
+		if e.DN == "" {
+			return e, nil
+		}
+		selected := selectAttributes(*e, r, isOperational)
+		return &selected, nil
+	}
+}
+
+// SelectAttributes returns e with the attributes requested by r only, for
+// the handlers answering searches from their own entries: the user
+// attributes when r lists none or "*", the OperationalAttributes when
+// listed or with "+", no attribute for "1.1", and without their values
+// when r has typesOnly set
+func SelectAttributes(e Entry, r ldap.SearchRequest) Entry {
+	return selectAttributes(e, r, func(name string) bool { return operational(nil, name) })
+}
+
+// selectAttributes returns e with the attributes requested by the search
+// r, as SelectAttributes, the operational ones being those for which
+// isOperational returns true with their lowercase name without options
+func selectAttributes(e Entry, r ldap.SearchRequest, isOperational func(name string) bool) Entry {
+	user, allOperational := len(r.Attributes()) == 0, false
+	wanted := make(map[string]bool, len(r.Attributes()))
+	for _, name := range r.Attributes() {
+		switch name {
+		case "*":
+			user = true
+		case "+":
+			allOperational = true
+		default:
+			wanted[attributeType(string(name))] = true
+		}
+	}
+	selected := Entry{DN: e.DN}
+	for _, a := range e.Attributes {
+		name := attributeType(a.Name)
+		switch {
+		case wanted[name],
+			isOperational(name) && allOperational,
+			!isOperational(name) && user:
+			if r.TypesOnly() {
+				a = EntryAttribute{Name: a.Name}
+			}
+			selected.Attributes = append(selected.Attributes, a)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,45 @@
+package ldapserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelectAttributes(t *testing.T) {
+	entry := *NewEntry("cn=alice,dc=example,dc=com").
+		Add("objectClass", "person").
+		Add("cn;lang-en", "alice").
+		Add("createTimestamp", "20240101000000Z")
+	tests := []struct {
+		attributes []string
+		typesOnly  bool
+		want       string
+	}{
+		{nil, false, "objectClass cn;lang-en"},
+		{[]string{"*"}, false, "objectClass cn;lang-en"},
+		{[]string{"+"}, false, "createTimestamp"},
+		{[]string{"*", "+"}, false, "objectClass cn;lang-en createTimestamp"},
+		{[]string{"CN", "createtimestamp"}, false, "cn;lang-en createTimestamp"},
+		{[]string{"1.1"}, false, ""},
+		{[]string{"cn"}, true, "cn;lang-en="},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.attributes, ","), func(t *testing.T) {
+			r, err := NewSearchRequest(SearchParams{BaseDN: entry.DN, Attributes: tt.attributes, TypesOnly: tt.typesOnly})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, a := range SelectAttributes(entry, r).Attributes {
+				if len(a.Values) == 0 {
+					got = append(got, a.Name+"=")
+					continue
+				}
+				got = append(got, a.Name)
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("selected %q, want %q", strings.Join(got, " "), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package ldapserver
+
+import (
+	"context"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// RequireBind returns a middleware answering insufficientAccessRights to the
+// requests of anonymous clients, so "must be bound before search" is
+// enforced in one place:
+//
+//	routes.Use(ldapserver.RequireBind("SearchRequest", "CompareRequest"))
+//
+// operations are the protocol operation names checked, all but binds,
+// abandons, unbinds and StartTLS if empty. A client is bound once a bind
+// succeeded, see BindState, or the application set its ACL BindEntry.
+func RequireBind(operations ...string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w ResponseWriter, m *Message) {
+			if requiresBind(m, operations) && boundAuthzID(m) == "" {
+				if res := NewResponseForRequest(m.ProtocolOp(), LDAPResultInsufficientAccessRights, "bind required"); res != nil {
+					w.Write(res)
+				}
+				return
+			}
+			next(w, m)
+		}
+	}
+}
+
+// requiresBind reports whether RequireBind checks m
+func requiresBind(m *Message, operations []string) bool {
+	switch m.ProtocolOp().(type) {
+	case ldap.BindRequest, ldap.AbandonRequest, ldap.UnbindRequest:
+		return false
+	}
+	if isStartTLS(m.LDAPMessage) {
+		return false
+	}
+	if len(operations) == 0 {
+		return true
+	}
+	for _, operation := range operations {
+		if operation == m.ProtocolOpName() {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireDNAccess returns a middleware answering insufficientAccessRights to
+// the requests targeting a DN for which allow returns false, for per-DN
+// access control lists in one place. allow is called for the search base,
+// the compared, added, modified or deleted entry, and for ModifyDN both
+// the entry and its new DN; it tells the client identity with
+// Message.AuthzID and the operation with Message.ProtocolOpName. As a
+// search may reach below its base, allow is also called for the entries it
+// returns, and those it refuses are dropped:
+//
+//	routes.Use(ldapserver.RequireDNAccess(func(m *ldapserver.Message, dn ldapserver.DN) bool {
+//		return !dn.IsDescendantOf(secrets, true) || m.AuthzID() == "dn:cn=admin,dc=example,dc=com"
+//	}))
+//
+// Requests with invalid DNs are answered with invalidDNSyntax, returned
+// entries with invalid DNs are dropped.
+func RequireDNAccess(allow func(m *Message, dn DN) bool) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w ResponseWriter, m *Message) {
+			dns, err := accessDNs(m.ProtocolOp())
+			if err != nil {
+				w.Write(NewResponseForRequest(m.ProtocolOp(), LDAPResultInvalidDNSyntax, err.Error()))
+				return
+			}
+			for _, dn := range dns {
+				if !allow(m, dn) {
+					m.logAt(LogLevelInfo, "%s of %s by %q denied", m.ProtocolOpName(), dn, m.AuthzID())
+					w.Write(NewResponseForRequest(m.ProtocolOp(), LDAPResultInsufficientAccessRights,
+						"access to "+dn.String()+" is not allowed"))
+					return
+				}
+			}
+			if _, ok := m.ProtocolOp().(ldap.SearchRequest); ok {
+				w = newTransformWriter(w, m, allowedEntries(m, allow))
+			}
+			next(w, m)
+		}
+	}
+}
+
+// allowedEntries returns the EntryTransform dropping the entries returned
+// to m for which allow returns false, see RequireDNAccess
+func allowedEntries(m *Message, allow func(m *Message, dn DN) bool) EntryTransform {
+	return func(ctx context.Context, e *Entry) (*Entry, error) {
+		dn, err := ParseDN(e.DN)
+		if err != nil || !allow(m, dn) {
+			return nil, nil
+		}
+		return e, nil
+	}
+}
+
+// accessDNs returns the DNs targeted by the request po, see RequireDNAccess
+func accessDNs(po ldap.ProtocolOp) ([]DN, error) {
+	var dns []string
+	switch r := po.(type) {
+	case ldap.BindRequest:
+		return nil, nil
+	case ldap.ModifyDNRequest:
+		entry, err := ParseDN(string(r.Entry()))
+		if err != nil {
+			return nil, err
+		}
+		rdn, err := ParseDN(string(r.NewRDN()))
+		if err != nil {
+			return nil, err
+		}
+		superior := entry.Parent()
+		if r.NewSuperior() != nil {
+			if superior, err = ParseDN(string(*r.NewSuperior())); err != nil {
+				return nil, err
+			}
+		}
+		return []DN{entry, append(rdn, superior...)}, nil
+	default:
+		dns = requestDNs(po)
+	}
+	parsed := make([]DN, len(dns))
+	for i, s := range dns {
+		dn, err := ParseDN(s)
+		if err != nil {
+			return nil, err
+		}
+		parsed[i] = dn
+	}
+	return parsed, nil
+}
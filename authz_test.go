@@ -0,0 +1,38 @@
+package ldapserver
+
+import "testing"
+
+func TestRequireDNAccess(t *testing.T) {
+	secrets, err := ParseDN("ou=secrets,dc=example,dc=com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allow := RequireDNAccess(func(m *Message, dn DN) bool {
+		return !dn.IsDescendantOf(secrets, true)
+	})
+	search := allow(func(w ResponseWriter, m *Message) {
+		WriteEntries(w, []Entry{
+			*NewEntry("dc=example,dc=com"),
+			*NewEntry("ou=secrets,dc=example,dc=com"),
+			*NewEntry("cn=key,ou=secrets,dc=example,dc=com"),
+			*NewEntry("ou=people,dc=example,dc=com"),
+		})
+		w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+	})
+
+	rec := NewResponseRecorder()
+	search(rec, testMessage(t, testSearchRequest("dc=example,dc=com", 0, 0)))
+	var dns []string
+	for _, e := range rec.Entries() {
+		dns = append(dns, e.DN)
+	}
+	if len(dns) != 2 || dns[0] != "dc=example,dc=com" || dns[1] != "ou=people,dc=example,dc=com" {
+		t.Errorf("entries returned %q, want the ones outside ou=secrets", dns)
+	}
+
+	rec = NewResponseRecorder()
+	search(rec, testMessage(t, testSearchRequest("ou=secrets,dc=example,dc=com", 0, 0)))
+	if code := rec.ResultCode(); code != LDAPResultInsufficientAccessRights {
+		t.Errorf("search of ou=secrets result code %d, want %d", code, LDAPResultInsufficientAccessRights)
+	}
+}
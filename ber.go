@@ -0,0 +1,174 @@
+package ldapserver
+
+import "errors"
+
+// Minimal BER encoding and decoding helpers, for the LDAP elements goldap
+// does not expose (extended response values, control values...).
+// Only low tag numbers (< 31) are supported, which covers LDAP.
+
+// BER identifier octets used by LDAP
+const (
+	berTagBoolean     = 0x01
+	berTagInteger     = 0x02
+	berTagOctetString = 0x04
+	berTagEnumerated  = 0x0a
+	berTagSequence    = 0x30
+	berTagSet         = 0x31
+
+	berClassApplication = 0x40
+	berClassContext     = 0x80
+	berConstructed      = 0x20
+)
+
+var errBERTruncated = errors.New("truncated BER element")
+
+// berEncodeLength returns the BER definite form of length n
+func berEncodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for v := n; v > 0; v >>= 8 {
+		b = append([]byte{byte(v)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+// berTLVLen returns the encoded length of an element holding n content octets
+func berTLVLen(n int) int {
+	return 1 + len(berEncodeLength(n)) + n
+}
+
+// appendBERHeader appends the identifier and length octets of an element
+func appendBERHeader(buf []byte, tag byte, n int) []byte {
+	buf = append(buf, tag)
+	return append(buf, berEncodeLength(n)...)
+}
+
+// berTLV encodes an element with the identifier octet tag
+func berTLV(tag byte, content []byte) []byte {
+	l := berEncodeLength(len(content))
+	b := make([]byte, 0, 1+len(l)+len(content))
+	b = append(b, tag)
+	b = append(b, l...)
+	return append(b, content...)
+}
+
+// berConstructedTLV encodes a constructed element holding the given
+// already encoded elements
+func berConstructedTLV(tag byte, elements ...[]byte) []byte {
+	n := 0
+	for _, e := range elements {
+		n += len(e)
+	}
+	content := make([]byte, 0, n)
+	for _, e := range elements {
+		content = append(content, e...)
+	}
+	return berTLV(tag, content)
+}
+
+func berSequence(elements ...[]byte) []byte {
+	return berConstructedTLV(berTagSequence, elements...)
+}
+
+func berOctetString(tag byte, s []byte) []byte {
+	return berTLV(tag, s)
+}
+
+func berBoolean(tag byte, v bool) []byte {
+	if v {
+		return berTLV(tag, []byte{0xff})
+	}
+	return berTLV(tag, []byte{0x00})
+}
+
+// berInteger encodes v with the minimal two's complement representation
+func berInteger(tag byte, v int64) []byte {
+	b := []byte{byte(v)}
+	for v > 0x7f || v < -0x80 {
+		v >>= 8
+		b = append([]byte{byte(v)}, b...)
+	}
+	return berTLV(tag, b)
+}
+
+// berElement is a decoded BER element
+type berElement struct {
+	tag  byte   // identifier octet
+	data []byte // content octets
+}
+
+func (e berElement) constructed() bool {
+	return e.tag&berConstructed != 0
+}
+
+// berRead decodes the first element of b, and returns it with the bytes
+// following it
+func berRead(b []byte) (e berElement, rest []byte, err error) {
+	if len(b) < 2 {
+		return e, nil, errBERTruncated
+	}
+	e.tag = b[0]
+	if e.tag&0x1f == 0x1f {
+		return e, nil, errors.New("BER high tag numbers are not supported")
+	}
+	length := int(b[1])
+	offset := 2
+	if length&0x80 != 0 {
+		numBytes := length & 0x7f
+		if numBytes == 0 {
+			return e, nil, errors.New("BER indefinite length is not supported")
+		}
+		if numBytes > 4 || len(b) < offset+numBytes {
+			return e, nil, errBERTruncated
+		}
+		length = 0
+		for _, c := range b[offset : offset+numBytes] {
+			length = length<<8 | int(c)
+		}
+		offset += numBytes
+	}
+	if length < 0 || len(b)-offset < length {
+		return e, nil, errBERTruncated
+	}
+	e.data = b[offset : offset+length]
+	return e, b[offset+length:], nil
+}
+
+// berChildren decodes all the elements of a constructed element content
+func berChildren(data []byte) ([]berElement, error) {
+	var children []berElement
+	for len(data) > 0 {
+		e, rest, err := berRead(data)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, e)
+		data = rest
+	}
+	return children, nil
+}
+
+// maxInt is the largest INTEGER of the protocol, RFC 4511 section 4.1.1
+const maxInt = 1<<31 - 1
+
+// berParseInteger decodes the content octets of an INTEGER or ENUMERATED
+func berParseInteger(data []byte) (int64, error) {
+	if len(data) == 0 || len(data) > 8 {
+		return 0, errors.New("invalid BER integer length")
+	}
+	v := int64(int8(data[0]))
+	for _, c := range data[1:] {
+		v = v<<8 | int64(c)
+	}
+	return v, nil
+}
+
+// berParseBoolean decodes the content octets of a BOOLEAN
+func berParseBoolean(data []byte) (bool, error) {
+	if len(data) != 1 {
+		return false, errors.New("invalid BER boolean length")
+	}
+	return data[0] != 0, nil
+}
@@ -0,0 +1,121 @@
+package ldapserver
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBerRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []byte
+		tag     byte
+		data    []byte
+		rest    []byte
+		wantErr bool
+	}{
+		{"short form", []byte{0x04, 0x02, 'a', 'b'}, 0x04, []byte("ab"), []byte{}, false},
+		{"empty content", []byte{0x30, 0x00, 0x05, 0x00}, 0x30, []byte{}, []byte{0x05, 0x00}, false},
+		{"long form", append([]byte{0x04, 0x81, 0x80}, make([]byte, 0x80)...), 0x04, make([]byte, 0x80), []byte{}, false},
+		{"long form with leading zero", []byte{0x04, 0x82, 0x00, 0x01, 'a'}, 0x04, []byte("a"), []byte{}, false},
+		{"rest", []byte{0x02, 0x01, 0x05, 0x01, 0x01, 0xff}, 0x02, []byte{0x05}, []byte{0x01, 0x01, 0xff}, false},
+		{"empty", nil, 0, nil, nil, true},
+		{"missing length", []byte{0x04}, 0, nil, nil, true},
+		{"truncated content", []byte{0x04, 0x03, 'a', 'b'}, 0, nil, nil, true},
+		{"truncated length", []byte{0x04, 0x82, 0x01}, 0, nil, nil, true},
+		{"indefinite length", []byte{0x30, 0x80, 0x00, 0x00}, 0, nil, nil, true},
+		{"high tag number", []byte{0x1f, 0x01, 0x00}, 0, nil, nil, true},
+		{"length too large", []byte{0x04, 0x85, 0x01, 0x00, 0x00, 0x00, 0x00}, 0, nil, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, rest, err := berRead(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("berRead(%x) returned no error", tt.in)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("berRead(%x): %s", tt.in, err)
+			}
+			if e.tag != tt.tag || !bytes.Equal(e.data, tt.data) || !bytes.Equal(rest, tt.rest) {
+				t.Errorf("berRead(%x) = %#x %x, rest %x, want %#x %x, rest %x", tt.in, e.tag, e.data, rest, tt.tag, tt.data, tt.rest)
+			}
+		})
+	}
+}
+
+func TestBerChildren(t *testing.T) {
+	children, err := berChildren([]byte{0x02, 0x01, 0x01, 0x04, 0x00, 0x01, 0x01, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := []byte{0x02, 0x04, 0x01}
+	if len(children) != len(tags) {
+		t.Fatalf("%d children, want %d", len(children), len(tags))
+	}
+	for i, tag := range tags {
+		if children[i].tag != tag {
+			t.Errorf("child %d has tag %#x, want %#x", i, children[i].tag, tag)
+		}
+	}
+
+	if _, err := berChildren([]byte{0x02, 0x01, 0x01, 0x04, 0x05}); err == nil {
+		t.Error("truncated child decoded")
+	}
+}
+
+func TestBerInteger(t *testing.T) {
+	tests := []struct {
+		v       int64
+		encoded []byte
+	}{
+		{0, []byte{0x02, 0x01, 0x00}},
+		{127, []byte{0x02, 0x01, 0x7f}},
+		{128, []byte{0x02, 0x02, 0x00, 0x80}},
+		{256, []byte{0x02, 0x02, 0x01, 0x00}},
+		{-1, []byte{0x02, 0x01, 0xff}},
+		{-128, []byte{0x02, 0x01, 0x80}},
+		{-129, []byte{0x02, 0x02, 0xff, 0x7f}},
+		{maxInt, []byte{0x02, 0x04, 0x7f, 0xff, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		encoded := berInteger(berTagInteger, tt.v)
+		if !bytes.Equal(encoded, tt.encoded) {
+			t.Errorf("berInteger(%d) = %x, want %x", tt.v, encoded, tt.encoded)
+		}
+		v, err := berParseInteger(tt.encoded[2:])
+		if err != nil || v != tt.v {
+			t.Errorf("berParseInteger(%x) = %d, %v, want %d", tt.encoded[2:], v, err, tt.v)
+		}
+	}
+
+	for _, data := range [][]byte{nil, make([]byte, 9)} {
+		if _, err := berParseInteger(data); err == nil {
+			t.Errorf("berParseInteger(%x) returned no error", data)
+		}
+	}
+}
+
+func TestBerEncodeLength(t *testing.T) {
+	tests := []struct {
+		n       int
+		encoded []byte
+	}{
+		{0, []byte{0x00}},
+		{0x7f, []byte{0x7f}},
+		{0x80, []byte{0x81, 0x80}},
+		{0xff, []byte{0x81, 0xff}},
+		{0x100, []byte{0x82, 0x01, 0x00}},
+		{1 << 24, []byte{0x84, 0x01, 0x00, 0x00, 0x00}},
+	}
+	for _, tt := range tests {
+		if encoded := berEncodeLength(tt.n); !bytes.Equal(encoded, tt.encoded) {
+			t.Errorf("berEncodeLength(%d) = %x, want %x", tt.n, encoded, tt.encoded)
+		}
+		if n := berTLVLen(tt.n); n != 1+len(tt.encoded)+tt.n {
+			t.Errorf("berTLVLen(%d) = %d, want %d", tt.n, n, 1+len(tt.encoded)+tt.n)
+		}
+	}
+}
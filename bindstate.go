@@ -0,0 +1,146 @@
+package ldapserver
+
+import (
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// BindMethod is the authentication method of a connection
+type BindMethod int
+
+const (
+	BindAnonymous BindMethod = iota // not bound, or bound with empty credentials
+	BindSimple                      // bound with a DN and a password
+	BindSASL                        // bound with a SASL mechanism
+)
+
+func (b BindMethod) String() string {
+	switch b {
+	case BindSimple:
+		return "simple"
+	case BindSASL:
+		return "sasl"
+	}
+	return "anonymous"
+}
+
+// BindState is the authentication state of a connection, updated by the
+// server when a BindResponse with the success result code is written
+type BindState struct {
+	Method    BindMethod
+	DN        string    // name of the bind request
+	Mechanism string    // SASL mechanism
+	Identity  string    // SASL authenticated identity, the client ACL BindEntry set by the SASL handler
+	Time      time.Time // time of the successful bind
+}
+
+// BindState returns the authentication state of the connection
+func (c *client) BindState() BindState {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.bindState
+}
+
+// startBind resets the connection to anonymous when a bind request is
+// received: RFC 4511 section 4.2.1, a failed or abandoned bind leaves the
+// connection anonymous. The client ACL is reset too, and the bind hooks
+// run to drop the state obtained with the previous identity.
+func (c *client) startBind() {
+	c.mutex.Lock()
+	c.bindState = BindState{}
+	hooks := c.bindHooks
+	c.bindHooks = nil
+	c.mutex.Unlock()
+	c.SetACL(ClientACL{})
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+// onBind registers f to run once when the next bind request is received,
+// replacing the hook registered with the same key
+func (c *client) onBind(key interface{}, f func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.bindHooks == nil {
+		c.bindHooks = make(map[interface{}]func())
+	}
+	c.bindHooks[key] = f
+}
+
+// bindStateWriter is a ResponseWriter updating the bind state of the
+// client once the bind request m is answered with success
+type bindStateWriter struct {
+	ResponseWriter
+	m *Message
+}
+
+// newBindStateWriter returns w tracking the outcome of the request m when
+// it is a bind request, and resets the connection to anonymous
+func newBindStateWriter(w ResponseWriter, m *Message) ResponseWriter {
+	if _, ok := m.ProtocolOp().(ldap.BindRequest); !ok || m.Client == nil {
+		return w
+	}
+	m.Client.startBind()
+	return &bindStateWriter{ResponseWriter: w, m: m}
+}
+
+func (b *bindStateWriter) Write(po ldap.ProtocolOp) {
+	b.WriteMessage(ldap.NewLDAPMessageWithProtocolOp(po))
+}
+
+func (b *bindStateWriter) WriteMessage(message *ldap.LDAPMessage) {
+	if _, ok := message.ProtocolOp().(ldap.BindResponse); ok {
+		if code, err := resultCodeOf(message); err == nil {
+			b.bound(code)
+		}
+	}
+	b.ResponseWriter.WriteMessage(message)
+}
+
+func (b *bindStateWriter) WriteRaw(protocolOp []byte) {
+	b.WriteRawWithControls(protocolOp, nil)
+}
+
+func (b *bindStateWriter) WriteRawWithControls(protocolOp []byte, controls [][]byte) {
+	if len(protocolOp) > 0 && protocolOp[0] == berClassApplication|berConstructed|ApplicationBindResponse {
+		if code, ok := rawResultCode(protocolOp); ok {
+			b.bound(code)
+		}
+	}
+	if len(controls) == 0 {
+		WriteRaw(b.ResponseWriter, protocolOp)
+		return
+	}
+	WriteRawWithControls(b.ResponseWriter, protocolOp, controls)
+}
+
+func (b *bindStateWriter) responded() bool {
+	if rw, ok := b.ResponseWriter.(interface{ responded() bool }); ok {
+		return rw.responded()
+	}
+	return false
+}
+
+// bound updates the bind state of the client once the bind is answered
+// with resultCode
+func (b *bindStateWriter) bound(resultCode int) {
+	if resultCode != LDAPResultSuccess {
+		return
+	}
+	r := b.m.GetBindRequest()
+	state := BindState{DN: string(r.Name()), Time: time.Now()}
+	switch {
+	case r.AuthenticationChoice() == "sasl":
+		state.Method = BindSASL
+		state.Mechanism, _, _ = parseSASLCredentials(b.m)
+		state.Identity = b.m.Client.ACL().BindEntry
+	case state.DN != "" && len(r.AuthenticationSimple()) > 0:
+		state.Method = BindSimple
+	}
+	c := b.m.Client
+	c.mutex.Lock()
+	c.bindState = state
+	c.mutex.Unlock()
+}
@@ -0,0 +1,188 @@
+package ldapserver
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// ChangeType is the type of a directory change
+type ChangeType int
+
+const (
+	ChangeAdd ChangeType = iota
+	ChangeDelete
+	ChangeModify
+	ChangeModifyDN
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case ChangeAdd:
+		return "add"
+	case ChangeDelete:
+		return "delete"
+	case ChangeModify:
+		return "modify"
+	case ChangeModifyDN:
+		return "modrdn"
+	}
+	return fmt.Sprintf("ChangeType(%d)", int(t))
+}
+
+// Change is a modification of an attribute, the attributes of an added
+// entry are ModifyRequestChangeOperationAdd changes
+type Change struct {
+	Operation int
+	Attribute string
+	Values    [][]byte
+}
+
+// ChangeEvent describes a change applied to the directory
+type ChangeEvent struct {
+	CSN     string // change sequence number, ordering the changes of the stream
+	Time    time.Time
+	Type    ChangeType
+	DN      string
+	Changes []Change // ChangeAdd and ChangeModify
+
+	// ChangeModifyDN
+	NewRDN       string
+	DeleteOldRDN bool
+	NewSuperior  string
+}
+
+// ChangeStream dispatches the directory changes to subscribers, see
+// Server.Changes. The built-in backends publish the changes they apply,
+// and Middleware the successful write requests of any handler.
+type ChangeStream struct {
+	mu          sync.Mutex
+	subscribers map[chan ChangeEvent]bool
+	lastTime    string // time part of the last CSN
+	count       int    // changes with the last CSN time
+	dropped     int64
+}
+
+// Subscribe returns a channel receiving the changes published from now on,
+// buffered to buffer events, and the function ending the subscription.
+// Changes are dropped when the channel is full, see Dropped.
+func (s *ChangeStream) Subscribe(buffer int) (<-chan ChangeEvent, func()) {
+	ch := make(chan ChangeEvent, buffer)
+	s.mu.Lock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[chan ChangeEvent]bool)
+	}
+	s.subscribers[ch] = true
+	s.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.mu.Lock()
+			delete(s.subscribers, ch)
+			s.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish stamps e with a CSN and the current time, and sends it to the
+// subscribers
+func (s *ChangeStream) Publish(e ChangeEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e.Time = time.Now().UTC()
+	t := e.Time.Format("20060102150405.000000Z")
+	if t == s.lastTime {
+		s.count++
+	} else {
+		s.lastTime, s.count = t, 0
+	}
+	e.CSN = fmt.Sprintf("%s#%06x#000#000000", t, s.count)
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- e:
+		default:
+			atomic.AddInt64(&s.dropped, 1)
+		}
+	}
+}
+
+// Dropped returns the number of changes not delivered to a subscriber whose
+// channel was full
+func (s *ChangeStream) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Middleware returns a middleware publishing the write requests answered
+// with success
+func (s *ChangeStream) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w ResponseWriter, m *Message) {
+			if !isWriteRequest(m.ProtocolOp()) {
+				next(w, m)
+				return
+			}
+			tee := &teeResponseWriter{w: w, rec: NewResponseRecorder()}
+			next(tee, m)
+			if tee.rec.ResultCode() == LDAPResultSuccess {
+				if e, ok := ChangeEventOf(m.ProtocolOp()); ok {
+					s.Publish(e)
+				}
+			}
+		}
+	}
+}
+
+// ChangeEventOf returns the change applied by the write request po, for
+// the handlers publishing their changes to a ChangeStream themselves
+func ChangeEventOf(po ldap.ProtocolOp) (ChangeEvent, bool) {
+	switch r := po.(type) {
+	case ldap.AddRequest:
+		e := ChangeEvent{Type: ChangeAdd, DN: string(r.Entry())}
+		for _, a := range r.Attributes() {
+			c := Change{Operation: ModifyRequestChangeOperationAdd, Attribute: string(a.Type_())}
+			for _, v := range a.Vals() {
+				c.Values = append(c.Values, []byte(v))
+			}
+			e.Changes = append(e.Changes, c)
+		}
+		return e, true
+	case ldap.DelRequest:
+		return ChangeEvent{Type: ChangeDelete, DN: string(r)}, true
+	case ldap.ModifyRequest:
+		e := ChangeEvent{Type: ChangeModify, DN: string(r.Object())}
+		for _, change := range r.Changes() {
+			modification := change.Modification()
+			c := Change{Operation: int(change.Operation()), Attribute: string(modification.Type_())}
+			for _, v := range modification.Vals() {
+				c.Values = append(c.Values, []byte(v))
+			}
+			e.Changes = append(e.Changes, c)
+		}
+		return e, true
+	case ldap.ModifyDNRequest:
+		e := ChangeEvent{
+			Type:         ChangeModifyDN,
+			DN:           string(r.Entry()),
+			NewRDN:       string(r.NewRDN()),
+			DeleteOldRDN: bool(r.DeleteOldRDN()),
+		}
+		if r.NewSuperior() != nil {
+			e.NewSuperior = string(*r.NewSuperior())
+		}
+		return e, true
+	}
+	return ChangeEvent{}, false
+}
+
+// Changes returns the stream of the directory changes applied by the
+// server built-in backends
+func (s *Server) Changes() *ChangeStream {
+	return &s.changes
+}
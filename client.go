@@ -2,10 +2,13 @@ package ldapserver
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
 	"io"
-	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	ldap "github.com/ps78674/goldap/message"
@@ -23,16 +26,33 @@ type client struct {
 	acl         ClientACL
 	numero      int
 	srv         *Server
+	handler     Handler // serves the requests, depends on the listener
 	rwc         net.Conn
 	br          *bufio.Reader
 	bw          *bufio.Writer
-	chanOut     chan *ldap.LDAPMessage
+	wmu         sync.Mutex // protects bw, replaced by SetConn
+	chanOut     chan *outMessage
 	wg          sync.WaitGroup
 	closing     chan bool
+	noticed     chan struct{} // closed once the shutdown watcher returned, see Server.waitNotices
+	watching    bool          // the shutdown watcher is running
 	requestList map[int]*Message
+	messageIDs  map[int]bool // message IDs of the requests in flight, reserved when read
 	mutex       sync.Mutex
 	writeDone   chan bool
 	rawData     []byte
+	settings    ConnSettings
+	operations  int
+	gone        int32 // set once the client connection is lost
+	ctx         context.Context
+	cancel      context.CancelFunc // cancels ctx, the parent of the requests contexts
+
+	maintenanceTimer *time.Timer            // pending disconnection, see Limits.Maintenance
+	lastActivity     int64                  // UnixNano of the last request or response, see reapIdle
+	reaped           int32                  // set once the connection is disconnected for being idle
+	bindState        BindState              // authentication state, protected by mutex
+	bindHooks        map[interface{}]func() // run once when a bind starts, see onBind
+	journal          *opJournal             // last operations, see Server.JournalSize
 }
 
 func (c *client) ACL() ClientACL {
@@ -43,6 +63,11 @@ func (c *client) SetACL(acl ClientACL) {
 	c.acl = acl
 }
 
+// Settings returns the policy applied to the connection
+func (c *client) Settings() ConnSettings {
+	return c.settings
+}
+
 func (c *client) Numero() int {
 	return c.numero
 }
@@ -56,16 +81,37 @@ func (c *client) GetRaw() []byte {
 }
 
 func (c *client) SetConn(conn net.Conn) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
 	c.rwc = conn
 	c.br = bufio.NewReader(c.rwc)
 	c.bw = bufio.NewWriter(c.rwc)
 }
 
-func (c *client) GetMessageByID(messageID int) (*Message, bool) {
-	if requestToAbandon, ok := c.requestList[messageID]; ok {
-		return requestToAbandon, true
+// TLSHandshake runs the TLS handshake on conn, bounded by the server
+// HandshakeTimeout when one is set. Handlers upgrading a connection with
+// StartTLS should use it instead of calling conn.Handshake directly, so a
+// client stalling the negotiation cannot hold the connection forever.
+func (c *client) TLSHandshake(conn *tls.Conn) error {
+	if timeout := c.srv.limits().HandshakeTimeout; timeout != 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+		defer conn.SetDeadline(time.Time{})
 	}
-	return nil, false
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	if onHandshake := c.srv.OnTLSHandshake; onHandshake != nil {
+		return onHandshake(conn.ConnectionState(), &c.settings)
+	}
+	return nil
+}
+
+// GetMessageByID returns the request in flight with messageID
+func (c *client) GetMessageByID(messageID int) (*Message, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	m, ok := c.requestList[messageID]
+	return m, ok
 }
 
 func (c *client) Addr() net.Addr {
@@ -73,7 +119,7 @@ func (c *client) Addr() net.Addr {
 }
 
 func (c *client) ReadPacket() (*messagePacket, error) {
-	mP, err := readMessagePacket(c.br)
+	mP, err := readMessagePacket(c.br, c.srv.MaxMessageSize)
 	c.rawData = make([]byte, len(mP.bytes))
 	copy(c.rawData, mP.bytes)
 	return mP, err
@@ -83,17 +129,28 @@ func (c *client) serve() {
 	defer c.close()
 
 	c.closing = make(chan bool)
+	c.ctx, c.cancel = context.WithCancel(c.srv.baseContext())
 	if onc := c.srv.onNewConnection; onc != nil {
 		if err := onc(c.rwc); err != nil {
-			log.Printf("client [%d]: onNewConnection error: %s", c.numero, err)
+			c.logAt(LogLevelWarn, "onNewConnection error: %s", err)
+			return
+		}
+	}
+	if admit := c.srv.OnAdmit; admit != nil {
+		if err := admit(c.rwc, &c.settings); err != nil {
+			c.logAt(LogLevelWarn, "connection refused: %s", err)
 			return
 		}
 	}
+	if err := c.admit(); err != nil {
+		c.logAt(LogLevelWarn, "connection refused: %s", err)
+		return
+	}
 
 	// Create the ldap response queue to be writted to client (buffered to 20)
 	// buffered to 20 means that If client is slow to handler responses, Server
 	// Handlers will stop to send more respones
-	c.chanOut = make(chan *ldap.LDAPMessage)
+	c.chanOut = make(chan *outMessage)
 	c.writeDone = make(chan bool)
 	// for each message in c.chanOut send it to client
 	go func() {
@@ -103,85 +160,152 @@ func (c *client) serve() {
 		close(c.writeDone)
 	}()
 
-	// Listen for server signal to shutdown
+	// Listen for server signal to shutdown, close waits for this goroutine
+	// to return before closing the response queue
+	c.watching = true
 	go func() {
-		for {
-			select {
-			case <-c.srv.chDone: // server signals shutdown process
-				c.wg.Add(1)
-				r := NewExtendedResponse(LDAPResultUnwillingToPerform)
-				r.SetDiagnosticMessage("server is about to stop")
-				r.SetResponseName(NoticeOfDisconnection)
-
-				m := ldap.NewLDAPMessageWithProtocolOp(r)
-
-				c.chanOut <- m
-				c.wg.Done()
-				c.rwc.SetReadDeadline(time.Now().Add(time.Millisecond))
-				return
-			case <-c.closing:
-				return
-			}
+		defer close(c.noticed)
+		select {
+		case <-c.srv.chDone: // server signals shutdown process
+			c.noticeOfDisconnection(LDAPResultUnwillingToPerform, "server is about to stop")
+			c.rwc.SetReadDeadline(time.Now().Add(time.Millisecond))
+		case <-c.closing:
 		}
 	}()
 
 	c.requestList = make(map[int]*Message)
+	c.messageIDs = make(map[int]bool)
+
+	// Clients using ldaps:// on a plaintext port start with a ClientHello
+	if c.srv.DetectTLS && !c.isTLS() {
+		if err := c.detectTLS(); err != nil {
+			if err != io.EOF {
+				c.logClientError("tls", "%s", err)
+			}
+			return
+		}
+	}
+
+	// Complete the handshake of LDAPS connections before reading the first
+	// PDU, so HandshakeTimeout applies instead of the read timeout
+	if tlsConn, ok := c.rwc.(*tls.Conn); ok {
+		if err := c.TLSHandshake(tlsConn); err != nil {
+			c.logClientError("tls", "TLS handshake error: %s", err)
+			return
+		}
+	}
 
 	for {
 
-		if c.srv.ReadTimeout != 0 {
-			c.rwc.SetReadDeadline(time.Now().Add(c.srv.ReadTimeout))
+		if readTimeout := c.readTimeout(); readTimeout != 0 {
+			c.rwc.SetReadDeadline(time.Now().Add(readTimeout))
 		}
-		if c.srv.WriteTimeout != 0 {
-			c.rwc.SetWriteDeadline(time.Now().Add(c.srv.WriteTimeout))
+		if c.settings.WriteTimeout != 0 {
+			c.rwc.SetWriteDeadline(time.Now().Add(c.settings.WriteTimeout))
 		}
 
 		//Read client input as a ASN1/BER binary message
 		messagePacket, err := c.ReadPacket()
 		if err != nil {
+			c.srv.decodeFailures.count(err)
 			if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
-				log.Printf("client [%d]: read timeout: %s", c.numero, err)
+				c.logClientError("timeout", "read timeout: %s", err)
 			} else if err != io.EOF { // do not show EOF messages
-				log.Printf("client [%d]: readMessagePacket error: %s", c.numero, err)
+				c.logClientError("read", "readMessagePacket error: %s", err)
+			}
+			if isConnLost(err) {
+				// running requests must not produce responses nobody
+				// will read
+				c.lost()
 			}
 			return
 		}
 
+		c.touch()
+
 		//Convert ASN1 binaryMessage to a ldap Message
 		message, err := messagePacket.readMessage()
 
 		if err != nil {
-			log.Printf("client [%d]: error reading message: %s", c.numero, err)
+			c.srv.decodeFailures.count(err)
+			c.logClientError("decode", "error reading message: %s", err)
 			return
 		}
 		// prints all inbound ops - no need for this
 		// log.Printf("client [%d]: <<< %s", c.numero, message.ProtocolOpName())
 
-		// TODO: Use a implementation to limit runnuning request by client
-		// solution 1 : when the buffered output channel is full, send a busy
-		// solution 2 : when 10 client requests (goroutines) are running, send a busy message
-		// And when the limit is reached THEN send a BusyLdapMessage
-
 		// When message is an UnbindRequest, stop serving
 		if _, ok := message.ProtocolOp().(ldap.UnbindRequest); ok {
 			return
 		}
 
+		// MaxConnectionOperations caps the operations run over the
+		// connection lifetime, MaxClientRequests those in flight below
+		c.operations++
+		if c.settings.MaxConnectionOperations != 0 && c.operations > c.settings.MaxConnectionOperations {
+			c.logAt(LogLevelWarn, "operation limit reached")
+			c.noticeOfDisconnection(LDAPResultAdminLimitExceeded, "operation limit reached")
+			return
+		}
+
+		// RFC 4511 section 4.2, binds with a version the server does not
+		// support are answered with protocolError
+		if version, ok := bindVersion(messagePacket.bytes); ok && version != 3 && (version != 2 || !c.srv.AllowLDAPv2) {
+			c.srv.decodeFailures.add(DecodeUnsupportedVersion)
+			c.logAt(LogLevelInfo, "bind with protocol version %d refused", version)
+			w := responseWriterImpl{chanOut: c.chanOut, messageID: message.MessageID().Int(), gone: &c.gone}
+			w.Write(NewResponseForRequest(message.ProtocolOp(), LDAPResultProtocolError, fmt.Sprintf("LDAP protocol version %d is not supported, use version 3", version)))
+			continue
+		}
+
+		if c.settings.RequireTLS && !c.isTLS() && !isStartTLS(&message) {
+			if _, ok := message.ProtocolOp().(ldap.AbandonRequest); !ok {
+				w := responseWriterImpl{chanOut: c.chanOut, messageID: message.MessageID().Int(), gone: &c.gone}
+				w.Write(NewResponseForRequest(message.ProtocolOp(), LDAPResultConfidentialityRequired, "TLS is required on this connection"))
+			}
+			continue
+		}
+
+		// RFC 4511 section 4.1.1.1, the message ID of a request in flight
+		// must not be reused
+		if !c.reserveMessageID(message.MessageID().Int()) {
+			atomic.AddInt64(&c.srv.messageIDReuses, 1)
+			c.logAt(LogLevelWarn, "message ID %d reused while in flight", message.MessageID().Int())
+			if !c.srv.AnswerMessageIDReuse {
+				c.noticeOfDisconnection(LDAPResultProtocolError, "message ID reused while in flight")
+				return
+			}
+			if _, ok := message.ProtocolOp().(ldap.AbandonRequest); !ok {
+				w := responseWriterImpl{chanOut: c.chanOut, messageID: message.MessageID().Int(), gone: &c.gone}
+				w.Write(NewResponseForRequest(message.ProtocolOp(), LDAPResultProtocolError, "message ID reused while in flight"))
+			}
+			continue
+		}
+
+		// A connection may not run more than MaxClientRequests requests at
+		// once, abandon requests are let through as they end others
+		if !c.admitRequest(&message) {
+			atomic.AddInt64(&c.srv.busyResponses, 1)
+			c.logAt(LogLevelWarn, "too many requests in flight, message ID %d answered with busy", message.MessageID().Int())
+			c.unreserveMessageID(message.MessageID().Int())
+			w := responseWriterImpl{chanOut: c.chanOut, messageID: message.MessageID().Int(), gone: &c.gone}
+			w.Write(NewResponseForRequest(message.ProtocolOp(), LDAPResultBusy, "too many requests in flight"))
+			continue
+		}
+
 		// If client requests a startTls, do not handle it in a
 		// goroutine, connection has to remain free until TLS is OK
 		// @see RFC https://tools.ietf.org/html/rfc4511#section-4.14.1
-		if req, ok := message.ProtocolOp().(ldap.ExtendedRequest); ok {
-			if req.RequestName() == NoticeOfStartTLS {
-				c.wg.Add(1)
-				c.ProcessRequestMessage(&message)
-				continue
-			}
+		if isStartTLS(&message) {
+			c.wg.Add(1)
+			c.ProcessRequestMessage(&message, c.operations)
+			continue
 		}
 
 		// TODO: go/non go routine choice should be done in the ProcessRequestMessage
 		// not in the client.serve func
 		c.wg.Add(1)
-		go c.ProcessRequestMessage(&message)
+		go c.ProcessRequestMessage(&message, c.operations)
 	}
 
 }
@@ -193,35 +317,201 @@ func (c *client) serve() {
 // * close client connection
 // * signal to server that client shutdown is ok
 func (c *client) close() {
-	log.Printf("client [%d]: closing connection", c.numero)
+	c.logAt(LogLevelInfo, "closing connection")
 	close(c.closing)
+	if c.watching {
+		<-c.noticed
+	} else {
+		close(c.noticed)
+	}
+	c.stopMaintenanceDisconnect()
 
 	// stop reading from client
 	c.rwc.SetReadDeadline(time.Now().Add(time.Millisecond))
 
-	// signals to all currently running request processor to stop
+	// signals to all currently running request processor to stop, write
+	// operations may be let complete depending on the drain policy
+	drain := c.srv.Drain
+	c.abandonRequests(TerminationDisconnected, func(m *Message) bool {
+		return drain == DrainNone || (drain == DrainWrites && !isWriteRequest(m.ProtocolOp()))
+	})
+
+	if drain != DrainNone && c.srv.DrainTimeout != 0 {
+		drained := make(chan struct{})
+		go func() {
+			c.wg.Wait()
+			close(drained)
+		}()
+		select {
+		case <-drained:
+		case <-time.After(c.srv.DrainTimeout):
+			c.logAt(LogLevelWarn, "drain timeout, abandoning remaining requests")
+			c.abandonRequests(TerminationTimedOut, func(*Message) bool { return true })
+		}
+	}
+
+	c.wg.Wait() // wait for all current running request processor to end
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	// the response queue is not set up when the connection was refused
+	if c.chanOut != nil {
+		close(c.chanOut) // No more message will be sent to client, close chanOUT
+		<-c.writeDone    // Wait for the last message sent to be written
+	}
+	c.rwc.Close() // close client connection
+	c.logAt(LogLevelInfo, "connection closed")
+
+	atomic.AddInt64(&c.srv.connections, -1)
+	c.srv.trackClient(c, false)
+	c.srv.events.emit(ConnClosed{Numero: c.numero, RemoteAddr: c.rwc.RemoteAddr()})
+
+	c.srv.wg.Done() // signal to server that client shutdown is ok
+}
+
+// abandonRequests signals the running requests selected by abandon to stop
+func (c *client) abandonRequests(reason TerminationReason, abandon func(m *Message) bool) {
 	c.mutex.Lock()
 	for _, request := range c.requestList {
-		go request.Abandon()
+		if abandon(request) {
+			go request.terminate(reason)
+		}
 	}
 	c.mutex.Unlock()
+}
 
-	c.wg.Wait()      // wait for all current running request processor to end
-	close(c.chanOut) // No more message will be sent to client, close chanOUT
+// readTimeout returns the read deadline to apply before waiting for the
+// next PDU, IdleTimeout is used when no request is in flight
+func (c *client) readTimeout() time.Duration {
+	if c.settings.IdleTimeout != 0 {
+		c.mutex.Lock()
+		idle := len(c.requestList) == 0
+		c.mutex.Unlock()
+		if idle {
+			return c.settings.IdleTimeout
+		}
+	}
+	return c.settings.ReadTimeout
+}
 
-	<-c.writeDone // Wait for the last message sent to be written
-	c.rwc.Close() // close client connection
-	log.Printf("client [%d]: connection closed", c.numero)
+// detectTLS peeks at the first byte sent by the client, when it is a TLS
+// handshake record the connection is upgraded using the server TLSConfig,
+// or an error is returned when the server has none
+func (c *client) detectTLS() error {
+	timeout := c.srv.limits().HandshakeTimeout
+	if timeout == 0 {
+		timeout = c.settings.ReadTimeout
+	}
+	if timeout != 0 {
+		c.rwc.SetReadDeadline(time.Now().Add(timeout))
+		defer c.rwc.SetReadDeadline(time.Time{})
+	}
 
-	c.srv.wg.Done() // signal to server that client shutdown is ok
+	b, err := c.br.Peek(1)
+	if err != nil {
+		return err
+	}
+	if b[0] != tlsRecordTypeHandshake {
+		return nil
+	}
+
+	if c.srv.TLSConfig == nil {
+		return fmt.Errorf("TLS ClientHello received on plaintext connection from %s, is the client using ldaps:// on a LDAP port?", c.rwc.RemoteAddr())
+	}
+	c.logAt(LogLevelInfo, "TLS ClientHello received on plaintext connection, serving TLS")
+	c.SetConn(tls.Server(&bufferedConn{Conn: c.rwc, r: c.br}, c.srv.TLSConfig))
+	return nil
+}
+
+func (c *client) isTLS() bool {
+	_, ok := c.rwc.(*tls.Conn)
+	return ok
+}
+
+// noticeOfDisconnection queues an unsolicited Notice of Disconnection
+func (c *client) noticeOfDisconnection(resultCode int, diagnosticMessage string) {
+	r := NewExtendedResponse(resultCode)
+	r.SetDiagnosticMessage(diagnosticMessage)
+	r.SetResponseName(NoticeOfDisconnection)
+
+	m := ldap.NewLDAPMessageWithProtocolOp(r)
+
+	c.chanOut <- &outMessage{message: m}
+}
+
+func isStartTLS(message *ldap.LDAPMessage) bool {
+	if req, ok := message.ProtocolOp().(ldap.ExtendedRequest); ok {
+		return req.RequestName() == NoticeOfStartTLS
+	}
+	return false
+}
+
+// outMessage is a response queued for the client, either a goldap message
+// encoded by the writer, or a protocolOp already BER encoded
+type outMessage struct {
+	messageID  int
+	terminated *int32 // request flag, intermediate responses are not written once it is set
+	message    *ldap.LDAPMessage
+	raw        []byte
+	encoded    []byte        // complete LDAPMessages, written as is
+	segments   [][]byte      // complete LDAPMessages split around large values, written in order
+	flushed    chan struct{} // closed once the messages queued before are written
 }
 
-func (c *client) writeMessage(m *ldap.LDAPMessage) {
-	data, _ := m.Write()
-	// prints all outgoind ops (include all search entries) - no need for this
-	// log.Printf("client [%d]: >>> %s", c.numero, m.ProtocolOpName())
-	c.bw.Write(data.Bytes())
-	c.bw.Flush()
+func (c *client) writeMessage(m *outMessage) {
+	if m.flushed != nil {
+		close(m.flushed)
+		return
+	}
+	if atomic.LoadInt32(&c.gone) == 1 {
+		return
+	}
+	if m.terminated != nil && atomic.LoadInt32(m.terminated) == 1 {
+		atomic.AddInt64(&c.srv.droppedResponses, 1)
+		return
+	}
+	c.touch()
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if m.encoded != nil {
+		c.bw.Write(m.encoded)
+	} else if m.segments != nil {
+		// large segments bypass the buffer, they are not copied
+		for _, segment := range m.segments {
+			c.bw.Write(segment)
+		}
+	} else if m.message != nil {
+		data, _ := m.message.Write()
+		// prints all outgoind ops (include all search entries) - no need for this
+		// log.Printf("client [%d]: >>> %s", c.numero, m.ProtocolOpName())
+		c.bw.Write(data.Bytes())
+	} else {
+		c.bw.Write(berSequence(berInteger(berTagInteger, int64(m.messageID)), m.raw))
+	}
+	if err := c.bw.Flush(); err != nil && isConnLost(err) {
+		c.lost()
+	}
+}
+
+// lost marks the client connection as lost: responses are not written
+// anymore, and the contexts of the running requests are canceled, even
+// those the drain policy lets complete
+func (c *client) lost() {
+	atomic.StoreInt32(&c.gone, 1)
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// isConnLost reports whether err tells the client connection is lost, as
+// opposed to a timeout or a malformed message
+func isConnLost(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	opErr, ok := err.(*net.OpError)
+	return ok && !opErr.Timeout()
 }
 
 // ResponseWriter interface is used by an LDAP handler to
@@ -232,40 +522,321 @@ type ResponseWriter interface {
 	WriteMessage(m *ldap.LDAPMessage)
 }
 
+// RawWriter is implemented by the ResponseWriters writing BER encoded
+// protocolOps as is, see WriteRaw
+type RawWriter interface {
+	// WriteRaw writes a BER encoded protocolOp, for responses goldap can't
+	// build such as extended responses carrying a value.
+	WriteRaw(protocolOp []byte)
+}
+
+// RawControlsWriter is implemented by the ResponseWriters writing BER
+// encoded protocolOps and response controls as is, see
+// WriteRawWithControls
+type RawControlsWriter interface {
+	// WriteRawWithControls writes a BER encoded protocolOp with BER
+	// encoded response controls.
+	WriteRawWithControls(protocolOp []byte, controls [][]byte)
+}
+
+// EntriesWriter is implemented by the ResponseWriters writing entries in
+// bulk, see WriteEntries
+type EntriesWriter interface {
+	// WriteEntries writes the entries as SearchResultEntry messages, they
+	// are encoded in a single buffer and flushed at once. Values of 64 KiB
+	// or more are not copied but written from the entries, which must not
+	// be modified once passed. It returns ErrClientGone once the client
+	// connection is lost, or ErrOperationTerminated once the request is
+	// abandoned or canceled, so backends can stop fetching entries.
+	WriteEntries(entries []Entry) error
+}
+
+// WriteRaw writes the BER encoded protocolOp with w, see
+// WriteRawWithControls
+func WriteRaw(w ResponseWriter, protocolOp []byte) error {
+	if rw, ok := w.(RawWriter); ok {
+		rw.WriteRaw(protocolOp)
+		return nil
+	}
+	return WriteRawWithControls(w, protocolOp, nil)
+}
+
+// WriteRawWithControls writes the BER encoded protocolOp and response
+// controls with w, as is when it is a RawControlsWriter. They are decoded
+// with goldap and written with WriteMessage otherwise, the error reports a
+// protocolOp goldap can't decode.
+func WriteRawWithControls(w ResponseWriter, protocolOp []byte, controls [][]byte) error {
+	if rw, ok := w.(RawControlsWriter); ok {
+		rw.WriteRawWithControls(protocolOp, controls)
+		return nil
+	}
+	m, err := decodeMessage(encodeRawMessage(0, protocolOp, controls))
+	if err != nil {
+		return err
+	}
+	w.WriteMessage(&m)
+	return nil
+}
+
+// WriteEntries writes the entries as SearchResultEntry messages with w, in
+// bulk when it is an EntriesWriter, one at a time with WriteRaw otherwise
+func WriteEntries(w ResponseWriter, entries []Entry) error {
+	if ew, ok := w.(EntriesWriter); ok {
+		return ew.WriteEntries(entries)
+	}
+	for i := range entries {
+		if err := WriteRaw(w, appendSearchResultEntry(nil, &entries[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type responseWriterImpl struct {
-	chanOut   chan *ldap.LDAPMessage
-	messageID int
+	chanOut    chan *outMessage
+	messageID  int
+	terminal   int32  // set once a terminal response was written
+	gone       *int32 // client flag set once the connection is lost
+	terminated *int32 // request flag set once it is abandoned, canceled or timed out
+	dropped    *int64 // server count of the intermediate responses dropped
 }
 
-func (w responseWriterImpl) Write(po ldap.ProtocolOp) {
+func (w *responseWriterImpl) Write(po ldap.ProtocolOp) {
+	terminal := isTerminalResponse(po)
+	if w.drop(terminal) {
+		return
+	}
 	m := ldap.NewLDAPMessageWithProtocolOp(po)
 	ldap.SetMessageID(m, w.messageID)
-	w.chanOut <- m
+	w.track(terminal)
+	w.chanOut <- w.intermediate(&outMessage{messageID: w.messageID, message: m}, terminal)
 }
 
-func (w responseWriterImpl) WriteMessage(m *ldap.LDAPMessage) {
+func (w *responseWriterImpl) WriteMessage(m *ldap.LDAPMessage) {
+	terminal := isTerminalResponse(m.ProtocolOp())
+	if w.drop(terminal) {
+		return
+	}
 	ldap.SetMessageID(m, w.messageID)
-	w.chanOut <- m
+	w.track(terminal)
+	w.chanOut <- w.intermediate(&outMessage{messageID: w.messageID, message: m}, terminal)
+}
+
+func (w *responseWriterImpl) WriteRaw(protocolOp []byte) {
+	terminal := isTerminalRaw(protocolOp)
+	if w.drop(terminal) {
+		return
+	}
+	w.track(terminal)
+	w.chanOut <- w.intermediate(&outMessage{messageID: w.messageID, raw: protocolOp}, terminal)
+}
+
+func (w *responseWriterImpl) WriteRawWithControls(protocolOp []byte, controls [][]byte) {
+	if len(controls) == 0 {
+		w.WriteRaw(protocolOp)
+		return
+	}
+	terminal := isTerminalRaw(protocolOp)
+	if w.drop(terminal) {
+		return
+	}
+	w.track(terminal)
+	w.chanOut <- w.intermediate(&outMessage{messageID: w.messageID, encoded: encodeRawMessage(w.messageID, protocolOp, controls)}, terminal)
+}
+
+// drop reports whether an intermediate response, a search entry for
+// instance, must be dropped rather than encoded because the request is
+// terminated. The terminal responses, such as the canceled result of a
+// Cancel operation, are always written.
+func (w *responseWriterImpl) drop(terminal bool) bool {
+	if terminal || w.terminated == nil || atomic.LoadInt32(w.terminated) == 0 {
+		return false
+	}
+	atomic.AddInt64(w.dropped, 1)
+	return true
+}
+
+// intermediate sets the request flag on the intermediate response m, so
+// the writer goroutine drops it when the request is terminated while it
+// is queued
+func (w *responseWriterImpl) intermediate(m *outMessage, terminal bool) *outMessage {
+	if !terminal {
+		m.terminated = w.terminated
+	}
+	return m
+}
+
+// isTerminalRaw reports whether the BER encoded protocolOp ends an
+// operation, as isTerminalResponse
+func isTerminalRaw(protocolOp []byte) bool {
+	if len(protocolOp) == 0 {
+		return false
+	}
+	switch protocolOp[0] & 0x1f {
+	case ApplicationSearchResultEntry, ApplicationSearchResultReference, ApplicationIntermediateResponse:
+		return false
+	}
+	return true
+}
+
+// encodeRawMessage returns the LDAPMessage holding the BER encoded
+// protocolOp and controls
+func encodeRawMessage(messageID int, protocolOp []byte, controls [][]byte) []byte {
+	elements := [][]byte{berInteger(berTagInteger, int64(messageID)), protocolOp}
+	if len(controls) > 0 {
+		elements = append(elements, berConstructedTLV(berClassContext|berConstructed|0, controls...))
+	}
+	return berSequence(elements...)
+}
+
+func (w *responseWriterImpl) track(terminal bool) {
+	if terminal {
+		atomic.StoreInt32(&w.terminal, 1)
+	}
 }
 
-func (c *client) ProcessRequestMessage(message *ldap.LDAPMessage) {
+// responded reports whether a terminal response was written
+func (w *responseWriterImpl) responded() bool {
+	return atomic.LoadInt32(&w.terminal) == 1
+}
+
+// isTerminalResponse reports whether po ends an operation, search entries,
+// references and intermediate responses do not
+func isTerminalResponse(po ldap.ProtocolOp) bool {
+	switch po.(type) {
+	case ldap.SearchResultEntry, ldap.SearchResultReference, ldap.IntermediateResponse:
+		return false
+	}
+	return true
+}
+
+// clientGone reports whether the client connection is lost
+func (w *responseWriterImpl) clientGone() bool {
+	return w.gone != nil && atomic.LoadInt32(w.gone) == 1
+}
+
+func (w *responseWriterImpl) WriteEntries(entries []Entry) error {
+	if w.clientGone() {
+		return ErrClientGone
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if w.drop(false) {
+		return ErrOperationTerminated
+	}
+
+	n, large := 0, 0
+	for i := range entries {
+		entryLen, _ := entries[i].searchResultEntryLen()
+		n += berTLVLen(entryLen) + 16 // room for the message envelope
+		large += entries[i].largeValuesLen()
+	}
+	enc := entryEncoder{buf: make([]byte, 0, n-large), large: large > 0}
+	for i := range entries {
+		enc.searchResultEntryMessage(w.messageID, &entries[i])
+	}
+
+	if enc.segments != nil {
+		w.chanOut <- w.intermediate(&outMessage{messageID: w.messageID, segments: enc.done()}, false)
+		return nil
+	}
+	w.chanOut <- w.intermediate(&outMessage{messageID: w.messageID, encoded: enc.buf}, false)
+	return nil
+}
+
+func (c *client) ProcessRequestMessage(message *ldap.LDAPMessage, sequence int) {
 	defer c.wg.Done()
 
+	parent := c.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
 	var m Message
 	m = Message{
 		LDAPMessage: message,
 		Done:        make(chan bool, 2),
 		Client:      c,
+		ctx:         ctx,
+		cancel:      cancel,
+		received:    time.Now(),
+		sequence:    sequence,
+		finished:    make(chan struct{}),
 	}
 
+	// the search limits bound the request Context, before m is registered
+	limits := c.srv.newLimitWriter(&m)
+	defer limits.stop()
+
 	c.registerRequest(&m)
 	defer c.unregisterRequest(&m)
+	defer close(m.finished)
 
 	var w responseWriterImpl
 	w.chanOut = c.chanOut
 	w.messageID = m.MessageID().Int()
+	w.gone = &c.gone
+	w.terminated = &m.terminated
+	w.dropped = &c.srv.droppedResponses
+
+	operation := m.ProtocolOpName()
+	c.srv.events.emit(OpStarted{Numero: c.numero, MessageID: w.messageID, Operation: operation})
+	start := time.Now()
+
+	var rw ResponseWriter = &w
+	if c.journal != nil {
+		cw := &countingWriter{ResponseWriter: &w, resultCode: -1}
+		seq := c.journal.start(&m)
+		defer c.journal.finish(seq, cw)
+		defer func() {
+			if r := recover(); r != nil {
+				c.srv.dumpJournals(c, r)
+				panic(r)
+			}
+		}()
+		rw = cw
+	}
 
-	c.srv.Handler.ServeLDAP(w, &m)
+	// the search limits apply to the entries left by the transforms
+	lw := limits.wrap(rw)
+	// binds reset the connection to anonymous, even when refused
+	hw := newBindStateWriter(newTransformWriter(lw, &m, c.srv.entryTransform(&m)), &m)
+	if res, release := c.srv.checkRequest(c.handler, &m); res != nil {
+		lw.Write(res)
+	} else {
+		if !c.srv.serveBuiltin(c.handler, hw, &m) {
+			c.srv.serveHandler(c.handler, hw, &m)
+		}
+		release()
+	}
+	limits.stop()
+
+	if !w.responded() && atomic.LoadInt32(&m.cancelRequested) == 1 {
+		// RFC 3909, the cancelled operation is answered with canceled
+		if res := NewResponseForRequest(m.ProtocolOp(), LDAPResultCanceled, ""); res != nil {
+			rw.Write(res)
+			atomic.StoreInt32(&m.canceled, 1)
+		}
+	}
+	if !w.responded() && !c.srv.MissingResponse.Disabled {
+		resultCode := c.srv.MissingResponse.ResultCode
+		if resultCode == 0 {
+			resultCode = LDAPResultOperationsError
+		}
+		diagnosticMessage := c.srv.MissingResponse.DiagnosticMessage
+		if diagnosticMessage == "" {
+			diagnosticMessage = "request handler returned without response"
+		}
+		if res := NewResponseForRequest(m.ProtocolOp(), resultCode, diagnosticMessage); res != nil {
+			m.logAt(LogLevelWarn, "no response written to %s", operation)
+			rw.Write(res)
+		}
+	}
+
+	c.srv.events.emit(OpFinished{Numero: c.numero, MessageID: w.messageID, Operation: operation, Duration: time.Since(start), FilterCost: m.filterCost, NamingContext: m.NamingContext()})
 }
 
 func (c *client) registerRequest(m *Message) {
@@ -277,5 +848,42 @@ func (c *client) registerRequest(m *Message) {
 func (c *client) unregisterRequest(m *Message) {
 	c.mutex.Lock()
 	delete(c.requestList, m.MessageID().Int())
+	delete(c.messageIDs, m.MessageID().Int())
+	c.mutex.Unlock()
+}
+
+// reserveMessageID reserves the message ID of a request read until it is
+// unregistered, it returns false when a request in flight uses it
+func (c *client) reserveMessageID(messageID int) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.messageIDs[messageID] {
+		return false
+	}
+	c.messageIDs[messageID] = true
+	return true
+}
+
+// unreserveMessageID releases the message ID of a request read which is
+// not served
+func (c *client) unreserveMessageID(messageID int) {
+	c.mutex.Lock()
+	delete(c.messageIDs, messageID)
 	c.mutex.Unlock()
 }
+
+// admitRequest reports whether the request m, whose message ID is
+// reserved, is within the MaxClientRequests limit of the server
+func (c *client) admitRequest(m *ldap.LDAPMessage) bool {
+	max := c.srv.MaxClientRequests
+	if max <= 0 {
+		return true
+	}
+	if _, ok := m.ProtocolOp().(ldap.AbandonRequest); ok {
+		return true
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// reserved message IDs are those of the requests in flight, m included
+	return len(c.messageIDs) <= max
+}
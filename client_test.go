@@ -0,0 +1,134 @@
+package ldapserver
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// readTestMessage reads the next message the server sent on conn
+func readTestMessage(t *testing.T, br *bufio.Reader) (ldap.LDAPMessage, error) {
+	t.Helper()
+	bytes, err := readLdapMessageBytes(br, 0)
+	if err != nil {
+		return ldap.LDAPMessage{}, err
+	}
+	return decodeMessage(*bytes)
+}
+
+func TestMessageIDReuse(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer bool
+	}{
+		{"disconnect", false},
+		{"answer", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			routes := NewRouteMux()
+			routes.Search(func(w ResponseWriter, m *Message) {
+				<-release
+				w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+			})
+			s := NewServer()
+			s.AnswerMessageIDReuse = tt.answer
+			s.Handle(routes)
+			addr := serveTest(t, s)
+			defer s.Stop()
+
+			conn, err := net.Dial("tcp", addr.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			search := encodeRawMessage(1, testSearchRequest("dc=example,dc=com", 0, 0), nil)
+			if _, err := conn.Write(append(append([]byte(nil), search...), search...)); err != nil {
+				t.Fatal(err)
+			}
+
+			br := bufio.NewReader(conn)
+			msg, err := readTestMessage(t, br)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code, _ := resultCodeOf(&msg); code != LDAPResultProtocolError {
+				t.Errorf("result code %d, want %d", code, LDAPResultProtocolError)
+			}
+			_, notice := msg.ProtocolOp().(ldap.ExtendedResponse)
+			if tt.answer {
+				if notice || msg.MessageID().Int() != 1 {
+					t.Fatalf("%s with message ID %d, want the search answered", msg.ProtocolOpName(), msg.MessageID().Int())
+				}
+			} else if !notice || msg.MessageID().Int() != 0 {
+				t.Fatalf("%s with message ID %d, want a Notice of Disconnection", msg.ProtocolOpName(), msg.MessageID().Int())
+			}
+			close(release)
+
+			if !tt.answer {
+				// the request in flight may still be answered before the
+				// connection is closed
+				for err == nil {
+					_, err = readTestMessage(t, br)
+				}
+				var ne net.Error
+				if errors.As(err, &ne) && ne.Timeout() {
+					t.Fatal("connection not closed")
+				}
+				return
+			}
+			msg, err = readTestMessage(t, br)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code, _ := resultCodeOf(&msg); code != LDAPResultSuccess || msg.MessageID().Int() != 1 {
+				t.Errorf("result code %d with message ID %d, want the search done", code, msg.MessageID().Int())
+			}
+		})
+	}
+}
+
+func TestMaxConnectionOperations(t *testing.T) {
+	routes := NewRouteMux()
+	routes.Search(func(w ResponseWriter, m *Message) {
+		w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+	})
+	s := NewServer()
+	s.MaxConnectionOperations = 2
+	s.Handle(routes)
+	addr := serveTest(t, s)
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+
+	// the operations are counted once answered, not only while in flight
+	for id := 1; id <= 3; id++ {
+		if _, err := conn.Write(encodeRawMessage(id, testSearchRequest("dc=example,dc=com", 0, 0), nil)); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := readTestMessage(t, br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, _ := resultCodeOf(&msg)
+		_, notice := msg.ProtocolOp().(ldap.ExtendedResponse)
+		if id <= 2 && (notice || code != LDAPResultSuccess) {
+			t.Errorf("operation %d: %s with result code %d, want the search done", id, msg.ProtocolOpName(), code)
+		}
+		if id == 3 && (!notice || code != LDAPResultAdminLimitExceeded) {
+			t.Errorf("operation %d: %s with result code %d, want a Notice of Disconnection", id, msg.ProtocolOpName(), code)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package ldapserver
+
+import (
+	"fmt"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// filterComplexity returns the nesting depth and the number of terms of a
+// filter. Terms are the items of the filter, including and, or and not.
+func filterComplexity(f ldap.Filter) (depth int, terms int) {
+	var children []ldap.Filter
+	switch f := f.(type) {
+	case ldap.FilterAnd:
+		children = f
+	case ldap.FilterOr:
+		children = f
+	case ldap.FilterNot:
+		children = []ldap.Filter{f.Filter}
+	}
+
+	maxDepth := 0
+	terms = 1
+	for _, child := range children {
+		d, t := filterComplexity(child)
+		if d > maxDepth {
+			maxDepth = d
+		}
+		terms += t
+	}
+	return maxDepth + 1, terms
+}
+
+// checkComplexity returns an adminLimitExceeded response when the request
+// exceeds one of the complexity limits
+func checkComplexity(po ldap.ProtocolOp, l Limits) ldap.ProtocolOp {
+	var err error
+	switch r := po.(type) {
+	case ldap.SearchRequest:
+		depth, terms := filterComplexity(r.Filter())
+		switch {
+		case l.MaxFilterDepth > 0 && depth > l.MaxFilterDepth:
+			err = fmt.Errorf("filter nesting depth exceeds %d", l.MaxFilterDepth)
+		case l.MaxFilterTerms > 0 && terms > l.MaxFilterTerms:
+			err = fmt.Errorf("number of filter terms exceeds %d", l.MaxFilterTerms)
+		case l.MaxRequestedAttributes > 0 && len(r.Attributes()) > l.MaxRequestedAttributes:
+			err = fmt.Errorf("number of requested attributes exceeds %d", l.MaxRequestedAttributes)
+		}
+	case ldap.ModifyRequest:
+		if l.MaxModifyChanges > 0 && len(r.Changes()) > l.MaxModifyChanges {
+			err = fmt.Errorf("number of changes exceeds %d", l.MaxModifyChanges)
+		}
+	}
+	if err != nil {
+		return NewResponseForRequest(po, LDAPResultAdminLimitExceeded, err.Error())
+	}
+	return nil
+}
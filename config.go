@@ -0,0 +1,151 @@
+package ldapserver
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config is a serializable description of a Server, meant to be decoded
+// from an application configuration file (JSON, YAML, TOML...)
+type Config struct {
+	Listen                  []string  `json:"listen" yaml:"listen"`                                   // addresses serving plaintext LDAP
+	ListenTLS               []string  `json:"listenTLS" yaml:"listenTLS"`                             // addresses serving LDAPS
+	TLSCertFile             string    `json:"tlsCertFile" yaml:"tlsCertFile"`                         // PEM certificate chain
+	TLSKeyFile              string    `json:"tlsKeyFile" yaml:"tlsKeyFile"`                           // PEM private key
+	DetectTLS               bool      `json:"detectTLS" yaml:"detectTLS"`                             // serve TLS ClientHello received on plaintext addresses
+	AllowLDAPv2             bool      `json:"allowLDAPv2" yaml:"allowLDAPv2"`                         // accept binds of LDAPv2 clients
+	ReadTimeout             Duration  `json:"readTimeout" yaml:"readTimeout"`                         // read timeout
+	WriteTimeout            Duration  `json:"writeTimeout" yaml:"writeTimeout"`                       // write timeout
+	HandshakeTimeout        Duration  `json:"handshakeTimeout" yaml:"handshakeTimeout"`               // TLS handshake timeout
+	IdleTimeout             Duration  `json:"idleTimeout" yaml:"idleTimeout"`                         // read timeout while no request is in flight
+	MaxConnectionOperations int       `json:"maxConnectionOperations" yaml:"maxConnectionOperations"` // operations accepted over a connection lifetime
+	RequireTLS              bool      `json:"requireTLS" yaml:"requireTLS"`                           // only accept StartTLS until TLS is established
+	MaxConnections          int       `json:"maxConnections" yaml:"maxConnections"`                   // connections served at the same time
+	AcceptRate              float64   `json:"acceptRate" yaml:"acceptRate"`                           // connections accepted per second
+	AcceptBurst             int       `json:"acceptBurst" yaml:"acceptBurst"`                         // connections accepted at once above acceptRate
+	NoticeOnRefusal         bool      `json:"noticeOnRefusal" yaml:"noticeOnRefusal"`                 // send a Notice of Disconnection to refused connections
+	Log                     LogConfig `json:"log" yaml:"log"`
+
+	GreetingDelay      Duration `json:"greetingDelay" yaml:"greetingDelay"`           // delay before the first PDU is read
+	RejectEarlyTalkers bool     `json:"rejectEarlyTalkers" yaml:"rejectEarlyTalkers"` // disconnect clients sending data that is not LDAP before greetingDelay
+	IdleReapInterval   Duration `json:"idleReapInterval" yaml:"idleReapInterval"`     // interval of the scans disconnecting connections idle beyond idleTimeout
+	IdleNotice         bool     `json:"idleNotice" yaml:"idleNotice"`                 // send a Notice of Disconnection to reaped connections
+
+	MaxFilterDepth         int `json:"maxFilterDepth" yaml:"maxFilterDepth"`                 // nesting depth of search filters
+	MaxFilterTerms         int `json:"maxFilterTerms" yaml:"maxFilterTerms"`                 // terms of search filters
+	MaxRequestedAttributes int `json:"maxRequestedAttributes" yaml:"maxRequestedAttributes"` // attributes requested by a search
+	MaxModifyChanges       int `json:"maxModifyChanges" yaml:"maxModifyChanges"`             // changes of a modify request
+
+	MaxValueSize          int            `json:"maxValueSize" yaml:"maxValueSize"`                   // bytes of the values of add and modify requests
+	MaxAttributeValueSize map[string]int `json:"maxAttributeValueSize" yaml:"maxAttributeValueSize"` // maxValueSize by attribute name
+
+	ReadOnly bool `json:"readOnly" yaml:"readOnly"` // refuse write operations
+
+	Maintenance           bool     `json:"maintenance" yaml:"maintenance"`                     // refuse all operations but binds
+	MaintenanceMessage    string   `json:"maintenanceMessage" yaml:"maintenanceMessage"`       // diagnostic message of refused operations
+	MaintenanceDisconnect Duration `json:"maintenanceDisconnect" yaml:"maintenanceDisconnect"` // delay before refused clients are disconnected
+}
+
+// LogConfig describes where the server logs go
+type LogConfig struct {
+	Disabled bool     `json:"disabled" yaml:"disabled"` // discard all server logs
+	Level    LogLevel `json:"level" yaml:"level"`       // minimum level of logged messages
+	File     string   `json:"file" yaml:"file"`         // append logs to this file instead of the standard logger
+	Prefix   string   `json:"prefix" yaml:"prefix"`     // prefix of each log line
+
+	ClientErrorBurst    int      `json:"clientErrorBurst" yaml:"clientErrorBurst"`       // client errors logged per host and class each interval, unlimited if zero
+	ClientErrorInterval Duration `json:"clientErrorInterval" yaml:"clientErrorInterval"` // interval of clientErrorBurst, one minute if zero
+}
+
+// Duration is a time.Duration serialized as a string such as "30s" or "1m30s"
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// NewServerFromConfig returns a LDAP Server set up from cfg. The TLS
+// material is loaded immediately, so errors are reported before serving.
+// Use ListenAndServeConfig to serve the configured addresses. The log file
+// opened is closed once the server is stopped.
+func NewServerFromConfig(cfg Config) (*Server, error) {
+	s := NewServer()
+	s.config = cfg
+	s.ReadTimeout = time.Duration(cfg.ReadTimeout)
+	s.WriteTimeout = time.Duration(cfg.WriteTimeout)
+	s.HandshakeTimeout = time.Duration(cfg.HandshakeTimeout)
+	s.IdleTimeout = time.Duration(cfg.IdleTimeout)
+	s.MaxConnectionOperations = cfg.MaxConnectionOperations
+	s.RequireTLS = cfg.RequireTLS
+	s.DetectTLS = cfg.DetectTLS
+	s.AllowLDAPv2 = cfg.AllowLDAPv2
+	s.MaxConnections = cfg.MaxConnections
+	s.AcceptRate = cfg.AcceptRate
+	s.AcceptBurst = cfg.AcceptBurst
+	s.NoticeOnRefusal = cfg.NoticeOnRefusal
+	s.GreetingDelay = time.Duration(cfg.GreetingDelay)
+	s.RejectEarlyTalkers = cfg.RejectEarlyTalkers
+	s.IdleReapInterval = time.Duration(cfg.IdleReapInterval)
+	s.IdleNotice = cfg.IdleNotice
+	s.LogLevel = cfg.Log.Level
+	s.ClientErrorLogLimit = LogRateLimit{Burst: cfg.Log.ClientErrorBurst, Interval: time.Duration(cfg.Log.ClientErrorInterval)}
+	s.MaxFilterDepth = cfg.MaxFilterDepth
+	s.MaxFilterTerms = cfg.MaxFilterTerms
+	s.MaxRequestedAttributes = cfg.MaxRequestedAttributes
+	s.MaxModifyChanges = cfg.MaxModifyChanges
+	s.MaxValueSize = cfg.MaxValueSize
+	s.MaxAttributeValueSize = make(map[string]int, len(cfg.MaxAttributeValueSize))
+	for name, max := range cfg.MaxAttributeValueSize {
+		s.MaxAttributeValueSize[strings.ToLower(name)] = max
+	}
+	s.ReadOnly = cfg.ReadOnly
+	s.Maintenance = cfg.Maintenance
+	s.MaintenanceMessage = cfg.MaintenanceMessage
+	s.MaintenanceDisconnect = time.Duration(cfg.MaintenanceDisconnect)
+
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+		tlsConfig, err := s.loadTLSConfig(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		s.TLSConfig = tlsConfig
+	} else if len(cfg.ListenTLS) > 0 {
+		return nil, fmt.Errorf("error creating server: TLS addresses configured without certificate")
+	}
+
+	switch {
+	case cfg.Log.Disabled:
+		s.ErrorLog = log.New(io.Discard, "", 0)
+	case cfg.Log.File != "":
+		f, err := os.OpenFile(cfg.Log.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("error opening log file: %s", err)
+		}
+		s.ErrorLog = log.New(f, cfg.Log.Prefix, log.LstdFlags)
+		s.OnShutdown(ShutdownClosed, func() { f.Close() })
+	case cfg.Log.Prefix != "":
+		s.ErrorLog = log.New(os.Stderr, cfg.Log.Prefix, log.LstdFlags)
+	}
+
+	return s, nil
+}
+
+// ListenAndServeConfig listens on every address of the server Config,
+// plaintext and TLS, and serves them until the server stops. Listening
+// errors are sent on ch, which is closed once all addresses are bound.
+func (s *Server) ListenAndServeConfig(ch chan error, options ...func(*Server)) {
+	s.ListenAndServeAddrs(s.config.Listen, s.config.ListenTLS, ch, options...)
+}
@@ -0,0 +1,20 @@
+package ldapserver
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigLogFileClosed(t *testing.T) {
+	s, err := NewServerFromConfig(Config{Log: LogConfig{File: filepath.Join(t.TempDir(), "ldap.log")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ErrorLog.Output(1, "serving"); err != nil {
+		t.Fatalf("log file not writable: %s", err)
+	}
+	s.Close()
+	if err := s.ErrorLog.Output(1, "stopped"); err == nil {
+		t.Error("log file not closed once stopped")
+	}
+}
@@ -0,0 +1,342 @@
+package ldapserver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// ConfigDN is the DN of the entry exposing the server configuration
+const ConfigDN = "cn=config"
+
+// ConfigSchemaDN is the DN of the entry exposing the schema set with
+// Server.SetSchema, below ConfigDN
+const ConfigSchemaDN = "cn=schema," + ConfigDN
+
+// ConfigBackendOptions configures the cn=config backend
+type ConfigBackendOptions struct {
+	// Authorize reports whether the client sending m may read (write is
+	// false) or modify (write is true) the configuration. All requests are
+	// refused when nil.
+	Authorize func(m *Message, write bool) bool
+}
+
+// configAttribute maps a cn=config attribute to a server limit
+type configAttribute struct {
+	name string
+	get  func(l *Limits) string
+	set  func(l *Limits, value string) error
+}
+
+func durationAttribute(name string, field func(l *Limits) *time.Duration) configAttribute {
+	return configAttribute{
+		name: name,
+		get:  func(l *Limits) string { return field(l).String() },
+		set: func(l *Limits, value string) error {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			*field(l) = d
+			return nil
+		},
+	}
+}
+
+func intAttribute(name string, field func(l *Limits) *int) configAttribute {
+	return configAttribute{
+		name: name,
+		get:  func(l *Limits) string { return strconv.Itoa(*field(l)) },
+		set: func(l *Limits, value string) error {
+			i, err := strconv.Atoi(value)
+			if err != nil {
+				return err
+			}
+			*field(l) = i
+			return nil
+		},
+	}
+}
+
+func boolAttribute(name string, field func(l *Limits) *bool) configAttribute {
+	return configAttribute{
+		name: name,
+		get: func(l *Limits) string {
+			if *field(l) {
+				return "TRUE"
+			}
+			return "FALSE"
+		},
+		set: func(l *Limits, value string) error {
+			switch value {
+			case "TRUE":
+				*field(l) = true
+			case "FALSE":
+				*field(l) = false
+			default:
+				return fmt.Errorf("%q is not a boolean", value)
+			}
+			return nil
+		},
+	}
+}
+
+var configAttributes = []configAttribute{
+	durationAttribute("olcReadTimeout", func(l *Limits) *time.Duration { return &l.ReadTimeout }),
+	durationAttribute("olcWriteTimeout", func(l *Limits) *time.Duration { return &l.WriteTimeout }),
+	durationAttribute("olcHandshakeTimeout", func(l *Limits) *time.Duration { return &l.HandshakeTimeout }),
+	durationAttribute("olcIdleTimeout", func(l *Limits) *time.Duration { return &l.IdleTimeout }),
+	intAttribute("olcMaxConnectionOperations", func(l *Limits) *int { return &l.MaxConnectionOperations }),
+	intAttribute("olcMaxConnections", func(l *Limits) *int { return &l.MaxConnections }),
+	intAttribute("olcAcceptBurst", func(l *Limits) *int { return &l.AcceptBurst }),
+	intAttribute("olcMaxFilterDepth", func(l *Limits) *int { return &l.MaxFilterDepth }),
+	intAttribute("olcMaxFilterTerms", func(l *Limits) *int { return &l.MaxFilterTerms }),
+	intAttribute("olcMaxRequestedAttributes", func(l *Limits) *int { return &l.MaxRequestedAttributes }),
+	intAttribute("olcMaxModifyChanges", func(l *Limits) *int { return &l.MaxModifyChanges }),
+	boolAttribute("olcReadOnly", func(l *Limits) *bool { return &l.ReadOnly }),
+	boolAttribute("olcMaintenance", func(l *Limits) *bool { return &l.Maintenance }),
+	durationAttribute("olcMaintenanceDisconnect", func(l *Limits) *time.Duration { return &l.MaintenanceDisconnect }),
+	{
+		name: "olcMaintenanceMessage",
+		get:  func(l *Limits) string { return l.MaintenanceMessage },
+		set:  func(l *Limits, value string) error { l.MaintenanceMessage = value; return nil },
+	},
+	{
+		name: "olcAcceptRate",
+		get:  func(l *Limits) string { return strconv.FormatFloat(l.AcceptRate, 'g', -1, 64) },
+		set: func(l *Limits, value string) error {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return err
+			}
+			l.AcceptRate = f
+			return nil
+		},
+	},
+	{
+		name: "olcLogLevel",
+		get:  func(l *Limits) string { return l.LogLevel.String() },
+		set:  func(l *Limits, value string) error { return l.LogLevel.UnmarshalText([]byte(value)) },
+	},
+}
+
+func findConfigAttribute(name string) (configAttribute, bool) {
+	for _, a := range configAttributes {
+		if strings.EqualFold(a.name, name) {
+			return a, true
+		}
+	}
+	return configAttribute{}, false
+}
+
+// ConfigBackend routes searches of the cn=config subtree and modifications
+// of the cn=config entry, OpenLDAP style. The cn=config entry exposes the
+// server runtime Limits, those left at their zero value are not listed.
+// Modifications apply immediately, as with Server.Settings(), and honor the
+// No-Op control. The cn=schema,cn=config entry lists the schema set with
+// Server.SetSchema, read-only. The access controls (ReadAccess,
+// WriteAccess...) are functions without a textual form, they are not
+// exposed.
+func (h *RouteMux) ConfigBackend(opts ConfigBackendOptions) {
+	h.SupportControls(ControlNoOp)
+	h.Search(func(w ResponseWriter, m *Message) {
+		handleConfigSearch(w, m, opts)
+	}).BaseDnSuffix(ConfigDN).Label("Config - Search")
+
+	h.Modify(func(w ResponseWriter, m *Message) {
+		handleConfigModify(w, m, opts)
+	}).BaseDn(ConfigDN).Label("Config - Modify").config = true
+}
+
+// configRouter is implemented by the handlers routing requests to the
+// ConfigBackend, see RouteMux.ConfigBackend
+type configRouter interface {
+	routesToConfig(m *Message) bool
+}
+
+// routesToConfigBackend reports whether handler serves m with the
+// ConfigBackend modify route, which stays possible in read-only and
+// maintenance modes so that they can be switched off. Requests served by other handlers, a Proxy
+// or a backend holding a cn=config entry, are not.
+func routesToConfigBackend(handler Handler, m *Message) bool {
+	router, ok := handler.(configRouter)
+	return ok && router.routesToConfig(m)
+}
+
+func handleConfigSearch(w ResponseWriter, m *Message, opts ConfigBackendOptions) {
+	if opts.Authorize == nil || !opts.Authorize(m, false) {
+		res := NewSearchResultDoneResponse(LDAPResultInsufficientAccessRights)
+		res.SetDiagnosticMessage("access to the configuration is not allowed")
+		w.Write(res)
+		return
+	}
+
+	if m.Client == nil {
+		w.Write(NewSearchResultDoneResponse(LDAPResultOperationsError))
+		return
+	}
+
+	r := m.GetSearchRequest()
+	base, err := ParseDN(string(r.BaseObject()))
+	if err != nil {
+		w.Write(NewSearchResultDoneResponse(LDAPResultInvalidDNSyntax))
+		return
+	}
+	var matches []Entry
+	baseFound := false
+	for _, e := range configEntries(m.Client.srv) {
+		dn, _ := ParseDN(e.DN)
+		if !dn.IsDescendantOf(base, true) {
+			continue
+		}
+		baseFound = baseFound || dn.Equal(base)
+
+		var inScope bool
+		switch int(r.Scope()) {
+		case SearchRequestScopeBaseObject:
+			inScope = dn.Equal(base)
+		case SearchRequestSingleLevel:
+			inScope = len(dn) == len(base)+1
+		default:
+			inScope = true
+		}
+		if inScope && matchFilter(&e, r.Filter()) {
+			matches = append(matches, SelectAttributes(e, r))
+		}
+	}
+	if !baseFound {
+		w.Write(NewSearchResultDoneResponse(LDAPResultNoSuchObject))
+		return
+	}
+
+	if err := WriteEntries(w, matches); err != nil {
+		return
+	}
+	w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+}
+
+// configEntries returns the entries of the cn=config subtree: cn=config
+// with the limits of srv, and cn=schema,cn=config with its schema if set
+func configEntries(srv *Server) []Entry {
+	limits := srv.limits()
+	config := NewEntry(ConfigDN).Add("objectClass", "top", "olcGlobal").Add("cn", "config")
+	for _, a := range configAttributes {
+		if configAttributeSet(a, &limits) {
+			config.Add(a.name, a.get(&limits))
+		}
+	}
+	entries := []Entry{*config}
+
+	schema, _ := srv.subschema()
+	if schema == nil {
+		return entries
+	}
+	// cn=config definitions are prefixed with their {index}
+	var attributeTypes, objectClasses []string
+	for i, t := range schema.AttributeTypes() {
+		attributeTypes = append(attributeTypes, fmt.Sprintf("{%d}%s", i, t.Definition))
+	}
+	for i, c := range schema.ObjectClasses() {
+		objectClasses = append(objectClasses, fmt.Sprintf("{%d}%s", i, c.Definition))
+	}
+	schemaEntry := NewEntry(ConfigSchemaDN).Add("objectClass", "top", "olcSchemaConfig").Add("cn", "schema")
+	if len(attributeTypes) > 0 {
+		schemaEntry.Add("olcAttributeTypes", attributeTypes...)
+	}
+	if len(objectClasses) > 0 {
+		schemaEntry.Add("olcObjectClasses", objectClasses...)
+	}
+	return append(entries, *schemaEntry)
+}
+
+// configAttributeSet reports whether the limit of a is set in l, a limit
+// left at its zero value has no value
+func configAttributeSet(a configAttribute, l *Limits) bool {
+	return a.get(l) != a.get(&Limits{})
+}
+
+func handleConfigModify(w ResponseWriter, m *Message, opts ConfigBackendOptions) {
+	if opts.Authorize == nil || !opts.Authorize(m, true) {
+		res := NewModifyResponse(LDAPResultInsufficientAccessRights)
+		res.SetDiagnosticMessage("modification of the configuration is not allowed")
+		w.Write(res)
+		return
+	}
+
+	// changes are checked on a copy, the modification is atomic
+	srv := m.Client.srv
+	r := m.GetModifyRequest()
+	var err error
+	var resultCode int
+	srv.Settings().Update(func(l *Limits) {
+		updated := *l
+		for _, change := range r.Changes() {
+			if resultCode, err = applyConfigChange(&updated, change); err != nil {
+				return
+			}
+		}
+		if !m.NoOp() {
+			*l = updated
+		}
+	})
+
+	if err != nil {
+		res := NewModifyResponse(resultCode)
+		res.SetDiagnosticMessage(err.Error())
+		w.Write(res)
+		return
+	}
+	if m.NoOp() {
+		WriteNoOp(w, m)
+		return
+	}
+	m.logAt(LogLevelInfo, "configuration modified")
+	if e, ok := ChangeEventOf(r); ok {
+		srv.Changes().Publish(e)
+	}
+	w.Write(NewModifyResponse(LDAPResultSuccess))
+}
+
+// applyConfigChange applies a modification to l, and returns the result
+// code to answer with when it is refused
+func applyConfigChange(l *Limits, change ldap.ModifyRequestChange) (int, error) {
+	modification := change.Modification()
+	a, ok := findConfigAttribute(string(modification.Type_()))
+	if !ok {
+		return LDAPResultUnwillingToPerform, fmt.Errorf("attribute %s can not be modified", modification.Type_())
+	}
+
+	vals := modification.Vals()
+	switch int(change.Operation()) {
+	case ModifyRequestChangeOperationDelete:
+		if !configAttributeSet(a, l) {
+			return LDAPResultNoSuchAttribute, fmt.Errorf("attribute %s has no value", a.name)
+		}
+		for _, v := range vals {
+			var deleted Limits
+			if err := a.set(&deleted, string(v)); err != nil || a.get(&deleted) != a.get(l) {
+				return LDAPResultNoSuchAttribute, fmt.Errorf("%q is not the value of %s", v, a.name)
+			}
+		}
+		// removing a limit disables it
+		return LDAPResultSuccess, a.set(l, a.get(&Limits{}))
+	case ModifyRequestChangeOperationAdd, ModifyRequestChangeOperationReplace:
+		if len(vals) != 1 {
+			return LDAPResultConstraintViolation, fmt.Errorf("attribute %s is single valued", a.name)
+		}
+		if int(change.Operation()) == ModifyRequestChangeOperationAdd && configAttributeSet(a, l) {
+			return LDAPResultAttributeOrValueExists, fmt.Errorf("attribute %s already has a value", a.name)
+		}
+		v, err := NormalizeValue(a.name, []byte(vals[0]))
+		if err != nil {
+			return LDAPResultInvalidAttributeSyntax, err
+		}
+		if err := a.set(l, string(v)); err != nil {
+			return LDAPResultInvalidAttributeSyntax, fmt.Errorf("invalid value for %s: %s", a.name, err)
+		}
+	}
+	return LDAPResultSuccess, nil
+}
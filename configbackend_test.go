@@ -0,0 +1,145 @@
+package ldapserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfigBackendSearch(t *testing.T) {
+	s := NewServer()
+	s.ReadOnly = true
+	schema := NewSchema()
+	if err := schema.AddAttributeType("( 2.5.4.3 NAME 'cn' SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 )"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetSchema(schema, ""); err != nil {
+		t.Fatal(err)
+	}
+	routes := NewRouteMux()
+	routes.ConfigBackend(ConfigBackendOptions{Authorize: func(m *Message, write bool) bool { return !write }})
+	s.Handle(routes)
+	addr := serveTest(t, s)
+	defer s.Stop()
+	c := dialTest(t, addr)
+	defer c.Close()
+
+	tests := []struct {
+		base       string
+		scope      int
+		filter     string
+		attributes []string
+		found      int
+	}{
+		{ConfigDN, SearchRequestScopeBaseObject, "(objectClass=olcGlobal)", nil, 1},
+		{ConfigDN, SearchRequestScopeBaseObject, "(olcReadOnly=TRUE)", []string{"olcReadOnly"}, 1},
+		{ConfigDN, SearchRequestScopeBaseObject, "(olcReadOnly=FALSE)", nil, 0},
+		{ConfigDN, SearchRequestScopeBaseObject, "(cn=other)", nil, 0},
+		{ConfigDN, SearchRequestHomeSubtree, "", nil, 2},
+		{ConfigDN, SearchRequestSingleLevel, "(olcAttributeTypes=*)", []string{"olcAttributeTypes"}, 1},
+		{ConfigSchemaDN, SearchRequestScopeBaseObject, "", []string{"cn"}, 1},
+	}
+	for _, tt := range tests {
+		entries, err := c.Search(SearchParams{BaseDN: tt.base, Scope: tt.scope, Filter: tt.filter, Attributes: tt.attributes})
+		if err != nil {
+			t.Fatalf("search %s: %s", tt.filter, err)
+		}
+		if len(entries) != tt.found {
+			t.Errorf("search %s found %d entries, want %d", tt.filter, len(entries), tt.found)
+		}
+		if tt.attributes != nil && len(entries) == 1 && len(entries[0].Attributes) != len(tt.attributes) {
+			t.Errorf("search %s returned %d attributes, want %d", tt.filter, len(entries[0].Attributes), len(tt.attributes))
+		}
+	}
+
+	_, err := c.Search(SearchParams{BaseDN: "cn=other," + ConfigDN})
+	if code := resultCode(err); code != LDAPResultNoSuchObject {
+		t.Errorf("search of a missing entry: result code %d, want noSuchObject", code)
+	}
+}
+
+func TestApplyConfigChange(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation int
+		attribute string
+		values    []string
+		code      int
+		want      string // value of olcIdleTimeout after the change
+	}{
+		{"add", ModifyRequestChangeOperationAdd, "olcMaxConnectionOperations", []string{"10"}, LDAPResultSuccess, "1m0s"},
+		{"add set", ModifyRequestChangeOperationAdd, "olcIdleTimeout", []string{"5m"}, LDAPResultAttributeOrValueExists, "1m0s"},
+		{"replace", ModifyRequestChangeOperationReplace, "olcIdleTimeout", []string{"5m"}, LDAPResultSuccess, "5m0s"},
+		{"replace multiple", ModifyRequestChangeOperationReplace, "olcIdleTimeout", []string{"5m", "6m"}, LDAPResultConstraintViolation, "1m0s"},
+		{"replace invalid", ModifyRequestChangeOperationReplace, "olcIdleTimeout", []string{"soon"}, LDAPResultInvalidAttributeSyntax, "1m0s"},
+		{"delete", ModifyRequestChangeOperationDelete, "olcIdleTimeout", nil, LDAPResultSuccess, "0s"},
+		{"delete value", ModifyRequestChangeOperationDelete, "olcIdleTimeout", []string{"60s"}, LDAPResultSuccess, "0s"},
+		{"delete other value", ModifyRequestChangeOperationDelete, "olcIdleTimeout", []string{"5m"}, LDAPResultNoSuchAttribute, "1m0s"},
+		{"delete unset", ModifyRequestChangeOperationDelete, "olcMaxConnectionOperations", nil, LDAPResultNoSuchAttribute, "1m0s"},
+		{"unknown attribute", ModifyRequestChangeOperationReplace, "olcDatabase", []string{"mdb"}, LDAPResultUnwillingToPerform, "1m0s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := Limits{IdleTimeout: time.Minute}
+			m := testMessage(t, configModify(ConfigDN, tt.operation, tt.attribute, tt.values...))
+			code, _ := applyConfigChange(&l, m.GetModifyRequest().Changes()[0])
+			if code != tt.code {
+				t.Errorf("result code %d, want %d", code, tt.code)
+			}
+			if code == LDAPResultSuccess && l.IdleTimeout.String() != tt.want {
+				t.Errorf("olcIdleTimeout %s, want %s", l.IdleTimeout, tt.want)
+			}
+		})
+	}
+}
+
+// configModify returns a ModifyRequest applying operation to the attribute
+// of dn
+func configModify(dn string, operation int, attribute string, values ...string) []byte {
+	encoded := make([][]byte, len(values))
+	for i, v := range values {
+		encoded[i] = berOctetString(berTagOctetString, []byte(v))
+	}
+	return berConstructedTLV(berClassApplication|berConstructed|ApplicationModifyRequest,
+		berOctetString(berTagOctetString, []byte(dn)),
+		berSequence(berSequence(
+			berInteger(berTagEnumerated, int64(operation)),
+			berSequence(berOctetString(berTagOctetString, []byte(attribute)), berConstructedTLV(berTagSet, encoded...)),
+		)),
+	)
+}
+
+func TestConfigBackendWritable(t *testing.T) {
+	config := NewRouteMux()
+	config.ConfigBackend(ConfigBackendOptions{Authorize: func(m *Message, write bool) bool { return true }})
+	// a handler of its own serving a cn=config entry
+	other := NewRouteMux()
+	other.Modify(successHandler{}.ServeLDAP).BaseDn(ConfigDN)
+
+	tests := []struct {
+		name    string
+		handler Handler
+		dn      string
+		refused bool
+	}{
+		{"config backend", config, ConfigDN, false},
+		{"config backend other entry", config, "cn=other", true},
+		{"other handler", other, ConfigDN, true},
+	}
+	for _, mode := range []string{"read-only", "maintenance"} {
+		s := NewServer()
+		s.ReadOnly = mode == "read-only"
+		s.Maintenance = mode == "maintenance"
+		for _, tt := range tests {
+			t.Run(mode+" "+tt.name, func(t *testing.T) {
+				m := testMessage(t, configModify(tt.dn, ModifyRequestChangeOperationReplace, "olcReadOnly", "FALSE"))
+				res, release := s.checkRequest(tt.handler, m)
+				if release != nil {
+					release()
+				}
+				if refused := res != nil; refused != tt.refused {
+					t.Errorf("refused %t, want %t", refused, tt.refused)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package ldapserver
+
+import (
+	"bufio"
+	"net"
+)
+
+// First byte of a TLS record carrying a handshake message (ClientHello)
+const tlsRecordTypeHandshake = 0x16
+
+// bufferedConn is a net.Conn whose reads are served from a bufio.Reader,
+// so bytes peeked from the connection are not lost when it is handed over
+// to another reader (a tls.Server for instance)
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -24,6 +24,7 @@ const (
 	ApplicationSearchResultReference = 19
 	ApplicationExtendedRequest       = 23
 	ApplicationExtendedResponse      = 24
+	ApplicationIntermediateResponse  = 25
 )
 
 // LDAP Result Codes
@@ -59,6 +60,8 @@ const (
 	LDAPResultUnavailable                  = 52
 	LDAPResultUnwillingToPerform           = 53
 	LDAPResultLoopDetect                   = 54
+	LDAPResultSortControlMissing           = 60 // draft-ietf-ldapext-ldapv3-vlv, VLV request without a sort control
+	LDAPResultOffsetRangeError             = 61 // draft-ietf-ldapext-ldapv3-vlv, VLV offset out of range
 	LDAPResultNamingViolation              = 64
 	LDAPResultObjectClassViolation         = 65
 	LDAPResultNotAllowedOnNonLeaf          = 66
@@ -67,6 +70,12 @@ const (
 	LDAPResultObjectClassModsProhibited    = 69
 	LDAPResultAffectsMultipleDSAs          = 71
 	LDAPResultOther                        = 80
+	LDAPResultCanceled                     = 118
+	LDAPResultNoSuchOperation              = 119
+	LDAPResultTooLate                      = 120
+	LDAPResultCannotCancel                 = 121
+	LDAPResultAuthorizationDenied          = 123   // RFC 4370, the proxied authorization is denied
+	LDAPResultNoOperation                  = 16654 // draft-zeilenga-ldap-noop, answers No-Op requests which would succeed
 
 	ErrorNetwork         = 200
 	ErrorFilterCompile   = 201
@@ -85,6 +94,10 @@ const SearchRequestScopeBaseObject = 0
 const SearchRequestSingleLevel = 1
 const SearchRequestHomeSubtree = 2
 
+// projectArc is the arc of the object identifiers defined by this
+// package, a UUID based OID (ITU-T X.667) needing no registration
+const projectArc = "2.25.14405027565436803754396886651122691634"
+
 // Extended operation responseName and requestName
 const (
 	NoticeOfDisconnection   ldap.LDAPOID = "1.3.6.1.4.1.1466.2003"
@@ -93,4 +106,20 @@ const (
 	NoticeOfWhoAmI          ldap.LDAPOID = "1.3.6.1.4.1.4203.1.11.3"
 	NoticeOfGetConnectionID ldap.LDAPOID = "1.3.6.1.4.1.26027.1.6.2"
 	NoticeOfPasswordModify  ldap.LDAPOID = "1.3.6.1.4.1.4203.1.11.1"
+	NoticeOfUndelete        ldap.LDAPOID = projectArc + ".2.1" // requestValue is the DN of the soft-deleted entry to restore, see memory.SoftDeletePolicy
+)
+
+// Control types
+const (
+	ControlPasswordPolicy ldap.LDAPOID = "1.3.6.1.4.1.42.2.27.8.5.1" // draft-behera-ldap-password-policy request and response
+	ControlDontUseCopy    ldap.LDAPOID = "1.3.6.1.1.22"              // RFC 6171 Don't Use Copy
+	ControlRelaxRules     ldap.LDAPOID = "1.3.6.1.4.1.4203.666.5.12" // draft-zeilenga-ldap-relax, relax data model constraints
+	ControlNoOp           ldap.LDAPOID = "1.3.6.1.4.1.4203.1.10.2"   // draft-zeilenga-ldap-noop, validate writes without applying them
+	ControlPagedResults   ldap.LDAPOID = "1.2.840.113556.1.4.319"    // RFC 2696 Simple Paged Results
+	ControlSortRequest    ldap.LDAPOID = "1.2.840.113556.1.4.473"    // RFC 2891 Server Side Sort request
+	ControlSortResponse   ldap.LDAPOID = "1.2.840.113556.1.4.474"    // RFC 2891 Server Side Sort response
+	ControlVLVRequest     ldap.LDAPOID = "2.16.840.1.113730.3.4.9"   // draft-ietf-ldapext-ldapv3-vlv Virtual List View request
+	ControlVLVResponse    ldap.LDAPOID = "2.16.840.1.113730.3.4.10"  // draft-ietf-ldapext-ldapv3-vlv Virtual List View response
+	ControlProxiedAuthz   ldap.LDAPOID = "2.16.840.1.113730.3.4.18"  // RFC 4370 Proxied Authorization v2
+	ControlShowDeleted    ldap.LDAPOID = "1.2.840.113556.1.4.417"    // Active Directory Show Deleted, returns the soft-deleted entries
 )
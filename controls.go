@@ -0,0 +1,204 @@
+package ldapserver
+
+import (
+	"fmt"
+	"sync"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// Control is a control of a request, decoded by Message.Controls
+type Control struct {
+	OID         ldap.LDAPOID
+	Criticality bool
+	Value       []byte // raw control value, nil when the control has none
+
+	// Decoded is the value decoded by the ControlType registered for OID,
+	// such as a *PagedResultsControl, nil when the type is not registered
+	Decoded interface{}
+}
+
+// ControlType tells how to decode and encode the value of a control, see
+// RegisterControl
+type ControlType struct {
+	OID  ldap.LDAPOID
+	Name string
+
+	// Decode, if non-nil, decodes the value of a request control, nil when
+	// the control has none
+	Decode func(criticality bool, value []byte) (interface{}, error)
+
+	// Encode, if non-nil, encodes the value of a response control, see
+	// EncodeControl
+	Encode func(v interface{}) ([]byte, error)
+}
+
+var (
+	controlTypesMu sync.RWMutex
+	controlTypes   = make(map[ldap.LDAPOID]ControlType)
+)
+
+// RegisterControl registers the control type t, replacing the type
+// registered for the same OID, if any. The controls of the requests are
+// then decoded by Message.Controls.
+func RegisterControl(t ControlType) {
+	controlTypesMu.Lock()
+	defer controlTypesMu.Unlock()
+	controlTypes[t.OID] = t
+}
+
+// LookupControl returns the control type registered for oid
+func LookupControl(oid ldap.LDAPOID) (ControlType, bool) {
+	controlTypesMu.RLock()
+	defer controlTypesMu.RUnlock()
+	t, ok := controlTypes[oid]
+	return t, ok
+}
+
+// RegisteredControls returns the OIDs of the registered control types
+func RegisteredControls() []ldap.LDAPOID {
+	controlTypesMu.RLock()
+	defer controlTypesMu.RUnlock()
+	oids := make([]ldap.LDAPOID, 0, len(controlTypes))
+	for oid := range controlTypes {
+		oids = append(oids, oid)
+	}
+	return oids
+}
+
+func init() {
+	RegisterControl(ControlType{OID: ControlPagedResults, Name: "Simple Paged Results",
+		Decode: func(criticality bool, value []byte) (interface{}, error) {
+			return nonNil(decodePagedResults(criticality, value))
+		}})
+	RegisterControl(ControlType{OID: ControlSortRequest, Name: "Server Side Sort",
+		Decode: func(criticality bool, value []byte) (interface{}, error) {
+			return nonNil(decodeSortControl(criticality, value))
+		}})
+	RegisterControl(ControlType{OID: ControlVLVRequest, Name: "Virtual List View",
+		Decode: func(criticality bool, value []byte) (interface{}, error) {
+			return nonNil(decodeVLVControl(criticality, value))
+		}})
+	RegisterControl(ControlType{OID: ControlProxiedAuthz, Name: "Proxied Authorization",
+		Decode: func(criticality bool, value []byte) (interface{}, error) {
+			return nonNil(decodeProxiedAuthz(criticality, value))
+		}})
+	for oid, name := range map[ldap.LDAPOID]string{
+		ControlPasswordPolicy: "Password Policy",
+		ControlDontUseCopy:    "Don't Use Copy",
+		ControlRelaxRules:     "Relax Rules",
+		ControlNoOp:           "No-Op",
+		ControlShowDeleted:    "Show Deleted",
+	} {
+		RegisterControl(ControlType{OID: oid, Name: name})
+	}
+}
+
+// nonNil returns the decoded control value v as an interface, nil when
+// decoding failed rather than a typed nil pointer
+func nonNil(v interface{}, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// controlValue returns the value of c, nil when it has none
+func controlValue(c ldap.Control) []byte {
+	if c.ControlValue() == nil {
+		return nil
+	}
+	value := []byte(*c.ControlValue())
+	if value == nil {
+		value = []byte{}
+	}
+	return value
+}
+
+// Controls returns the controls of the request m, in order, their values
+// decoded by the registered control types. The error, a *ResultError with
+// the protocolError code, tells the first control which could not be
+// decoded; the returned list still holds all the controls.
+func (m *Message) Controls() ([]Control, error) {
+	if m.LDAPMessage.Controls() == nil {
+		return nil, nil
+	}
+	var controls []Control
+	var decodeErr error
+	for _, c := range *m.LDAPMessage.Controls() {
+		control := Control{
+			OID:         c.ControlType(),
+			Criticality: c.Criticality(),
+			Value:       controlValue(c),
+		}
+		if t, ok := LookupControl(control.OID); ok && t.Decode != nil {
+			decoded, err := t.Decode(control.Criticality, control.Value)
+			if err == nil {
+				control.Decoded = decoded
+			} else if decodeErr == nil {
+				decodeErr = err
+				if _, ok := err.(*ResultError); !ok {
+					decodeErr = NewResultError(LDAPResultProtocolError, fmt.Sprintf("malformed control %s: %s", control.OID, err))
+				}
+			}
+		}
+		controls = append(controls, control)
+	}
+	return controls, decodeErr
+}
+
+// Control returns the control oid of the request m, see Controls
+func (m *Message) Control(oid ldap.LDAPOID) (Control, bool, error) {
+	c, ok := requestControl(m, oid)
+	if !ok {
+		return Control{}, false, nil
+	}
+	control := Control{OID: oid, Criticality: c.Criticality(), Value: controlValue(c)}
+	if t, ok := LookupControl(oid); ok && t.Decode != nil {
+		decoded, err := t.Decode(control.Criticality, control.Value)
+		if err != nil {
+			return control, true, err
+		}
+		control.Decoded = decoded
+	}
+	return control, true, nil
+}
+
+// EncodeControl returns the BER encoded response control oid, its value
+// v encoded by the registered control type, for ResponseWriter
+// WriteRawWithControls. A []byte v is used as the raw value, and a nil v
+// leaves the control without value.
+func EncodeControl(oid ldap.LDAPOID, criticality bool, v interface{}) ([]byte, error) {
+	elements := [][]byte{berOctetString(berTagOctetString, []byte(oid))}
+	if criticality {
+		elements = append(elements, berBoolean(berTagBoolean, true))
+	}
+	switch value := v.(type) {
+	case nil:
+	case []byte:
+		elements = append(elements, berOctetString(berTagOctetString, value))
+	default:
+		t, ok := LookupControl(oid)
+		if !ok || t.Encode == nil {
+			return nil, fmt.Errorf("no encoder registered for control %s", oid)
+		}
+		encoded, err := t.Encode(v)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, berOctetString(berTagOctetString, encoded))
+	}
+	return berSequence(elements...), nil
+}
+
+// encodeControl returns the BER encoding of a goldap Control
+func encodeControl(c ldap.Control) []byte {
+	elements := [][]byte{berOctetString(berTagOctetString, []byte(c.ControlType()))}
+	if c.Criticality() {
+		elements = append(elements, berBoolean(berTagBoolean, true))
+	}
+	if c.ControlValue() != nil {
+		elements = append(elements, berOctetString(berTagOctetString, []byte(*c.ControlValue())))
+	}
+	return berSequence(elements...)
+}
@@ -0,0 +1,78 @@
+package ldapserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// DecodeFailure is the category of a DecodeError
+type DecodeFailure int
+
+const (
+	DecodeMalformed          DecodeFailure = iota // invalid LDAP message content
+	DecodeBadTag                                  // the PDU does not start with a SEQUENCE tag
+	DecodeOversizeLength                          // the PDU length is too large
+	DecodeTruncated                               // the connection ended in the middle of a PDU
+	DecodeUnsupportedVersion                      // BindRequest with a protocol version not supported
+)
+
+func (f DecodeFailure) String() string {
+	switch f {
+	case DecodeMalformed:
+		return "malformed"
+	case DecodeBadTag:
+		return "badTag"
+	case DecodeOversizeLength:
+		return "oversizeLength"
+	case DecodeTruncated:
+		return "truncated"
+	case DecodeUnsupportedVersion:
+		return "unsupportedVersion"
+	}
+	return fmt.Sprintf("DecodeFailure(%d)", int(f))
+}
+
+// DecodeFailureCounts counts the PDUs received which could not be decoded,
+// by category. Port scanners typically send bad tags, while broken clients
+// send malformed messages.
+type DecodeFailureCounts struct {
+	Malformed          int64 `json:"malformed"`
+	BadTag             int64 `json:"badTag"`
+	OversizeLength     int64 `json:"oversizeLength"`
+	Truncated          int64 `json:"truncated"`
+	UnsupportedVersion int64 `json:"unsupportedVersion"`
+}
+
+// decodeFailures counts the decode failures of a server
+type decodeFailures struct {
+	counts [DecodeUnsupportedVersion + 1]int64
+}
+
+func (d *decodeFailures) add(f DecodeFailure) {
+	if f >= 0 && int(f) < len(d.counts) {
+		atomic.AddInt64(&d.counts[f], 1)
+	}
+}
+
+// count counts the read error err when it is a decode failure
+func (d *decodeFailures) count(err error) {
+	var de *DecodeError
+	switch {
+	case errors.As(err, &de):
+		d.add(de.Reason)
+	case err == io.ErrUnexpectedEOF:
+		d.add(DecodeTruncated)
+	}
+}
+
+func (d *decodeFailures) snapshot() DecodeFailureCounts {
+	return DecodeFailureCounts{
+		Malformed:          atomic.LoadInt64(&d.counts[DecodeMalformed]),
+		BadTag:             atomic.LoadInt64(&d.counts[DecodeBadTag]),
+		OversizeLength:     atomic.LoadInt64(&d.counts[DecodeOversizeLength]),
+		Truncated:          atomic.LoadInt64(&d.counts[DecodeTruncated]),
+		UnsupportedVersion: atomic.LoadInt64(&d.counts[DecodeUnsupportedVersion]),
+	}
+}
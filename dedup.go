@@ -0,0 +1,67 @@
+package ldapserver
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// DedupPolicy selects how the search result entries sent twice in response
+// to one search are recognized, when alias dereferencing or several naming
+// contexts yield the same entry
+type DedupPolicy int
+
+const (
+	DedupNone      DedupPolicy = iota // send all the entries written by the handler
+	DedupDN                           // drop the entries whose normalized DN was sent
+	DedupEntryUUID                    // drop the entries whose entryUUID was sent, or their normalized DN without entryUUID
+)
+
+// dedupTransform returns an EntryTransform dropping the entries already
+// sent in response to one search, as told by policy
+func dedupTransform(policy DedupPolicy) EntryTransform {
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	return func(ctx context.Context, e *Entry) (*Entry, error) {
+		key := "dn:" + NormalizeDN(e.DN)
+		if policy == DedupEntryUUID {
+			if uuid := entryValue(e, "entryUUID"); uuid != "" {
+				key = "uuid:" + strings.ToLower(uuid)
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if seen[key] {
+			return nil, nil
+		}
+		seen[key] = true
+		return e, nil
+	}
+}
+
+// chainTransforms returns an EntryTransform applying the transforms in
+// order, the nil ones skipped, until one drops the entry
+func chainTransforms(transforms ...EntryTransform) EntryTransform {
+	var chain []EntryTransform
+	for _, t := range transforms {
+		if t != nil {
+			chain = append(chain, t)
+		}
+	}
+	switch len(chain) {
+	case 0:
+		return nil
+	case 1:
+		return chain[0]
+	}
+	return func(ctx context.Context, e *Entry) (*Entry, error) {
+		for _, t := range chain {
+			var err error
+			if e, err = t(ctx, e); err != nil || e == nil {
+				return e, err
+			}
+		}
+		return e, nil
+	}
+}
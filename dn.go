@@ -0,0 +1,360 @@
+package ldapserver
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// AttributeTypeAndValue is an assertion of a relative distinguished name
+type AttributeTypeAndValue struct {
+	Type  string
+	Value string
+}
+
+// RDN is a relative distinguished name, a set of assertions
+type RDN []AttributeTypeAndValue
+
+// DN is a distinguished name, its RDNs are ordered from the entry to the
+// root, as in the string representation
+type DN []RDN
+
+// ParseDN parses a distinguished name in the RFC 4514 string representation.
+// Like RFC 2253 implementations, it accepts spaces around separators, so
+// "o=My Company, c=US" is accepted, and ';' separating RDNs.
+func ParseDN(s string) (DN, error) {
+	return parseDN(s, false)
+}
+
+// ParseDNStrict parses a distinguished name as ParseDN does, but rejects
+// what RFC 4514 does not allow: ';' separators, unescaped ';' in values,
+// and spaces around separators or leading and trailing values which are
+// not escaped.
+func ParseDNStrict(s string) (DN, error) {
+	return parseDN(s, true)
+}
+
+func parseDN(s string, strict bool) (DN, error) {
+	p := dnParser{s: s, strict: strict}
+	dn, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid DN %q: %s", s, err)
+	}
+	return dn, nil
+}
+
+type dnParser struct {
+	s      string
+	pos    int
+	strict bool // RFC 4514 only, see ParseDNStrict
+}
+
+// skipSpaces skips the spaces around separators, none are allowed when
+// strict
+func (p *dnParser) skipSpaces() {
+	for !p.strict && p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *dnParser) parse() (DN, error) {
+	var dn DN
+	p.skipSpaces()
+	if p.pos == len(p.s) {
+		return dn, nil
+	}
+	for {
+		rdn, err := p.parseRDN()
+		if err != nil {
+			return nil, err
+		}
+		dn = append(dn, rdn)
+		if p.pos == len(p.s) {
+			return dn, nil
+		}
+		// parseRDN stops on a separator or the end of the string
+		if c := p.s[p.pos]; c != ',' && (c != ';' || p.strict) {
+			return nil, fmt.Errorf("unexpected %q at offset %d", c, p.pos)
+		}
+		p.pos++
+	}
+}
+
+func (p *dnParser) parseRDN() (RDN, error) {
+	var rdn RDN
+	for {
+		atv, err := p.parseAttributeTypeAndValue()
+		if err != nil {
+			return nil, err
+		}
+		rdn = append(rdn, atv)
+		if p.pos < len(p.s) && p.s[p.pos] == '+' {
+			p.pos++
+			continue
+		}
+		return rdn, nil
+	}
+}
+
+func (p *dnParser) parseAttributeTypeAndValue() (atv AttributeTypeAndValue, err error) {
+	p.skipSpaces()
+	start := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] != '=' && p.s[p.pos] != ' ' {
+		p.pos++
+	}
+	atv.Type = p.s[start:p.pos]
+	if !validAttributeType(atv.Type) {
+		return atv, fmt.Errorf("invalid attribute type %q", atv.Type)
+	}
+	p.skipSpaces()
+	if p.pos == len(p.s) || p.s[p.pos] != '=' {
+		return atv, fmt.Errorf("missing '=' after attribute type %q", atv.Type)
+	}
+	p.pos++
+	p.skipSpaces()
+
+	if p.pos < len(p.s) && p.s[p.pos] == '#' {
+		atv.Value, err = p.parseHexString()
+	} else {
+		atv.Value, err = p.parseString()
+	}
+	if err != nil {
+		return atv, err
+	}
+	p.skipSpaces()
+	return atv, nil
+}
+
+// parseHexString parses the #hexstring form of a value
+func (p *dnParser) parseHexString() (string, error) {
+	p.pos++
+	start := p.pos
+	for p.pos < len(p.s) && isHexDigit(p.s[p.pos]) {
+		p.pos++
+	}
+	digits := p.s[start:p.pos]
+	if len(digits) == 0 || len(digits)%2 != 0 {
+		return "", errors.New("invalid hexstring value")
+	}
+	b, _ := hex.DecodeString(digits)
+	return string(b), nil
+}
+
+// parseString parses the string form of a value, up to an unescaped
+// separator. Trailing unescaped spaces are not part of the value.
+func (p *dnParser) parseString() (string, error) {
+	var b []byte
+	trailing := 0 // unescaped spaces at the end of b
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		switch c {
+		case ',', ';', '+':
+			return p.endString(b, trailing)
+		case '\\':
+			p.pos++
+			if p.pos == len(p.s) {
+				return "", errors.New("unterminated escape sequence")
+			}
+			if isHexDigit(p.s[p.pos]) {
+				if p.pos+1 == len(p.s) || !isHexDigit(p.s[p.pos+1]) {
+					return "", fmt.Errorf("invalid escape sequence at offset %d", p.pos-1)
+				}
+				v, _ := hex.DecodeString(p.s[p.pos : p.pos+2])
+				b = append(b, v[0])
+				p.pos += 2
+			} else if strings.IndexByte(dnSpecialChars, p.s[p.pos]) >= 0 {
+				b = append(b, p.s[p.pos])
+				p.pos++
+			} else {
+				return "", fmt.Errorf("invalid escape sequence at offset %d", p.pos-1)
+			}
+			trailing = 0
+			continue
+		case '"', '<', '>', 0:
+			return "", fmt.Errorf("unescaped %q at offset %d", c, p.pos)
+		case ' ':
+			if p.strict && len(b) == 0 {
+				return "", fmt.Errorf("unescaped leading space at offset %d", p.pos)
+			}
+			trailing++
+		default:
+			trailing = 0
+		}
+		b = append(b, c)
+		p.pos++
+	}
+	return p.endString(b, trailing)
+}
+
+func (p *dnParser) endString(b []byte, trailing int) (string, error) {
+	if p.strict && trailing > 0 {
+		return "", fmt.Errorf("unescaped trailing space at offset %d", p.pos-1)
+	}
+	b = b[:len(b)-trailing]
+	if !utf8.Valid(b) {
+		return "", errors.New("value is not valid UTF-8")
+	}
+	return string(b), nil
+}
+
+// characters which may be escaped with a backslash
+const dnSpecialChars = "\\\"+,;<>=# "
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// validAttributeType reports whether s is a descr or a numericoid
+func validAttributeType(s string) bool {
+	if s == "" {
+		return false
+	}
+	if s[0] >= '0' && s[0] <= '9' {
+		for _, arc := range strings.Split(s, ".") {
+			if arc == "" || (len(arc) > 1 && arc[0] == '0') {
+				return false
+			}
+			for i := 0; i < len(arc); i++ {
+				if arc[i] < '0' || arc[i] > '9' {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		alpha := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !alpha && (i == 0 || !(c >= '0' && c <= '9') && c != '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the RFC 4514 string representation of the DN
+func (dn DN) String() string {
+	rdns := make([]string, len(dn))
+	for i, rdn := range dn {
+		rdns[i] = rdn.String()
+	}
+	return strings.Join(rdns, ",")
+}
+
+// String returns the RFC 4514 string representation of the RDN
+func (rdn RDN) String() string {
+	atvs := make([]string, len(rdn))
+	for i, atv := range rdn {
+		atvs[i] = atv.Type + "=" + escapeDNValue(atv.Value)
+	}
+	return strings.Join(atvs, "+")
+}
+
+// escapeDNValue escapes a value as required by RFC 4514 section 2.4
+func escapeDNValue(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, "\\%02x", c)
+		case strings.IndexByte("\\\"+,;<>", c) >= 0,
+			i == 0 && (c == '#' || c == ' '),
+			i == len(v)-1 && c == ' ':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Normalize returns a string suitable to compare DNs: attribute types and
+// values are lowercased, and the assertions of multi-valued RDNs sorted
+func (dn DN) Normalize() string {
+	rdns := make([]string, len(dn))
+	for i, rdn := range dn {
+		atvs := make([]string, len(rdn))
+		for j, atv := range rdn {
+			atvs[j] = strings.ToLower(atv.Type) + "=" + escapeDNValue(strings.ToLower(atv.Value))
+		}
+		sort.Strings(atvs)
+		rdns[i] = strings.Join(atvs, "+")
+	}
+	return strings.Join(rdns, ",")
+}
+
+// Parent returns the DN of the parent entry, nil for the root
+func (dn DN) Parent() DN {
+	if len(dn) == 0 {
+		return nil
+	}
+	return dn[1:]
+}
+
+// Equal reports whether both DNs are the same once normalized
+func (dn DN) Equal(other DN) bool {
+	return dn.Normalize() == other.Normalize()
+}
+
+// IsDescendantOf reports whether dn is below ancestor, or ancestor itself
+// when orEqual is set
+func (dn DN) IsDescendantOf(ancestor DN, orEqual bool) bool {
+	if len(dn) < len(ancestor) || (!orEqual && len(dn) == len(ancestor)) {
+		return false
+	}
+	return dn[len(dn)-len(ancestor):].Equal(ancestor)
+}
+
+// NormalizeDN returns the normalized form of the DN string s, or s
+// lowercased when it is not a valid DN
+func NormalizeDN(s string) string {
+	dn, err := ParseDN(s)
+	if err != nil {
+		return strings.ToLower(s)
+	}
+	return dn.Normalize()
+}
+
+// requestDNs returns the DN fields of a request, the new RDN of a
+// ModifyDNRequest is checked as a single RDN DN
+func requestDNs(po ldap.ProtocolOp) []string {
+	switch r := po.(type) {
+	case ldap.BindRequest:
+		return []string{string(r.Name())}
+	case ldap.SearchRequest:
+		return []string{string(r.BaseObject())}
+	case ldap.AddRequest:
+		return []string{string(r.Entry())}
+	case ldap.ModifyRequest:
+		return []string{string(r.Object())}
+	case ldap.DelRequest:
+		return []string{string(r)}
+	case ldap.CompareRequest:
+		return []string{string(r.Entry())}
+	case ldap.ModifyDNRequest:
+		dns := []string{string(r.Entry()), string(r.NewRDN())}
+		if r.NewSuperior() != nil {
+			dns = append(dns, string(*r.NewSuperior()))
+		}
+		return dns
+	}
+	return nil
+}
+
+// checkDNSyntax returns an invalidDNSyntax response when one of the DNs of
+// the request is not valid, as parsed by ParseDNStrict
+func checkDNSyntax(po ldap.ProtocolOp) ldap.ProtocolOp {
+	for _, s := range requestDNs(po) {
+		if _, err := ParseDNStrict(s); err != nil {
+			return NewResponseForRequest(po, LDAPResultInvalidDNSyntax, err.Error())
+		}
+	}
+	return nil
+}
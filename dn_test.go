@@ -0,0 +1,116 @@
+package ldapserver
+
+import "testing"
+
+func TestParseDN(t *testing.T) {
+	tests := []struct {
+		in         string
+		str        string // String of the parsed DN
+		normalized string
+		wantErr    bool
+	}{
+		{in: "", str: "", normalized: ""},
+		{in: "dc=example,dc=com", str: "dc=example,dc=com", normalized: "dc=example,dc=com"},
+		{in: "CN=John Smith,O=Example", str: "CN=John Smith,O=Example", normalized: "cn=john smith,o=example"},
+		{in: "o=My Company, c=US", str: "o=My Company,c=US", normalized: "o=my company,c=us"},
+		{in: "  cn = foo ,dc=com  ", str: "cn=foo,dc=com", normalized: "cn=foo,dc=com"},
+		{in: "cn=foo;dc=com", str: "cn=foo,dc=com", normalized: "cn=foo,dc=com"},
+		{in: "uid=b+cn=a,dc=com", str: "uid=b+cn=a,dc=com", normalized: "cn=a+uid=b,dc=com"},
+		{in: `cn=Smith\, John,dc=com`, str: `cn=Smith\, John,dc=com`, normalized: `cn=smith\, john,dc=com`},
+		{in: `cn=\23hash,dc=com`, str: `cn=\#hash,dc=com`, normalized: `cn=\#hash,dc=com`},
+		{in: `cn=\ lead,dc=com`, str: `cn=\ lead,dc=com`, normalized: `cn=\ lead,dc=com`},
+		{in: `cn=trail\ ,dc=com`, str: `cn=trail\ ,dc=com`, normalized: `cn=trail\ ,dc=com`},
+		{in: `cn=caf\c3\a9,dc=com`, str: "cn=café,dc=com", normalized: "cn=café,dc=com"},
+		{in: "cn=#666f6f,dc=com", str: "cn=foo,dc=com", normalized: "cn=foo,dc=com"},
+		{in: "2.5.4.3=foo", str: "2.5.4.3=foo", normalized: "2.5.4.3=foo"},
+		{in: "cn=,dc=com", str: "cn=,dc=com", normalized: "cn=,dc=com"},
+		{in: "cn", wantErr: true},
+		{in: "=foo", wantErr: true},
+		{in: "cn=foo,", wantErr: true},
+		{in: "1cn=foo", wantErr: true},
+		{in: "2.05.4.3=foo", wantErr: true},
+		{in: "c_n=foo", wantErr: true},
+		{in: `cn=foo\`, wantErr: true},
+		{in: `cn=foo\4`, wantErr: true},
+		{in: `cn=foo\zz`, wantErr: true},
+		{in: `cn="foo"`, wantErr: true},
+		{in: "cn=#66f", wantErr: true},
+		{in: "cn=#zz", wantErr: true},
+		{in: `cn=\ff\fe`, wantErr: true},
+	}
+	for _, tt := range tests {
+		dn, err := ParseDN(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseDN(%q) = %q, want an error", tt.in, dn)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDN(%q): %s", tt.in, err)
+			continue
+		}
+		if s := dn.String(); s != tt.str {
+			t.Errorf("ParseDN(%q).String() = %q, want %q", tt.in, s, tt.str)
+		}
+		if s := dn.Normalize(); s != tt.normalized {
+			t.Errorf("ParseDN(%q).Normalize() = %q, want %q", tt.in, s, tt.normalized)
+		}
+	}
+}
+
+func TestParseDNStrict(t *testing.T) {
+	tests := []struct {
+		in      string
+		wantErr bool
+	}{
+		{"", false},
+		{"dc=example,dc=com", false},
+		{"uid=b+cn=a,dc=com", false},
+		{`cn=\ lead\, trail\ ,dc=com`, false},
+		{"cn=#666f6f,dc=com", false},
+		{"cn=,dc=com", false},
+		{"cn=foo;dc=com", true},
+		{`cn=foo\;bar,dc=com`, false},
+		{"cn=foo;bar,dc=com", true},
+		{"o=My Company, c=US", true},
+		{"o=My Company ,c=US", true},
+		{"cn = foo", true},
+		{" cn=foo", true},
+		{"cn=foo ", true},
+		{"cn=foo + sn=bar", true},
+	}
+	for _, tt := range tests {
+		dn, err := ParseDNStrict(tt.in)
+		if tt.wantErr && err == nil {
+			t.Errorf("ParseDNStrict(%q) = %q, want an error", tt.in, dn)
+		}
+		if !tt.wantErr && err != nil {
+			t.Errorf("ParseDNStrict(%q): %s", tt.in, err)
+		}
+	}
+}
+
+func TestDNIsDescendantOf(t *testing.T) {
+	tests := []struct {
+		dn, ancestor string
+		orEqual      bool
+		want         bool
+	}{
+		{"cn=foo,dc=example,dc=com", "dc=example,dc=com", false, true},
+		{"cn=foo,dc=example,dc=com", "DC=Example, DC=Com", false, true},
+		{"cn=foo,dc=example,dc=com", "", false, true},
+		{"dc=example,dc=com", "dc=example,dc=com", false, false},
+		{"dc=example,dc=com", "dc=example,dc=com", true, true},
+		{"dc=com", "dc=example,dc=com", true, false},
+		{"cn=foo,dc=other,dc=com", "dc=example,dc=com", false, false},
+		{"cn=foo,dc=myexample,dc=com", "dc=example,dc=com", false, false},
+	}
+	for _, tt := range tests {
+		dn, _ := ParseDN(tt.dn)
+		ancestor, _ := ParseDN(tt.ancestor)
+		if got := dn.IsDescendantOf(ancestor, tt.orEqual); got != tt.want {
+			t.Errorf("%q.IsDescendantOf(%q, %t) = %t, want %t", tt.dn, tt.ancestor, tt.orEqual, got, tt.want)
+		}
+	}
+}
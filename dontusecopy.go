@@ -0,0 +1,41 @@
+package ldapserver
+
+import (
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// ErrOnlyCopies is returned by backends serving a request with the Don't
+// Use Copy control when only copied information, from a cache or a
+// replica, is available
+var ErrOnlyCopies = NewResultError(LDAPResultUnwillingToPerform, "only copied information is available")
+
+// DontUseCopy reports whether the search or compare request m carries the
+// Don't Use Copy control (RFC 6171): it must be served from the
+// authoritative source, not from a cache or a replica. Backends which
+// can't answer ErrOnlyCopies.
+func (m *Message) DontUseCopy() bool {
+	switch m.ProtocolOp().(type) {
+	case ldap.SearchRequest, ldap.CompareRequest:
+		_, ok := requestControl(m, ControlDontUseCopy)
+		return ok
+	}
+	return false
+}
+
+// checkDontUseCopy returns the response to a request with an invalid Don't
+// Use Copy control: it must be critical, have no value, and is only
+// appropriate for searches and compares
+func checkDontUseCopy(m *Message) ldap.ProtocolOp {
+	c, ok := requestControl(m, ControlDontUseCopy)
+	if !ok {
+		return nil
+	}
+	if !c.Criticality() || c.ControlValue() != nil {
+		return NewResponseForRequest(m.ProtocolOp(), LDAPResultProtocolError, "the Don't Use Copy control must be critical and have no value")
+	}
+	switch m.ProtocolOp().(type) {
+	case ldap.SearchRequest, ldap.CompareRequest:
+		return nil
+	}
+	return NewResponseForRequest(m.ProtocolOp(), LDAPResultUnavailableCriticalExtension, "the Don't Use Copy control only applies to searches and compares")
+}
@@ -0,0 +1,96 @@
+package ldapserver
+
+import ldap "github.com/ps78674/goldap/message"
+
+// Entry is a directory entry, as produced by backends
+type Entry struct {
+	DN         string
+	Attributes []EntryAttribute
+}
+
+// EntryAttribute is an attribute of an Entry with its values
+type EntryAttribute struct {
+	Name   string
+	Values [][]byte
+}
+
+// searchResultEntryLen returns the length of the SearchResultEntry content
+func (e *Entry) searchResultEntryLen() (n int, attributes int) {
+	for i := range e.Attributes {
+		attributes += berTLVLen(e.Attributes[i].partialAttributeLen())
+	}
+	return berTLVLen(len(e.DN)) + berTLVLen(attributes), attributes
+}
+
+// partialAttributeLen returns the length of the PartialAttribute content
+func (a *EntryAttribute) partialAttributeLen() int {
+	vals := 0
+	for _, v := range a.Values {
+		vals += berTLVLen(len(v))
+	}
+	return berTLVLen(len(a.Name)) + berTLVLen(vals)
+}
+
+// appendSearchResultEntryMessage appends to buf the LDAPMessage holding e
+// as a SearchResultEntry, without intermediate copies
+func appendSearchResultEntryMessage(buf []byte, messageID int, e *Entry) []byte {
+	enc := entryEncoder{buf: buf}
+	enc.searchResultEntryMessage(messageID, e)
+	return enc.buf
+}
+
+// searchResultEntryMessage encodes the LDAPMessage holding e as a
+// SearchResultEntry
+func (enc *entryEncoder) searchResultEntryMessage(messageID int, e *Entry) {
+	id := berInteger(berTagInteger, int64(messageID))
+	entryLen, _ := e.searchResultEntryLen()
+
+	enc.buf = appendBERHeader(enc.buf, berTagSequence, len(id)+berTLVLen(entryLen))
+	enc.buf = append(enc.buf, id...)
+	enc.searchResultEntry(e)
+}
+
+// appendSearchResultEntry appends to buf the SearchResultEntry protocolOp
+// holding e
+func appendSearchResultEntry(buf []byte, e *Entry) []byte {
+	enc := entryEncoder{buf: buf}
+	enc.searchResultEntry(e)
+	return enc.buf
+}
+
+// searchResultEntry encodes the SearchResultEntry protocolOp holding e
+func (enc *entryEncoder) searchResultEntry(e *Entry) {
+	entryLen, attributesLen := e.searchResultEntryLen()
+	enc.buf = appendBERHeader(enc.buf, berClassApplication|berConstructed|ApplicationSearchResultEntry, entryLen)
+	enc.buf = appendBERHeader(enc.buf, berTagOctetString, len(e.DN))
+	enc.buf = append(enc.buf, e.DN...)
+	enc.buf = appendBERHeader(enc.buf, berTagSequence, attributesLen)
+	for i := range e.Attributes {
+		a := &e.Attributes[i]
+		enc.buf = appendBERHeader(enc.buf, berTagSequence, a.partialAttributeLen())
+		enc.buf = appendBERHeader(enc.buf, berTagOctetString, len(a.Name))
+		enc.buf = append(enc.buf, a.Name...)
+		vals := 0
+		for _, v := range a.Values {
+			vals += berTLVLen(len(v))
+		}
+		enc.buf = appendBERHeader(enc.buf, berTagSet, vals)
+		for _, v := range a.Values {
+			enc.buf = appendBERHeader(enc.buf, berTagOctetString, len(v))
+			enc.value(v)
+		}
+	}
+}
+
+// entryFromSearchResultEntry converts a goldap SearchResultEntry
+func entryFromSearchResultEntry(e *ldap.SearchResultEntry) Entry {
+	entry := Entry{DN: string(e.ObjectName())}
+	for _, a := range e.Attributes() {
+		attribute := EntryAttribute{Name: string(a.Type_())}
+		for _, v := range a.Vals() {
+			attribute.Values = append(attribute.Values, []byte(v))
+		}
+		entry.Attributes = append(entry.Attributes, attribute)
+	}
+	return entry
+}
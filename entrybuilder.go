@@ -0,0 +1,88 @@
+package ldapserver
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// NewEntry returns an empty entry dn, to be built with the Add methods:
+//
+//	e := NewEntry("cn=John,ou=people,dc=example,dc=com").
+//		Add("objectClass", "top", "person").
+//		Add("cn", "John").
+//		AddBytes("jpegPhoto", photo)
+//	w.Write(e.SearchResultEntry(m.GetSearchRequest()))
+func NewEntry(dn string) *Entry {
+	return &Entry{DN: dn}
+}
+
+// Add adds string values to the attribute name, which is created when e
+// does not have it yet, and returns e
+func (e *Entry) Add(name string, values ...string) *Entry {
+	bytes := make([][]byte, len(values))
+	for i, v := range values {
+		bytes[i] = []byte(v)
+	}
+	return e.AddBytes(name, bytes...)
+}
+
+// AddBytes adds binary values to the attribute name, and returns e.
+// Attribute names are case insensitive, options are part of the name.
+func (e *Entry) AddBytes(name string, values ...[]byte) *Entry {
+	for i := range e.Attributes {
+		if strings.EqualFold(e.Attributes[i].Name, name) {
+			e.Attributes[i].Values = append(e.Attributes[i].Values, values...)
+			return e
+		}
+	}
+	e.Attributes = append(e.Attributes, EntryAttribute{Name: name, Values: values})
+	return e
+}
+
+// AddInt adds integer values to the attribute name, and returns e
+func (e *Entry) AddInt(name string, values ...int64) *Entry {
+	bytes := make([][]byte, len(values))
+	for i, v := range values {
+		bytes[i] = strconv.AppendInt(nil, v, 10)
+	}
+	return e.AddBytes(name, bytes...)
+}
+
+// AddBool adds a boolean value, TRUE or FALSE, to the attribute name, and
+// returns e
+func (e *Entry) AddBool(name string, value bool) *Entry {
+	if value {
+		return e.Add(name, "TRUE")
+	}
+	return e.Add(name, "FALSE")
+}
+
+// AddTime adds generalized time values (RFC 4517 section 3.3.13), in UTC,
+// to the attribute name, and returns e
+func (e *Entry) AddTime(name string, values ...time.Time) *Entry {
+	bytes := make([][]byte, len(values))
+	for i, v := range values {
+		bytes[i] = []byte(v.UTC().Format("20060102150405Z"))
+	}
+	return e.AddBytes(name, bytes...)
+}
+
+// SearchResultEntry returns e as a goldap SearchResultEntry answering the
+// search r, with the attributes SelectAttributes selects: the requested
+// ones, operational attributes only when asked for, and without their
+// values when r has typesOnly set
+func (e *Entry) SearchResultEntry(r ldap.SearchRequest) ldap.SearchResultEntry {
+	selected := SelectAttributes(*e, r)
+	res := NewSearchResultEntry(selected.DN)
+	for _, a := range selected.Attributes {
+		values := make([]ldap.AttributeValue, len(a.Values))
+		for i, v := range a.Values {
+			values[i] = ldap.AttributeValue(v)
+		}
+		res.AddAttribute(ldap.AttributeDescription(a.Name), values...)
+	}
+	return res
+}
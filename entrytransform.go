@@ -0,0 +1,111 @@
+package ldapserver
+
+import (
+	"context"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// EntryTransform rewrites a search result entry before it is sent, to drop
+// attributes, mask values or add tenant prefixes for instance. It returns
+// the entry to send, which may be e modified in place, or nil to drop it.
+// When it fails the entry is dropped and the error logged.
+type EntryTransform func(ctx context.Context, e *Entry) (*Entry, error)
+
+// transformWriter is a ResponseWriter applying an EntryTransform to the
+// entries written, whichever method writes them
+type transformWriter struct {
+	ResponseWriter
+	m         *Message
+	transform EntryTransform
+}
+
+// newTransformWriter returns w applying transform to the entries written
+// in response to m, or w when transform is nil
+func newTransformWriter(w ResponseWriter, m *Message, transform EntryTransform) ResponseWriter {
+	if transform == nil {
+		return w
+	}
+	return &transformWriter{ResponseWriter: w, m: m, transform: transform}
+}
+
+// apply returns the transformed entry, or nil when it is dropped
+func (t *transformWriter) apply(e Entry) *Entry {
+	transformed, err := t.transform(t.m.Context(), &e)
+	if err != nil {
+		t.m.logAt(LogLevelWarn, "entry %s dropped: %s", e.DN, err)
+		return nil
+	}
+	return transformed
+}
+
+func (t *transformWriter) Write(po ldap.ProtocolOp) {
+	e, ok := po.(ldap.SearchResultEntry)
+	if !ok {
+		t.ResponseWriter.Write(po)
+		return
+	}
+	if transformed := t.apply(entryFromSearchResultEntry(&e)); transformed != nil {
+		WriteEntries(t.ResponseWriter, []Entry{*transformed})
+	}
+}
+
+func (t *transformWriter) WriteMessage(m *ldap.LDAPMessage) {
+	e, ok := m.ProtocolOp().(ldap.SearchResultEntry)
+	if !ok {
+		t.ResponseWriter.WriteMessage(m)
+		return
+	}
+	transformed := t.apply(entryFromSearchResultEntry(&e))
+	if transformed == nil {
+		return
+	}
+	var controls [][]byte
+	if m.Controls() != nil {
+		for _, c := range *m.Controls() {
+			controls = append(controls, encodeControl(c))
+		}
+	}
+	WriteRawWithControls(t.ResponseWriter, appendSearchResultEntry(nil, transformed), controls)
+}
+
+func (t *transformWriter) WriteRaw(protocolOp []byte) {
+	t.WriteRawWithControls(protocolOp, nil)
+}
+
+func (t *transformWriter) WriteRawWithControls(protocolOp []byte, controls [][]byte) {
+	if len(protocolOp) == 0 || protocolOp[0] != berClassApplication|berConstructed|ApplicationSearchResultEntry {
+		WriteRawWithControls(t.ResponseWriter, protocolOp, controls)
+		return
+	}
+	element, _, err := berRead(protocolOp)
+	if err != nil {
+		WriteRawWithControls(t.ResponseWriter, protocolOp, controls)
+		return
+	}
+	e, err := parseClientEntry(clientResponse{tag: element.tag, data: element.data})
+	if err != nil {
+		WriteRawWithControls(t.ResponseWriter, protocolOp, controls)
+		return
+	}
+	if transformed := t.apply(e); transformed != nil {
+		WriteRawWithControls(t.ResponseWriter, appendSearchResultEntry(nil, transformed), controls)
+	}
+}
+
+func (t *transformWriter) WriteEntries(entries []Entry) error {
+	transformed := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		if e := t.apply(e); e != nil {
+			transformed = append(transformed, *e)
+		}
+	}
+	return WriteEntries(t.ResponseWriter, transformed)
+}
+
+func (t *transformWriter) responded() bool {
+	if rw, ok := t.ResponseWriter.(interface{ responded() bool }); ok {
+		return rw.responded()
+	}
+	return false
+}
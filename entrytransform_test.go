@@ -0,0 +1,88 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// entryWrites writes an entry with each of the ResponseWriter methods
+var entryWrites = []struct {
+	method string
+	write  func(w ResponseWriter, e Entry)
+}{
+	{"Write", func(w ResponseWriter, e Entry) {
+		w.Write(testSearchResultEntry(e))
+	}},
+	{"WriteMessage", func(w ResponseWriter, e Entry) {
+		w.WriteMessage(ldap.NewLDAPMessageWithProtocolOp(testSearchResultEntry(e)))
+	}},
+	{"WriteRaw", func(w ResponseWriter, e Entry) {
+		WriteRaw(w, appendSearchResultEntry(nil, &e))
+	}},
+	{"WriteRawWithControls", func(w ResponseWriter, e Entry) {
+		WriteRawWithControls(w, appendSearchResultEntry(nil, &e), nil)
+	}},
+	{"WriteEntries", func(w ResponseWriter, e Entry) {
+		WriteEntries(w, []Entry{e})
+	}},
+}
+
+func testSearchResultEntry(e Entry) ldap.SearchResultEntry {
+	r := NewSearchResultEntry(e.DN)
+	for _, a := range e.Attributes {
+		values := make([]ldap.AttributeValue, len(a.Values))
+		for i, v := range a.Values {
+			values[i] = ldap.AttributeValue(v)
+		}
+		r.AddAttribute(ldap.AttributeDescription(a.Name), values...)
+	}
+	return r
+}
+
+func TestTransformWriter(t *testing.T) {
+	transform := func(ctx context.Context, e *Entry) (*Entry, error) {
+		switch {
+		case strings.HasPrefix(e.DN, "cn=drop,"):
+			return nil, nil
+		case strings.HasPrefix(e.DN, "cn=fail,"):
+			return nil, errors.New("transform failed")
+		}
+		return e.Add("description", "transformed"), nil
+	}
+	entries := []Entry{
+		*NewEntry("cn=keep,dc=example,dc=com").Add("cn", "keep"),
+		*NewEntry("cn=drop,dc=example,dc=com").Add("cn", "drop"),
+		*NewEntry("cn=fail,dc=example,dc=com").Add("cn", "fail"),
+	}
+	for _, tt := range entryWrites {
+		t.Run(tt.method, func(t *testing.T) {
+			rec := NewResponseRecorder()
+			w := newTransformWriter(rec, &Message{}, transform)
+			for _, e := range entries {
+				tt.write(w, e)
+			}
+			w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+
+			written := rec.Entries()
+			if len(written) != 1 || written[0].DN != "cn=keep,dc=example,dc=com" {
+				t.Fatalf("entries written %v, want cn=keep only", written)
+			}
+			if values := written[0].values("description"); len(values) != 1 || string(values[0]) != "transformed" {
+				t.Errorf("description %q, want transformed", values)
+			}
+			if code := rec.ResultCode(); code != LDAPResultSuccess {
+				t.Errorf("result code %d, want %d", code, LDAPResultSuccess)
+			}
+		})
+	}
+
+	if w := newTransformWriter(NewResponseRecorder(), &Message{}, nil); w == nil {
+		t.Error("nil writer without transform")
+	} else if _, ok := w.(*transformWriter); ok {
+		t.Error("writer wrapped without transform")
+	}
+}
@@ -0,0 +1,85 @@
+package ldapserver
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNoHandler is returned when serving a server without Handler
+	ErrNoHandler = errors.New("no request handler defined")
+
+	// ErrHandlerRegistered is the value Handle panics with when the server
+	// already has a Handler
+	ErrHandlerRegistered = errors.New("error registering request handler: multiple registrations")
+
+	// ErrListenerClosed is returned when a listener is closed while the
+	// server was not stopping
+	ErrListenerClosed = errors.New("listener closed")
+
+	// ErrClientGone is returned by ResponseWriter.WriteEntries once the
+	// client connection is lost
+	ErrClientGone = errors.New("client connection lost")
+
+	// ErrOperationTerminated is returned by ResponseWriter.WriteEntries once
+	// the request is abandoned or canceled, its entries are not sent
+	ErrOperationTerminated = errors.New("operation terminated")
+
+	// ErrSizeLimitExceeded and ErrTimeLimitExceeded are returned by
+	// ResponseWriter.WriteEntries once the search was answered with
+	// sizeLimitExceeded or timeLimitExceeded, see Server.MaxSearchSizeLimit
+	ErrSizeLimitExceeded = errors.New("size limit exceeded")
+	ErrTimeLimitExceeded = errors.New("time limit exceeded")
+
+	// ErrTLSLoad is wrapped by errors loading TLS certificates and keys
+	ErrTLSLoad = errors.New("error creating certificate chain")
+)
+
+// ListenError reports an address which could not be listened on
+type ListenError struct {
+	Addr string
+	Err  error
+}
+
+func (e *ListenError) Error() string {
+	if e.Addr == "" {
+		return fmt.Sprintf("error creating listener: %s", e.Err)
+	}
+	return fmt.Sprintf("error creating listener on %s: %s", e.Addr, e.Err)
+}
+
+func (e *ListenError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeError reports bytes received from a client which are not a valid
+// LDAP message
+type DecodeError struct {
+	Offset int // offset in the message where decoding failed
+	Reason DecodeFailure
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("error decoding message at offset %d: %s", e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// ResultError is an error carrying the LDAP result to answer with, handlers
+// registered with RouteMux.HandleErrors may return it
+type ResultError struct {
+	ResultCode        int
+	DiagnosticMessage string
+}
+
+// NewResultError returns an error answered with resultCode
+func NewResultError(resultCode int, diagnosticMessage string) *ResultError {
+	return &ResultError{ResultCode: resultCode, DiagnosticMessage: diagnosticMessage}
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("result code %d: %s", e.ResultCode, e.DiagnosticMessage)
+}
@@ -0,0 +1,152 @@
+package ldapserver
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Event is emitted on the server EventBus, it is one of ListenerStarted,
+// ListenFailed, ConnAccepted, ConnClosed, OpStarted, OpFinished, OpTerminated,
+// WriteDenied, ReadinessChanged, ServerStopping or ShutdownPhaseReached
+type Event interface {
+	event()
+}
+
+// ListenerStarted is emitted when the server starts accepting connections
+// on a listener
+type ListenerStarted struct {
+	Addr net.Addr
+}
+
+// ListenFailed is emitted when ListenAndServeAll fails to bind one of the
+// addresses it serves
+type ListenFailed struct {
+	Addr string
+	Err  error
+}
+
+// ConnAccepted is emitted when a client connection is accepted
+type ConnAccepted struct {
+	Numero     int
+	RemoteAddr net.Addr
+}
+
+// ConnClosed is emitted once a client connection is closed
+type ConnClosed struct {
+	Numero     int
+	RemoteAddr net.Addr
+}
+
+// OpStarted is emitted before a request is passed to the Handler
+type OpStarted struct {
+	Numero    int
+	MessageID int
+	Operation string // protocol operation name, SearchRequest for instance
+}
+
+// OpFinished is emitted when the Handler returns
+type OpFinished struct {
+	Numero     int
+	MessageID  int
+	Operation  string
+	Duration   time.Duration
+	FilterCost int // estimated cost of the search filter, see FilterCostPolicy
+
+	// NamingContext is the suffix of the ContextMux naming context which
+	// served the request, empty outside of one
+	NamingContext string
+}
+
+// OpTerminated is emitted when a running request is signaled to stop
+// before completing
+type OpTerminated struct {
+	Numero    int
+	MessageID int
+	Operation string
+	Reason    TerminationReason
+
+	NamingContext string // see OpFinished
+}
+
+// WriteDenied is emitted when ModifyAccess refuses changes of a Modify
+// request
+type WriteDenied struct {
+	Numero    int
+	MessageID int
+	BindDN    string // authorization identity DN, the proxied one with ProxiedAuthorization
+	DN        string // entry modified
+	Changes   []DeniedChange
+	Stripped  bool // the other changes were passed to the handler
+
+	NamingContext string // see OpFinished
+}
+
+// ServerStopping is emitted when the server starts stopping
+type ServerStopping struct{}
+
+// ShutdownPhaseReached is emitted once the OnShutdown hooks of a phase
+// returned
+type ShutdownPhaseReached struct {
+	Phase ShutdownPhase
+}
+
+func (ListenerStarted) event()      {}
+func (ListenFailed) event()         {}
+func (ConnAccepted) event()         {}
+func (ConnClosed) event()           {}
+func (OpStarted) event()            {}
+func (OpFinished) event()           {}
+func (OpTerminated) event()         {}
+func (WriteDenied) event()          {}
+func (ServerStopping) event()       {}
+func (ShutdownPhaseReached) event() {}
+
+// EventBus dispatches the server events to its subscribers. Subscribers
+// are called synchronously from the goroutine emitting the event, in
+// subscription order, so they must not block.
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers []*subscriber
+}
+
+type subscriber struct {
+	f func(Event)
+}
+
+// Subscribe registers f to receive all events, until the returned function
+// is called
+func (b *EventBus) Subscribe(f func(Event)) (unsubscribe func()) {
+	sub := &subscriber{f: f}
+
+	b.mu.Lock()
+	b.subscribers = append(b.subscribers, sub)
+	b.mu.Unlock()
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for i, s := range b.subscribers {
+			if s == sub {
+				b.subscribers = append(b.subscribers[:i:i], b.subscribers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// emit calls the subscribers with e
+func (b *EventBus) emit(e Event) {
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+
+	for _, s := range subscribers {
+		s.f(e)
+	}
+}
+
+// Events returns the bus on which the server emits its lifecycle events
+func (s *Server) Events() *EventBus {
+	return &s.events
+}
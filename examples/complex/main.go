@@ -2,7 +2,6 @@ package main
 
 import (
 	"crypto/tls"
-	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -18,7 +17,7 @@ func main() {
 	//Create routes bindings
 	routes := ldap.NewRouteMux()
 	routes.NotFound(handleNotFound)
-	routes.Abandon(handleAbandon)
+	routes.Abandon(ldap.HandleAbandon)
 	routes.Bind(handleBind)
 	routes.Compare(handleCompare)
 	routes.Add(handleAdd)
@@ -82,15 +81,6 @@ func handleNotFound(w ldap.ResponseWriter, r *ldap.Message) {
 	}
 }
 
-func handleAbandon(w ldap.ResponseWriter, m *ldap.Message) {
-	var req = m.GetAbandonRequest()
-	// retreive the request to abandon, and send a abort signal to it
-	if requestToAbandon, ok := m.Client.GetMessageByID(int(req)); ok {
-		requestToAbandon.Abandon()
-		log.Printf("Abandon signal sent to request processor [messageID=%d]", int(req))
-	}
-}
-
 func handleBind(w ldap.ResponseWriter, m *ldap.Message) {
 	r := m.GetBindRequest()
 	res := ldap.NewBindResponse(ldap.LDAPResultSuccess)
@@ -310,19 +300,9 @@ func getTLSconfig() (*tls.Config, error) {
 
 func handleStartTLS(w ldap.ResponseWriter, m *ldap.Message) {
 	tlsconfig, _ := getTLSconfig()
-	tlsConn := tls.Server(m.Client.GetConn(), tlsconfig)
-	res := ldap.NewExtendedResponse(ldap.LDAPResultSuccess)
-	res.SetResponseName(ldap.NoticeOfStartTLS)
-	w.Write(res)
-
-	if err := tlsConn.Handshake(); err != nil {
-		log.Printf("StartTLS Handshake error %v", err)
-		res.SetDiagnosticMessage(fmt.Sprintf("StartTLS Handshake error : \"%s\"", err.Error()))
-		res.SetResultCode(ldap.LDAPResultOperationsError)
-		w.Write(res)
+	if err := m.Client.StartTLS(w, m, tlsconfig); err != nil {
+		log.Printf("StartTLS error %v", err)
 		return
 	}
-
-	m.Client.SetConn(tlsConn)
 	log.Println("StartTLS OK")
 }
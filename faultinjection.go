@@ -0,0 +1,129 @@
+package ldapserver
+
+import (
+	"math/rand"
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// Fault is a fault simulated by a FaultInjector
+type Fault string
+
+const (
+	FaultDelay    Fault = "delay"    // the request is handled after Delay
+	FaultDrop     Fault = "drop"     // the connection is closed without a response
+	FaultBusy     Fault = "busy"     // the request is answered with busy
+	FaultTruncate Fault = "truncate" // the response is cut and the connection closed
+)
+
+// FaultInjector simulates faults on the requests it handles, so the retry
+// behavior of clients can be verified against this server. It is meant for
+// test environments only. Each fault is drawn independently, with its
+// probability between 0 and 1; a delay may precede any other fault.
+// Requests without a connection, served through a ResponseRecorder for
+// instance, are left unanswered by the drop and truncate faults.
+type FaultInjector struct {
+	Delay               time.Duration // delay of the delayed requests
+	DelayProbability    float64
+	DropProbability     float64
+	BusyProbability     float64
+	TruncateProbability float64
+
+	// Float64 returns a pseudo-random number in [0.0,1.0), math/rand
+	// Float64 if nil
+	Float64 func() float64
+
+	// OnFault, if set, is called with each fault injected
+	OnFault func(m *Message, fault Fault)
+}
+
+// Middleware returns a middleware injecting faults before the request
+// reaches the next handler
+func (f *FaultInjector) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w ResponseWriter, m *Message) {
+			// abandon requests have no response to alter
+			if _, ok := m.ProtocolOp().(ldap.AbandonRequest); ok {
+				next(w, m)
+				return
+			}
+
+			if f.Delay > 0 && f.draw(f.DelayProbability) {
+				f.inject(m, FaultDelay)
+				select {
+				case <-time.After(f.Delay):
+				case <-m.Context().Done():
+					return
+				}
+			}
+
+			switch {
+			case f.draw(f.DropProbability):
+				f.inject(m, FaultDrop)
+				if m.Client != nil {
+					m.Client.disconnect()
+				}
+			case f.draw(f.BusyProbability):
+				f.inject(m, FaultBusy)
+				w.Write(NewResponseForRequest(m.ProtocolOp(), LDAPResultBusy, "simulated fault"))
+			case f.draw(f.TruncateProbability):
+				rec := NewResponseRecorder()
+				rec.MessageID = m.MessageID().Int()
+				next(rec, m)
+				f.inject(m, FaultTruncate)
+				if m.Client != nil {
+					m.Client.writeTruncated(rec)
+				}
+			default:
+				next(w, m)
+			}
+		}
+	}
+}
+
+// draw reports whether an event of probability p happens
+func (f *FaultInjector) draw(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	random := rand.Float64
+	if f.Float64 != nil {
+		random = f.Float64
+	}
+	return random() < p
+}
+
+func (f *FaultInjector) inject(m *Message, fault Fault) {
+	m.logAt(LogLevelWarn, "injecting fault %s", fault)
+	if f.OnFault != nil {
+		f.OnFault(m, fault)
+	}
+}
+
+// writeTruncated writes the responses recorded by rec cut short, then
+// disconnects the client as if the connection broke while writing
+func (c *client) writeTruncated(rec *ResponseRecorder) {
+	var encoded []byte
+	for _, message := range rec.Messages() {
+		data, err := message.Write()
+		if err != nil {
+			continue
+		}
+		encoded = append(encoded, data.Bytes()...)
+	}
+	if len(encoded) > 1 {
+		c.chanOut <- &outMessage{encoded: encoded[:1+rand.Intn(len(encoded)-1)]}
+		flushed := make(chan struct{})
+		c.chanOut <- &outMessage{flushed: flushed}
+		<-flushed
+	}
+	c.disconnect()
+}
+
+// disconnect drops the client connection: no more response is written and
+// the connection is closed once the running requests end
+func (c *client) disconnect() {
+	c.lost()
+	c.rwc.SetReadDeadline(time.Now().Add(time.Millisecond))
+}
@@ -0,0 +1,37 @@
+package ldapserver
+
+import "testing"
+
+func TestFaultInjectorWithoutClient(t *testing.T) {
+	for _, fault := range []Fault{FaultDrop, FaultBusy, FaultTruncate} {
+		t.Run(string(fault), func(t *testing.T) {
+			f := &FaultInjector{Float64: func() float64 { return 0 }}
+			switch fault {
+			case FaultDrop:
+				f.DropProbability = 1
+			case FaultBusy:
+				f.BusyProbability = 1
+			case FaultTruncate:
+				f.TruncateProbability = 1
+			}
+			var injected []Fault
+			f.OnFault = func(m *Message, fault Fault) { injected = append(injected, fault) }
+			handler := f.Middleware()(func(w ResponseWriter, m *Message) {
+				w.Write(NewResponseForRequest(m.ProtocolOp(), LDAPResultSuccess, ""))
+			})
+
+			w := NewResponseRecorder()
+			handler(w, testMessage(t, berOctetString(berClassApplication|ApplicationDelRequest, []byte("cn=alice"))))
+			if len(injected) != 1 || injected[0] != fault {
+				t.Errorf("injected %v, want %s", injected, fault)
+			}
+			want := -1
+			if fault == FaultBusy {
+				want = LDAPResultBusy
+			}
+			if w.ResultCode() != want {
+				t.Errorf("result code %d, want %d", w.ResultCode(), want)
+			}
+		})
+	}
+}
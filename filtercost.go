@@ -0,0 +1,130 @@
+package ldapserver
+
+import (
+	"fmt"
+	"sync"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// Filter cost units of DefaultFilterCost
+const (
+	FilterCostIndexed = 1   // lookup of an indexed attribute
+	FilterCostRange   = 10  // range or leading wildcard lookup of an indexed attribute
+	FilterCostScan    = 100 // scan of all the entries
+)
+
+// FilterCostPolicy scores the estimated cost of search filters, to reject
+// or deprioritize expensive searches. The score of a search is available to
+// handlers with Message.FilterCost and reported in OpFinished events.
+type FilterCostPolicy struct {
+	// Score estimates the cost of the filter of the search m. If nil, the
+	// score is DefaultFilterCost(f, Indexed).
+	Score func(m *Message, f ldap.Filter) int
+
+	// Indexed reports whether searching an attribute is cheap, it is used
+	// by the default Score. If nil, all attributes are indexed.
+	Indexed func(attribute string) bool
+
+	// Reject, if non-zero, is the score from which searches are refused
+	// with unwillingToPerform
+	Reject int
+
+	// Expensive, if non-zero, is the score from which searches are
+	// deprioritized: at most MaxExpensive of them (1 if zero) are passed to
+	// the Handler at the same time, the others wait for their turn
+	Expensive    int
+	MaxExpensive int
+
+	slotsOnce sync.Once
+	slots     chan struct{}
+}
+
+// DefaultFilterCost estimates the cost of a filter in FilterCost units:
+// an and costs as much as its cheapest term, an or the sum of its terms,
+// and a not, an extensible match or a term over an attribute which is not
+// indexed require a scan. A nil indexed treats all attributes as indexed.
+func DefaultFilterCost(f ldap.Filter, indexed func(attribute string) bool) int {
+	lookup := func(attribute ldap.AttributeDescription, cost int) int {
+		if indexed != nil && !indexed(string(attribute)) {
+			return FilterCostScan
+		}
+		return cost
+	}
+
+	switch f := f.(type) {
+	case ldap.FilterAnd:
+		cost := 0
+		for i, child := range f {
+			if c := DefaultFilterCost(child, indexed); i == 0 || c < cost {
+				cost = c
+			}
+		}
+		return cost
+	case ldap.FilterOr:
+		cost := 0
+		for _, child := range f {
+			cost += DefaultFilterCost(child, indexed)
+		}
+		return cost
+	case ldap.FilterNot:
+		return FilterCostScan + DefaultFilterCost(f.Filter, indexed)
+	case ldap.FilterEqualityMatch:
+		return lookup(f.AttributeDesc(), FilterCostIndexed)
+	case ldap.FilterApproxMatch:
+		return lookup(f.AttributeDesc(), FilterCostIndexed)
+	case ldap.FilterPresent:
+		return lookup(ldap.AttributeDescription(f), FilterCostIndexed)
+	case ldap.FilterGreaterOrEqual:
+		return lookup(f.AttributeDesc(), FilterCostRange)
+	case ldap.FilterLessOrEqual:
+		return lookup(f.AttributeDesc(), FilterCostRange)
+	case ldap.FilterSubstrings:
+		if substrings := f.Substrings(); len(substrings) > 0 {
+			if _, ok := substrings[0].(ldap.SubstringInitial); ok {
+				return lookup(f.Type_(), FilterCostIndexed)
+			}
+		}
+		return lookup(f.Type_(), FilterCostRange)
+	}
+	return FilterCostScan
+}
+
+// score returns the cost of the search m
+func (p *FilterCostPolicy) score(m *Message) int {
+	r := m.GetSearchRequest()
+	f := r.Filter()
+	if p.Score != nil {
+		return p.Score(m, f)
+	}
+	return DefaultFilterCost(f, p.Indexed)
+}
+
+// admit scores the search m, and either returns the response refusing it,
+// or a function to call once it is handled
+func (p *FilterCostPolicy) admit(m *Message) (ldap.ProtocolOp, func()) {
+	m.filterCost = p.score(m)
+	if p.Reject > 0 && m.filterCost >= p.Reject {
+		return NewResponseForRequest(m.ProtocolOp(), LDAPResultUnwillingToPerform,
+			fmt.Sprintf("search filter is too expensive (cost %d)", m.filterCost)), nil
+	}
+	if p.Expensive <= 0 || m.filterCost < p.Expensive {
+		return nil, func() {}
+	}
+
+	p.slotsOnce.Do(func() {
+		max := p.MaxExpensive
+		if max < 1 {
+			max = 1
+		}
+		p.slots = make(chan struct{}, max)
+	})
+	select {
+	case p.slots <- struct{}{}:
+	case <-m.Done:
+		// let the Handler deal with the abandon as usual
+		m.Done <- true
+		return nil, func() {}
+	}
+	return nil, func() { <-p.slots }
+}
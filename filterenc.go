@@ -0,0 +1,260 @@
+package ldapserver
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// ParseFilter parses an RFC 4515 filter string into a goldap Filter, to
+// build filters for tests, route conditions or search requests for
+// instance. The outer parentheses may be omitted, as in "cn=foo".
+func ParseFilter(s string) (ldap.Filter, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, fmt.Errorf("empty filter")
+	}
+	if s[0] != '(' {
+		s = "(" + s + ")"
+	}
+	p := filterParser{s: s}
+	f, err := p.parseFilter()
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %s", s, err)
+	}
+	if p.pos != len(p.s) {
+		return nil, fmt.Errorf("invalid filter %q: unexpected %q at offset %d", s, p.s[p.pos], p.pos)
+	}
+	return f, nil
+}
+
+type filterParser struct {
+	s   string
+	pos int
+}
+
+func (p *filterParser) parseFilter() (ldap.Filter, error) {
+	if p.pos >= len(p.s) || p.s[p.pos] != '(' {
+		return nil, fmt.Errorf("missing '(' at offset %d", p.pos)
+	}
+	p.pos++
+	if p.pos >= len(p.s) {
+		return nil, fmt.Errorf("unterminated filter")
+	}
+
+	var f ldap.Filter
+	var err error
+	switch p.s[p.pos] {
+	case '&':
+		p.pos++
+		var filters []ldap.Filter
+		if filters, err = p.parseFilterList(); err == nil {
+			f = ldap.FilterAnd(filters)
+		}
+	case '|':
+		p.pos++
+		var filters []ldap.Filter
+		if filters, err = p.parseFilterList(); err == nil {
+			f = ldap.FilterOr(filters)
+		}
+	case '!':
+		p.pos++
+		var not ldap.Filter
+		if not, err = p.parseFilter(); err == nil {
+			f = ldap.FilterNot{Filter: not}
+		}
+	default:
+		f, err = p.parseItem()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if p.pos >= len(p.s) || p.s[p.pos] != ')' {
+		return nil, fmt.Errorf("missing ')' at offset %d", p.pos)
+	}
+	p.pos++
+	return f, nil
+}
+
+func (p *filterParser) parseFilterList() ([]ldap.Filter, error) {
+	var filters []ldap.Filter
+	for p.pos < len(p.s) && p.s[p.pos] == '(' {
+		f, err := p.parseFilter()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+// parseItem parses a simple, present, substring or extensible item, up to
+// the closing parenthesis
+func (p *filterParser) parseItem() (ldap.Filter, error) {
+	end := strings.IndexByte(p.s[p.pos:], ')')
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated filter")
+	}
+	item := p.s[p.pos : p.pos+end]
+	p.pos += end
+
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, fmt.Errorf("invalid filter item %q", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+
+	switch attr[len(attr)-1] {
+	case '>':
+		ava, err := p.assertion(attr[:len(attr)-1], value)
+		return ldap.FilterGreaterOrEqual(ava), err
+	case '<':
+		ava, err := p.assertion(attr[:len(attr)-1], value)
+		return ldap.FilterLessOrEqual(ava), err
+	case '~':
+		ava, err := p.assertion(attr[:len(attr)-1], value)
+		return ldap.FilterApproxMatch(ava), err
+	case ':':
+		return p.extensible(attr[:len(attr)-1], value)
+	}
+
+	if value == "*" {
+		if !validAttributeDescription(attr) {
+			return nil, fmt.Errorf("invalid attribute description %q", attr)
+		}
+		return ldap.FilterPresent(attr), nil
+	}
+	if strings.IndexByte(value, '*') >= 0 {
+		return p.substrings(attr, value)
+	}
+	ava, err := p.assertion(attr, value)
+	return ldap.FilterEqualityMatch(ava), err
+}
+
+func (p *filterParser) assertion(attr string, value string) (ldap.AttributeValueAssertion, error) {
+	var ava ldap.AttributeValueAssertion
+	if !validAttributeDescription(attr) {
+		return ava, fmt.Errorf("invalid attribute description %q", attr)
+	}
+	v, err := unescapeFilterValue(value)
+	if err != nil {
+		return ava, err
+	}
+	ava.SetAttributeDesc(ldap.AttributeDescription(attr))
+	ava.SetAssertionValue(ldap.AssertionValue(v))
+	return ava, nil
+}
+
+func (p *filterParser) substrings(attr string, value string) (ldap.Filter, error) {
+	if !validAttributeDescription(attr) {
+		return nil, fmt.Errorf("invalid attribute description %q", attr)
+	}
+	parts := strings.Split(value, "*")
+	var substrings []ldap.Substring
+	for i, part := range parts {
+		if part == "" {
+			if i != 0 && i != len(parts)-1 {
+				return nil, fmt.Errorf("invalid substrings %q", value)
+			}
+			continue
+		}
+		v, err := unescapeFilterValue(part)
+		if err != nil {
+			return nil, err
+		}
+		switch i {
+		case 0:
+			substrings = append(substrings, ldap.SubstringInitial(v))
+		case len(parts) - 1:
+			substrings = append(substrings, ldap.SubstringFinal(v))
+		default:
+			substrings = append(substrings, ldap.SubstringAny(v))
+		}
+	}
+	f := ldap.FilterSubstrings{}
+	f.SetType(ldap.AttributeDescription(attr))
+	f.SetSubstrings(substrings)
+	return f, nil
+}
+
+// extensible parses attr[:dn][:rule] or [:dn]:rule, the part before ":="
+func (p *filterParser) extensible(desc string, value string) (ldap.Filter, error) {
+	parts := strings.Split(desc, ":")
+	attr, rule, dnAttributes := parts[0], "", false
+	for _, part := range parts[1:] {
+		switch {
+		case part == "dn" && !dnAttributes && rule == "":
+			dnAttributes = true
+		case part != "" && rule == "":
+			rule = part
+		default:
+			return nil, fmt.Errorf("invalid extensible match %q", desc)
+		}
+	}
+	if attr == "" && rule == "" {
+		return nil, fmt.Errorf("extensible match %q needs a type or a matching rule", desc)
+	}
+	if attr != "" && !validAttributeDescription(attr) {
+		return nil, fmt.Errorf("invalid attribute description %q", attr)
+	}
+	v, err := unescapeFilterValue(value)
+	if err != nil {
+		return nil, err
+	}
+
+	f := ldap.FilterExtensibleMatch{}
+	if rule != "" {
+		f.SetMatchingRule(ldap.MatchingRuleId(rule))
+	}
+	if attr != "" {
+		f.SetType(ldap.AttributeDescription(attr))
+	}
+	f.SetMatchValue(ldap.AssertionValue(v))
+	f.SetDnAttributes(ldap.BOOLEAN(dnAttributes))
+	return f, nil
+}
+
+// unescapeFilterValue decodes the \XX escapes of an assertion value
+func unescapeFilterValue(s string) ([]byte, error) {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			if i+2 >= len(s) || !isHexDigit(s[i+1]) || !isHexDigit(s[i+2]) {
+				return nil, fmt.Errorf("invalid escape sequence in %q", s)
+			}
+			v, _ := hex.DecodeString(s[i+1 : i+3])
+			b = append(b, v[0])
+			i += 2
+		case '(', ')', '*', 0:
+			return nil, fmt.Errorf("unescaped %q in %q", c, s)
+		default:
+			b = append(b, c)
+		}
+	}
+	return b, nil
+}
+
+// validAttributeDescription reports whether s is an attribute type with
+// options, such as "cn" or "userCertificate;binary"
+func validAttributeDescription(s string) bool {
+	parts := strings.Split(s, ";")
+	if !validAttributeType(parts[0]) {
+		return false
+	}
+	for _, option := range parts[1:] {
+		if option == "" {
+			return false
+		}
+		for i := 0; i < len(option); i++ {
+			c := option[i]
+			if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
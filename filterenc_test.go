@@ -0,0 +1,93 @@
+package ldapserver
+
+import "testing"
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string // FilterString of the parsed filter
+		wantErr bool
+	}{
+		{in: "cn=foo", want: "(cn=foo)"},
+		{in: " (cn=foo) ", want: "(cn=foo)"},
+		{in: "(objectClass=*)", want: "(objectClass=*)"},
+		{in: "(cn;lang-en=foo)", want: "(cn;lang-en=foo)"},
+		{in: "(2.5.4.3=foo)", want: "(2.5.4.3=foo)"},
+		{in: "(uidNumber>=1000)", want: "(uidNumber>=1000)"},
+		{in: "(uidNumber<=1000)", want: "(uidNumber<=1000)"},
+		{in: "(cn~=jon)", want: "(cn~=jon)"},
+		{in: "(cn=a*)", want: "(cn=a*)"},
+		{in: "(cn=*a)", want: "(cn=*a)"},
+		{in: "(cn=*a*b*)", want: "(cn=*a*b*)"},
+		{in: "(cn=a*b*c)", want: "(cn=a*b*c)"},
+		{in: "(&(objectClass=person)(|(uid=a*)(mail=*@example.com)))", want: "(&(objectClass=person)(|(uid=a*)(mail=*@example.com)))"},
+		{in: "(!(cn=foo))", want: "(!(cn=foo))"},
+		{in: "(cn:caseExactMatch:=Foo)", want: "(cn:caseExactMatch:=Foo)"},
+		{in: "(cn:dn:2.5.13.5:=Foo)", want: "(cn:dn:2.5.13.5:=Foo)"},
+		{in: "(:1.2.840.113556.1.4.803:=2)", want: "(:1.2.840.113556.1.4.803:=2)"},
+		{in: "(cn:=foo)", want: "(cn:=foo)"},
+		{in: `(cn=a\2ab)`, want: `(cn=a\2ab)`},
+		{in: `(cn=\28\29\5c)`, want: `(cn=\28\29\5c)`},
+		{in: `(cn=caf\c3\a9)`, want: "(cn=café)"},
+		{in: "", wantErr: true},
+		{in: "(cn=foo", wantErr: true},
+		{in: "(cn=foo))", wantErr: true},
+		{in: "((cn=foo))", wantErr: true},
+		{in: "(=foo)", wantErr: true},
+		{in: "(cn)", wantErr: true},
+		{in: "(c_n=foo)", wantErr: true},
+		{in: "(cn;=foo)", wantErr: true},
+		{in: "(cn=a**b)", wantErr: true},
+		{in: `(cn=\zz)`, wantErr: true},
+		{in: `(cn=a\2)`, wantErr: true},
+		{in: "(!(cn=a)(cn=b))", wantErr: true},
+		{in: "(:=foo)", wantErr: true},
+		{in: "(cn:rule:other:=foo)", wantErr: true},
+	}
+	for _, tt := range tests {
+		f, err := ParseFilter(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseFilter(%q) = %s, want an error", tt.in, FilterString(f))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseFilter(%q): %s", tt.in, err)
+			continue
+		}
+		if s := FilterString(f); s != tt.want {
+			t.Errorf("ParseFilter(%q) = %s, want %s", tt.in, s, tt.want)
+		}
+	}
+}
+
+func TestUnescapeFilterValue(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "foo", want: "foo"},
+		{in: "", want: ""},
+		{in: `\2a`, want: "*"},
+		{in: `\2A\28`, want: "*("},
+		{in: `a\00b`, want: "a\x00b"},
+		{in: `\`, wantErr: true},
+		{in: `\g0`, wantErr: true},
+		{in: "a*", wantErr: true},
+		{in: "a(", wantErr: true},
+	}
+	for _, tt := range tests {
+		v, err := unescapeFilterValue(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("unescapeFilterValue(%q) = %q, want an error", tt.in, v)
+			}
+			continue
+		}
+		if err != nil || string(v) != tt.want {
+			t.Errorf("unescapeFilterValue(%q) = %q, %v, want %q", tt.in, v, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package ldapserver
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// Matches reports whether the entry e matches the filter f, so handlers
+// backed by SQL or NoSQL stores can post-filter the candidate rows of a
+// search converted to entries. Values are compared ignoring case, except
+// binary ones, ordering compares integers numerically, approximate matches
+// compare the words of the values phonetically and extensible matches
+// support the matching rules of MatchingRules. The error reports a filter
+// whose value is Undefined (RFC 4511 section 4.5.1.7), because of an
+// unknown matching rule, which matches no entry.
+func Matches(f ldap.Filter, e Entry) (bool, error) {
+	return evalFilter(&e, f, nil)
+}
+
+// matchFilter reports whether the entry e matches the filter f, filters
+// evaluating to Undefined match no entry
+func matchFilter(e *Entry, f ldap.Filter) bool {
+	ok, err := evalFilter(e, f, nil)
+	return ok && err == nil
+}
+
+// evalFilter evaluates f for e, the error reporting the Undefined value.
+// An AND with a FALSE component is FALSE and an OR with a TRUE component
+// TRUE even when other components are Undefined. The components asserting
+// an attribute for which hidden, if non-nil, returns true are Undefined.
+func evalFilter(e *Entry, f ldap.Filter, hidden func(attribute string) bool) (bool, error) {
+	if hidden != nil {
+		if attribute := filterAttribute(f); attribute != "" && hidden(attribute) {
+			return false, errHiddenAttribute
+		}
+	}
+	switch f := f.(type) {
+	case ldap.FilterAnd:
+		var undefined error
+		for _, child := range f {
+			ok, err := evalFilter(e, child, hidden)
+			switch {
+			case err != nil:
+				undefined = err
+			case !ok:
+				return false, nil
+			}
+		}
+		return undefined == nil, undefined
+	case ldap.FilterOr:
+		var undefined error
+		for _, child := range f {
+			ok, err := evalFilter(e, child, hidden)
+			switch {
+			case err != nil:
+				undefined = err
+			case ok:
+				return true, nil
+			}
+		}
+		return false, undefined
+	case ldap.FilterNot:
+		ok, err := evalFilter(e, f.Filter, hidden)
+		if err != nil {
+			return false, err
+		}
+		return !ok, nil
+	case ldap.FilterPresent:
+		return len(e.values(string(f))) > 0, nil
+	case ldap.FilterEqualityMatch:
+		return e.anyValue(string(f.AttributeDesc()), func(v []byte) bool {
+			return CompareValues(string(f.AttributeDesc()), v, []byte(f.AssertionValue())) == 0
+		}), nil
+	case ldap.FilterApproxMatch:
+		return e.anyValue(string(f.AttributeDesc()), func(v []byte) bool {
+			return approxEqual(string(v), string(f.AssertionValue()))
+		}), nil
+	case ldap.FilterGreaterOrEqual:
+		return e.anyValue(string(f.AttributeDesc()), func(v []byte) bool {
+			return CompareValues(string(f.AttributeDesc()), v, []byte(f.AssertionValue())) >= 0
+		}), nil
+	case ldap.FilterLessOrEqual:
+		return e.anyValue(string(f.AttributeDesc()), func(v []byte) bool {
+			return CompareValues(string(f.AttributeDesc()), v, []byte(f.AssertionValue())) <= 0
+		}), nil
+	case ldap.FilterSubstrings:
+		return e.anyValue(string(f.Type_()), func(v []byte) bool {
+			return matchSubstrings(f, v)
+		}), nil
+	case ldap.FilterExtensibleMatch:
+		return matchExtensible(e, f)
+	}
+	return false, nil
+}
+
+// values returns the values of the attribute name of e, options excepted
+func (e *Entry) values(name string) [][]byte {
+	name = attributeType(name)
+	var values [][]byte
+	for _, a := range e.Attributes {
+		if attributeType(a.Name) == name {
+			values = append(values, a.Values...)
+		}
+	}
+	return values
+}
+
+// anyValue reports whether a value of the attribute name of e satisfies f
+func (e *Entry) anyValue(name string, f func(v []byte) bool) bool {
+	for _, v := range e.values(name) {
+		if f(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// attributeType returns the attribute type of an attribute description,
+// lowercased and without options
+func attributeType(description string) string {
+	if i := strings.IndexByte(description, ';'); i >= 0 {
+		description = description[:i]
+	}
+	return strings.ToLower(description)
+}
+
+// CompareValues compares two values of the attribute name as the filters
+// of Matches do: binary values byte by byte, integers numerically, and the
+// other values ignoring case
+func CompareValues(name string, a, b []byte) int {
+	if IsBinaryAttribute(name) {
+		return bytes.Compare(a, b)
+	}
+	if x, err := strconv.ParseInt(string(a), 10, 64); err == nil {
+		if y, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(strings.ToLower(string(a)), strings.ToLower(string(b)))
+}
+
+// matchSubstrings reports whether the value v matches the substrings
+// filter f
+func matchSubstrings(f ldap.FilterSubstrings, v []byte) bool {
+	binary := IsBinaryAttribute(string(f.Type_()))
+	fold := func(s string) string {
+		if binary {
+			return s
+		}
+		return strings.ToLower(s)
+	}
+	value := fold(string(v))
+	for _, s := range f.Substrings() {
+		switch s := s.(type) {
+		case ldap.SubstringInitial:
+			prefix := fold(string(s))
+			if !strings.HasPrefix(value, prefix) {
+				return false
+			}
+			value = value[len(prefix):]
+		case ldap.SubstringAny:
+			part := fold(string(s))
+			i := strings.Index(value, part)
+			if i < 0 {
+				return false
+			}
+			value = value[i+len(part):]
+		case ldap.SubstringFinal:
+			if !strings.HasSuffix(value, fold(string(s))) {
+				return false
+			}
+			value = ""
+		}
+	}
+	return true
+}
@@ -0,0 +1,251 @@
+package ldapserver
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GroupResolver resolves the groups an identity is a member of, through
+// the member and uniqueMember attributes holding member DNs, and the
+// memberUid attribute holding member uids. Results are cached.
+type GroupResolver struct {
+	// Lookup returns the DNs of the groups whose attribute holds value. It
+	// may be built with ClientGroupLookup.
+	Lookup func(attribute string, value string) ([]string, error)
+
+	// Attributes are the membership attributes looked up, member and
+	// uniqueMember if empty. memberUid is looked up with the value of the
+	// uid RDN of the identity.
+	Attributes []string
+
+	// Nested resolves the groups of the groups, up to MaxDepth levels (10
+	// if zero)
+	Nested   bool
+	MaxDepth int
+
+	// CacheTTL, if non-zero, is the time resolved memberships are cached
+	CacheTTL time.Duration
+
+	// MaxCacheEntries is the number of identities whose memberships are
+	// cached, 10000 if zero. Beyond, the expired memberships are dropped,
+	// then the one expiring first.
+	MaxCacheEntries int
+
+	mu    sync.Mutex
+	cache map[string]groupCacheEntry
+}
+
+type groupCacheEntry struct {
+	groups  []string
+	expires time.Time
+}
+
+// Groups returns the normalized DNs of the groups dn is a member of
+func (g *GroupResolver) Groups(dn string) ([]string, error) {
+	key := NormalizeDN(dn)
+	if g.CacheTTL != 0 {
+		g.mu.Lock()
+		cached, ok := g.cache[key]
+		g.mu.Unlock()
+		if ok && time.Now().Before(cached.expires) {
+			return cached.groups, nil
+		}
+	}
+
+	groups, err := g.resolve(dn)
+	if err != nil {
+		return nil, err
+	}
+
+	if g.CacheTTL != 0 {
+		g.mu.Lock()
+		if g.cache == nil {
+			g.cache = make(map[string]groupCacheEntry)
+		}
+		if _, ok := g.cache[key]; !ok {
+			g.evict()
+		}
+		g.cache[key] = groupCacheEntry{groups: groups, expires: time.Now().Add(g.CacheTTL)}
+		g.mu.Unlock()
+	}
+	return groups, nil
+}
+
+// evict makes room for a membership in the cache, g.mu is held
+func (g *GroupResolver) evict() {
+	maxEntries := g.MaxCacheEntries
+	if maxEntries <= 0 {
+		maxEntries = 10000
+	}
+	if len(g.cache) < maxEntries {
+		return
+	}
+	now := time.Now()
+	for key, cached := range g.cache {
+		if !now.Before(cached.expires) {
+			delete(g.cache, key)
+		}
+	}
+	for len(g.cache) >= maxEntries {
+		var first string
+		var expires time.Time
+		for key, cached := range g.cache {
+			if expires.IsZero() || cached.expires.Before(expires) {
+				first, expires = key, cached.expires
+			}
+		}
+		delete(g.cache, first)
+	}
+}
+
+// Invalidate drops the cached memberships, for instance once a group is
+// modified
+func (g *GroupResolver) Invalidate() {
+	g.mu.Lock()
+	g.cache = nil
+	g.mu.Unlock()
+}
+
+func (g *GroupResolver) resolve(dn string) ([]string, error) {
+	attributes := g.Attributes
+	if len(attributes) == 0 {
+		attributes = []string{"member", "uniqueMember"}
+	}
+	maxDepth := 1
+	if g.Nested {
+		maxDepth = g.MaxDepth
+		if maxDepth <= 0 {
+			maxDepth = 10
+		}
+	}
+
+	var groups []string
+	seen := map[string]bool{NormalizeDN(dn): true}
+	members := []string{dn}
+	for depth := 0; depth < maxDepth && len(members) > 0; depth++ {
+		var found []string
+		for _, member := range members {
+			for _, attribute := range attributes {
+				dns, err := g.Lookup(attribute, member)
+				if err != nil {
+					return nil, err
+				}
+				found = append(found, dns...)
+			}
+			// only the identity itself has a uid, groups are nested by DN
+			if depth == 0 {
+				if uid := rdnValue(member, "uid"); uid != "" {
+					dns, err := g.Lookup("memberUid", uid)
+					if err != nil {
+						return nil, err
+					}
+					found = append(found, dns...)
+				}
+			}
+		}
+
+		members = members[:0]
+		for _, group := range found {
+			normalized := NormalizeDN(group)
+			if seen[normalized] {
+				continue
+			}
+			seen[normalized] = true
+			groups = append(groups, normalized)
+			members = append(members, group)
+		}
+	}
+	return groups, nil
+}
+
+// IsMember reports whether dn is a member of group
+func (g *GroupResolver) IsMember(dn string, group string) (bool, error) {
+	groups, err := g.Groups(dn)
+	if err != nil {
+		return false, err
+	}
+	group = NormalizeDN(group)
+	for _, other := range groups {
+		if other == group {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// InGroup reports whether the authorization identity of m, see AuthzID, is
+// a member of one of the groups, for use in authorization functions such
+// as NamingContext.Authorize. Lookup errors are logged and deny access.
+func (g *GroupResolver) InGroup(m *Message, groups ...string) bool {
+	dn := authzDN(m)
+	if dn == "" {
+		return false
+	}
+	for _, group := range groups {
+		ok, err := g.IsMember(dn, group)
+		if err != nil {
+			m.logAt(LogLevelError, "error resolving groups of %s: %s", dn, err)
+			return false
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireGroup returns a middleware answering insufficientAccessRights to
+// the requests of clients which are not bound as a member of one of the
+// groups. It may wrap a single route handler:
+//
+//	routes.Search(groups.RequireGroup("cn=admins,ou=groups,dc=example,dc=com")(handleSearch))
+func (g *GroupResolver) RequireGroup(groups ...string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w ResponseWriter, m *Message) {
+			if !g.InGroup(m, groups...) {
+				if res := NewResponseForRequest(m.ProtocolOp(), LDAPResultInsufficientAccessRights, "access requires group membership"); res != nil {
+					w.Write(res)
+				}
+				return
+			}
+			next(w, m)
+		}
+	}
+}
+
+// ClientGroupLookup returns a GroupResolver Lookup searching the groups
+// below baseDN through c
+func ClientGroupLookup(c *ClientConn, baseDN string) func(attribute string, value string) ([]string, error) {
+	return func(attribute string, value string) ([]string, error) {
+		entries, err := c.Search(SearchParams{
+			BaseDN:     baseDN,
+			Scope:      SearchRequestHomeSubtree,
+			Filter:     fmt.Sprintf("(%s=%s)", attribute, escapeFilterValue(value)),
+			Attributes: []string{"1.1"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		dns := make([]string, len(entries))
+		for i, e := range entries {
+			dns[i] = e.DN
+		}
+		return dns, nil
+	}
+}
+
+// rdnValue returns the value of the attribute typ in the first RDN of dn
+func rdnValue(dn string, typ string) string {
+	parsed, err := ParseDN(dn)
+	if err != nil || len(parsed) == 0 {
+		return ""
+	}
+	for _, atv := range parsed[0] {
+		if strings.EqualFold(atv.Type, typ) {
+			return atv.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,40 @@
+package ldapserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGroupResolverCacheBounded(t *testing.T) {
+	lookups := 0
+	g := &GroupResolver{
+		Lookup: func(attribute string, value string) ([]string, error) {
+			lookups++
+			return nil, nil
+		},
+		Attributes:      []string{"member"},
+		CacheTTL:        time.Hour,
+		MaxCacheEntries: 2,
+	}
+	for _, dn := range []string{"cn=a", "cn=b", "cn=c"} {
+		if _, err := g.Groups(dn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(g.cache); n != 2 {
+		t.Errorf("%d memberships cached, want 2", n)
+	}
+	if _, ok := g.cache["cn=a"]; ok {
+		t.Error("membership expiring first kept")
+	}
+
+	lookups = 0
+	g.Groups("cn=c")
+	if lookups != 0 {
+		t.Error("cached membership looked up")
+	}
+	g.Groups("cn=a")
+	if lookups != 1 {
+		t.Error("evicted membership not looked up")
+	}
+}
@@ -0,0 +1,80 @@
+package ldapserver
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// touch records activity on the connection, see reapIdle
+func (c *client) touch() {
+	atomic.StoreInt64(&c.lastActivity, time.Now().UnixNano())
+}
+
+// idleFor returns the time since the last request or response of c, zero
+// while a request is in flight
+func (c *client) idleFor(now time.Time) time.Duration {
+	c.mutex.Lock()
+	busy := len(c.requestList) > 0
+	c.mutex.Unlock()
+	if busy {
+		return 0
+	}
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&c.lastActivity)))
+}
+
+// reapIdle closes, every IdleReapInterval, the connections idle for longer
+// than the IdleTimeout limit, until the server stops
+func (s *Server) reapIdle() {
+	ticker := time.NewTicker(s.IdleReapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.chDone:
+			return
+		case now := <-ticker.C:
+			limit := s.limits().IdleTimeout
+			if limit == 0 {
+				continue
+			}
+			s.mu.Lock()
+			var idle []*client
+			for c := range s.clients {
+				if c.idleFor(now) > limit {
+					idle = append(idle, c)
+				}
+			}
+			s.mu.Unlock()
+			for _, c := range idle {
+				c.reap()
+			}
+		}
+	}
+}
+
+// reap disconnects the idle client c, with a Notice of Disconnection when
+// the server IdleNotice is set
+func (c *client) reap() {
+	if !atomic.CompareAndSwapInt32(&c.reaped, 0, 1) {
+		return
+	}
+	atomic.AddInt64(&c.srv.idleReaped, 1)
+	c.logAt(LogLevelInfo, "disconnecting idle connection")
+
+	c.mutex.Lock()
+	select {
+	case <-c.closing:
+		c.mutex.Unlock()
+		return
+	default:
+	}
+	if c.srv.IdleNotice && c.chanOut != nil {
+		// keeps the response queue open until the notice is sent
+		c.wg.Add(1)
+		c.mutex.Unlock()
+		c.noticeOfDisconnection(LDAPResultUnavailable, "idle timeout")
+		c.wg.Done()
+	} else {
+		c.mutex.Unlock()
+	}
+	c.rwc.SetReadDeadline(time.Now().Add(time.Millisecond))
+}
@@ -0,0 +1,67 @@
+package ldapserver
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+func TestIdleReaper(t *testing.T) {
+	routes := NewRouteMux()
+	routes.Search(func(w ResponseWriter, m *Message) {
+		// in flight beyond IdleTimeout
+		time.Sleep(250 * time.Millisecond)
+		w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+	})
+	s := NewServer()
+	s.IdleTimeout = 100 * time.Millisecond
+	s.IdleReapInterval = 20 * time.Millisecond
+	s.IdleNotice = true
+	s.Handle(routes)
+	addr := serveTest(t, s)
+	defer s.Stop()
+
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// the search is in flight when the server waits for the next request,
+	// the connection is then only closed by the reaper
+	if _, err := conn.Write(encodeRawMessage(1, testSearchRequest("dc=example,dc=com", 0, 0), nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(conn)
+	msg, err := readTestMessage(t, br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := resultCodeOf(&msg); msg.MessageID().Int() != 1 || code != LDAPResultSuccess {
+		t.Fatalf("%s with result code %d, want the search answered", msg.ProtocolOpName(), code)
+	}
+	start := time.Now()
+	msg, err = readTestMessage(t, br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.ProtocolOp().(ldap.ExtendedResponse); !ok || msg.MessageID().Int() != 0 {
+		t.Fatalf("%s with message ID %d, want a Notice of Disconnection", msg.ProtocolOpName(), msg.MessageID().Int())
+	}
+	if code, _ := resultCodeOf(&msg); code != LDAPResultUnavailable {
+		t.Errorf("notice result code %d, want unavailable", code)
+	}
+	if idle := time.Since(start); idle < 50*time.Millisecond {
+		t.Errorf("reaped after %s idle, IdleTimeout is %s", idle, s.IdleTimeout)
+	}
+	if _, err := readTestMessage(t, br); err == nil {
+		t.Error("connection not closed")
+	}
+	if reaped := s.Stats().IdleReaped; reaped != 1 {
+		t.Errorf("%d connections reaped, want 1", reaped)
+	}
+}
@@ -0,0 +1,145 @@
+package ldapserver
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// JournalEntry is the summary of an operation kept in the journal of its
+// connection, see Server.JournalSize. Credentials, compared values and
+// filter assertion values are never recorded.
+type JournalEntry struct {
+	MessageID  int           `json:"messageID"`
+	Operation  string        `json:"operation"`  // protocol operation name, SearchRequest for instance
+	Request    string        `json:"request"`    // the request as rendered by DescribeRequest, with redacted filter values
+	Received   time.Time     `json:"received"`   // time the request was read
+	Duration   time.Duration `json:"duration"`   // time to complete, zero while in flight
+	Finished   bool          `json:"finished"`   // the operation completed
+	ResultCode int           `json:"resultCode"` // result code of the terminal response, -1 when none was written
+	Entries    int64         `json:"entries"`    // search result entries sent
+}
+
+// ConnJournal is the journal of the last operations of a connection
+type ConnJournal struct {
+	Numero     int            `json:"client"`
+	RemoteAddr string         `json:"remoteAddr"`
+	Bind       BindState      `json:"bind"`
+	Operations []JournalEntry `json:"operations"` // oldest first
+}
+
+// opJournal is the ring buffer of the last operations of a connection
+type opJournal struct {
+	mu      sync.Mutex
+	entries []JournalEntry
+	seqs    []uint64 // sequence number of the operation in each slot
+	seq     uint64   // sequence number of the last operation
+}
+
+func newOpJournal(size int) *opJournal {
+	return &opJournal{entries: make([]JournalEntry, 0, size), seqs: make([]uint64, 0, size)}
+}
+
+// start records the operation m and returns its sequence number
+func (j *opJournal) start(m *Message) uint64 {
+	entry := JournalEntry{
+		MessageID:  m.MessageID().Int(),
+		Operation:  m.ProtocolOpName(),
+		Request:    describeRequest(m, redactFilterValue),
+		Received:   m.received,
+		ResultCode: -1,
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.seq++
+	if len(j.entries) < cap(j.entries) {
+		j.entries = append(j.entries, entry)
+		j.seqs = append(j.seqs, j.seq)
+	} else {
+		i := int(j.seq-1) % cap(j.entries)
+		j.entries[i], j.seqs[i] = entry, j.seq
+	}
+	return j.seq
+}
+
+// finish records the completion of the operation seq, unless it was
+// overwritten since
+func (j *opJournal) finish(seq uint64, cw *countingWriter) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	i := int(seq-1) % cap(j.entries)
+	if i >= len(j.seqs) || j.seqs[i] != seq {
+		return
+	}
+	e := &j.entries[i]
+	e.Finished = true
+	e.Duration = time.Since(e.Received)
+	e.ResultCode = int(atomic.LoadInt32(&cw.resultCode))
+	e.Entries = atomic.LoadInt64(&cw.entries)
+}
+
+// snapshot returns the operations recorded, oldest first
+func (j *opJournal) snapshot() []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries := make([]JournalEntry, 0, len(j.entries))
+	if len(j.entries) < cap(j.entries) {
+		return append(entries, j.entries...)
+	}
+	oldest := int(j.seq) % cap(j.entries)
+	entries = append(entries, j.entries[oldest:]...)
+	return append(entries, j.entries[:oldest]...)
+}
+
+// journalSnapshot returns the journal of c
+func (c *client) journalSnapshot() ConnJournal {
+	return ConnJournal{
+		Numero:     c.numero,
+		RemoteAddr: c.rwc.RemoteAddr().String(),
+		Bind:       c.BindState(),
+		Operations: c.journal.snapshot(),
+	}
+}
+
+// Journals returns the journals of the connections being served, ordered
+// by connection numero, empty unless JournalSize is set
+func (s *Server) Journals() []ConnJournal {
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		if c.journal != nil {
+			clients = append(clients, c)
+		}
+	}
+	s.mu.Unlock()
+
+	journals := make([]ConnJournal, len(clients))
+	for i, c := range clients {
+		journals[i] = c.journalSnapshot()
+	}
+	sort.Slice(journals, func(i, j int) bool { return journals[i].Numero < journals[j].Numero })
+	return journals
+}
+
+// dumpJournals logs the journals of all the connections, the one of c
+// first, before a handler panic crashes the server
+func (s *Server) dumpJournals(c *client, reason interface{}) {
+	c.logAt(LogLevelError, "handler panic: %v, dumping operation journals", reason)
+	journals := s.Journals()
+	sort.SliceStable(journals, func(i, j int) bool { return journals[i].Numero == c.numero && journals[j].Numero != c.numero })
+	for _, journal := range journals {
+		for _, e := range journal.Operations {
+			status := fmt.Sprintf("in flight for %s", time.Since(e.Received))
+			if e.Finished {
+				status = fmt.Sprintf("result %d, %d entries, in %s", e.ResultCode, e.Entries, e.Duration)
+			}
+			s.log(LogLevelError, fmt.Sprintf("journal: %s at %s: %s", e.Request, e.Received.Format(time.RFC3339Nano), status),
+				LogField{"client", journal.Numero},
+				LogField{"remoteAddr", journal.RemoteAddr},
+				LogField{"messageID", e.MessageID},
+			)
+		}
+	}
+}
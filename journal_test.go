@@ -0,0 +1,24 @@
+package ldapserver
+
+import (
+	"strings"
+	"testing"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+func TestJournalRedactsFilterValues(t *testing.T) {
+	r, err := NewSearchRequest(SearchParams{BaseDN: "dc=example,dc=com", Filter: "(&(uid=alice)(userPassword=sec*ret))"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := newOpJournal(1)
+	j.start(&Message{LDAPMessage: ldap.NewLDAPMessageWithProtocolOp(r)})
+	request := j.snapshot()[0].Request
+	if strings.Contains(request, "alice") || strings.Contains(request, "sec") {
+		t.Errorf("journal recorded %s", request)
+	}
+	if !strings.Contains(request, "(userPassword=<redacted>*<redacted>)") {
+		t.Errorf("journal recorded %s, want the filter structure", request)
+	}
+}
@@ -0,0 +1,106 @@
+package ldapserver
+
+import (
+	"fmt"
+	"strings"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// largeValueSize is the size from which the values of the entries passed to
+// WriteEntries are written by reference instead of being copied into the
+// encoded messages, so certificates or photos of several megabytes are not
+// held twice
+const largeValueSize = 64 << 10
+
+// entryEncoder encodes SearchResultEntry messages. With large set, the
+// values of at least largeValueSize are kept as segments of their own
+// instead of being appended to buf.
+type entryEncoder struct {
+	buf      []byte
+	large    bool
+	segments [][]byte
+}
+
+// value encodes the content of an attribute value v
+func (enc *entryEncoder) value(v []byte) {
+	if !enc.large || len(v) < largeValueSize {
+		enc.buf = append(enc.buf, v...)
+		return
+	}
+	if len(enc.buf) > 0 {
+		enc.segments = append(enc.segments, enc.buf)
+	}
+	enc.segments = append(enc.segments, v)
+	enc.buf = enc.buf[len(enc.buf):]
+}
+
+// done returns the encoded segments
+func (enc *entryEncoder) done() [][]byte {
+	if len(enc.buf) > 0 {
+		enc.segments = append(enc.segments, enc.buf)
+	}
+	return enc.segments
+}
+
+// largeValuesLen returns the total size of the values of e written by
+// reference
+func (e *Entry) largeValuesLen() int {
+	n := 0
+	for i := range e.Attributes {
+		for _, v := range e.Attributes[i].Values {
+			if len(v) >= largeValueSize {
+				n += len(v)
+			}
+		}
+	}
+	return n
+}
+
+// maxValueSize returns the size limit of the values of the attribute name,
+// zero if unlimited
+func (s *Server) maxValueSize(name string) int {
+	name = strings.ToLower(name)
+	if i := strings.IndexByte(name, ';'); i >= 0 {
+		name = name[:i]
+	}
+	if max, ok := s.MaxAttributeValueSize[name]; ok {
+		return max
+	}
+	return s.MaxValueSize
+}
+
+// checkValueSizes returns an adminLimitExceeded response when a value of an
+// Add or Modify request exceeds its size limit, see MaxValueSize
+func (s *Server) checkValueSizes(po ldap.ProtocolOp) ldap.ProtocolOp {
+	check := func(name ldap.AttributeDescription, vals []ldap.AttributeValue) ldap.ProtocolOp {
+		max := s.maxValueSize(string(name))
+		if max <= 0 {
+			return nil
+		}
+		for _, v := range vals {
+			if len(v) > max {
+				return NewResponseForRequest(po, LDAPResultAdminLimitExceeded,
+					fmt.Sprintf("value of %s exceeds %d bytes", name, max))
+			}
+		}
+		return nil
+	}
+
+	switch r := po.(type) {
+	case ldap.AddRequest:
+		for _, a := range r.Attributes() {
+			if res := check(a.Type_(), a.Vals()); res != nil {
+				return res
+			}
+		}
+	case ldap.ModifyRequest:
+		for _, change := range r.Changes() {
+			modification := change.Modification()
+			if res := check(modification.Type_(), modification.Vals()); res != nil {
+				return res
+			}
+		}
+	}
+	return nil
+}
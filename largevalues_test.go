@@ -0,0 +1,147 @@
+package ldapserver
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+// largeEntry returns an entry holding values of the given sizes in the
+// jpegPhoto attribute, and a small cn
+func largeEntry(sizes ...int) Entry {
+	e := NewEntry("cn=photo,dc=example,dc=com").Add("cn", "photo")
+	for i, size := range sizes {
+		e.AddBytes("jpegPhoto", bytes.Repeat([]byte{byte('a' + i)}, size))
+	}
+	return *e
+}
+
+var largeEntryTests = []struct {
+	name     string
+	sizes    []int
+	segments int // segments written by reference
+}{
+	{"small values", []int{16, largeValueSize - 1}, 0},
+	{"threshold", []int{largeValueSize}, 1},
+	{"3 MB value", []int{3 << 20}, 1},
+	{"several large values", []int{2 << 20, 16, 5 << 20}, 2},
+}
+
+func TestEntryEncoderSegments(t *testing.T) {
+	for _, tt := range largeEntryTests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := largeEntry(tt.sizes...)
+			want := appendSearchResultEntryMessage(nil, 3, &e)
+
+			enc := entryEncoder{large: true}
+			enc.searchResultEntryMessage(3, &e)
+			segments := enc.done()
+			if got := bytes.Join(segments, nil); !bytes.Equal(got, want) {
+				t.Fatalf("segments encode %d bytes differing from the %d bytes of the entry", len(got), len(want))
+			}
+
+			// the large values are not copied
+			shared := 0
+			for _, segment := range segments {
+				for _, v := range e.Attributes[1].Values {
+					if len(v) >= largeValueSize && len(segment) == len(v) && &segment[0] == &v[0] {
+						shared++
+					}
+				}
+			}
+			if shared != tt.segments {
+				t.Errorf("%d values written by reference, want %d", shared, tt.segments)
+			}
+			if n := e.largeValuesLen(); tt.segments == 0 && n != 0 {
+				t.Errorf("largeValuesLen() = %d, want 0", n)
+			}
+		})
+	}
+}
+
+func TestWriteLargeEntries(t *testing.T) {
+	for _, tt := range largeEntryTests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := largeEntry(tt.sizes...)
+			chanOut := make(chan *outMessage, 1)
+			w := &responseWriterImpl{chanOut: chanOut, messageID: 7}
+			if err := WriteEntries(w, []Entry{e}); err != nil {
+				t.Fatal(err)
+			}
+			out := <-chanOut
+			if tt.segments > 0 && out.segments == nil {
+				t.Error("large entry queued without segments")
+			}
+
+			// written on the connection as one message
+			var conn bytes.Buffer
+			c := &client{srv: &Server{}, bw: bufio.NewWriter(&conn)}
+			c.writeMessage(out)
+			packet, err := readLdapMessageBytes(bufio.NewReader(&conn), 0)
+			if err != nil {
+				t.Fatalf("reading the message written: %s", err)
+			}
+			if conn.Len() != 0 {
+				t.Errorf("%d bytes written after the message", conn.Len())
+			}
+			m, err := decodeMessage(*packet)
+			if err != nil {
+				t.Fatalf("decoding the message written: %s", err)
+			}
+			if id := m.MessageID().Int(); id != 7 {
+				t.Errorf("message ID %d, want 7", id)
+			}
+
+			rec := NewResponseRecorder()
+			rec.WriteMessage(&m)
+			entries := rec.Entries()
+			if len(entries) != 1 {
+				t.Fatalf("%d entries decoded, want 1", len(entries))
+			}
+			values := entries[0].values("jpegPhoto")
+			if len(values) != len(tt.sizes) {
+				t.Fatalf("%d jpegPhoto values, want %d", len(values), len(tt.sizes))
+			}
+			for i, v := range values {
+				if !bytes.Equal(v, e.Attributes[1].Values[i]) {
+					t.Errorf("value %d of %d bytes differs from the %d bytes written", i, len(v), tt.sizes[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckValueSizes(t *testing.T) {
+	add := func(name string, size int) []byte {
+		return berConstructedTLV(berClassApplication|berConstructed|ApplicationAddRequest,
+			berOctetString(berTagOctetString, []byte("cn=photo,dc=example,dc=com")),
+			berSequence(berSequence(
+				berOctetString(berTagOctetString, []byte(name)),
+				berConstructedTLV(berTagSet, berOctetString(berTagOctetString, make([]byte, size))),
+			)),
+		)
+	}
+	tests := []struct {
+		name     string
+		max      int
+		perType  map[string]int
+		request  []byte
+		rejected bool
+	}{
+		{"unlimited", 0, nil, add("jpegPhoto", 4<<20), false},
+		{"below the limit", 1 << 20, nil, add("cn", 1<<20), false},
+		{"above the limit", 1 << 20, nil, add("cn", 1<<20+1), true},
+		{"attribute limit", 1 << 10, map[string]int{"jpegphoto": 4 << 20}, add("jpegPhoto", 3<<20), false},
+		{"attribute limit with options", 1 << 10, map[string]int{"jpegphoto": 1 << 20}, add("jpegPhoto;binary", 3<<20), true},
+		{"other attribute", 1 << 10, map[string]int{"jpegphoto": 4 << 20}, add("description", 2<<10), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{MaxValueSize: tt.max, MaxAttributeValueSize: tt.perType}
+			res := s.checkValueSizes(testMessage(t, tt.request).ProtocolOp())
+			if rejected := res != nil; rejected != tt.rejected {
+				t.Errorf("rejected %t, want %t", rejected, tt.rejected)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package ldapserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// ClientConn is a minimal LDAP client, meant for round-trip tests of a
+// server and for proxying requests to another directory. It runs one
+// operation at a time.
+type ClientConn struct {
+	// Timeout, if non-zero, bounds the time given to each operation
+	Timeout time.Duration
+
+	mu        sync.Mutex // serializes operations
+	conn      net.Conn
+	br        *bufio.Reader
+	messageID int
+}
+
+// SearchParams describes a search sent with ClientConn.Search
+type SearchParams struct {
+	BaseDN       string
+	Scope        int    // SearchRequestScopeBaseObject, SearchRequestSingleLevel or SearchRequestHomeSubtree
+	DerefAliases int    // 0 never, 1 in searching, 2 finding base object, 3 always
+	SizeLimit    int    // maximum number of entries returned, 0 for no limit
+	TimeLimit    int    // maximum time in seconds, 0 for no limit
+	TypesOnly    bool   // return attribute names only
+	Filter       string // RFC 4515 filter, "(objectClass=*)" when empty
+	Attributes   []string
+}
+
+// DialClient connects to the LDAP server at address on the named network
+func DialClient(network string, address string) (*ClientConn, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientConn(conn), nil
+}
+
+// DialClientTLS connects to the LDAPS server at address on the named network
+func DialClientTLS(network string, address string, config *tls.Config) (*ClientConn, error) {
+	conn, err := tls.Dial(network, address, config)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientConn(conn), nil
+}
+
+// NewClientConn returns a client speaking LDAP over conn
+func NewClientConn(conn net.Conn) *ClientConn {
+	return &ClientConn{conn: conn, br: bufio.NewReader(conn)}
+}
+
+// Close sends an UnbindRequest and closes the connection
+func (c *ClientConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messageID++
+	if encoded, err := encodeClientMessage(c.messageID, ldap.UnbindRequest{}); err == nil {
+		c.conn.Write(encoded)
+	}
+	return c.conn.Close()
+}
+
+// Bind authenticates with a simple bind. A failed bind returns a
+// *ResultError.
+func (c *ClientConn) Bind(dn string, password string) error {
+	responses, err := c.roundTrip(NewSimpleBindRequest(dn, password))
+	if err != nil {
+		return err
+	}
+	_, err = parseClientResult(responses[len(responses)-1])
+	return err
+}
+
+// Search runs a search and returns the entries found. When the search does
+// not succeed, the entries received are returned with a *ResultError.
+func (c *ClientConn) Search(params SearchParams) ([]Entry, error) {
+	r, err := NewSearchRequest(params)
+	if err != nil {
+		return nil, err
+	}
+	responses, err := c.roundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, response := range responses[:len(responses)-1] {
+		if response.tag&0x1f != ApplicationSearchResultEntry {
+			continue
+		}
+		e, err := parseClientEntry(response)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+	_, err = parseClientResult(responses[len(responses)-1])
+	return entries, err
+}
+
+// Extended sends an extended request, value is omitted when nil. The
+// response is returned even when the result is not a success, in which
+// case the error is a *ResultError.
+func (c *ClientConn) Extended(name ldap.LDAPOID, value []byte) (*ExtendedValueResponse, error) {
+	responses, err := c.roundTrip(NewExtendedRequest(name, value))
+	if err != nil {
+		return nil, err
+	}
+	result := responses[len(responses)-1]
+	res, err := parseClientResult(result)
+	if res == nil {
+		return nil, err
+	}
+	children, _ := berChildren(result.data)
+	for _, child := range children[3:] {
+		switch child.tag {
+		case berClassContext | 10:
+			res.ResponseName = ldap.LDAPOID(child.data)
+		case berClassContext | 11:
+			res.ResponseValue = child.data
+		}
+	}
+	return res, err
+}
+
+// Do sends a request encoded as a protocolOp, with optional encoded
+// controls, and returns all the response messages up to the one ending
+// the operation, decoded with goldap
+func (c *ClientConn) Do(protocolOp []byte, controls [][]byte) ([]ldap.LDAPMessage, error) {
+	responses, err := c.exchange(func(messageID int) ([]byte, error) {
+		return encodeRawMessage(messageID, protocolOp, controls), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]ldap.LDAPMessage, len(responses))
+	for i, response := range responses {
+		if messages[i], err = decodeMessage(response.message); err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
+}
+
+// clientResponse is a response message received by a ClientConn
+type clientResponse struct {
+	message []byte // the whole LDAPMessage
+	tag     byte   // protocolOp identifier octet
+	data    []byte // protocolOp content octets
+}
+
+// roundTrip sends the goldap request po and reads its responses, see
+// exchange
+func (c *ClientConn) roundTrip(po ldap.ProtocolOp) ([]clientResponse, error) {
+	return c.exchange(func(messageID int) ([]byte, error) {
+		return encodeClientMessage(messageID, po)
+	})
+}
+
+// exchange sends the request encoded with the next message ID and reads
+// its responses up to the one ending the operation. Unsolicited
+// notifications end the operation with an error.
+func (c *ClientConn) exchange(encode func(messageID int) ([]byte, error)) ([]clientResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Timeout != 0 {
+		c.conn.SetDeadline(time.Now().Add(c.Timeout))
+		defer c.conn.SetDeadline(time.Time{})
+	}
+
+	c.messageID++
+	encoded, err := encode(c.messageID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := c.conn.Write(encoded); err != nil {
+		return nil, err
+	}
+
+	var responses []clientResponse
+	for {
+		bytes, err := readLdapMessageBytes(c.br, 0)
+		if err != nil {
+			return nil, err
+		}
+		response, messageID, err := parseClientResponse(*bytes)
+		if err != nil {
+			return nil, err
+		}
+		if messageID == 0 {
+			if res, _ := parseClientResult(response); res != nil {
+				return nil, fmt.Errorf("unsolicited notification received: %s", res.DiagnosticMessage)
+			}
+			return nil, errors.New("unsolicited notification received")
+		}
+		if messageID != c.messageID {
+			continue
+		}
+		responses = append(responses, response)
+		switch response.tag & 0x1f {
+		case ApplicationSearchResultEntry, ApplicationSearchResultReference, ApplicationIntermediateResponse:
+		default:
+			return responses, nil
+		}
+	}
+}
+
+// encodeClientMessage returns the LDAPMessage carrying the request po,
+// encoded with goldap
+func encodeClientMessage(messageID int, po ldap.ProtocolOp) ([]byte, error) {
+	m := ldap.NewLDAPMessageWithProtocolOp(po)
+	ldap.SetMessageID(m, messageID)
+	data, err := m.Write()
+	if err != nil {
+		return nil, err
+	}
+	return data.Bytes(), nil
+}
+
+func parseClientResponse(b []byte) (clientResponse, int, error) {
+	response := clientResponse{message: b}
+	message, _, err := berRead(b)
+	if err != nil {
+		return response, 0, err
+	}
+	children, err := berChildren(message.data)
+	if err != nil {
+		return response, 0, err
+	}
+	if len(children) < 2 || children[0].tag != berTagInteger {
+		return response, 0, errors.New("malformed LDAP message")
+	}
+	messageID, err := berParseInteger(children[0].data)
+	if err != nil {
+		return response, 0, err
+	}
+	response.tag, response.data = children[1].tag, children[1].data
+	return response, int(messageID), nil
+}
+
+// parseClientResult decodes the LDAPResult of a response, the error is a
+// *ResultError when the result code is not a success
+func parseClientResult(response clientResponse) (*ExtendedValueResponse, error) {
+	children, err := berChildren(response.data)
+	if err != nil {
+		return nil, err
+	}
+	if len(children) < 3 || children[0].tag != berTagEnumerated {
+		return nil, errors.New("malformed LDAP result")
+	}
+	code, err := berParseInteger(children[0].data)
+	if err != nil {
+		return nil, err
+	}
+	res := &ExtendedValueResponse{
+		ResultCode:        int(code),
+		MatchedDN:         string(children[1].data),
+		DiagnosticMessage: string(children[2].data),
+	}
+	switch res.ResultCode {
+	case LDAPResultSuccess, LDAPResultCompareFalse, LDAPResultCompareTrue:
+		return res, nil
+	}
+	return res, NewResultError(res.ResultCode, res.DiagnosticMessage)
+}
+
+// parseClientEntry decodes a SearchResultEntry
+func parseClientEntry(response clientResponse) (Entry, error) {
+	var e Entry
+	children, err := berChildren(response.data)
+	if err != nil {
+		return e, err
+	}
+	if len(children) != 2 {
+		return e, errors.New("malformed search result entry")
+	}
+	e.DN = string(children[0].data)
+	attributes, err := berChildren(children[1].data)
+	if err != nil {
+		return e, err
+	}
+	for _, attribute := range attributes {
+		parts, err := berChildren(attribute.data)
+		if err != nil || len(parts) != 2 {
+			return e, errors.New("malformed search result entry attribute")
+		}
+		values, err := berChildren(parts[1].data)
+		if err != nil {
+			return e, err
+		}
+		a := EntryAttribute{Name: string(parts[0].data)}
+		for _, v := range values {
+			a.Values = append(a.Values, v.data)
+		}
+		e.Attributes = append(e.Attributes, a)
+	}
+	return e, nil
+}
@@ -0,0 +1,216 @@
+package ldapserver
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StartTLS sends a StartTLS extended request (RFC 4511 section 4.14) and
+// then performs the TLS handshake with config, the following operations
+// are sent over TLS. A refused request returns a *ResultError and leaves
+// the connection in clear.
+func (c *ClientConn) StartTLS(config *tls.Config) error {
+	if _, err := c.Extended(NoticeOfStartTLS, nil); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conn := tls.Client(c.conn, config)
+	if c.Timeout != 0 {
+		conn.SetDeadline(time.Now().Add(c.Timeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	c.conn, c.br = conn, bufio.NewReader(conn)
+	return nil
+}
+
+// TLSConnectionState returns the state of the TLS connection, established
+// with DialClientTLS or StartTLS, false when the connection is in clear
+func (c *ClientConn) TLSConnectionState() (tls.ConnectionState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conn, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+	return conn.ConnectionState(), true
+}
+
+// SASLClientMechanism is the client side of a SASL mechanism, see
+// ClientConn.SASLBind. It runs one exchange at a time.
+type SASLClientMechanism interface {
+	// Name returns the mechanism name, such as "EXTERNAL"
+	Name() string
+
+	// Start begins an exchange and returns the initial credentials, nil
+	// for none
+	Start() ([]byte, error)
+
+	// Next returns the credentials answering the challenge of the server.
+	// It is also given the serverSaslCreds of the successful bind
+	// response, nil when the server sent none, to verify them: an error
+	// fails the bind.
+	Next(challenge []byte) ([]byte, error)
+}
+
+// SASLBind authenticates with mechanism, sending bind requests until the
+// server ends the exchange. A failed bind returns a *ResultError.
+func (c *ClientConn) SASLBind(mechanism SASLClientMechanism) error {
+	credentials, err := mechanism.Start()
+	if err != nil {
+		return err
+	}
+	for {
+		responses, err := c.roundTrip(NewSASLBindRequest(mechanism.Name(), credentials))
+		if err != nil {
+			return err
+		}
+		response := responses[len(responses)-1]
+		res, err := parseClientResult(response)
+		if res == nil {
+			return err
+		}
+		challenge := parseServerSaslCreds(response)
+		switch {
+		case res.ResultCode == LDAPResultSaslBindInProgress:
+			if credentials, err = mechanism.Next(challenge); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		default:
+			_, err = mechanism.Next(challenge)
+			return err
+		}
+	}
+}
+
+// parseServerSaslCreds returns the serverSaslCreds of a bind response, nil
+// when absent
+func parseServerSaslCreds(response clientResponse) []byte {
+	children, err := berChildren(response.data)
+	if err != nil || len(children) < 3 {
+		return nil
+	}
+	for _, child := range children[3:] {
+		if child.tag == berClassContext|7 {
+			return append([]byte{}, child.data...)
+		}
+	}
+	return nil
+}
+
+// SASLExternalClient is the client side of the EXTERNAL mechanism, the
+// identity is the certificate presented during the TLS handshake
+type SASLExternalClient struct {
+	// AuthzID is the authorization identity requested, "dn:" followed by
+	// a DN for instance. The authentication identity is used if empty.
+	AuthzID string
+}
+
+func (e *SASLExternalClient) Name() string {
+	return "EXTERNAL"
+}
+
+func (e *SASLExternalClient) Start() ([]byte, error) {
+	return []byte(e.AuthzID), nil
+}
+
+func (e *SASLExternalClient) Next(challenge []byte) ([]byte, error) {
+	if challenge == nil {
+		return nil, nil
+	}
+	return nil, errors.New("unexpected EXTERNAL challenge")
+}
+
+// SASLDigestMD5Client is the client side of the DIGEST-MD5 mechanism, with
+// the "auth" quality of protection
+type SASLDigestMD5Client struct {
+	Username string
+	Password string
+	Realm    string // the realm announced by the server if empty
+	AuthzID  string // authorization identity requested, none if empty
+	Host     string // server host name, of the "ldap/host" digest-uri
+
+	directives map[string]string // of the response sent
+	verified   bool              // once the rspauth is verified
+}
+
+func (d *SASLDigestMD5Client) Name() string {
+	return "DIGEST-MD5"
+}
+
+func (d *SASLDigestMD5Client) Start() ([]byte, error) {
+	d.directives, d.verified = nil, false
+	return nil, nil
+}
+
+func (d *SASLDigestMD5Client) Next(challenge []byte) ([]byte, error) {
+	switch {
+	case d.verified:
+		// the success following an rspauth sent in progress
+		if challenge != nil {
+			return nil, errors.New("unexpected DIGEST-MD5 challenge")
+		}
+		return nil, nil
+	case d.directives != nil:
+		// the rspauth proves the server knows the password too, the
+		// authentication is not mutual without it
+		if challenge == nil {
+			return nil, errors.New("missing DIGEST-MD5 rspauth")
+		}
+		if string(challenge) != "rspauth="+digestMD5Response(d.directives, d.Password, ":") {
+			return nil, errors.New("invalid DIGEST-MD5 rspauth")
+		}
+		d.verified = true
+		return []byte{}, nil
+	}
+
+	challenged, err := parseDigestDirectives(string(challenge))
+	if err != nil {
+		return nil, err
+	}
+	if challenged["nonce"] == "" {
+		return nil, errors.New("missing DIGEST-MD5 nonce")
+	}
+	if qop := challenged["qop"]; qop != "" && !strings.Contains(qop, "auth") {
+		return nil, fmt.Errorf("unsupported DIGEST-MD5 qop %q", qop)
+	}
+	cnonce := make([]byte, 16)
+	if _, err := rand.Read(cnonce); err != nil {
+		return nil, err
+	}
+	realm := d.Realm
+	if realm == "" {
+		realm = challenged["realm"]
+	}
+	d.directives = map[string]string{
+		"username":   d.Username,
+		"realm":      realm,
+		"nonce":      challenged["nonce"],
+		"cnonce":     base64.RawStdEncoding.EncodeToString(cnonce),
+		"nc":         "00000001",
+		"qop":        "auth",
+		"digest-uri": "ldap/" + d.Host,
+		"authzid":    d.AuthzID,
+	}
+
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace
+	response := fmt.Sprintf(`username="%s",realm="%s",nonce="%s",cnonce="%s",nc=00000001,qop=auth,digest-uri="%s",response=%s,charset=utf-8`,
+		quote(d.Username), quote(realm), quote(d.directives["nonce"]), d.directives["cnonce"], quote(d.directives["digest-uri"]),
+		digestMD5Response(d.directives, d.Password, "AUTHENTICATE:"))
+	if d.AuthzID != "" {
+		response += fmt.Sprintf(`,authzid="%s"`, quote(d.AuthzID))
+	}
+	return []byte(response), nil
+}
@@ -0,0 +1,294 @@
+package ldapserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCertificates returns a CA pool, and a server certificate for
+// 127.0.0.1 and a client certificate with the subject CN=client it issued
+func testCertificates(t *testing.T) (*x509.CertPool, tls.Certificate, tls.Certificate) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	issue := func(serial int64, commonName string, usage x509.ExtKeyUsage) tls.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: commonName},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+	return pool, issue(2, "127.0.0.1", x509.ExtKeyUsageServerAuth), issue(3, "client", x509.ExtKeyUsageClientAuth)
+}
+
+// startSASLServer serves the SASL binds of sasl, with StartTLS available,
+// and returns the server address and the TLS configuration of a client
+// presenting the certificate CN=client
+func startSASLServer(t *testing.T, sasl *SASL) (*Server, net.Addr, *tls.Config) {
+	t.Helper()
+	pool, serverCert, clientCert := testCertificates(t)
+	s := NewServer()
+	s.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}
+	routes := NewRouteMux()
+	routes.SASLBind(sasl)
+	s.Handle(routes)
+	config := &tls.Config{RootCAs: pool, ServerName: "127.0.0.1", Certificates: []tls.Certificate{clientCert}}
+	return s, serveTest(t, s), config
+}
+
+// dialTest connects to the server at addr
+func dialTest(t *testing.T, addr net.Addr) *ClientConn {
+	t.Helper()
+	c, err := DialClient("tcp", addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Timeout = 5 * time.Second
+	return c
+}
+
+// whoAmI returns the authorization identity c is bound as
+func whoAmI(t *testing.T, c *ClientConn) string {
+	t.Helper()
+	res, err := c.Extended(NoticeOfWhoAmI, nil)
+	if err != nil {
+		t.Fatalf("Who am I?: %s", err)
+	}
+	return string(res.ResponseValue)
+}
+
+// resultCode returns the result code of the *ResultError err, -1 for
+// other errors and 0 for nil
+func resultCode(err error) int {
+	var re *ResultError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &re):
+		return re.ResultCode
+	}
+	return -1
+}
+
+func TestClientStartTLS(t *testing.T) {
+	s, addr, config := startSASLServer(t, &SASL{})
+	defer s.Stop()
+
+	c := dialTest(t, addr)
+	defer c.Close()
+	if _, ok := c.TLSConnectionState(); ok {
+		t.Error("TLS established before StartTLS")
+	}
+	if err := c.StartTLS(config); err != nil {
+		t.Fatalf("StartTLS: %s", err)
+	}
+	if state, ok := c.TLSConnectionState(); !ok || !state.HandshakeComplete {
+		t.Fatal("TLS not established by StartTLS")
+	}
+	if id := whoAmI(t, c); id != "" {
+		t.Errorf("bound as %q over TLS, want anonymous", id)
+	}
+	if code := resultCode(c.StartTLS(config)); code != LDAPResultOperationsError {
+		t.Errorf("second StartTLS result code %d, want %d", code, LDAPResultOperationsError)
+	}
+
+	// without a TLSConfig, the connection stays in clear
+	s.TLSConfig = nil
+	c = dialTest(t, addr)
+	defer c.Close()
+	if code := resultCode(c.StartTLS(config)); code != LDAPResultProtocolError {
+		t.Errorf("StartTLS without TLSConfig result code %d, want %d", code, LDAPResultProtocolError)
+	}
+	if _, ok := c.TLSConnectionState(); ok {
+		t.Error("TLS established by a refused StartTLS")
+	}
+	if id := whoAmI(t, c); id != "" {
+		t.Errorf("bound as %q, want anonymous", id)
+	}
+}
+
+func TestClientSASLExternal(t *testing.T) {
+	tests := []struct {
+		name        string
+		startTLS    bool
+		certificate bool
+		authzID     string
+		code        int
+		want        string // identity bound as
+	}{
+		{"certificate", true, true, "", 0, "dn:CN=client"},
+		{"authzid of the identity", true, true, "dn:cn=client", 0, "dn:CN=client"},
+		{"other authzid", true, true, "dn:cn=admin", LDAPResultInvalidCredentials, ""},
+		{"no certificate", true, false, "", LDAPResultInappropriateAuthentication, ""},
+		{"in clear", false, false, "", LDAPResultInappropriateAuthentication, ""},
+	}
+	s, addr, config := startSASLServer(t, &SASL{Mechanisms: []SASLMechanism{&SASLExternal{}}})
+	defer s.Stop()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := dialTest(t, addr)
+			defer c.Close()
+			if tt.startTLS {
+				config := config.Clone()
+				if !tt.certificate {
+					config.Certificates = nil
+				}
+				if err := c.StartTLS(config); err != nil {
+					t.Fatalf("StartTLS: %s", err)
+				}
+			}
+			err := c.SASLBind(&SASLExternalClient{AuthzID: tt.authzID})
+			if code := resultCode(err); code != tt.code {
+				t.Fatalf("EXTERNAL bind error %v, want result code %d", err, tt.code)
+			}
+			if id := whoAmI(t, c); id != tt.want {
+				t.Errorf("bound as %q, want %q", id, tt.want)
+			}
+		})
+	}
+}
+
+// noRspauth is a DIGEST-MD5 mechanism answering the success without the
+// rspauth
+type noRspauth struct {
+	SASLDigestMD5
+}
+
+func (d *noRspauth) Start(m *Message) (SASLExchange, error) {
+	x, err := d.SASLDigestMD5.Start(m)
+	return &noRspauthExchange{x}, err
+}
+
+type noRspauthExchange struct {
+	SASLExchange
+}
+
+func (x *noRspauthExchange) Step(credentials []byte) ([]byte, bool, string, error) {
+	challenge, done, identity, err := x.SASLExchange.Step(credentials)
+	if done {
+		challenge = nil
+	}
+	return challenge, done, identity, err
+}
+
+func TestClientSASLDigestMD5(t *testing.T) {
+	password := func(username, realm string) (string, string, error) {
+		if username != "alice" {
+			return "", "", nil
+		}
+		return "secret", "dn:uid=alice,dc=example,dc=com", nil
+	}
+	digestMD5 := SASLDigestMD5{Realm: "example.com", Hosts: []string{"localhost", "127.0.0.1"}, Password: password}
+	tests := []struct {
+		name      string
+		mechanism SASLMechanism
+		username  string
+		password  string
+		realm     string
+		host      string
+		code      int // -1 for an error of the client
+		want      string
+	}{
+		{"valid password", &digestMD5, "alice", "secret", "", "127.0.0.1", 0, "dn:uid=alice,dc=example,dc=com"},
+		{"wrong password", &digestMD5, "alice", "guess", "", "127.0.0.1", LDAPResultInvalidCredentials, ""},
+		{"unknown user", &digestMD5, "bob", "secret", "", "127.0.0.1", LDAPResultInvalidCredentials, ""},
+		{"other realm", &digestMD5, "alice", "secret", "example.org", "127.0.0.1", LDAPResultInvalidCredentials, ""},
+		{"other host", &digestMD5, "alice", "secret", "", "ldap.example.org", LDAPResultInvalidCredentials, ""},
+		{"missing rspauth", &noRspauth{digestMD5}, "alice", "secret", "", "127.0.0.1", -1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, addr, _ := startSASLServer(t, &SASL{Mechanisms: []SASLMechanism{tt.mechanism}})
+			defer s.Stop()
+			c := dialTest(t, addr)
+			defer c.Close()
+
+			mechanism := &SASLDigestMD5Client{Username: tt.username, Password: tt.password, Realm: tt.realm, Host: tt.host}
+			err := c.SASLBind(mechanism)
+			if code := resultCode(err); code != tt.code {
+				t.Fatalf("DIGEST-MD5 bind error %v, want result code %d", err, tt.code)
+			}
+			if tt.code == 0 && whoAmI(t, c) != tt.want {
+				t.Errorf("not bound as %q", tt.want)
+			}
+		})
+	}
+}
+
+func TestSASLDigestMD5ClientRspauth(t *testing.T) {
+	d := &SASLDigestMD5Client{Username: "alice", Password: "secret", Host: "127.0.0.1"}
+	start := func() {
+		d.Start()
+		if _, err := d.Next([]byte(`realm="example.com",nonce="abc",qop="auth",charset=utf-8,algorithm=md5-sess`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rspauth := func() []byte {
+		return []byte("rspauth=" + digestMD5Response(d.directives, d.Password, ":"))
+	}
+
+	start()
+	if _, err := d.Next(nil); err == nil {
+		t.Error("success without rspauth accepted")
+	}
+	start()
+	if _, err := d.Next([]byte("rspauth=0123")); err == nil {
+		t.Error("invalid rspauth accepted")
+	}
+	start()
+	if _, err := d.Next(rspauth()); err != nil {
+		t.Errorf("rspauth refused: %s", err)
+	}
+	// an rspauth sent in progress is followed by a success with none
+	if _, err := d.Next(nil); err != nil {
+		t.Errorf("success after the rspauth refused: %s", err)
+	}
+}
@@ -0,0 +1,118 @@
+// Package ldapv3 provides adapters between the ldapserver types and the
+// go-ldap/ldap/v3 ones, so code written against go-ldap can be reused inside
+// handlers and proxies. It is a separate package so that servers not using
+// go-ldap don't depend on it.
+package ldapv3
+
+import (
+	ber "github.com/go-asn1-ber/asn1-ber"
+	v3 "github.com/go-ldap/ldap/v3"
+	ldap "github.com/ps78674/goldap/message"
+	"github.com/ps78674/ldapserver"
+)
+
+// FromEntry converts e to a go-ldap Entry
+func FromEntry(e ldapserver.Entry) *v3.Entry {
+	entry := &v3.Entry{DN: e.DN}
+	for _, a := range e.Attributes {
+		attribute := &v3.EntryAttribute{Name: a.Name}
+		for _, v := range a.Values {
+			attribute.Values = append(attribute.Values, string(v))
+			attribute.ByteValues = append(attribute.ByteValues, v)
+		}
+		entry.Attributes = append(entry.Attributes, attribute)
+	}
+	return entry
+}
+
+// ToEntry converts a go-ldap Entry, for instance to write it with
+// ldapserver.WriteEntries
+func ToEntry(e *v3.Entry) ldapserver.Entry {
+	entry := ldapserver.Entry{DN: e.DN}
+	for _, a := range e.Attributes {
+		attribute := ldapserver.EntryAttribute{Name: a.Name}
+		if len(a.ByteValues) > 0 {
+			attribute.Values = append(attribute.Values, a.ByteValues...)
+		} else {
+			for _, v := range a.Values {
+				attribute.Values = append(attribute.Values, []byte(v))
+			}
+		}
+		entry.Attributes = append(entry.Attributes, attribute)
+	}
+	return entry
+}
+
+// FromSearchRequest converts the search request m, with its controls, to a
+// go-ldap SearchRequest, for instance to forward it to another directory
+// with a go-ldap connection
+func FromSearchRequest(m *ldapserver.Message) (*v3.SearchRequest, error) {
+	r := m.GetSearchRequest()
+	attributes := make([]string, len(r.Attributes()))
+	for i, a := range r.Attributes() {
+		attributes[i] = string(a)
+	}
+
+	var controls []v3.Control
+	if m.LDAPMessage.Controls() != nil {
+		var err error
+		if controls, err = FromControls(*m.LDAPMessage.Controls()); err != nil {
+			return nil, err
+		}
+	}
+
+	return v3.NewSearchRequest(
+		string(r.BaseObject()),
+		int(r.Scope()),
+		int(r.DerefAliases()),
+		int(r.SizeLimit()),
+		int(r.TimeLimit()),
+		bool(r.TypesOnly()),
+		r.FilterString(),
+		attributes,
+		controls,
+	), nil
+}
+
+// ToSearchParams converts a go-ldap SearchRequest to the parameters of
+// ldapserver.ClientConn.Search, its controls are converted with ToControl
+func ToSearchParams(r *v3.SearchRequest) ldapserver.SearchParams {
+	return ldapserver.SearchParams{
+		BaseDN:       r.BaseDN,
+		Scope:        r.Scope,
+		DerefAliases: r.DerefAliases,
+		SizeLimit:    r.SizeLimit,
+		TimeLimit:    r.TimeLimit,
+		TypesOnly:    r.TypesOnly,
+		Filter:       r.Filter,
+		Attributes:   r.Attributes,
+	}
+}
+
+// FromControls converts goldap controls, the controls go-ldap knows
+// (paging, ppolicy...) are decoded to their go-ldap type
+func FromControls(controls ldap.Controls) ([]v3.Control, error) {
+	converted := make([]v3.Control, 0, len(controls))
+	for _, c := range controls {
+		packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Control")
+		packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(c.ControlType()), "Control Type"))
+		if c.Criticality() {
+			packet.AppendChild(ber.NewBoolean(ber.ClassUniversal, ber.TypePrimitive, ber.TagBoolean, true, "Criticality"))
+		}
+		if c.ControlValue() != nil {
+			packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, string(*c.ControlValue()), "Control Value"))
+		}
+		control, err := v3.DecodeControl(packet)
+		if err != nil {
+			return nil, err
+		}
+		converted = append(converted, control)
+	}
+	return converted, nil
+}
+
+// ToControl returns the BER encoding of a go-ldap control, as passed to
+// ldapserver.ClientConn.Do
+func ToControl(c v3.Control) []byte {
+	return c.Encode().Bytes()
+}
@@ -0,0 +1,98 @@
+package ldapserver
+
+import (
+	"fmt"
+	"log"
+)
+
+// LogField is a structured field of a log message
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger receives the server log messages, see Server.Logger. Messages
+// about a client come with the fields "client" (its numero) and
+// "remoteAddr", and those about a request with "messageID" too, and
+// "namingContext" when served by a ContextMux naming context, so they can
+// be handed to structured loggers such as zap or log/slog.
+type Logger interface {
+	Log(level LogLevel, msg string, fields ...LogField)
+}
+
+// LoggerFunc is a Logger calling f
+type LoggerFunc func(level LogLevel, msg string, fields ...LogField)
+
+func (f LoggerFunc) Log(level LogLevel, msg string, fields ...LogField) {
+	f(level, msg, fields...)
+}
+
+// log sends msg to the Logger when set. Otherwise msg is printed to the
+// ErrorLog or the standard logger, prefixed with the client numero and
+// followed by the message ID.
+func (s *Server) log(level LogLevel, msg string, fields ...LogField) {
+	if level < s.limits().LogLevel {
+		return
+	}
+	if s.Logger != nil {
+		s.Logger.Log(level, msg, fields...)
+		return
+	}
+
+	for _, f := range fields {
+		switch f.Key {
+		case "client":
+			msg = fmt.Sprintf("client [%v]: %s", f.Value, msg)
+		case "messageID":
+			msg = fmt.Sprintf("%s [messageID=%v]", msg, f.Value)
+		case "namingContext":
+			msg = fmt.Sprintf("%s [namingContext=%v]", msg, f.Value)
+		}
+	}
+	if s.ErrorLog != nil {
+		s.ErrorLog.Print(msg)
+	} else {
+		log.Print(msg)
+	}
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	s.logAt(LogLevelInfo, format, args...)
+}
+
+func (s *Server) logAt(level LogLevel, format string, args ...interface{}) {
+	s.log(level, fmt.Sprintf(format, args...))
+}
+
+// logAt logs a message about the client c
+func (c *client) logAt(level LogLevel, format string, args ...interface{}) {
+	c.srv.log(level, fmt.Sprintf(format, args...),
+		LogField{"client", c.numero},
+		LogField{"remoteAddr", c.rwc.RemoteAddr()},
+	)
+}
+
+// Logf logs a message about the request m with the server Logger, for
+// handlers logging the errors they do not report to the client. It is
+// dropped when m does not come from a client.
+func (m *Message) Logf(level LogLevel, format string, args ...interface{}) {
+	m.logAt(level, format, args...)
+}
+
+// logAt logs a message about the request m, it is dropped when m does not
+// come from a client
+func (m *Message) logAt(level LogLevel, format string, args ...interface{}) {
+	c := m.Client
+	if c == nil {
+		return
+	}
+	fields := []LogField{
+		{"client", c.numero},
+		{"remoteAddr", c.rwc.RemoteAddr()},
+		{"messageID", m.MessageID().Int()},
+	}
+	if nc := m.NamingContext(); nc != "" {
+		fields = append(fields, LogField{"namingContext", nc})
+	}
+	c.srv.log(level, fmt.Sprintf(format, args...), fields...)
+}
@@ -0,0 +1,115 @@
+package ldapserver
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// LogRateLimit bounds the logging of repeated client errors: at most Burst
+// messages per Interval are logged for each client host and error class,
+// the others are counted and reported once the Interval ends
+type LogRateLimit struct {
+	Burst    int           // messages logged per Interval, unlimited if zero
+	Interval time.Duration // one minute if zero
+}
+
+func (l LogRateLimit) interval() time.Duration {
+	if l.Interval > 0 {
+		return l.Interval
+	}
+	return time.Minute
+}
+
+// logSampler applies the server ClientErrorLogLimit
+type logSampler struct {
+	mu       sync.Mutex
+	windows  map[logSampleKey]*logWindow
+	reporter sync.Once
+}
+
+// logSampleKey identifies the errors of a class from a client host
+type logSampleKey struct {
+	host  string
+	class string
+}
+
+// logWindow counts the errors of a key during an interval
+type logWindow struct {
+	start      time.Time
+	logged     int
+	suppressed int
+}
+
+// logClientError logs an error of class, such as "timeout" or "decode",
+// met reading from c, unless ClientErrorLogLimit suppresses it
+func (c *client) logClientError(class string, format string, args ...interface{}) {
+	limit := c.srv.ClientErrorLogLimit
+	if limit.Burst <= 0 {
+		c.logAt(LogLevelWarn, format, args...)
+		return
+	}
+	host := c.rwc.RemoteAddr().String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if c.srv.clientErrors.allow(c.srv, logSampleKey{host, class}, limit) {
+		c.logAt(LogLevelWarn, format, args...)
+	}
+}
+
+// allow reports whether an error of key may be logged now
+func (l *logSampler) allow(s *Server, key logSampleKey, limit LogRateLimit) bool {
+	l.reporter.Do(func() { go l.report(s, limit.interval()) })
+
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.windows == nil {
+		l.windows = make(map[logSampleKey]*logWindow)
+	}
+	w := l.windows[key]
+	if w == nil || now.Sub(w.start) >= limit.interval() {
+		if w != nil && w.suppressed > 0 {
+			s.reportSuppressed(key, w.suppressed)
+		}
+		w = &logWindow{start: now}
+		l.windows[key] = w
+	}
+	if w.logged < limit.Burst {
+		w.logged++
+		return true
+	}
+	w.suppressed++
+	return false
+}
+
+// report logs, every interval, the errors suppressed during the windows
+// which ended, and forgets these windows, until the server stops
+func (l *logSampler) report(s *Server, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.chDone:
+			return
+		case now := <-ticker.C:
+			l.mu.Lock()
+			for key, w := range l.windows {
+				if now.Sub(w.start) < interval {
+					continue
+				}
+				if w.suppressed > 0 {
+					s.reportSuppressed(key, w.suppressed)
+				}
+				delete(l.windows, key)
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+func (s *Server) reportSuppressed(key logSampleKey, n int) {
+	s.log(LogLevelWarn, fmt.Sprintf("%d %s errors from %s suppressed", n, key.class, key.host), LogField{"remoteAddr", key.host})
+}
@@ -0,0 +1,82 @@
+package ldapserver
+
+import (
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// checkMaintenance returns an unavailable response when the server is in
+// maintenance mode and m, served by handler, is not allowed. Binds,
+// StartTLS, abandons and the modifications routed to the ConfigBackend are
+// still served.
+func checkMaintenance(handler Handler, m *Message, l Limits) ldap.ProtocolOp {
+	if !l.Maintenance {
+		return nil
+	}
+	po := m.ProtocolOp()
+	switch po.(type) {
+	case ldap.BindRequest, ldap.AbandonRequest, ldap.UnbindRequest:
+		return nil
+	}
+	if isStartTLS(m.LDAPMessage) || routesToConfigBackend(handler, m) {
+		return nil
+	}
+
+	if l.MaintenanceDisconnect > 0 && m.Client != nil {
+		m.Client.scheduleMaintenanceDisconnect(l.MaintenanceDisconnect)
+	}
+	return NewResponseForRequest(po, LDAPResultUnavailable, maintenanceMessage(l))
+}
+
+func maintenanceMessage(l Limits) string {
+	if l.MaintenanceMessage != "" {
+		return l.MaintenanceMessage
+	}
+	return "server is in maintenance"
+}
+
+// scheduleMaintenanceDisconnect sends a Notice of Disconnection to the
+// client after d, unless maintenance mode is switched off meanwhile
+func (c *client) scheduleMaintenanceDisconnect(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.maintenanceTimer != nil {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		c.mutex.Lock()
+		if c.maintenanceTimer != timer {
+			// the client is closing
+			c.mutex.Unlock()
+			return
+		}
+		c.maintenanceTimer = nil
+		l := c.srv.limits()
+		if !l.Maintenance {
+			c.mutex.Unlock()
+			return
+		}
+		// keeps the response queue open until the notice is sent
+		c.wg.Add(1)
+		c.mutex.Unlock()
+
+		c.logAt(LogLevelInfo, "disconnecting for maintenance")
+		c.noticeOfDisconnection(LDAPResultUnavailable, maintenanceMessage(l))
+		c.wg.Done()
+		c.rwc.SetReadDeadline(time.Now().Add(time.Millisecond))
+	})
+	c.maintenanceTimer = timer
+}
+
+// stopMaintenanceDisconnect cancels the pending maintenance disconnection
+func (c *client) stopMaintenanceDisconnect() {
+	c.mutex.Lock()
+	if c.maintenanceTimer != nil {
+		c.maintenanceTimer.Stop()
+		c.maintenanceTimer = nil
+	}
+	c.mutex.Unlock()
+}
@@ -0,0 +1,179 @@
+package ldapserver
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// MatchingRule reports whether an attribute value matches the assertion
+// value of an extensible match filter
+type MatchingRule func(value, assertion []byte) bool
+
+// MatchingRules are the matching rules of the extensible match filters
+// evaluated by Matches, by OID and lowercase name. Rules may be added
+// before the server is started.
+var MatchingRules = map[string]MatchingRule{}
+
+func init() {
+	rules := []struct {
+		oid  string
+		name string
+		rule MatchingRule
+	}{
+		{"2.5.13.1", "distinguishedNameMatch", func(v, a []byte) bool { return NormalizeDN(string(v)) == NormalizeDN(string(a)) }},
+		{"2.5.13.2", "caseIgnoreMatch", func(v, a []byte) bool { return strings.EqualFold(prepareString(v), prepareString(a)) }},
+		{"2.5.13.3", "caseIgnoreOrderingMatch", func(v, a []byte) bool {
+			return strings.ToLower(prepareString(v)) < strings.ToLower(prepareString(a))
+		}},
+		{"2.5.13.5", "caseExactMatch", func(v, a []byte) bool { return prepareString(v) == prepareString(a) }},
+		{"2.5.13.6", "caseExactOrderingMatch", func(v, a []byte) bool { return prepareString(v) < prepareString(a) }},
+		{"2.5.13.8", "numericStringMatch", func(v, a []byte) bool {
+			return strings.ReplaceAll(string(v), " ", "") == strings.ReplaceAll(string(a), " ", "")
+		}},
+		{"2.5.13.13", "booleanMatch", func(v, a []byte) bool { return strings.EqualFold(string(v), string(a)) }},
+		{"2.5.13.14", "integerMatch", integerRule(func(v, a int64) bool { return v == a })},
+		{"2.5.13.15", "integerOrderingMatch", integerRule(func(v, a int64) bool { return v < a })},
+		{"2.5.13.17", "octetStringMatch", bytes.Equal},
+		{"1.3.6.1.4.1.1466.109.114.1", "caseExactIA5Match", func(v, a []byte) bool { return prepareString(v) == prepareString(a) }},
+		{"1.3.6.1.4.1.1466.109.114.2", "caseIgnoreIA5Match", func(v, a []byte) bool { return strings.EqualFold(prepareString(v), prepareString(a)) }},
+		// Active Directory bitwise rules, userAccountControl flags for instance
+		{"1.2.840.113556.1.4.803", "", integerRule(func(v, a int64) bool { return v&a == a })},
+		{"1.2.840.113556.1.4.804", "", integerRule(func(v, a int64) bool { return v&a != 0 })},
+	}
+	for _, r := range rules {
+		MatchingRules[r.oid] = r.rule
+		if r.name != "" {
+			MatchingRules[strings.ToLower(r.name)] = r.rule
+		}
+	}
+}
+
+// integerRule returns a MatchingRule comparing integer values with f,
+// values which are not integers do not match
+func integerRule(f func(v, a int64) bool) MatchingRule {
+	return func(value, assertion []byte) bool {
+		v, err := strconv.ParseInt(strings.TrimSpace(string(value)), 10, 64)
+		if err != nil {
+			return false
+		}
+		a, err := strconv.ParseInt(strings.TrimSpace(string(assertion)), 10, 64)
+		return err == nil && f(v, a)
+	}
+}
+
+// prepareString removes the insignificant spaces of a string value, RFC
+// 4518 section 2.6.1: leading and trailing ones, and the repeated ones
+func prepareString(v []byte) string {
+	return strings.Join(strings.Fields(string(v)), " ")
+}
+
+// matchExtensible evaluates the extensible match filter f for e, which is
+// Undefined when its matching rule is not supported. Without a matching
+// rule the values are compared as by an equality match.
+func matchExtensible(e *Entry, f ldap.FilterExtensibleMatch) (bool, error) {
+	var rule MatchingRule
+	switch {
+	case f.MatchingRule() != nil:
+		name := string(*f.MatchingRule())
+		var ok bool
+		if rule, ok = MatchingRules[strings.ToLower(name)]; !ok {
+			return false, fmt.Errorf("unsupported matching rule %s", name)
+		}
+	case f.Type_() == nil:
+		return false, fmt.Errorf("extensible match without type nor matching rule")
+	}
+	assertion := []byte(f.MatchValue())
+	match := func(name string, v []byte) bool {
+		if rule != nil {
+			return rule(v, assertion)
+		}
+		return CompareValues(name, v, assertion) == 0
+	}
+
+	if f.Type_() != nil {
+		name := string(*f.Type_())
+		if e.anyValue(name, func(v []byte) bool { return match(name, v) }) {
+			return true, nil
+		}
+	} else {
+		for _, a := range e.Attributes {
+			for _, v := range a.Values {
+				if match(a.Name, v) {
+					return true, nil
+				}
+			}
+		}
+	}
+
+	// the attributes of the entry DN are matched too with dnAttributes
+	if !f.DnAttributes() {
+		return false, nil
+	}
+	dn, err := ParseDN(e.DN)
+	if err != nil {
+		return false, nil
+	}
+	for _, rdn := range dn {
+		for _, atv := range rdn {
+			if f.Type_() != nil && attributeType(atv.Type) != attributeType(string(*f.Type_())) {
+				continue
+			}
+			if match(atv.Type, []byte(atv.Value)) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// approxEqual reports whether the values a and b sound alike: they have
+// the same number of words, with the same Soundex codes
+func approxEqual(a, b string) bool {
+	wordsA, wordsB := strings.Fields(strings.ToLower(a)), strings.Fields(strings.ToLower(b))
+	if len(wordsA) != len(wordsB) {
+		return false
+	}
+	for i := range wordsA {
+		if soundex(wordsA[i]) != soundex(wordsB[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// soundex returns the Soundex code of the lowercase word w, or w itself
+// when it does not start with a letter
+func soundex(w string) string {
+	if w == "" || w[0] < 'a' || w[0] > 'z' {
+		return w
+	}
+	const codes = "01230120022455012623010202" // a to z
+	code := []byte{w[0] - 'a' + 'A'}
+	last := codes[w[0]-'a']
+	for i := 1; i < len(w) && len(code) < 4; i++ {
+		c := w[i]
+		if c < 'a' || c > 'z' {
+			continue
+		}
+		digit := codes[c-'a']
+		switch {
+		case c == 'h' || c == 'w':
+			// h and w do not separate letters with the same code
+			continue
+		case digit == '0':
+			last = 0
+			continue
+		case digit != last:
+			code = append(code, digit)
+		}
+		last = digit
+	}
+	for len(code) < 4 {
+		code = append(code, '0')
+	}
+	return string(code)
+}
@@ -0,0 +1,692 @@
+// Package memory provides an ldapserver.Handler storing a directory in
+// memory, for tests, development servers or small directories.
+package memory
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+	"github.com/ps78674/ldapserver"
+)
+
+// Backend is a Handler storing a directory in memory. It answers searches,
+// with the filters of ldapserver.Matches, additions, deletions,
+// modifications, renames and compares, simple binds checking the clear text
+// userPassword of the entries, and the extended operations handled by
+// default by RouteMux. It implements EntryCounter so the quotas of a
+// ContextMux naming context can be enforced, it may enforce them itself
+// with Quota.
+//
+// An entry may only be added below an existing one, except a naming
+// context suffix: an entry none of whose ancestors is held by the backend.
+type Backend struct {
+	// Changes, Server.Changes for instance, receives the changes applied
+	Changes *ldapserver.ChangeStream
+	// Snapshots serves the paged searches from snapshots, the pages are
+	// computed from the current entries if nil
+	Snapshots *ldapserver.SearchSnapshots
+	// Encryption, if non-nil, declares the attributes stored encrypted
+	Encryption *AttributeEncryption
+	// SoftDelete, if non-nil, keeps the deleted entries so they can be
+	// restored
+	SoftDelete *SoftDeletePolicy
+	// Quota, if non-nil, limits the entries of each naming context held by
+	// the backend and the children of any entry: the additions,
+	// restorations and moves exceeding it are answered with
+	// adminLimitExceeded. Its Counter is not used.
+	Quota *ldapserver.Quota
+
+	// AuthorizeWrite, if non-nil, reports whether the client sending the
+	// write request m may modify the entry dn, refused requests get
+	// insufficientAccessRights. The entry dn is the one added, deleted,
+	// modified, renamed or restored. If nil, the writes of anonymous
+	// clients are refused and the others allowed.
+	AuthorizeWrite func(m *ldapserver.Message, dn string) bool
+
+	mu      sync.RWMutex
+	entries map[string]*storedEntry // by normalized DN
+}
+
+type storedEntry struct {
+	dn         ldapserver.DN
+	attributes []ldapserver.EntryAttribute
+	deleted    time.Time // time of the soft deletion, zero for live entries
+}
+
+// NewBackend returns an empty Backend
+func NewBackend() *Backend {
+	return &Backend{entries: make(map[string]*storedEntry)}
+}
+
+// AddEntry adds e to the directory, to seed it before serving
+func (b *Backend) AddEntry(e ldapserver.Entry) error {
+	dn, err := ldapserver.ParseDN(e.DN)
+	if err != nil {
+		return ldapserver.NewResultError(ldapserver.LDAPResultInvalidDNSyntax, err.Error())
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.add(dn, e.Attributes, false)
+}
+
+// LoadLDIF adds the content records of an LDIF file (RFC 2849) to the
+// directory, in the order of the file which must list the parents first
+func (b *Backend) LoadLDIF(r io.Reader) error {
+	records, err := ldapserver.ParseStaticLDIF(r)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		e := ldapserver.Entry{DN: record.DN}
+		for _, a := range record.Attributes {
+			attribute := ldapserver.EntryAttribute{Name: a.Name}
+			for _, v := range a.Values {
+				attribute.Values = append(attribute.Values, []byte(v))
+			}
+			e.Attributes = append(e.Attributes, attribute)
+		}
+		if err := b.AddEntry(e); err != nil {
+			return fmt.Errorf("entry %q: %s", record.DN, err)
+		}
+	}
+	return nil
+}
+
+// Entry returns a copy of the entry dn, its encrypted attributes
+// decrypted, or left sealed when they can't be
+func (b *Backend) Entry(dn string) (ldapserver.Entry, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	e, ok := b.live(ldapserver.NormalizeDN(dn))
+	if !ok {
+		return ldapserver.Entry{}, false
+	}
+	if opened, err := b.opened(e); err == nil {
+		e = opened
+	}
+	return e.entry(), true
+}
+
+// CountEntries implements ldapserver.EntryCounter
+func (b *Backend) CountEntries(base string, scope int) (int, error) {
+	dn, err := ldapserver.ParseDN(base)
+	if err != nil {
+		return 0, ldapserver.NewResultError(ldapserver.LDAPResultInvalidDNSyntax, err.Error())
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.countLive(dn, scope), nil
+}
+
+// ServeLDAP answers the request m from the entries in memory
+func (b *Backend) ServeLDAP(w ldapserver.ResponseWriter, m *ldapserver.Message) {
+	var err error
+	switch r := m.ProtocolOp().(type) {
+	case ldap.BindRequest:
+		err = b.bind(w, r)
+	case ldap.SearchRequest:
+		err = b.search(w, m, r)
+	case ldap.AddRequest:
+		err = b.write(w, m, string(r.Entry()), func() error { return b.addRequest(r) })
+	case ldap.DelRequest:
+		err = b.write(w, m, string(r), func() error { return b.delete(r) })
+	case ldap.ModifyRequest:
+		err = b.write(w, m, string(r.Object()), func() error { return b.modify(r) })
+	case ldap.ModifyDNRequest:
+		err = b.write(w, m, string(r.Entry()), func() error { return b.modifyDN(r) })
+	case ldap.CompareRequest:
+		err = b.compare(w, m, r)
+	case ldap.ExtendedRequest:
+		if r.RequestName() != ldapserver.NoticeOfUndelete {
+			ldapserver.NewRouteMux().ServeLDAP(w, m)
+			break
+		}
+		err = b.undelete(w, m, r)
+	default:
+		// abandons and extended operations are answered as by a RouteMux
+		// without routes
+		ldapserver.NewRouteMux().ServeLDAP(w, m)
+	}
+	if err != nil {
+		resultCode, diagnosticMessage := ldapserver.DefaultErrorMapper(err)
+		w.Write(ldapserver.NewResponseForRequest(m.ProtocolOp(), resultCode, diagnosticMessage))
+	}
+}
+
+// authorizeWrite returns an insufficientAccessRights error unless the
+// client sending m may modify the entry dn, see AuthorizeWrite
+func (b *Backend) authorizeWrite(m *ldapserver.Message, dn string) error {
+	if b.AuthorizeWrite == nil && m.AuthzID() == "" {
+		return ldapserver.NewResultError(ldapserver.LDAPResultInsufficientAccessRights, "anonymous writes are not allowed")
+	}
+	if b.AuthorizeWrite != nil && !b.AuthorizeWrite(m, dn) {
+		return ldapserver.NewResultError(ldapserver.LDAPResultInsufficientAccessRights, fmt.Sprintf("modifying %s is not allowed", dn))
+	}
+	return nil
+}
+
+// write applies the write request m to the entry dn with apply, answers it
+// and publishes the change. With the No-Op control apply is run on a copy
+// of the directory, which is discarded.
+func (b *Backend) write(w ldapserver.ResponseWriter, m *ldapserver.Message, dn string, apply func() error) error {
+	if err := b.authorizeWrite(m, dn); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	if m.NoOp() {
+		entries := b.entries
+		b.entries = make(map[string]*storedEntry, len(entries))
+		for k, e := range entries {
+			b.entries[k] = e
+		}
+		err := apply()
+		b.entries = entries
+		b.mu.Unlock()
+		if err != nil {
+			return err
+		}
+		ldapserver.WriteNoOp(w, m)
+		return nil
+	}
+	err := apply()
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if e, ok := ldapserver.ChangeEventOf(m.ProtocolOp()); ok && b.Changes != nil {
+		b.Changes.Publish(b.Encryption.redact(e))
+	}
+	w.Write(ldapserver.NewResponseForRequest(m.ProtocolOp(), ldapserver.LDAPResultSuccess, ""))
+	return nil
+}
+
+func (b *Backend) bind(w ldapserver.ResponseWriter, r ldap.BindRequest) error {
+	if r.AuthenticationChoice() == "sasl" {
+		return ldapserver.NewResultError(ldapserver.LDAPResultAuthMethodNotSupported, "SASL binds are not supported")
+	}
+	if string(r.Name()) == "" && len(r.AuthenticationSimple()) == 0 {
+		// anonymous bind, RFC 4513 section 5.1.1
+		w.Write(ldapserver.NewBindResponse(ldapserver.LDAPResultSuccess))
+		return nil
+	}
+	if len(r.AuthenticationSimple()) == 0 {
+		// unauthenticated bind, RFC 4513 section 5.1.2
+		return ldapserver.NewResultError(ldapserver.LDAPResultUnwillingToPerform, "unauthenticated binds are not allowed")
+	}
+
+	b.mu.RLock()
+	e, ok := b.live(ldapserver.NormalizeDN(string(r.Name())))
+	var err error
+	if ok {
+		e, err = b.opened(e)
+	}
+	b.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	valid := false
+	if ok {
+		for _, v := range e.values("userPassword") {
+			if bytes.Equal(v, []byte(r.AuthenticationSimple())) {
+				valid = true
+			}
+		}
+	}
+	if !valid {
+		return ldapserver.NewResultError(ldapserver.LDAPResultInvalidCredentials, "")
+	}
+	w.Write(ldapserver.NewBindResponse(ldapserver.LDAPResultSuccess))
+	return nil
+}
+
+func (b *Backend) search(w ldapserver.ResponseWriter, m *ldapserver.Message, r ldap.SearchRequest) error {
+	base, err := ldapserver.ParseDN(string(r.BaseObject()))
+	if err != nil {
+		return ldapserver.NewResultError(ldapserver.LDAPResultInvalidDNSyntax, err.Error())
+	}
+	_, showDeleted, _ := m.Control(ldapserver.ControlShowDeleted)
+	if showDeleted {
+		if err := b.authorizeShowDeleted(m); err != nil {
+			return err
+		}
+	}
+	b.mu.RLock()
+	e, ok := b.entries[base.Normalize()]
+	b.mu.RUnlock()
+	if !ok || (!e.deleted.IsZero() && !showDeleted) {
+		return ldapserver.NewResultError(ldapserver.LDAPResultNoSuchObject, "")
+	}
+
+	fetch := func() ([]ldapserver.Entry, error) {
+		b.mu.RLock()
+		defer b.mu.RUnlock()
+		var matches []*storedEntry
+		for _, e := range b.entries {
+			if (e.deleted.IsZero() || showDeleted) && inSearchScope(e.dn, base, int(r.Scope())) {
+				matches = append(matches, e)
+			}
+		}
+		sortEntries(matches)
+		entries := make([]ldapserver.Entry, 0, len(matches))
+		for _, e := range matches {
+			read, err := b.visible(m, e)
+			if err != nil {
+				m.Logf(ldapserver.LogLevelError, "memory backend entry %s: %s", e.dn, err)
+				continue
+			}
+			entry := read.entry()
+			if ok, err := ldapserver.Matches(r.Filter(), entry); ok && err == nil {
+				entries = append(entries, ldapserver.SelectAttributes(entry, r))
+			}
+		}
+		return entries, nil
+	}
+	// the errors are written by the paging functions, or are write errors
+	if b.Snapshots != nil {
+		b.Snapshots.WritePage(w, m, fetch)
+		return nil
+	}
+	entries, _ := fetch()
+	ldapserver.WritePagedEntries(w, m, entries)
+	return nil
+}
+
+func (b *Backend) compare(w ldapserver.ResponseWriter, m *ldapserver.Message, r ldap.CompareRequest) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	e, ok := b.live(ldapserver.NormalizeDN(string(r.Entry())))
+	if !ok {
+		return ldapserver.NewResultError(ldapserver.LDAPResultNoSuchObject, "")
+	}
+	e, err := b.visible(m, e)
+	if err != nil {
+		return err
+	}
+	attribute := string(r.Ava().AttributeDesc())
+	values := e.values(attribute)
+	if len(values) == 0 {
+		return ldapserver.NewResultError(ldapserver.LDAPResultNoSuchAttribute, "")
+	}
+	for _, v := range values {
+		if ldapserver.CompareValues(attribute, v, []byte(r.Ava().AssertionValue())) == 0 {
+			w.Write(ldapserver.NewCompareResponse(ldapserver.LDAPResultCompareTrue))
+			return nil
+		}
+	}
+	w.Write(ldapserver.NewCompareResponse(ldapserver.LDAPResultCompareFalse))
+	return nil
+}
+
+func (b *Backend) addRequest(r ldap.AddRequest) error {
+	dn, err := ldapserver.ParseDN(string(r.Entry()))
+	if err != nil {
+		return ldapserver.NewResultError(ldapserver.LDAPResultInvalidDNSyntax, err.Error())
+	}
+	var attributes []ldapserver.EntryAttribute
+	for _, a := range r.Attributes() {
+		attribute := ldapserver.EntryAttribute{Name: string(a.Type_())}
+		for _, v := range a.Vals() {
+			attribute.Values = append(attribute.Values, []byte(v))
+		}
+		attributes = append(attributes, attribute)
+	}
+	return b.add(dn, attributes, true)
+}
+
+// add adds the entry dn, the values of its RDN must be among its
+// attributes. The attributes are copied unless owned is set.
+func (b *Backend) add(dn ldapserver.DN, attributes []ldapserver.EntryAttribute, owned bool) error {
+	if len(dn) == 0 {
+		return ldapserver.NewResultError(ldapserver.LDAPResultUnwillingToPerform, "the root DSE can not be added")
+	}
+	// a soft-deleted entry is replaced
+	if _, ok := b.live(dn.Normalize()); ok {
+		return ldapserver.NewResultError(ldapserver.LDAPResultEntryAlreadyExists, "")
+	}
+	if _, ok := b.live(dn.Parent().Normalize()); !ok && b.hasAncestor(dn) {
+		return ldapserver.NewResultError(ldapserver.LDAPResultNoSuchObject, fmt.Sprintf("parent entry %s does not exist", dn.Parent()))
+	}
+	if err := b.checkQuota(dn, 1); err != nil {
+		return err
+	}
+	if !owned {
+		attributes = copyAttributes(attributes)
+	}
+
+	e := &storedEntry{dn: dn, attributes: attributes}
+	for _, atv := range dn[0] {
+		if !e.hasValue(atv.Type, []byte(atv.Value)) {
+			return ldapserver.NewResultError(ldapserver.LDAPResultNamingViolation, fmt.Sprintf("value of the naming attribute %s is missing", atv.Type))
+		}
+	}
+	return b.store(e)
+}
+
+// hasAncestor reports whether a live ancestor of dn is held by the backend
+func (b *Backend) hasAncestor(dn ldapserver.DN) bool {
+	for parent := dn.Parent(); len(parent) > 0; parent = parent.Parent() {
+		if _, ok := b.live(parent.Normalize()); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Backend) delete(r ldap.DelRequest) error {
+	dn, err := ldapserver.ParseDN(string(r))
+	if err != nil {
+		return ldapserver.NewResultError(ldapserver.LDAPResultInvalidDNSyntax, err.Error())
+	}
+	e, ok := b.live(dn.Normalize())
+	if !ok {
+		return ldapserver.NewResultError(ldapserver.LDAPResultNoSuchObject, "")
+	}
+	for _, child := range b.entries {
+		if child.deleted.IsZero() && child.dn.IsDescendantOf(dn, false) {
+			return ldapserver.NewResultError(ldapserver.LDAPResultNotAllowedOnNonLeaf, "the entry has children")
+		}
+	}
+	if b.SoftDelete == nil {
+		delete(b.entries, dn.Normalize())
+		return nil
+	}
+	b.purge(time.Now())
+	b.entries[dn.Normalize()] = &storedEntry{dn: e.dn, attributes: e.attributes, deleted: time.Now()}
+	return nil
+}
+
+// modify applies the changes of r to a copy of the entry, which replaces
+// it once they all succeed
+func (b *Backend) modify(r ldap.ModifyRequest) error {
+	dn, err := ldapserver.ParseDN(string(r.Object()))
+	if err != nil {
+		return ldapserver.NewResultError(ldapserver.LDAPResultInvalidDNSyntax, err.Error())
+	}
+	current, ok := b.live(dn.Normalize())
+	if !ok {
+		return ldapserver.NewResultError(ldapserver.LDAPResultNoSuchObject, "")
+	}
+
+	e, err := b.opened(current)
+	if err != nil {
+		return err
+	}
+	for _, change := range r.Changes() {
+		modification := change.Modification()
+		name := string(modification.Type_())
+		var values [][]byte
+		for _, v := range modification.Vals() {
+			values = append(values, []byte(v))
+		}
+
+		switch int(change.Operation()) {
+		case ldapserver.ModifyRequestChangeOperationAdd:
+			if len(values) == 0 {
+				return ldapserver.NewResultError(ldapserver.LDAPResultProtocolError, fmt.Sprintf("no value to add to %s", name))
+			}
+			for _, v := range values {
+				if e.hasValue(name, v) {
+					return ldapserver.NewResultError(ldapserver.LDAPResultAttributeOrValueExists, fmt.Sprintf("%s already has the value %s", name, v))
+				}
+				e.addValue(name, v)
+			}
+		case ldapserver.ModifyRequestChangeOperationDelete:
+			if len(e.values(name)) == 0 {
+				return ldapserver.NewResultError(ldapserver.LDAPResultNoSuchAttribute, fmt.Sprintf("no attribute %s", name))
+			}
+			if len(values) == 0 {
+				e.removeAttribute(name)
+				break
+			}
+			for _, v := range values {
+				if !e.removeValue(name, v) {
+					return ldapserver.NewResultError(ldapserver.LDAPResultNoSuchAttribute, fmt.Sprintf("%s has no value %s", name, v))
+				}
+			}
+		case ldapserver.ModifyRequestChangeOperationReplace:
+			e.removeAttribute(name)
+			for _, v := range values {
+				if !e.hasValue(name, v) {
+					e.addValue(name, v)
+				}
+			}
+		default:
+			return ldapserver.NewResultError(ldapserver.LDAPResultProtocolError, fmt.Sprintf("unknown modify operation %d", int(change.Operation())))
+		}
+	}
+
+	for _, atv := range e.dn[0] {
+		if !e.hasValue(atv.Type, []byte(atv.Value)) {
+			return ldapserver.NewResultError(ldapserver.LDAPResultNotAllowedOnRDN, fmt.Sprintf("the value of the naming attribute %s can not be removed", atv.Type))
+		}
+	}
+	return b.store(e)
+}
+
+// modifyDN renames the entry of r, moving its subtree along
+func (b *Backend) modifyDN(r ldap.ModifyDNRequest) error {
+	dn, err := ldapserver.ParseDN(string(r.Entry()))
+	if err != nil {
+		return ldapserver.NewResultError(ldapserver.LDAPResultInvalidDNSyntax, err.Error())
+	}
+	current, ok := b.live(dn.Normalize())
+	if !ok {
+		return ldapserver.NewResultError(ldapserver.LDAPResultNoSuchObject, "")
+	}
+	rdn, err := ldapserver.ParseDN(string(r.NewRDN()))
+	if err != nil || len(rdn) != 1 {
+		return ldapserver.NewResultError(ldapserver.LDAPResultInvalidDNSyntax, fmt.Sprintf("invalid new RDN %q", r.NewRDN()))
+	}
+
+	parent := dn.Parent()
+	if r.NewSuperior() != nil {
+		if parent, err = ldapserver.ParseDN(string(*r.NewSuperior())); err != nil {
+			return ldapserver.NewResultError(ldapserver.LDAPResultInvalidDNSyntax, err.Error())
+		}
+		if _, ok := b.live(parent.Normalize()); !ok {
+			return ldapserver.NewResultError(ldapserver.LDAPResultNoSuchObject, fmt.Sprintf("new superior %s does not exist", parent))
+		}
+		if parent.IsDescendantOf(dn, true) {
+			return ldapserver.NewResultError(ldapserver.LDAPResultUnwillingToPerform, "an entry can not be moved below itself")
+		}
+	}
+	newDN := append(ldapserver.DN{rdn[0]}, parent...)
+	if _, ok := b.live(newDN.Normalize()); ok && !newDN.Equal(dn) {
+		return ldapserver.NewResultError(ldapserver.LDAPResultEntryAlreadyExists, "")
+	}
+	if !parent.Equal(dn.Parent()) {
+		// a subtree moved to another naming context adds its entries there
+		moved := 0
+		if !b.contextOf(parent).Equal(b.contextOf(dn)) {
+			moved = b.countLive(dn, ldapserver.SearchRequestHomeSubtree)
+		}
+		if err := b.checkQuota(newDN, moved); err != nil {
+			return err
+		}
+	}
+
+	e, err := b.opened(current)
+	if err != nil {
+		return err
+	}
+	e.dn = newDN
+	if bool(r.DeleteOldRDN()) {
+		for _, atv := range dn[0] {
+			e.removeValue(atv.Type, []byte(atv.Value))
+		}
+	}
+	for _, atv := range rdn[0] {
+		if !e.hasValue(atv.Type, []byte(atv.Value)) {
+			e.addValue(atv.Type, []byte(atv.Value))
+		}
+	}
+
+	// the subtree is renamed once the entry checks passed, the encrypted
+	// values are sealed with the DN of their entry
+	var descendants, moved []*storedEntry
+	for _, descendant := range b.entries {
+		if !descendant.dn.IsDescendantOf(dn, false) {
+			continue
+		}
+		movedDN := append(append(ldapserver.DN{}, descendant.dn[:len(descendant.dn)-len(dn)]...), newDN...)
+		attributes, err := b.Encryption.reseal(descendant.dn, movedDN, descendant.attributes)
+		if err != nil {
+			return ldapserver.NewResultError(ldapserver.LDAPResultOperationsError, err.Error())
+		}
+		descendants = append(descendants, descendant)
+		moved = append(moved, &storedEntry{dn: movedDN, attributes: attributes, deleted: descendant.deleted})
+	}
+	for i, descendant := range descendants {
+		delete(b.entries, descendant.dn.Normalize())
+		b.entries[moved[i].dn.Normalize()] = moved[i]
+	}
+	delete(b.entries, dn.Normalize())
+	return b.store(e)
+}
+
+// opened returns a copy of e with its encrypted attributes decrypted
+func (b *Backend) opened(e *storedEntry) (*storedEntry, error) {
+	attributes, err := b.Encryption.open(e.dn, e.attributes)
+	if err != nil {
+		return nil, ldapserver.NewResultError(ldapserver.LDAPResultOperationsError, err.Error())
+	}
+	return &storedEntry{dn: e.dn, attributes: copyAttributes(attributes), deleted: e.deleted}, nil
+}
+
+// visible returns a copy of e as read by the client sending m: decrypted,
+// without the encrypted attributes it may not read
+func (b *Backend) visible(m *ldapserver.Message, e *storedEntry) (*storedEntry, error) {
+	opened, err := b.opened(e)
+	if err != nil || b.Encryption == nil {
+		return opened, err
+	}
+	dn := e.dn.String()
+	attributes := opened.attributes[:0]
+	for _, a := range opened.attributes {
+		if b.Encryption.readable(m, dn, a.Name) {
+			attributes = append(attributes, a)
+		}
+	}
+	opened.attributes = attributes
+	return opened, nil
+}
+
+// store stores e, its encrypted attributes sealed
+func (b *Backend) store(e *storedEntry) error {
+	attributes, err := b.Encryption.seal(e.dn, e.attributes)
+	if err != nil {
+		return ldapserver.NewResultError(ldapserver.LDAPResultOperationsError, err.Error())
+	}
+	b.entries[e.dn.Normalize()] = &storedEntry{dn: e.dn, attributes: attributes}
+	return nil
+}
+
+// entry returns a copy of e, soft-deleted entries have the isDeleted
+// attribute
+func (e *storedEntry) entry() ldapserver.Entry {
+	entry := ldapserver.Entry{DN: e.dn.String(), Attributes: copyAttributes(e.attributes)}
+	if !e.deleted.IsZero() {
+		entry.Attributes = append(entry.Attributes, ldapserver.EntryAttribute{Name: "isDeleted", Values: [][]byte{[]byte("TRUE")}})
+	}
+	return entry
+}
+
+// values returns the values of the attribute description name
+func (e *storedEntry) values(name string) [][]byte {
+	for _, a := range e.attributes {
+		if strings.EqualFold(a.Name, name) {
+			return a.Values
+		}
+	}
+	return nil
+}
+
+func (e *storedEntry) hasValue(name string, v []byte) bool {
+	for _, value := range e.values(name) {
+		if ldapserver.CompareValues(name, value, v) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *storedEntry) addValue(name string, v []byte) {
+	for i := range e.attributes {
+		if strings.EqualFold(e.attributes[i].Name, name) {
+			e.attributes[i].Values = append(e.attributes[i].Values, v)
+			return
+		}
+	}
+	e.attributes = append(e.attributes, ldapserver.EntryAttribute{Name: name, Values: [][]byte{v}})
+}
+
+// removeValue removes the value v of the attribute name, and the attribute
+// with its last value. It returns false when there is no such value.
+func (e *storedEntry) removeValue(name string, v []byte) bool {
+	for i := range e.attributes {
+		if !strings.EqualFold(e.attributes[i].Name, name) {
+			continue
+		}
+		values := e.attributes[i].Values
+		for j := range values {
+			if ldapserver.CompareValues(name, values[j], v) != 0 {
+				continue
+			}
+			e.attributes[i].Values = append(values[:j:j], values[j+1:]...)
+			if len(e.attributes[i].Values) == 0 {
+				e.removeAttribute(name)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func (e *storedEntry) removeAttribute(name string) {
+	attributes := e.attributes[:0:0]
+	for _, a := range e.attributes {
+		if !strings.EqualFold(a.Name, name) {
+			attributes = append(attributes, a)
+		}
+	}
+	e.attributes = attributes
+}
+
+// copyAttributes returns a copy of attributes, the values are shared as
+// they are never modified in place
+func copyAttributes(attributes []ldapserver.EntryAttribute) []ldapserver.EntryAttribute {
+	copied := make([]ldapserver.EntryAttribute, len(attributes))
+	for i, a := range attributes {
+		copied[i] = ldapserver.EntryAttribute{Name: a.Name, Values: append([][]byte(nil), a.Values...)}
+	}
+	return copied
+}
+
+// inSearchScope reports whether dn is in the scope of a search of base
+func inSearchScope(dn ldapserver.DN, base ldapserver.DN, scope int) bool {
+	switch scope {
+	case ldapserver.SearchRequestScopeBaseObject:
+		return dn.Equal(base)
+	case ldapserver.SearchRequestSingleLevel:
+		return len(dn) == len(base)+1 && dn.IsDescendantOf(base, false)
+	}
+	return dn.IsDescendantOf(base, true)
+}
+
+// sortEntries sorts entries parents first, so the results are in the same
+// order for each page of a paged search
+func sortEntries(entries []*storedEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if len(entries[i].dn) != len(entries[j].dn) {
+			return len(entries[i].dn) < len(entries[j].dn)
+		}
+		return entries[i].dn.Normalize() < entries[j].dn.Normalize()
+	})
+}
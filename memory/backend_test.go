@@ -0,0 +1,191 @@
+package memory
+
+import (
+	"testing"
+
+	ldap "github.com/ps78674/goldap/message"
+	"github.com/ps78674/ldapserver"
+)
+
+// tlv returns the BER element of tag with the concatenated children as
+// value
+func tlv(tag byte, children ...[]byte) []byte {
+	var value []byte
+	for _, child := range children {
+		value = append(value, child...)
+	}
+	n := len(value)
+	if n < 0x80 {
+		return append([]byte{tag, byte(n)}, value...)
+	}
+	var length []byte
+	for ; n > 0; n >>= 8 {
+		length = append([]byte{byte(n)}, length...)
+	}
+	return append(append([]byte{tag, 0x80 | byte(len(length))}, length...), value...)
+}
+
+func octetString(s string) []byte {
+	return tlv(0x04, []byte(s))
+}
+
+// request returns the message of ID 1 with the BER encoded protocolOp
+func request(t *testing.T, protocolOp []byte) *ldapserver.Message {
+	t.Helper()
+	msg, err := ldap.ReadLDAPMessage(ldap.NewBytes(0, tlv(0x30, tlv(0x02, []byte{1}), protocolOp)))
+	if err != nil {
+		t.Fatalf("decoding the request: %s", err)
+	}
+	return &ldapserver.Message{LDAPMessage: &msg}
+}
+
+func bindRequest(dn, password string) []byte {
+	return tlv(0x60, tlv(0x02, []byte{3}), octetString(dn), tlv(0x80, []byte(password)))
+}
+
+func addRequest(dn string, attributes ...[2]string) []byte {
+	var list [][]byte
+	for _, a := range attributes {
+		list = append(list, tlv(0x30, octetString(a[0]), tlv(0x31, octetString(a[1]))))
+	}
+	return tlv(0x68, octetString(dn), tlv(0x30, list...))
+}
+
+func compareRequest(dn, attribute, value string) []byte {
+	return tlv(0x6e, octetString(dn), tlv(0x30, octetString(attribute), octetString(value)))
+}
+
+func newTestBackend(t *testing.T) *Backend {
+	t.Helper()
+	b := NewBackend()
+	entries := []ldapserver.Entry{
+		{DN: "dc=example,dc=com", Attributes: []ldapserver.EntryAttribute{
+			{Name: "objectClass", Values: [][]byte{[]byte("domain")}},
+			{Name: "dc", Values: [][]byte{[]byte("example")}},
+		}},
+		{DN: "uid=alice,dc=example,dc=com", Attributes: []ldapserver.EntryAttribute{
+			{Name: "objectClass", Values: [][]byte{[]byte("person")}},
+			{Name: "uid", Values: [][]byte{[]byte("alice")}},
+			{Name: "userPassword", Values: [][]byte{[]byte("secret")}},
+		}},
+	}
+	for _, e := range entries {
+		if err := b.AddEntry(e); err != nil {
+			t.Fatalf("adding %s: %s", e.DN, err)
+		}
+	}
+	return b
+}
+
+func TestBind(t *testing.T) {
+	tests := []struct {
+		name       string
+		dn         string
+		password   string
+		resultCode int
+	}{
+		{"anonymous", "", "", ldapserver.LDAPResultSuccess},
+		{"unauthenticated", "uid=alice,dc=example,dc=com", "", ldapserver.LDAPResultUnwillingToPerform},
+		{"unauthenticated unknown entry", "uid=nobody,dc=example,dc=com", "", ldapserver.LDAPResultUnwillingToPerform},
+		{"valid password", "uid=alice,dc=example,dc=com", "secret", ldapserver.LDAPResultSuccess},
+		{"normalized DN", "UID=Alice, DC=Example, DC=Com", "secret", ldapserver.LDAPResultSuccess},
+		{"invalid password", "uid=alice,dc=example,dc=com", "Secret", ldapserver.LDAPResultInvalidCredentials},
+		{"unknown entry", "uid=nobody,dc=example,dc=com", "secret", ldapserver.LDAPResultInvalidCredentials},
+		{"entry without password", "dc=example,dc=com", "secret", ldapserver.LDAPResultInvalidCredentials},
+	}
+	b := newTestBackend(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := ldapserver.NewResponseRecorder()
+			b.ServeLDAP(w, request(t, bindRequest(tt.dn, tt.password)))
+			if got := w.ResultCode(); got != tt.resultCode {
+				t.Errorf("result code %d, want %d", got, tt.resultCode)
+			}
+		})
+	}
+}
+
+func TestAuthorizeWrite(t *testing.T) {
+	bob := addRequest("uid=bob,dc=example,dc=com", [2]string{"objectClass", "person"}, [2]string{"uid", "bob"})
+	tests := []struct {
+		name       string
+		authorize  func(m *ldapserver.Message, dn string) bool
+		protocolOp []byte
+		resultCode int
+		added      bool
+	}{
+		{"anonymous add", nil, bob, ldapserver.LDAPResultInsufficientAccessRights, false},
+		{"anonymous delete", nil, tlv(0x4a, []byte("uid=alice,dc=example,dc=com")), ldapserver.LDAPResultInsufficientAccessRights, false},
+		{"allowed add", func(*ldapserver.Message, string) bool { return true }, bob, ldapserver.LDAPResultSuccess, true},
+		{"refused add", func(*ldapserver.Message, string) bool { return false }, bob, ldapserver.LDAPResultInsufficientAccessRights, false},
+		{"other entry allowed", func(_ *ldapserver.Message, dn string) bool {
+			return ldapserver.NormalizeDN(dn) == ldapserver.NormalizeDN("uid=carol,dc=example,dc=com")
+		}, bob, ldapserver.LDAPResultInsufficientAccessRights, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBackend(t)
+			b.AuthorizeWrite = tt.authorize
+			w := ldapserver.NewResponseRecorder()
+			b.ServeLDAP(w, request(t, tt.protocolOp))
+			if got := w.ResultCode(); got != tt.resultCode {
+				t.Errorf("result code %d, want %d", got, tt.resultCode)
+			}
+			if _, ok := b.Entry("uid=bob,dc=example,dc=com"); ok != tt.added {
+				t.Errorf("entry added %t, want %t", ok, tt.added)
+			}
+			if _, ok := b.Entry("uid=alice,dc=example,dc=com"); !ok {
+				t.Error("entry uid=alice deleted")
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name       string
+		dn         string
+		attribute  string
+		value      string
+		resultCode int
+	}{
+		{"equal", "uid=alice,dc=example,dc=com", "uid", "ALICE", ldapserver.LDAPResultCompareTrue},
+		{"different", "uid=alice,dc=example,dc=com", "uid", "bob", ldapserver.LDAPResultCompareFalse},
+		{"missing attribute", "uid=alice,dc=example,dc=com", "mail", "alice@example.com", ldapserver.LDAPResultNoSuchAttribute},
+		{"missing entry", "uid=bob,dc=example,dc=com", "uid", "bob", ldapserver.LDAPResultNoSuchObject},
+	}
+	b := newTestBackend(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := ldapserver.NewResponseRecorder()
+			b.ServeLDAP(w, request(t, compareRequest(tt.dn, tt.attribute, tt.value)))
+			if got := w.ResultCode(); got != tt.resultCode {
+				t.Errorf("result code %d, want %d", got, tt.resultCode)
+			}
+		})
+	}
+}
+
+func TestCountEntries(t *testing.T) {
+	tests := []struct {
+		base  string
+		scope int
+		count int
+	}{
+		{"dc=example,dc=com", ldapserver.SearchRequestScopeBaseObject, 1},
+		{"dc=example,dc=com", ldapserver.SearchRequestSingleLevel, 1},
+		{"dc=example,dc=com", ldapserver.SearchRequestHomeSubtree, 2},
+		{"uid=alice,dc=example,dc=com", ldapserver.SearchRequestSingleLevel, 0},
+		{"dc=org", ldapserver.SearchRequestHomeSubtree, 0},
+	}
+	b := newTestBackend(t)
+	for _, tt := range tests {
+		count, err := b.CountEntries(tt.base, tt.scope)
+		if err != nil {
+			t.Fatalf("CountEntries(%q, %d): %s", tt.base, tt.scope, err)
+		}
+		if count != tt.count {
+			t.Errorf("CountEntries(%q, %d) = %d, want %d", tt.base, tt.scope, count, tt.count)
+		}
+	}
+}
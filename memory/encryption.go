@@ -0,0 +1,221 @@
+package memory
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/ps78674/ldapserver"
+)
+
+// KeyProvider supplies the keys encrypting attribute values at rest, a KMS
+// client for instance. Keys are 16, 24 or 32 bytes long, for AES-128,
+// AES-192 or AES-256.
+type KeyProvider interface {
+	// CurrentKey returns the key encrypting the new values, and its
+	// identifier stored with them
+	CurrentKey() (id string, key []byte, err error)
+	// Key returns the key identified by id, to decrypt the values
+	// encrypted before a key rotation
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider holding its keys in memory
+type StaticKeys struct {
+	Current string            // identifier of the key encrypting the new values
+	Keys    map[string][]byte // keys by identifier
+}
+
+func (k *StaticKeys) CurrentKey() (string, []byte, error) {
+	key, err := k.Key(k.Current)
+	return k.Current, key, err
+}
+
+func (k *StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+	return key, nil
+}
+
+// AttributeEncryption declares the attributes a Backend stores
+// encrypted, with AES-GCM. Their values are decrypted to evaluate binds,
+// and returned to the clients, or used in their filters and compares, only
+// when Authorize allows it. They are also left out of the published
+// changes. The sealed values are bound to their attribute and entry DN,
+// they are sealed again when the entry is renamed.
+type AttributeEncryption struct {
+	Attributes []string // attribute types, pwdHistory or recovery codes for instance
+	Keys       KeyProvider
+
+	// Authorize reports whether the client sending m may read the
+	// attribute of the entry dn in clear. The encrypted attributes are
+	// never read by clients when nil.
+	Authorize func(m *ldapserver.Message, dn string, attribute string) bool
+}
+
+// encryptedValuePrefix starts the encrypted values, followed by the key
+// identifier, a colon and the base64 encoded nonce and ciphertext. Values
+// stored before the encryption was enabled are read as is.
+const encryptedValuePrefix = "{ENC}"
+
+// encrypts reports whether the attribute name is stored encrypted
+func (a *AttributeEncryption) encrypts(name string) bool {
+	if a == nil {
+		return false
+	}
+	for _, attribute := range a.Attributes {
+		if attributeType(attribute) == attributeType(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// readable reports whether the client sending m may read the attribute
+// name of the entry dn
+func (a *AttributeEncryption) readable(m *ldapserver.Message, dn string, name string) bool {
+	return !a.encrypts(name) || (a.Authorize != nil && a.Authorize(m, dn, name))
+}
+
+// seal returns the attributes of the entry dn with the values of the
+// encrypted ones sealed
+func (a *AttributeEncryption) seal(dn ldapserver.DN, attributes []ldapserver.EntryAttribute) ([]ldapserver.EntryAttribute, error) {
+	if a == nil {
+		return attributes, nil
+	}
+	var aead cipher.AEAD
+	var id string
+	sealed := make([]ldapserver.EntryAttribute, len(attributes))
+	for i, attribute := range attributes {
+		sealed[i] = attribute
+		if !a.encrypts(attribute.Name) {
+			continue
+		}
+		if aead == nil {
+			var key []byte
+			var err error
+			if id, key, err = a.Keys.CurrentKey(); err != nil {
+				return nil, err
+			}
+			if aead, err = newAEAD(key); err != nil {
+				return nil, err
+			}
+		}
+		sealed[i].Values = make([][]byte, len(attribute.Values))
+		for j, v := range attribute.Values {
+			nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(v)+aead.Overhead())
+			if _, err := rand.Read(nonce); err != nil {
+				return nil, err
+			}
+			ciphertext := aead.Seal(nonce, nonce, v, additionalData(dn, attribute.Name))
+			sealed[i].Values[j] = []byte(encryptedValuePrefix + id + ":" + base64.StdEncoding.EncodeToString(ciphertext))
+		}
+	}
+	return sealed, nil
+}
+
+// open returns the attributes of the entry dn with the values of the
+// encrypted ones decrypted
+func (a *AttributeEncryption) open(dn ldapserver.DN, attributes []ldapserver.EntryAttribute) ([]ldapserver.EntryAttribute, error) {
+	if a == nil {
+		return attributes, nil
+	}
+	opened := make([]ldapserver.EntryAttribute, len(attributes))
+	for i, attribute := range attributes {
+		opened[i] = attribute
+		if !a.encrypts(attribute.Name) {
+			continue
+		}
+		opened[i].Values = make([][]byte, len(attribute.Values))
+		for j, v := range attribute.Values {
+			plaintext, err := a.openValue(additionalData(dn, attribute.Name), v)
+			if err != nil {
+				return nil, fmt.Errorf("attribute %s: %s", attribute.Name, err)
+			}
+			opened[i].Values[j] = plaintext
+		}
+	}
+	return opened, nil
+}
+
+// openValue decrypts the value v sealed with the additional data ad
+func (a *AttributeEncryption) openValue(ad []byte, v []byte) ([]byte, error) {
+	if !bytes.HasPrefix(v, []byte(encryptedValuePrefix)) {
+		return v, nil
+	}
+	sealed := string(v[len(encryptedValuePrefix):])
+	i := strings.IndexByte(sealed, ':')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid encrypted value")
+	}
+	key, err := a.Keys.Key(sealed[:i])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed[i+1:])
+	if err != nil || len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid encrypted value")
+	}
+	nonce := ciphertext[:aead.NonceSize()]
+	return aead.Open(nil, nonce, ciphertext[aead.NonceSize():], ad)
+}
+
+// reseal returns the attributes sealed for the entry from, sealed for the
+// entry to, once it is renamed
+func (a *AttributeEncryption) reseal(from, to ldapserver.DN, attributes []ldapserver.EntryAttribute) ([]ldapserver.EntryAttribute, error) {
+	opened, err := a.open(from, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return a.seal(to, opened)
+}
+
+// additionalData returns the data authenticated with the sealed values of
+// the attribute name of the entry dn, so a value can not be moved to
+// another attribute or entry
+func additionalData(dn ldapserver.DN, name string) []byte {
+	return []byte(attributeType(name) + "\x00" + dn.Normalize())
+}
+
+// redact removes the values of the encrypted attributes from the change e
+func (a *AttributeEncryption) redact(e ldapserver.ChangeEvent) ldapserver.ChangeEvent {
+	if a == nil {
+		return e
+	}
+	changes := make([]ldapserver.Change, len(e.Changes))
+	for i, c := range e.Changes {
+		changes[i] = c
+		if a.encrypts(c.Attribute) {
+			changes[i].Values = nil
+		}
+	}
+	e.Changes = changes
+	return e
+}
+
+// attributeType returns the attribute type of an attribute description,
+// lowercased and without options
+func attributeType(description string) string {
+	if i := strings.IndexByte(description, ';'); i >= 0 {
+		description = description[:i]
+	}
+	return strings.ToLower(description)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ps78674/ldapserver"
+)
+
+func testEncryption() *AttributeEncryption {
+	return &AttributeEncryption{
+		Attributes: []string{"userPassword", "pwdHistory"},
+		Keys:       &StaticKeys{Current: "k1", Keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}},
+	}
+}
+
+func TestSealBindsEntry(t *testing.T) {
+	a := testEncryption()
+	alice, _ := ldapserver.ParseDN("uid=alice,dc=example,dc=com")
+	bob, _ := ldapserver.ParseDN("uid=bob,dc=example,dc=com")
+	sealed, err := a.seal(alice, []ldapserver.EntryAttribute{{Name: "userPassword", Values: [][]byte{[]byte("secret")}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed[0].Values[0], []byte("secret")) {
+		t.Fatal("value stored in clear")
+	}
+
+	tests := []struct {
+		name    string
+		dn      ldapserver.DN
+		rename  string
+		wantErr bool
+	}{
+		{"same entry", alice, "userPassword", false},
+		{"case of the DN", func() ldapserver.DN { dn, _ := ldapserver.ParseDN("UID=Alice,DC=Example,DC=Com"); return dn }(), "USERPASSWORD;x-opt", false},
+		{"other entry", bob, "userPassword", true},
+		{"other attribute", alice, "pwdHistory", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			moved := []ldapserver.EntryAttribute{{Name: tt.rename, Values: sealed[0].Values}}
+			opened, err := a.open(tt.dn, moved)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("value opened as %q", opened[0].Values[0])
+				}
+				return
+			}
+			if err != nil || string(opened[0].Values[0]) != "secret" {
+				t.Errorf("opened %v, %v, want secret", opened, err)
+			}
+		})
+	}
+}
+
+func TestModifyDNReseals(t *testing.T) {
+	b := NewBackend()
+	b.Encryption = testEncryption()
+	b.AuthorizeWrite = func(m *ldapserver.Message, dn string) bool { return true }
+	for _, e := range []ldapserver.Entry{
+		{DN: "dc=example,dc=com", Attributes: []ldapserver.EntryAttribute{{Name: "dc", Values: [][]byte{[]byte("example")}}}},
+		{DN: "ou=people,dc=example,dc=com", Attributes: []ldapserver.EntryAttribute{{Name: "ou", Values: [][]byte{[]byte("people")}}}},
+		{DN: "uid=alice,ou=people,dc=example,dc=com", Attributes: []ldapserver.EntryAttribute{
+			{Name: "uid", Values: [][]byte{[]byte("alice")}},
+			{Name: "userPassword", Values: [][]byte{[]byte("secret")}},
+		}},
+	} {
+		if err := b.AddEntry(e); err != nil {
+			t.Fatalf("adding %s: %s", e.DN, err)
+		}
+	}
+
+	w := ldapserver.NewResponseRecorder()
+	b.ServeLDAP(w, request(t, tlv(0x6c, octetString("ou=people,dc=example,dc=com"), octetString("ou=staff"), tlv(0x01, []byte{0xff}))))
+	if code := w.ResultCode(); code != ldapserver.LDAPResultSuccess {
+		t.Fatalf("ModifyDN result code %d", code)
+	}
+	e, ok := b.Entry("uid=alice,ou=staff,dc=example,dc=com")
+	if !ok {
+		t.Fatal("entry not moved")
+	}
+	for _, a := range e.Attributes {
+		if a.Name == "userPassword" && string(a.Values[0]) != "secret" {
+			t.Errorf("userPassword %q once moved, want secret", a.Values[0])
+		}
+	}
+}
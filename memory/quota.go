@@ -0,0 +1,45 @@
+package memory
+
+import "github.com/ps78674/ldapserver"
+
+// checkQuota returns an adminLimitExceeded error when the entry dn, added
+// or moved with added entries, exceeds Quota. b.mu is held.
+func (b *Backend) checkQuota(dn ldapserver.DN, added int) error {
+	if b.Quota == nil {
+		return nil
+	}
+	parent := dn.Parent()
+	if _, ok := b.live(parent.Normalize()); !ok {
+		// dn is the suffix of a new naming context
+		return b.Quota.CheckEntries(dn.String(), 0, added)
+	}
+	if err := b.Quota.CheckChildren(parent.String(), b.countLive(parent, ldapserver.SearchRequestSingleLevel)); err != nil {
+		return err
+	}
+	suffix := b.contextOf(parent)
+	return b.Quota.CheckEntries(suffix.String(), b.countLive(suffix, ldapserver.SearchRequestHomeSubtree), added)
+}
+
+// contextOf returns the suffix of the naming context holding the entry
+// dn: its farthest live ancestor held by the backend, or dn itself
+func (b *Backend) contextOf(dn ldapserver.DN) ldapserver.DN {
+	suffix := dn
+	for parent := dn.Parent(); len(parent) > 0; parent = parent.Parent() {
+		if _, ok := b.live(parent.Normalize()); ok {
+			suffix = parent
+		}
+	}
+	return suffix
+}
+
+// countLive returns the number of live entries in the scope of base, b.mu
+// is held
+func (b *Backend) countLive(base ldapserver.DN, scope int) int {
+	n := 0
+	for _, e := range b.entries {
+		if e.deleted.IsZero() && inSearchScope(e.dn, base, scope) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,51 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/ps78674/ldapserver"
+)
+
+func modifyDNRequest(dn, newRDN, newSuperior string) []byte {
+	return tlv(0x6c, octetString(dn), octetString(newRDN), tlv(0x01, []byte{0xff}), tlv(0x80, []byte(newSuperior)))
+}
+
+func TestQuota(t *testing.T) {
+	person := func(dn, uid string) []byte {
+		return addRequest(dn, [2]string{"objectClass", "person"}, [2]string{"uid", uid})
+	}
+	tests := []struct {
+		name       string
+		quota      ldapserver.Quota
+		protocolOp []byte
+		resultCode int
+	}{
+		{"within MaxEntries", ldapserver.Quota{MaxEntries: 3}, person("uid=bob,dc=example,dc=com", "bob"), ldapserver.LDAPResultSuccess},
+		{"beyond MaxEntries", ldapserver.Quota{MaxEntries: 2}, person("uid=bob,dc=example,dc=com", "bob"), ldapserver.LDAPResultAdminLimitExceeded},
+		{"new context", ldapserver.Quota{MaxEntries: 2}, addRequest("dc=example,dc=net", [2]string{"objectClass", "domain"}, [2]string{"dc", "example"}), ldapserver.LDAPResultSuccess},
+		{"beyond MaxChildren", ldapserver.Quota{MaxChildren: 1}, person("uid=bob,dc=example,dc=com", "bob"), ldapserver.LDAPResultAdminLimitExceeded},
+		{"move within the context", ldapserver.Quota{MaxEntries: 2}, modifyDNRequest("uid=alice,dc=example,dc=com", "uid=alice", "dc=example,dc=com"), ldapserver.LDAPResultSuccess},
+		{"move to a full context", ldapserver.Quota{MaxEntries: 2}, modifyDNRequest("uid=alice,dc=example,dc=com", "uid=alice", "uid=carol,dc=example,dc=org"), ldapserver.LDAPResultAdminLimitExceeded},
+		{"move to another context", ldapserver.Quota{MaxEntries: 3}, modifyDNRequest("uid=alice,dc=example,dc=com", "uid=alice", "uid=carol,dc=example,dc=org"), ldapserver.LDAPResultSuccess},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBackend(t)
+			for _, e := range []ldapserver.Entry{
+				*ldapserver.NewEntry("dc=example,dc=org").Add("objectClass", "domain").Add("dc", "example"),
+				*ldapserver.NewEntry("uid=carol,dc=example,dc=org").Add("objectClass", "person").Add("uid", "carol"),
+			} {
+				if err := b.AddEntry(e); err != nil {
+					t.Fatal(err)
+				}
+			}
+			b.Quota = &tt.quota
+			b.AuthorizeWrite = func(*ldapserver.Message, string) bool { return true }
+			w := ldapserver.NewResponseRecorder()
+			b.ServeLDAP(w, request(t, tt.protocolOp))
+			if got := w.ResultCode(); got != tt.resultCode {
+				t.Errorf("result code %d, want %d", got, tt.resultCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package memory
+
+import (
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+	"github.com/ps78674/ldapserver"
+)
+
+// SoftDeletePolicy configures the soft deletion of the entries of a Backend,
+// as the Active Directory recycle bin: deleted entries are kept with the
+// isDeleted attribute, hidden from the requests except the searches with
+// the Show Deleted control, until they are restored with the
+// NoticeOfUndelete extended operation, authorized as writes by
+// Backend.AuthorizeWrite, or purged. An entry may be deleted
+// while it has soft-deleted children, and a new entry added in place of a
+// soft-deleted one replaces it.
+type SoftDeletePolicy struct {
+	// Retention is the time the deleted entries are kept, they are purged
+	// by the next deletion or Backend.Purge call once it elapsed. They are
+	// kept until purged with Backend.Purge if zero.
+	Retention time.Duration
+
+	// AuthorizeShowDeleted, if non-nil, reports whether the client sending
+	// the search m may read the soft-deleted entries with the Show Deleted
+	// control, refused searches get insufficientAccessRights. If nil, the
+	// control is refused to anonymous clients and allowed to the others.
+	AuthorizeShowDeleted func(m *ldapserver.Message) bool
+}
+
+// authorizeShowDeleted returns an insufficientAccessRights error unless
+// the client sending m may use the Show Deleted control, see
+// AuthorizeShowDeleted
+func (b *Backend) authorizeShowDeleted(m *ldapserver.Message) error {
+	var authorize func(m *ldapserver.Message) bool
+	if b.SoftDelete != nil {
+		authorize = b.SoftDelete.AuthorizeShowDeleted
+	}
+	if authorize == nil && m.AuthzID() == "" {
+		return ldapserver.NewResultError(ldapserver.LDAPResultInsufficientAccessRights, "anonymous clients may not read deleted entries")
+	}
+	if authorize != nil && !authorize(m) {
+		return ldapserver.NewResultError(ldapserver.LDAPResultInsufficientAccessRights, "reading deleted entries is not allowed")
+	}
+	return nil
+}
+
+// live returns the entry of normalized DN key unless it is soft-deleted
+func (b *Backend) live(key string) (*storedEntry, bool) {
+	e, ok := b.entries[key]
+	if !ok || !e.deleted.IsZero() {
+		return nil, false
+	}
+	return e, true
+}
+
+// Purge removes the soft-deleted entries whose retention elapsed, or all
+// of them without retention, and returns their number
+func (b *Backend) Purge() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := time.Now()
+	if b.SoftDelete != nil {
+		t = t.Add(-b.SoftDelete.Retention)
+	}
+	return b.purgeBefore(t)
+}
+
+// purge removes the soft-deleted entries whose retention elapsed at now
+func (b *Backend) purge(now time.Time) int {
+	if b.SoftDelete == nil || b.SoftDelete.Retention == 0 {
+		return 0
+	}
+	return b.purgeBefore(now.Add(-b.SoftDelete.Retention))
+}
+
+// purgeBefore removes the entries soft-deleted at t or before
+func (b *Backend) purgeBefore(t time.Time) int {
+	n := 0
+	for key, e := range b.entries {
+		if !e.deleted.IsZero() && !e.deleted.After(t) {
+			delete(b.entries, key)
+			n++
+		}
+	}
+	return n
+}
+
+// undelete answers the NoticeOfUndelete extended request m, restoring the
+// soft-deleted entry named by its requestValue. The parent of the entry
+// must be live, deleted subtrees are restored from the top.
+func (b *Backend) undelete(w ldapserver.ResponseWriter, m *ldapserver.Message, r ldap.ExtendedRequest) error {
+	if r.RequestValue() == nil {
+		return ldapserver.NewResultError(ldapserver.LDAPResultProtocolError, "missing DN of the entry to restore")
+	}
+	dn, err := ldapserver.ParseDN(string(*r.RequestValue()))
+	if err != nil {
+		return ldapserver.NewResultError(ldapserver.LDAPResultInvalidDNSyntax, err.Error())
+	}
+	if err := b.authorizeWrite(m, dn.String()); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	e, ok := b.entries[dn.Normalize()]
+	switch {
+	case !ok || e.deleted.IsZero():
+		b.mu.Unlock()
+		return ldapserver.NewResultError(ldapserver.LDAPResultNoSuchObject, "no soft-deleted entry "+dn.String())
+	case !b.parentLive(dn):
+		b.mu.Unlock()
+		return ldapserver.NewResultError(ldapserver.LDAPResultUnwillingToPerform, "the parent entry must be restored first")
+	}
+	if err := b.checkQuota(dn, 1); err != nil {
+		b.mu.Unlock()
+		return err
+	}
+	b.entries[dn.Normalize()] = &storedEntry{dn: e.dn, attributes: e.attributes}
+	b.mu.Unlock()
+
+	if b.Changes != nil {
+		change := ldapserver.ChangeEvent{Type: ldapserver.ChangeAdd, DN: e.dn.String()}
+		if opened, err := b.Encryption.open(e.dn, e.attributes); err == nil {
+			for _, a := range opened {
+				change.Changes = append(change.Changes, ldapserver.Change{Operation: ldapserver.ModifyRequestChangeOperationAdd, Attribute: a.Name, Values: a.Values})
+			}
+		}
+		b.Changes.Publish(b.Encryption.redact(change))
+	}
+	m.Logf(ldapserver.LogLevelInfo, "entry %s restored", e.dn)
+	w.Write(ldapserver.NewExtendedResponse(ldapserver.LDAPResultSuccess))
+	return nil
+}
+
+// parentLive reports whether the parent of dn is live, or dn a suffix
+func (b *Backend) parentLive(dn ldapserver.DN) bool {
+	if _, ok := b.live(dn.Parent().Normalize()); ok {
+		return true
+	}
+	return !b.hasAncestor(dn) && b.entries[dn.Parent().Normalize()] == nil
+}
+
+// SupportedExtensions returns the requestNames of the extended operations
+// served, NoticeOfUndelete with soft deletion
+func (b *Backend) SupportedExtensions() []ldap.LDAPOID {
+	oids := ldapserver.NewRouteMux().SupportedExtensions()
+	if b.SoftDelete != nil {
+		oids = append(oids, ldapserver.NoticeOfUndelete)
+	}
+	return oids
+}
+
+// SupportedControls returns the request controls honored: No-Op, Simple
+// Paged Results, and Show Deleted with soft deletion
+func (b *Backend) SupportedControls() []ldap.LDAPOID {
+	oids := []ldap.LDAPOID{ldapserver.ControlNoOp, ldapserver.ControlPagedResults}
+	if b.SoftDelete != nil {
+		oids = append(oids, ldapserver.ControlShowDeleted)
+	}
+	return oids
+}
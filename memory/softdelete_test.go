@@ -0,0 +1,52 @@
+package memory
+
+import (
+	"testing"
+
+	ldap "github.com/ps78674/goldap/message"
+	"github.com/ps78674/ldapserver"
+)
+
+func TestAuthorizeShowDeleted(t *testing.T) {
+	// base search of uid=alice with a critical Show Deleted control
+	search := tlv(0x63, octetString("uid=alice,dc=example,dc=com"),
+		tlv(0x0a, []byte{0}), tlv(0x0a, []byte{0}), tlv(0x02, []byte{0}), tlv(0x02, []byte{0}), tlv(0x01, []byte{0}),
+		tlv(0x87, []byte("objectClass")), tlv(0x30))
+	control := tlv(0x30, octetString(string(ldapserver.ControlShowDeleted)), tlv(0x01, []byte{0xff}))
+	msg, err := ldap.ReadLDAPMessage(ldap.NewBytes(0, tlv(0x30, tlv(0x02, []byte{1}), search, tlv(0xa0, control))))
+	if err != nil {
+		t.Fatalf("decoding the request: %s", err)
+	}
+
+	tests := []struct {
+		name       string
+		authorize  func(m *ldapserver.Message) bool
+		resultCode int
+		entries    int
+	}{
+		{"anonymous", nil, ldapserver.LDAPResultInsufficientAccessRights, 0},
+		{"refused", func(*ldapserver.Message) bool { return false }, ldapserver.LDAPResultInsufficientAccessRights, 0},
+		{"allowed", func(*ldapserver.Message) bool { return true }, ldapserver.LDAPResultSuccess, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBackend(t)
+			b.SoftDelete = &SoftDeletePolicy{AuthorizeShowDeleted: tt.authorize}
+			b.AuthorizeWrite = func(*ldapserver.Message, string) bool { return true }
+			w := ldapserver.NewResponseRecorder()
+			b.ServeLDAP(w, request(t, tlv(0x4a, []byte("uid=alice,dc=example,dc=com"))))
+			if got := w.ResultCode(); got != ldapserver.LDAPResultSuccess {
+				t.Fatalf("delete result code %d", got)
+			}
+
+			w = ldapserver.NewResponseRecorder()
+			b.ServeLDAP(w, &ldapserver.Message{LDAPMessage: &msg})
+			if got := w.ResultCode(); got != tt.resultCode {
+				t.Errorf("result code %d, want %d", got, tt.resultCode)
+			}
+			if n := len(w.Entries()); n != tt.entries {
+				t.Errorf("%d entries returned, want %d", n, tt.entries)
+			}
+		})
+	}
+}
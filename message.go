@@ -1,13 +1,30 @@
 package ldapserver
 
 import (
+	"context"
+	"sync/atomic"
+	"time"
+
 	ldap "github.com/ps78674/goldap/message"
 )
 
 type Message struct {
 	*ldap.LDAPMessage
-	Client *client
-	Done   chan bool
+	Client     *client
+	Done       chan bool
+	filterCost int   // estimated cost of the search filter, see FilterCostPolicy
+	terminated int32 // set once the request is signaled to stop, see terminate
+	ctx        context.Context
+	cancel     context.CancelFunc
+	received   time.Time // time the request was read
+	sequence   int       // rank of the request among those read on the connection
+	authzID    *string   // authorization identity of the ProxiedAuthorization control, if any
+
+	namingContext atomic.Value // suffix of the naming context serving the request, see NamingContext
+
+	cancelRequested int32         // set by a Cancel extended operation
+	canceled        int32         // set once answered with the canceled result code
+	finished        chan struct{} // closed once the request is answered
 }
 
 // unused now
@@ -21,6 +38,40 @@ func (m *Message) Abandon() {
 	m.Done <- true
 }
 
+// Context returns the context of the request, it is canceled when the
+// request is abandoned or cancelled, the connection is lost, or the client
+// unbinds or the server stops and the drain policy does not let the
+// request complete. Handlers should pass it to database or HTTP calls
+// rather than polling Done, so backends stop fetching data nobody will
+// read.
+func (m *Message) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+// Deadline returns the time the operation must complete by: the earliest
+// of the end of the search timeLimit, counted from the request receipt,
+// and the deadline of the request Context, set by a route Timeout for
+// instance. ok is false when the operation is not bounded.
+func (m *Message) Deadline() (deadline time.Time, ok bool) {
+	deadline, ok = m.Context().Deadline()
+	if r, isSearch := m.ProtocolOp().(ldap.SearchRequest); isSearch && r.TimeLimit() > 0 && !m.received.IsZero() {
+		limit := m.received.Add(time.Duration(r.TimeLimit()) * time.Second)
+		if !ok || limit.Before(deadline) {
+			deadline, ok = limit, true
+		}
+	}
+	return deadline, ok
+}
+
+// FilterCost returns the estimated cost of the search filter, as scored by
+// the server FilterCostPolicy, or zero
+func (m *Message) FilterCost() int {
+	return m.filterCost
+}
+
 func (m *Message) GetAbandonRequest() ldap.AbandonRequest {
 	return m.ProtocolOp().(ldap.AbandonRequest)
 }
@@ -52,3 +103,14 @@ func (m *Message) GetCompareRequest() ldap.CompareRequest {
 func (m *Message) GetExtendedRequest() ldap.ExtendedRequest {
 	return m.ProtocolOp().(ldap.ExtendedRequest)
 }
+
+// isWriteRequest reports whether po is an operation modifying the directory
+func isWriteRequest(po ldap.ProtocolOp) bool {
+	switch r := po.(type) {
+	case ldap.AddRequest, ldap.ModifyRequest, ldap.DelRequest, ldap.ModifyDNRequest:
+		return true
+	case ldap.ExtendedRequest:
+		return r.RequestName() == NoticeOfPasswordModify
+	}
+	return false
+}
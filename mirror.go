@@ -0,0 +1,241 @@
+package ldapserver
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// Mirror is a Handler serving requests with Primary, and duplicating the
+// read operations (searches and compares) to Shadow, for instance a new
+// backend being validated against a proxied legacy directory. The Shadow
+// responses are discarded, only their divergences from the Primary ones
+// are reported.
+type Mirror struct {
+	Primary Handler
+	Shadow  Handler
+
+	// MaxInFlight, if non-zero, is the number of mirrored requests the
+	// Shadow handles at the same time, requests above it are not mirrored
+	MaxInFlight int
+
+	// Timeout, if non-zero, is the time given to the Shadow before its
+	// request is abandoned
+	Timeout time.Duration
+
+	// OnDivergence, if non-nil, is called when the responses differ, with
+	// a description of the first difference. Otherwise divergences are
+	// logged by the server at the warn level.
+	OnDivergence func(m *Message, primary *ResponseRecorder, shadow *ResponseRecorder, diff string)
+
+	slotsOnce sync.Once
+	slots     chan struct{}
+	stats     MirrorStats
+}
+
+// MirrorStats counts the requests mirrored by a Mirror
+type MirrorStats struct {
+	Mirrored  int64 `json:"mirrored"`  // requests served by the Shadow
+	Divergent int64 `json:"divergent"` // requests answered differently
+	Dropped   int64 `json:"dropped"`   // requests not mirrored, MaxInFlight was reached
+}
+
+// Stats returns a snapshot of the mirroring counters
+func (mr *Mirror) Stats() MirrorStats {
+	return MirrorStats{
+		Mirrored:  atomic.LoadInt64(&mr.stats.Mirrored),
+		Divergent: atomic.LoadInt64(&mr.stats.Divergent),
+		Dropped:   atomic.LoadInt64(&mr.stats.Dropped),
+	}
+}
+
+// SupportedControls returns the request controls honored by the Primary
+// handler, which serves the write requests
+func (mr *Mirror) SupportedControls() []ldap.LDAPOID {
+	if lister, ok := mr.Primary.(controlLister); ok {
+		return lister.SupportedControls()
+	}
+	return nil
+}
+
+// ServeLDAP serves m with the Primary handler, and mirrors it to the Shadow
+// when it is a read operation
+func (mr *Mirror) ServeLDAP(w ResponseWriter, m *Message) {
+	switch m.ProtocolOp().(type) {
+	case ldap.SearchRequest, ldap.CompareRequest:
+	default:
+		mr.Primary.ServeLDAP(w, m)
+		return
+	}
+
+	if !mr.acquire() {
+		atomic.AddInt64(&mr.stats.Dropped, 1)
+		mr.Primary.ServeLDAP(w, m)
+		return
+	}
+
+	primary := NewResponseRecorder()
+	primary.MessageID = m.MessageID().Int()
+	done := make(chan struct{})
+	go mr.mirror(m, primary, done)
+
+	mr.Primary.ServeLDAP(&teeResponseWriter{w: w, rec: primary}, m)
+	close(done)
+}
+
+func (mr *Mirror) acquire() bool {
+	if mr.MaxInFlight <= 0 {
+		return true
+	}
+	mr.slotsOnce.Do(func() {
+		mr.slots = make(chan struct{}, mr.MaxInFlight)
+	})
+	select {
+	case mr.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (mr *Mirror) release() {
+	if mr.MaxInFlight > 0 {
+		<-mr.slots
+	}
+}
+
+// mirror serves a copy of m with the Shadow, then compares its responses
+// to the Primary ones once primaryDone is closed
+func (mr *Mirror) mirror(m *Message, primary *ResponseRecorder, primaryDone chan struct{}) {
+	defer mr.release()
+
+	shadowMessage := &Message{
+		LDAPMessage: m.LDAPMessage,
+		Client:      m.Client,
+		Done:        make(chan bool, 2),
+	}
+	shadow := NewResponseRecorder()
+	shadow.MessageID = primary.MessageID
+
+	if mr.Timeout != 0 {
+		timer := time.AfterFunc(mr.Timeout, shadowMessage.Abandon)
+		defer timer.Stop()
+	}
+	mr.Shadow.ServeLDAP(shadow, shadowMessage)
+	atomic.AddInt64(&mr.stats.Mirrored, 1)
+
+	<-primaryDone
+	diff := diffResponses(primary, shadow)
+	if diff == "" {
+		return
+	}
+	atomic.AddInt64(&mr.stats.Divergent, 1)
+	if mr.OnDivergence != nil {
+		mr.OnDivergence(m, primary, shadow, diff)
+	} else {
+		m.logAt(LogLevelWarn, "mirrored %s diverges: %s", m.ProtocolOpName(), diff)
+	}
+}
+
+// diffResponses describes the first difference between the responses
+// recorded, or returns an empty string when they match. Entries are
+// compared regardless of their order, and of the order of their values.
+func diffResponses(primary *ResponseRecorder, shadow *ResponseRecorder) string {
+	if p, s := primary.ResultCode(), shadow.ResultCode(); p != s {
+		return fmt.Sprintf("result code %d, shadow %d", p, s)
+	}
+
+	pEntries, sEntries := primary.Entries(), shadow.Entries()
+	if len(pEntries) != len(sEntries) {
+		return fmt.Sprintf("%d entries, shadow %d", len(pEntries), len(sEntries))
+	}
+	shadowEntries := make(map[string]Entry, len(sEntries))
+	for _, e := range sEntries {
+		shadowEntries[NormalizeDN(e.DN)] = e
+	}
+	for _, e := range pEntries {
+		other, ok := shadowEntries[NormalizeDN(e.DN)]
+		if !ok {
+			return fmt.Sprintf("entry %s missing from shadow", e.DN)
+		}
+		if diff := diffEntry(e, other); diff != "" {
+			return fmt.Sprintf("entry %s: %s", e.DN, diff)
+		}
+	}
+	return ""
+}
+
+func diffEntry(primary Entry, shadow Entry) string {
+	values := func(e Entry) map[string][][]byte {
+		attributes := make(map[string][][]byte, len(e.Attributes))
+		for _, a := range e.Attributes {
+			name := strings.ToLower(a.Name)
+			attributes[name] = append(attributes[name], a.Values...)
+		}
+		for _, vals := range attributes {
+			sort.Slice(vals, func(i, j int) bool { return bytes.Compare(vals[i], vals[j]) < 0 })
+		}
+		return attributes
+	}
+
+	p, s := values(primary), values(shadow)
+	if len(p) != len(s) {
+		return fmt.Sprintf("%d attributes, shadow %d", len(p), len(s))
+	}
+	for name, pVals := range p {
+		sVals, ok := s[name]
+		if !ok {
+			return fmt.Sprintf("attribute %s missing from shadow", name)
+		}
+		if len(pVals) != len(sVals) {
+			return fmt.Sprintf("attribute %s has %d values, shadow %d", name, len(pVals), len(sVals))
+		}
+		for i := range pVals {
+			if !bytes.Equal(pVals[i], sVals[i]) {
+				return fmt.Sprintf("attribute %s values differ", name)
+			}
+		}
+	}
+	return ""
+}
+
+// teeResponseWriter writes the responses to w, and records them in rec
+type teeResponseWriter struct {
+	w   ResponseWriter
+	rec *ResponseRecorder
+}
+
+func (t *teeResponseWriter) Write(po ldap.ProtocolOp) {
+	t.WriteMessage(ldap.NewLDAPMessageWithProtocolOp(po))
+}
+
+func (t *teeResponseWriter) WriteMessage(m *ldap.LDAPMessage) {
+	// recorded first, the message is not touched once queued to w
+	t.rec.WriteMessage(m)
+	t.w.WriteMessage(m)
+}
+
+func (t *teeResponseWriter) WriteRaw(protocolOp []byte) {
+	t.rec.WriteRaw(protocolOp)
+	WriteRaw(t.w, protocolOp)
+}
+
+func (t *teeResponseWriter) WriteRawWithControls(protocolOp []byte, controls [][]byte) {
+	t.rec.WriteRawWithControls(protocolOp, controls)
+	WriteRawWithControls(t.w, protocolOp, controls)
+}
+
+func (t *teeResponseWriter) WriteEntries(entries []Entry) error {
+	t.rec.WriteEntries(entries)
+	return WriteEntries(t.w, entries)
+}
+
+func (t *teeResponseWriter) responded() bool {
+	return t.rec.responded()
+}
@@ -0,0 +1,117 @@
+package ldapserver
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testDirectory returns a handler answering searches with entries and
+// deletes with success, counting the requests served
+func testDirectory(served *int64, entries ...Entry) *RouteMux {
+	routes := NewRouteMux()
+	routes.Search(func(w ResponseWriter, m *Message) {
+		atomic.AddInt64(served, 1)
+		WriteEntries(w, entries)
+		w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+	})
+	routes.Delete(func(w ResponseWriter, m *Message) {
+		atomic.AddInt64(served, 1)
+		w.Write(NewDeleteResponse(LDAPResultSuccess))
+	})
+	return routes
+}
+
+func TestDiffResponses(t *testing.T) {
+	alice := *NewEntry("uid=alice,dc=example").Add("mail", "a@example.com", "alice@example.com").Add("cn", "alice")
+	tests := []struct {
+		name   string
+		shadow []Entry
+		code   int
+		diff   string
+	}{
+		{"same", []Entry{*NewEntry("UID=Alice,dc=example").Add("CN", "alice").Add("mail", "alice@example.com", "a@example.com")}, LDAPResultSuccess, ""},
+		{"result code", []Entry{alice}, LDAPResultBusy, "result code 0, shadow 51"},
+		{"entries", nil, LDAPResultSuccess, "1 entries, shadow 0"},
+		{"entry", []Entry{*NewEntry("uid=bob,dc=example")}, LDAPResultSuccess, "entry uid=alice,dc=example missing from shadow"},
+		{"attribute", []Entry{*NewEntry("uid=alice,dc=example").Add("mail", "a@example.com", "alice@example.com").Add("sn", "alice")}, LDAPResultSuccess, "entry uid=alice,dc=example: attribute cn missing from shadow"},
+		{"values", []Entry{*NewEntry("uid=alice,dc=example").Add("mail", "a@example.com").Add("cn", "alice")}, LDAPResultSuccess, "entry uid=alice,dc=example: attribute mail has 2 values, shadow 1"},
+		{"value", []Entry{*NewEntry("uid=alice,dc=example").Add("mail", "a@example.com", "b@example.com").Add("cn", "alice")}, LDAPResultSuccess, "entry uid=alice,dc=example: attribute mail values differ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary, shadow := NewResponseRecorder(), NewResponseRecorder()
+			WriteEntries(primary, []Entry{alice})
+			primary.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+			WriteEntries(shadow, tt.shadow)
+			shadow.Write(NewSearchResultDoneResponse(tt.code))
+			if diff := diffResponses(primary, shadow); diff != tt.diff {
+				t.Errorf("diff %q, want %q", diff, tt.diff)
+			}
+		})
+	}
+}
+
+func TestMirror(t *testing.T) {
+	var primaryServed, shadowServed int64
+	diffs := make(chan string, 1)
+	mr := &Mirror{
+		Primary: testDirectory(&primaryServed, *NewEntry("uid=alice,dc=example").Add("cn", "alice")),
+		Shadow:  testDirectory(&shadowServed, *NewEntry("uid=alice,dc=example").Add("cn", "Alice")),
+		OnDivergence: func(m *Message, primary *ResponseRecorder, shadow *ResponseRecorder, diff string) {
+			diffs <- diff
+		},
+	}
+
+	w := NewResponseRecorder()
+	mr.ServeLDAP(w, testMessage(t, testSearchRequest("dc=example", 0, 0)))
+	if entries := w.Entries(); len(entries) != 1 || string(entries[0].Attributes[0].Values[0]) != "alice" {
+		t.Errorf("client answered with %v, want the primary entry", entries)
+	}
+	select {
+	case diff := <-diffs:
+		if diff != "entry uid=alice,dc=example: attribute cn values differ" {
+			t.Errorf("divergence %q", diff)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("divergence not reported")
+	}
+	if stats := mr.Stats(); stats.Mirrored != 1 || stats.Divergent != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+
+	// writes are served by the primary only
+	w = NewResponseRecorder()
+	mr.ServeLDAP(w, testMessage(t, berOctetString(berClassApplication|ApplicationDelRequest, []byte("uid=alice,dc=example"))))
+	if code := w.ResultCode(); code != LDAPResultSuccess {
+		t.Errorf("delete result code %d", code)
+	}
+	if n := atomic.LoadInt64(&primaryServed); n != 2 {
+		t.Errorf("primary served %d requests, want 2", n)
+	}
+	if n := atomic.LoadInt64(&shadowServed); n != 1 {
+		t.Errorf("shadow served %d requests, want 1", n)
+	}
+}
+
+func TestMirrorMaxInFlight(t *testing.T) {
+	release := make(chan struct{})
+	shadow := NewRouteMux()
+	shadow.Search(func(w ResponseWriter, m *Message) {
+		<-release
+		w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+	})
+	var served int64
+	mr := &Mirror{Primary: testDirectory(&served), Shadow: shadow, MaxInFlight: 1}
+
+	mr.ServeLDAP(NewResponseRecorder(), testMessage(t, testSearchRequest("dc=example", 0, 0)))
+	// the shadow is still busy with the first search
+	mr.ServeLDAP(NewResponseRecorder(), testMessage(t, testSearchRequest("dc=example", 0, 0)))
+	if stats := mr.Stats(); stats.Dropped != 1 {
+		t.Errorf("stats %+v, want 1 request dropped", stats)
+	}
+	if n := atomic.LoadInt64(&served); n != 2 {
+		t.Errorf("primary served %d requests, want 2", n)
+	}
+	close(release)
+}
@@ -0,0 +1,74 @@
+package ldapserver
+
+// MonitorDN is the DN of the entry exposing the server activity
+const MonitorDN = "cn=monitor"
+
+// MonitorBackendOptions configures the cn=monitor backend
+type MonitorBackendOptions struct {
+	// Authorize reports whether the client sending m may read the server
+	// activity. All requests are refused when nil.
+	Authorize func(m *Message) bool
+}
+
+// MonitorBackend routes searches of the cn=monitor entry, which exposes
+// the server Stats counters
+func (h *RouteMux) MonitorBackend(opts MonitorBackendOptions) {
+	h.Search(func(w ResponseWriter, m *Message) {
+		handleMonitorSearch(w, m, opts)
+	}).BaseDn(MonitorDN).Label("Monitor - Search")
+}
+
+func handleMonitorSearch(w ResponseWriter, m *Message, opts MonitorBackendOptions) {
+	if opts.Authorize == nil || !opts.Authorize(m) {
+		res := NewSearchResultDoneResponse(LDAPResultInsufficientAccessRights)
+		res.SetDiagnosticMessage("access to the server activity is not allowed")
+		w.Write(res)
+		return
+	}
+
+	if m.Client == nil {
+		w.Write(NewSearchResultDoneResponse(LDAPResultOperationsError))
+		return
+	}
+
+	r := m.GetSearchRequest()
+	if int(r.Scope()) != SearchRequestSingleLevel {
+		e := monitorEntry(m.Client.srv.Stats())
+		if matchFilter(e, r.Filter()) {
+			if err := WriteEntries(w, []Entry{SelectAttributes(*e, r)}); err != nil {
+				return
+			}
+		}
+	}
+
+	w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+}
+
+// monitorEntry returns the cn=monitor entry with the counters of stats
+func monitorEntry(stats Stats) *Entry {
+	e := NewEntry(MonitorDN).Add("objectClass", "top", "monitorServer").Add("cn", "monitor")
+	if !stats.Started.IsZero() {
+		e.AddTime("monitorStartTime", stats.Started)
+	}
+	counters := []struct {
+		name  string
+		value int64
+	}{
+		{"monitorCurrentConnections", int64(stats.Connections)},
+		{"monitorTotalConnections", int64(stats.TotalConnections)},
+		{"monitorRefusedConnections", stats.RefusedConnections},
+		{"monitorIdleReaped", stats.IdleReaped},
+		{"monitorDecodeMalformed", stats.DecodeFailures.Malformed},
+		{"monitorDecodeBadTag", stats.DecodeFailures.BadTag},
+		{"monitorDecodeOversizeLength", stats.DecodeFailures.OversizeLength},
+		{"monitorDecodeTruncated", stats.DecodeFailures.Truncated},
+		{"monitorDecodeUnsupportedVersion", stats.DecodeFailures.UnsupportedVersion},
+		{"monitorMessageIDReuses", stats.MessageIDReuses},
+		{"monitorBusyResponses", stats.BusyResponses},
+		{"monitorDroppedResponses", stats.DroppedResponses},
+	}
+	for _, c := range counters {
+		e.AddInt(c.name, c.value)
+	}
+	return e
+}
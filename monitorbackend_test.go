@@ -0,0 +1,56 @@
+package ldapserver
+
+import "testing"
+
+func TestMonitorBackendSearch(t *testing.T) {
+	s := NewServer()
+	routes := NewRouteMux()
+	routes.MonitorBackend(MonitorBackendOptions{Authorize: func(m *Message) bool { return true }})
+	s.Handle(routes)
+	addr := serveTest(t, s)
+	defer s.Stop()
+	c := dialTest(t, addr)
+	defer c.Close()
+
+	tests := []struct {
+		filter     string
+		attributes []string
+		typesOnly  bool
+		found      bool
+	}{
+		{"(objectClass=monitorServer)", nil, false, true},
+		{"(objectClass=foo)", nil, false, false},
+		{"(monitorCurrentConnections>=1)", []string{"monitorCurrentConnections"}, false, true},
+		{"(cn=monitor)", []string{"monitorIdleReaped", "monitorBusyResponses"}, true, true},
+	}
+	for _, tt := range tests {
+		entries, err := c.Search(SearchParams{BaseDN: MonitorDN, Filter: tt.filter, Attributes: tt.attributes, TypesOnly: tt.typesOnly})
+		if err != nil {
+			t.Fatalf("search %s: %s", tt.filter, err)
+		}
+		if found := len(entries) == 1; found != tt.found {
+			t.Fatalf("search %s found %d entries", tt.filter, len(entries))
+		}
+		if !tt.found || tt.attributes == nil {
+			continue
+		}
+		if len(entries[0].Attributes) != len(tt.attributes) {
+			t.Errorf("search %s returned %d attributes, want %d", tt.filter, len(entries[0].Attributes), len(tt.attributes))
+		}
+		for _, a := range entries[0].Attributes {
+			if tt.typesOnly != (len(a.Values) == 0) {
+				t.Errorf("search %s returned %d values of %s", tt.filter, len(a.Values), a.Name)
+			}
+		}
+	}
+}
+
+func TestMonitorBackendWithoutClient(t *testing.T) {
+	routes := NewRouteMux()
+	routes.MonitorBackend(MonitorBackendOptions{Authorize: func(m *Message) bool { return true }})
+	w := NewResponseRecorder()
+	routes.ServeLDAP(w, testMessage(t, testSearchRequest(MonitorDN, 0, 0)))
+	if code := w.ResultCode(); code != LDAPResultOperationsError {
+		t.Errorf("result code %d, want operationsError", code)
+	}
+}
@@ -0,0 +1,348 @@
+package ldapserver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// NamingContext is a directory tree, identified by its suffix, served by
+// its own Handler
+type NamingContext struct {
+	Suffix  string
+	Handler Handler
+
+	// Limits, if non-nil, replace the server request complexity limits
+	// (MaxFilterDepth, MaxFilterTerms, MaxRequestedAttributes and
+	// MaxModifyChanges) for the requests of this context
+	Limits *Limits
+
+	// Authorize, if non-nil, reports whether the client sending m may
+	// access this context, refused requests get insufficientAccessRights
+	Authorize func(m *Message) bool
+
+	// ServerNames, if set, restrict the context to the clients which sent
+	// one of these names with TLS SNI, for virtual hosted tenants
+	ServerNames []string
+
+	// Quota, if non-nil, limits the number of entries of the context
+	Quota *Quota
+
+	suffix   DN
+	quotaMu  sync.Mutex     // serializes the writes adding entries, see Quota
+	counters tenantCounters // see ContextMux.Stats
+}
+
+// ContextMux serves several independent naming contexts on one server,
+// for instance one per tenant: each request is passed to the Handler of
+// the context holding the DN it targets. The RootDSE lists the mounted
+// contexts in namingContexts.
+type ContextMux struct {
+	mu       sync.RWMutex
+	contexts []*NamingContext // longest suffixes first
+
+	// Default, if non-nil, serves the requests targeting no naming context,
+	// such as anonymous binds or extended operations. Otherwise they are
+	// answered with noSuchObject, or invalidCredentials for binds.
+	Default Handler
+
+	// RootDSE, if non-nil, may add attributes to the RootDSE entry
+	RootDSE func(m *Message, e *ldap.SearchResultEntry)
+}
+
+// NewContextMux returns a new *ContextMux
+// ContextMux implements ldapserver.Handler
+func NewContextMux() *ContextMux {
+	return &ContextMux{}
+}
+
+// Mount adds the naming context nc, its suffix must be a valid DN which is
+// not already mounted
+func (mux *ContextMux) Mount(nc *NamingContext) error {
+	suffix, err := ParseDN(nc.Suffix)
+	if err != nil {
+		return err
+	}
+	if len(suffix) == 0 {
+		return fmt.Errorf("naming context suffix can not be empty")
+	}
+	nc.suffix = suffix
+	if nc.Quota != nil && nc.counter() == nil {
+		return fmt.Errorf("naming context %s has a quota but no EntryCounter", nc.Suffix)
+	}
+
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	for _, other := range mux.contexts {
+		if other.suffix.Equal(suffix) {
+			return fmt.Errorf("naming context %s is already mounted", nc.Suffix)
+		}
+	}
+	mux.contexts = append(mux.contexts, nc)
+	sort.SliceStable(mux.contexts, func(i, j int) bool {
+		return len(mux.contexts[i].suffix) > len(mux.contexts[j].suffix)
+	})
+	return nil
+}
+
+// Unmount removes the naming context with the given suffix, and reports
+// whether it was mounted
+func (mux *ContextMux) Unmount(suffix string) bool {
+	dn, err := ParseDN(suffix)
+	if err != nil {
+		return false
+	}
+
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	for i, nc := range mux.contexts {
+		if nc.suffix.Equal(dn) {
+			mux.contexts = append(mux.contexts[:i], mux.contexts[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// NamingContexts returns the suffixes of the mounted naming contexts
+func (mux *ContextMux) NamingContexts() []string {
+	return mux.namingContexts(func(*NamingContext) bool { return true })
+}
+
+func (mux *ContextMux) namingContexts(include func(nc *NamingContext) bool) []string {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+	var suffixes []string
+	for _, nc := range mux.contexts {
+		if include(nc) {
+			suffixes = append(suffixes, nc.Suffix)
+		}
+	}
+	return suffixes
+}
+
+// visible reports whether the context serves clients which sent the SNI
+// name serverName
+func (nc *NamingContext) visible(serverName string) bool {
+	if len(nc.ServerNames) == 0 {
+		return true
+	}
+	for _, name := range nc.ServerNames {
+		if strings.EqualFold(name, serverName) {
+			return true
+		}
+	}
+	return false
+}
+
+// context returns the naming context holding dn, visible to clients which
+// sent the SNI name serverName
+func (mux *ContextMux) context(dn DN, serverName string) *NamingContext {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+	for _, nc := range mux.contexts {
+		if dn.IsDescendantOf(nc.suffix, true) && nc.visible(serverName) {
+			return nc
+		}
+	}
+	return nil
+}
+
+// ServeLDAP passes the request to the Handler of the naming context it
+// targets. A ModifyDN moving an entry to another naming context is
+// answered with affectsMultipleDSAs.
+func (mux *ContextMux) ServeLDAP(w ResponseWriter, m *Message) {
+	po := m.ProtocolOp()
+	if isRootDSESearch(po) {
+		mux.serveRootDSE(w, m)
+		return
+	}
+
+	var nc *NamingContext
+	if dns := requestDNs(po); len(dns) > 0 && dns[0] != "" {
+		dn, err := ParseDN(dns[0])
+		if err != nil {
+			w.Write(NewResponseForRequest(po, LDAPResultInvalidDNSyntax, err.Error()))
+			return
+		}
+		nc = mux.context(dn, m.Client.ServerName())
+	}
+
+	// the handler of one naming context can not move an entry to another
+	if r, ok := po.(ldap.ModifyDNRequest); ok && r.NewSuperior() != nil {
+		superior, err := ParseDN(string(*r.NewSuperior()))
+		if err != nil {
+			w.Write(NewResponseForRequest(po, LDAPResultInvalidDNSyntax, err.Error()))
+			return
+		}
+		if mux.context(superior, m.Client.ServerName()) != nc {
+			w.Write(NewResponseForRequest(po, LDAPResultAffectsMultipleDSAs, "the new superior is in another naming context"))
+			return
+		}
+	}
+
+	if nc == nil {
+		mux.serveDefault(w, m)
+		return
+	}
+
+	nc.serveCounted(w, m, func(w ResponseWriter, m *Message) { mux.serveContext(nc, w, m) })
+}
+
+// serveContext passes m to the handler of the naming context nc, once
+// authorized and within its limits
+func (mux *ContextMux) serveContext(nc *NamingContext, w ResponseWriter, m *Message) {
+	po := m.ProtocolOp()
+	if nc.Authorize != nil && !nc.Authorize(m) {
+		w.Write(NewResponseForRequest(po, LDAPResultInsufficientAccessRights,
+			fmt.Sprintf("access to %s is not allowed", nc.Suffix)))
+		return
+	}
+	if res := checkAdminControls(nc.Handler, m); res != nil {
+		w.Write(res)
+		return
+	}
+	if nc.Limits != nil {
+		if res := checkComplexity(po, *nc.Limits); res != nil {
+			w.Write(res)
+			return
+		}
+	}
+	if nc.Quota != nil {
+		nc.serveWithQuota(w, m)
+		return
+	}
+	nc.Handler.ServeLDAP(w, m)
+}
+
+func (mux *ContextMux) serveDefault(w ResponseWriter, m *Message) {
+	if mux.Default != nil {
+		if res := checkAdminControls(mux.Default, m); res != nil {
+			w.Write(res)
+			return
+		}
+		mux.Default.ServeLDAP(w, m)
+		return
+	}
+
+	switch v := m.ProtocolOp().(type) {
+	case ldap.AbandonRequest:
+		HandleAbandon(w, m)
+	case ldap.BindRequest:
+		w.Write(NewResponseForRequest(v, LDAPResultInvalidCredentials, ""))
+	case ldap.ExtendedRequest:
+		if canStartTLS(m) {
+			HandleStartTLS(w, m)
+			return
+		}
+		if v.RequestName() == NoticeOfWhoAmI {
+			HandleWhoAmI(w, m)
+			return
+		}
+		res := NewExtendedResponse(LDAPResultProtocolError)
+		res.SetDiagnosticMessage(unsupportedExtensionMessage(v.RequestName(), mux.SupportedExtensions()))
+		w.Write(res)
+	default:
+		if res := NewResponseForRequest(v, LDAPResultNoSuchObject, "no naming context holds this entry"); res != nil {
+			w.Write(res)
+		}
+	}
+}
+
+// extensionLister is implemented by the handlers reporting the extended
+// operations they serve, such as RouteMux
+type extensionLister interface {
+	SupportedExtensions() []ldap.LDAPOID
+}
+
+// SupportedExtensions returns the requestNames of the extended operations
+// served by the Default handler and the naming contexts handlers which
+// report them
+func (mux *ContextMux) SupportedExtensions() []ldap.LDAPOID {
+	handlers := []Handler{mux.Default}
+	mux.mu.RLock()
+	for _, nc := range mux.contexts {
+		handlers = append(handlers, nc.Handler)
+	}
+	mux.mu.RUnlock()
+
+	var oids []ldap.LDAPOID
+	seen := make(map[ldap.LDAPOID]bool)
+	for _, h := range handlers {
+		lister, ok := h.(extensionLister)
+		if !ok {
+			continue
+		}
+		for _, oid := range lister.SupportedExtensions() {
+			if !seen[oid] {
+				seen[oid] = true
+				oids = append(oids, oid)
+			}
+		}
+	}
+	return oids
+}
+
+// SupportedControls returns the request controls honored by the Default
+// handler or the naming contexts handlers which report them, they are
+// checked again against the handler of the context a request targets
+func (mux *ContextMux) SupportedControls() []ldap.LDAPOID {
+	handlers := []Handler{mux.Default}
+	mux.mu.RLock()
+	for _, nc := range mux.contexts {
+		handlers = append(handlers, nc.Handler)
+	}
+	mux.mu.RUnlock()
+
+	var oids []ldap.LDAPOID
+	seen := make(map[ldap.LDAPOID]bool)
+	for _, h := range handlers {
+		lister, ok := h.(controlLister)
+		if !ok {
+			continue
+		}
+		for _, oid := range lister.SupportedControls() {
+			if !seen[oid] {
+				seen[oid] = true
+				oids = append(oids, oid)
+			}
+		}
+	}
+	return oids
+}
+
+// isRootDSESearch reports whether po is a base search of the RootDSE
+func isRootDSESearch(po ldap.ProtocolOp) bool {
+	r, ok := po.(ldap.SearchRequest)
+	return ok && r.BaseObject() == "" && int(r.Scope()) == SearchRequestScopeBaseObject
+}
+
+func (mux *ContextMux) serveRootDSE(w ResponseWriter, m *Message) {
+	e := NewSearchResultEntry("")
+	e.AddAttribute("objectClass", "top")
+	e.AddAttribute("supportedLDAPVersion", "3")
+	serverName := m.Client.ServerName()
+	suffixes := mux.namingContexts(func(nc *NamingContext) bool { return nc.visible(serverName) })
+	if len(suffixes) > 0 {
+		values := make([]ldap.AttributeValue, len(suffixes))
+		for i, suffix := range suffixes {
+			values[i] = ldap.AttributeValue(suffix)
+		}
+		e.AddAttribute("namingContexts", values...)
+	}
+	if oids := mux.SupportedExtensions(); len(oids) > 0 {
+		values := make([]ldap.AttributeValue, len(oids))
+		for i, oid := range oids {
+			values[i] = ldap.AttributeValue(oid)
+		}
+		e.AddAttribute("supportedExtension", values...)
+	}
+	if mux.RootDSE != nil {
+		mux.RootDSE(m, &e)
+	}
+	w.Write(e)
+	w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+}
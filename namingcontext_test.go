@@ -0,0 +1,54 @@
+package ldapserver
+
+import "testing"
+
+// successHandler answers every request with success
+type successHandler struct{}
+
+func (successHandler) ServeLDAP(w ResponseWriter, m *Message) {
+	w.Write(NewResponseForRequest(m.ProtocolOp(), LDAPResultSuccess, ""))
+}
+
+func TestContextMuxModifyDN(t *testing.T) {
+	mux := NewContextMux()
+	mux.Default = successHandler{}
+	for _, suffix := range []string{"dc=example,dc=com", "dc=example,dc=org"} {
+		if err := mux.Mount(&NamingContext{Suffix: suffix, Handler: successHandler{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	modifyDN := func(entry string, newSuperior ...string) []byte {
+		elements := [][]byte{
+			berOctetString(berTagOctetString, []byte(entry)),
+			berOctetString(berTagOctetString, []byte("cn=new")),
+			berBoolean(berTagBoolean, true),
+		}
+		for _, s := range newSuperior {
+			elements = append(elements, berOctetString(berClassContext|0, []byte(s)))
+		}
+		return berConstructedTLV(berClassApplication|berConstructed|ApplicationModifyDNRequest, elements...)
+	}
+
+	tests := []struct {
+		name    string
+		request []byte
+		code    int
+	}{
+		{"rename", modifyDN("cn=a,dc=example,dc=com"), LDAPResultSuccess},
+		{"same context", modifyDN("cn=a,ou=x,dc=example,dc=com", "ou=y,dc=example,dc=com"), LDAPResultSuccess},
+		{"other context", modifyDN("cn=a,dc=example,dc=com", "dc=example,dc=org"), LDAPResultAffectsMultipleDSAs},
+		{"out of the contexts", modifyDN("cn=a,dc=example,dc=com", "dc=example,dc=net"), LDAPResultAffectsMultipleDSAs},
+		{"into a context", modifyDN("cn=a,dc=example,dc=net", "dc=example,dc=com"), LDAPResultAffectsMultipleDSAs},
+		{"between default entries", modifyDN("cn=a,dc=example,dc=net", "ou=x,dc=example,dc=net"), LDAPResultSuccess},
+		{"invalid new superior", modifyDN("cn=a,dc=example,dc=com", "dc"), LDAPResultInvalidDNSyntax},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := NewResponseRecorder()
+			mux.ServeLDAP(rec, testMessage(t, tt.request))
+			if code := rec.ResultCode(); code != tt.code {
+				t.Errorf("result code %d, want %d", code, tt.code)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package ldapserver
 import (
 	"bufio"
 	"fmt"
+	"io"
 
 	ldap "github.com/ps78674/goldap/message"
 )
@@ -11,10 +12,10 @@ type messagePacket struct {
 	bytes []byte
 }
 
-func readMessagePacket(br *bufio.Reader) (*messagePacket, error) {
+func readMessagePacket(br *bufio.Reader, maxSize int) (*messagePacket, error) {
 	var err error
 	var bytes *[]byte
-	bytes, err = readLdapMessageBytes(br)
+	bytes, err = readLdapMessageBytes(br, maxSize)
 
 	if err == nil {
 		messagePacket := &messagePacket{bytes: *bytes}
@@ -27,11 +28,58 @@ func readMessagePacket(br *bufio.Reader) (*messagePacket, error) {
 func (msg *messagePacket) readMessage() (m ldap.LDAPMessage, err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = fmt.Errorf("invalid packet received hex=%x, %#v", msg.bytes, r)
+			// the packet itself is not reported, it may be large or hold
+			// credentials
+			err = &DecodeError{Offset: decodeErrorOffset(msg.bytes), Err: fmt.Errorf("invalid packet of %d bytes received: %v", len(msg.bytes), r)}
 		}
 	}()
 
-	return decodeMessage(msg.bytes)
+	m, err = decodeMessage(msg.bytes)
+	if err != nil {
+		err = &DecodeError{Offset: decodeErrorOffset(msg.bytes), Err: err}
+	}
+	return
+}
+
+// decodeErrorOffset returns the offset in the LDAPMessage packet where its
+// decoding failed: that of the first element which is not valid BER, or
+// when the BER encoding is valid, of the messageID when it is not an
+// INTEGER, and of the protocol operation otherwise
+func decodeErrorOffset(packet []byte) int {
+	if offset := berInvalidOffset(packet, 0); offset >= 0 {
+		return offset
+	}
+	message, rest, _ := berRead(packet)
+	offset := len(packet) - len(rest) - len(message.data)
+	messageID, after, err := berRead(message.data)
+	if err != nil || messageID.tag != berTagInteger {
+		return offset
+	}
+	if _, err := berParseInteger(messageID.data); err != nil {
+		return offset
+	}
+	return offset + len(message.data) - len(after)
+}
+
+// berInvalidOffset returns the offset of the first element of data which
+// is not valid BER, descending into the constructed elements, offset being
+// that of data, or -1 when all of them are valid
+func berInvalidOffset(data []byte, offset int) int {
+	for len(data) > 0 {
+		e, rest, err := berRead(data)
+		if err != nil {
+			return offset
+		}
+		if e.constructed() {
+			header := len(data) - len(rest) - len(e.data)
+			if invalid := berInvalidOffset(e.data, offset+header); invalid >= 0 {
+				return invalid
+			}
+		}
+		offset += len(data) - len(rest)
+		data = rest
+	}
+	return -1
 }
 
 func decodeMessage(bytes []byte) (ret ldap.LDAPMessage, err error) {
@@ -47,22 +95,64 @@ func decodeMessage(bytes []byte) (ret ldap.LDAPMessage, err error) {
 
 // BELLOW SHOULD BE IN ROOX PACKAGE
 
-func readLdapMessageBytes(br *bufio.Reader) (ret *[]byte, err error) {
+// readLdapMessageBytes reads an LDAPMessage, refused with a DecodeError
+// before its content is read when it is longer than maxSize bytes, if
+// non-zero
+func readLdapMessageBytes(br *bufio.Reader, maxSize int) (ret *[]byte, err error) {
 	var bytes []byte
 	var tagAndLength ldap.TagAndLength
-	tagAndLength, err = readTagAndLength(br, &bytes)
+	tagAndLength, err = readTagAndLength(br, &bytes, maxSize)
 	if err != nil {
-		return
+		return nil, decodeError(len(bytes), err)
+	}
+	_, err = readBytes(br, &bytes, tagAndLength.Length)
+	if err != nil {
+		return nil, decodeError(len(bytes), err)
 	}
-	readBytes(br, &bytes, tagAndLength.Length)
 	return &bytes, err
 }
 
+// decodeError wraps the goldap syntax errors into a DecodeError, network
+// errors are returned as is. The end of the connection in the middle of a
+// message is reported as io.ErrUnexpectedEOF.
+func decodeError(offset int, err error) error {
+	switch err.(type) {
+	case ldap.StructuralError, ldap.SyntaxError:
+		return &DecodeError{Offset: offset, Err: err}
+	}
+	if err == io.EOF && offset > 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// bindVersion returns the protocol version of the BindRequest encoded in
+// packet, ok is false when packet is not a BindRequest
+func bindVersion(packet []byte) (version int, ok bool) {
+	message, _, err := berRead(packet)
+	if err != nil {
+		return 0, false
+	}
+	children, err := berChildren(message.data)
+	if err != nil || len(children) < 2 || children[1].tag != berClassApplication|berConstructed|ApplicationBindRequest {
+		return 0, false
+	}
+	fields, err := berChildren(children[1].data)
+	if err != nil || len(fields) == 0 || fields[0].tag != berTagInteger {
+		return 0, false
+	}
+	v, err := berParseInteger(fields[0].data)
+	if err != nil {
+		return 0, false
+	}
+	return int(v), true
+}
+
 // readTagAndLength parses an ASN.1 tag and length pair from a live connection
 // into a byte slice. It returns the parsed data and the new offset. SET and
 // SET OF (tag 17) are mapped to SEQUENCE and SEQUENCE OF (tag 16) since we
 // don't distinguish between ordered and unordered objects in this code.
-func readTagAndLength(conn *bufio.Reader, bytes *[]byte) (ret ldap.TagAndLength, err error) {
+func readTagAndLength(conn *bufio.Reader, bytes *[]byte, maxSize int) (ret ldap.TagAndLength, err error) {
 	// offset = initOffset
 	//b := bytes[offset]
 	//offset++
@@ -86,7 +176,7 @@ func readTagAndLength(conn *bufio.Reader, bytes *[]byte) (ret ldap.TagAndLength,
 	// We are expecting the LDAP sequence tag 0x30 as first byte
 	if b != 0x30 {
 		m := fmt.Sprintf("expecting 0x30 as first byte, got %#x instead", b)
-		err = ldap.StructuralError{Msg: m}
+		err = &DecodeError{Offset: 0, Reason: DecodeBadTag, Err: ldap.StructuralError{Msg: m}}
 		return
 	}
 
@@ -114,7 +204,7 @@ func readTagAndLength(conn *bufio.Reader, bytes *[]byte) (ret ldap.TagAndLength,
 			if ret.Length >= 1<<23 {
 				// We can't shift ret.length up without
 				// overflowing.
-				err = ldap.StructuralError{"length too large"}
+				err = &DecodeError{Offset: len(*bytes), Reason: DecodeOversizeLength, Err: ldap.StructuralError{"length too large"}}
 				return
 			}
 			ret.Length <<= 8
@@ -128,6 +218,10 @@ func readTagAndLength(conn *bufio.Reader, bytes *[]byte) (ret ldap.TagAndLength,
 			// }
 		}
 	}
+	if maxSize > 0 && ret.Length > maxSize {
+		m := fmt.Sprintf("message of %d bytes exceeds the limit of %d bytes", ret.Length, maxSize)
+		err = &DecodeError{Offset: 1, Reason: DecodeOversizeLength, Err: ldap.StructuralError{Msg: m}}
+	}
 
 	return
 }
@@ -143,7 +237,7 @@ func readBytes(conn *bufio.Reader, bytes *[]byte, length int) (b byte, err error
 	// } else if err != nil {
 	// 	return
 	// }
-	_, err = conn.Read(newbytes)
+	_, err = io.ReadFull(conn, newbytes)
 	if err != nil {
 		return
 	}
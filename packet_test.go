@@ -0,0 +1,132 @@
+package ldapserver
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// testMessage returns the request of message ID 1 with the BER encoded
+// protocolOp, as read from a client
+func testMessage(t *testing.T, protocolOp []byte) *Message {
+	t.Helper()
+	msg, err := decodeMessage(berSequence(berInteger(berTagInteger, 1), protocolOp))
+	if err != nil {
+		t.Fatalf("decoding the request: %s", err)
+	}
+	return &Message{LDAPMessage: &msg}
+}
+
+func TestReadLdapMessageBytes(t *testing.T) {
+	unbind := berSequence(berInteger(berTagInteger, 1), berTLV(berClassApplication|ApplicationUnbindRequest, nil))
+	large := berSequence(berInteger(berTagInteger, 2), berOctetString(berClassApplication|ApplicationDelRequest, bytes.Repeat([]byte("a"), 300)))
+	tests := []struct {
+		name   string
+		in     []byte
+		max    int // MaxMessageSize
+		want   []byte
+		err    error         // error returned, if not a DecodeError
+		reason DecodeFailure // reason of the DecodeError returned
+	}{
+		{name: "message", in: unbind, want: unbind},
+		{name: "long form length", in: large, want: large},
+		{name: "next message left", in: append(append([]byte{}, unbind...), unbind...), want: unbind},
+		{name: "end of stream", in: nil, err: io.EOF},
+		{name: "truncated", in: unbind[:len(unbind)-1], err: io.ErrUnexpectedEOF},
+		{name: "truncated length", in: []byte{0x30, 0x82, 0x01}, err: io.ErrUnexpectedEOF},
+		{name: "bad tag", in: []byte{0x16, 0x03, 0x01, 0x00}, reason: DecodeBadTag},
+		{name: "indefinite length", in: []byte{0x30, 0x80, 0x00, 0x00}, reason: DecodeMalformed},
+		{name: "oversize length", in: []byte{0x30, 0x85, 0x01, 0x00, 0x00, 0x00, 0x00}, reason: DecodeOversizeLength},
+		{name: "within MaxMessageSize", in: large, max: len(large), want: large},
+		{name: "exceeds MaxMessageSize", in: large, max: 100, reason: DecodeOversizeLength},
+		{name: "length exceeds MaxMessageSize", in: []byte{0x30, 0x84, 0x7f, 0x00, 0x00, 0x00}, max: 1 << 20, reason: DecodeOversizeLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readLdapMessageBytes(bufio.NewReader(bytes.NewReader(tt.in)), tt.max)
+			if tt.want != nil {
+				if err != nil {
+					t.Fatalf("error %v", err)
+				}
+				if !bytes.Equal(*got, tt.want) {
+					t.Errorf("read %x, want %x", *got, tt.want)
+				}
+				return
+			}
+			if tt.err != nil {
+				if err != tt.err {
+					t.Errorf("error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) {
+				t.Fatalf("error %v, want a DecodeError", err)
+			}
+			if decodeErr.Reason != tt.reason {
+				t.Errorf("reason %s, want %s", decodeErr.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestDecodeMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []byte
+		op      string
+		wantErr bool
+	}{
+		{"unbind", berSequence(berInteger(berTagInteger, 1), berTLV(berClassApplication|ApplicationUnbindRequest, nil)), "UnbindRequest", false},
+		{"delete", berSequence(berInteger(berTagInteger, 1), berOctetString(berClassApplication|ApplicationDelRequest, []byte("cn=foo"))), "DelRequest", false},
+		{"missing protocolOp", berSequence(berInteger(berTagInteger, 1)), "", true},
+		{"unknown protocolOp", berSequence(berInteger(berTagInteger, 1), berTLV(berClassApplication|30, nil)), "", true},
+		{"not a message", berOctetString(berTagOctetString, []byte("foo")), "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := decodeMessage(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("decoded %x", tt.in)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error %v", err)
+			}
+			if name := m.ProtocolOpName(); name != tt.op {
+				t.Errorf("protocolOp %s, want %s", name, tt.op)
+			}
+			if id := m.MessageID().Int(); id != 1 {
+				t.Errorf("message ID %d, want 1", id)
+			}
+		})
+	}
+}
+
+func TestDecodeErrorOffset(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want int
+	}{
+		{"truncated element", berSequence(berInteger(berTagInteger, 1), berTLV(berClassApplication|berConstructed|ApplicationAddRequest, []byte{0x04, 0x05, 'a'})), 7},
+		{"invalid protocolOp", berSequence(berInteger(berTagInteger, 1), berTLV(berClassApplication|berConstructed|ApplicationAddRequest, nil)), 5},
+		{"invalid messageID", berSequence(berOctetString(berTagOctetString, []byte("1")), berTLV(berClassApplication|ApplicationUnbindRequest, nil)), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := (&messagePacket{bytes: tt.in}).readMessage()
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) {
+				t.Fatalf("error %v, want a DecodeError", err)
+			}
+			if decodeErr.Offset != tt.want {
+				t.Errorf("offset %d, want %d", decodeErr.Offset, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package ldapserver
+
+import (
+	"encoding/binary"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// PagedResultsControl is the Simple Paged Results control (RFC 2696) of a
+// search request: the client asks for Size entries at most, starting
+// after the page identified by Cookie, empty for the first page
+type PagedResultsControl struct {
+	Size        int
+	Cookie      []byte
+	Criticality bool
+}
+
+// PagedResults returns the Simple Paged Results control of the search
+// request m, nil when it has none. The error is a *ResultError with the
+// protocolError code when the control value is malformed.
+func (m *Message) PagedResults() (*PagedResultsControl, error) {
+	if _, ok := m.ProtocolOp().(ldap.SearchRequest); !ok {
+		return nil, nil
+	}
+	c, ok := requestControl(m, ControlPagedResults)
+	if !ok {
+		return nil, nil
+	}
+	return decodePagedResults(c.Criticality(), controlValue(c))
+}
+
+// decodePagedResults decodes the value of a Simple Paged Results control
+func decodePagedResults(criticality bool, value []byte) (*PagedResultsControl, error) {
+	malformed := NewResultError(LDAPResultProtocolError, "malformed paged results control")
+	if value == nil {
+		return nil, malformed
+	}
+
+	// realSearchControlValue ::= SEQUENCE { size INTEGER, cookie OCTET STRING }
+	sequence, _, err := berRead(value)
+	if err != nil || sequence.tag != berTagSequence {
+		return nil, malformed
+	}
+	fields, err := berChildren(sequence.data)
+	if err != nil || len(fields) != 2 || fields[0].tag != berTagInteger || fields[1].tag != berTagOctetString {
+		return nil, malformed
+	}
+	size, err := berParseInteger(fields[0].data)
+	if err != nil || size < 0 || size > maxInt {
+		return nil, malformed
+	}
+	return &PagedResultsControl{
+		Size:        int(size),
+		Cookie:      fields[1].data,
+		Criticality: criticality,
+	}, nil
+}
+
+// PagedResultsResponseControl returns the BER encoded Simple Paged Results
+// response control, with the estimated result size, 0 when unknown, and
+// the cookie of the next page, empty on the last page
+func PagedResultsResponseControl(size int, cookie []byte) []byte {
+	value := berSequence(
+		berInteger(berTagInteger, int64(size)),
+		berOctetString(berTagOctetString, cookie),
+	)
+	return berSequence(
+		berOctetString(berTagOctetString, []byte(ControlPagedResults)),
+		berOctetString(berTagOctetString, value),
+	)
+}
+
+// WritePagedResultsDone ends a page of search results with a
+// SearchResultDone carrying the Simple Paged Results response control,
+// cookie identifies the next page and is empty on the last one
+func WritePagedResultsDone(w ResponseWriter, resultCode int, diagnosticMessage string, size int, cookie []byte) {
+	WriteSearchResultDone(w, resultCode, diagnosticMessage, PagedResultsResponseControl(size, cookie))
+}
+
+// WritePagedEntries answers the search request m with entries, its whole
+// result, a page at a time when m has the Simple Paged Results control.
+// The cookies hold the offset of the next page, so the backend must
+// return the entries in the same order for each page.
+func WritePagedEntries(w ResponseWriter, m *Message, entries []Entry) error {
+	paged, err := m.PagedResults()
+	if err != nil {
+		resultCode, diagnosticMessage := DefaultErrorMapper(err)
+		w.Write(NewResponseForRequest(m.ProtocolOp(), resultCode, diagnosticMessage))
+		return err
+	}
+	if paged == nil {
+		if err := WriteEntries(w, entries); err != nil {
+			return err
+		}
+		w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+		return nil
+	}
+
+	offset := 0
+	if len(paged.Cookie) > 0 {
+		if len(paged.Cookie) != 8 || binary.BigEndian.Uint64(paged.Cookie) > uint64(len(entries)) {
+			WritePagedResultsDone(w, LDAPResultUnwillingToPerform, "invalid paged results cookie", 0, nil)
+			return nil
+		}
+		offset = int(binary.BigEndian.Uint64(paged.Cookie))
+	}
+	// a zero size abandons the paged search
+	if paged.Size == 0 {
+		WritePagedResultsDone(w, LDAPResultSuccess, "", len(entries), nil)
+		return nil
+	}
+
+	end := len(entries)
+	if paged.Size < len(entries)-offset {
+		end = offset + paged.Size
+	}
+	if err := WriteEntries(w, entries[offset:end]); err != nil {
+		return err
+	}
+	var cookie []byte
+	if end < len(entries) {
+		cookie = make([]byte, 8)
+		binary.BigEndian.PutUint64(cookie, uint64(end))
+	}
+	WritePagedResultsDone(w, LDAPResultSuccess, "", len(entries), cookie)
+	return nil
+}
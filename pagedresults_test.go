@@ -0,0 +1,109 @@
+package ldapserver
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// pagedResultsControl returns a Simple Paged Results request control
+func pagedResultsControl(size int64, cookie []byte) []byte {
+	return berSequence(
+		berOctetString(berTagOctetString, []byte(ControlPagedResults)),
+		berOctetString(berTagOctetString, berSequence(berInteger(berTagInteger, size), berOctetString(berTagOctetString, cookie))),
+	)
+}
+
+func TestDecodePagedResults(t *testing.T) {
+	tests := []struct {
+		name  string
+		value []byte
+		want  *PagedResultsControl
+	}{
+		{"first page", berSequence(berInteger(berTagInteger, 10), berOctetString(berTagOctetString, nil)), &PagedResultsControl{Size: 10, Cookie: []byte{}}},
+		{"next page", berSequence(berInteger(berTagInteger, 10), berOctetString(berTagOctetString, []byte("next"))), &PagedResultsControl{Size: 10, Cookie: []byte("next")}},
+		{"no value", nil, nil},
+		{"negative size", berSequence(berInteger(berTagInteger, -1), berOctetString(berTagOctetString, nil)), nil},
+		{"no cookie", berSequence(berInteger(berTagInteger, 10)), nil},
+		{"not a sequence", berInteger(berTagInteger, 10), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			control, err := decodePagedResults(false, tt.value)
+			if tt.want == nil {
+				var resultErr *ResultError
+				if !errors.As(err, &resultErr) || resultErr.ResultCode != LDAPResultProtocolError {
+					t.Errorf("decoded %+v, %v, want protocolError", control, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(control, tt.want) {
+				t.Errorf("decoded %+v, want %+v", control, tt.want)
+			}
+		})
+	}
+}
+
+// pagedSearch serves a page of entries with WritePagedEntries, and returns
+// the DNs of the page and the paged results response control
+func pagedSearch(t *testing.T, entries []Entry, size int64, cookie []byte) (string, int, *PagedResultsControl) {
+	t.Helper()
+	msg, err := decodeMessage(encodeRawMessage(1, testSearchRequest("dc=example", 0, 0), [][]byte{pagedResultsControl(size, cookie)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewResponseRecorder()
+	if err := WritePagedEntries(w, &Message{LDAPMessage: &msg}, entries); err != nil {
+		t.Fatal(err)
+	}
+	var dns []string
+	for _, e := range w.Entries() {
+		dns = append(dns, e.DN)
+	}
+	controls := w.Controls()
+	if len(controls) != 1 || controls[0].ControlType() != ControlPagedResults {
+		t.Fatalf("response controls %v, want the paged results control", controls)
+	}
+	response, err := decodePagedResults(false, controlValue(controls[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(dns, " "), w.ResultCode(), response
+}
+
+func TestWritePagedEntries(t *testing.T) {
+	var entries []Entry
+	for _, cn := range "abcde" {
+		entries = append(entries, *NewEntry("cn=" + string(cn)))
+	}
+
+	var pages []string
+	var cookie []byte
+	for i := 0; i < 5; i++ {
+		page, code, response := pagedSearch(t, entries, 2, cookie)
+		if code != LDAPResultSuccess || response.Size != len(entries) {
+			t.Fatalf("page %d: result code %d, size %d", i, code, response.Size)
+		}
+		pages = append(pages, page)
+		if cookie = response.Cookie; len(cookie) == 0 {
+			break
+		}
+	}
+	if want := []string{"cn=a cn=b", "cn=c cn=d", "cn=e"}; !reflect.DeepEqual(pages, want) {
+		t.Errorf("pages %q, want %q", pages, want)
+	}
+
+	// a zero size abandons the paged search
+	if page, code, response := pagedSearch(t, entries, 0, nil); page != "" || code != LDAPResultSuccess || len(response.Cookie) != 0 {
+		t.Errorf("abandoned search: entries %q, result code %d, cookie %x", page, code, response.Cookie)
+	}
+	for _, cookie := range [][]byte{[]byte("next"), {0, 0, 0, 0, 0, 0, 0, 6}} {
+		if _, code, _ := pagedSearch(t, entries, 2, cookie); code != LDAPResultUnwillingToPerform {
+			t.Errorf("cookie %x: result code %d, want unwillingToPerform", cookie, code)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package ldapserver
+
+import (
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// PasswordModifyRequest is a Password Modify extended request (RFC 3062)
+type PasswordModifyRequest struct {
+	value PasswordModifyValue
+}
+
+// UserIdentity returns the identity of the user whose password is changed,
+// empty for the user the client is bound as
+func (r PasswordModifyRequest) UserIdentity() string {
+	return r.value.UserIdentity
+}
+
+// OldPasswd returns the current password of the user, nil when absent
+func (r PasswordModifyRequest) OldPasswd() []byte {
+	return r.value.OldPassword
+}
+
+// NewPasswd returns the new password, nil when absent: the server should
+// then generate one and return it with NewPasswordModifyResponse
+func (r PasswordModifyRequest) NewPasswd() []byte {
+	return r.value.NewPassword
+}
+
+// GetPasswordModifyRequest decodes the Password Modify extended request
+// m. The error is a *ResultError with the protocolError code when m is not
+// a Password Modify request or its value is malformed.
+func (m *Message) GetPasswordModifyRequest() (PasswordModifyRequest, error) {
+	r, ok := m.ProtocolOp().(ldap.ExtendedRequest)
+	if !ok || r.RequestName() != NoticeOfPasswordModify {
+		return PasswordModifyRequest{}, NewResultError(LDAPResultProtocolError, "not a password modify request")
+	}
+	var value []byte
+	if r.RequestValue() != nil {
+		value = []byte(*r.RequestValue())
+	}
+	v, err := parsePasswordModifyValue(value)
+	if err != nil {
+		return PasswordModifyRequest{}, NewResultError(LDAPResultProtocolError, err.Error())
+	}
+	return PasswordModifyRequest{value: v}, nil
+}
+
+// NewPasswordModifyResponse returns the response to a Password Modify
+// request, carrying genPasswd, the password generated by the server, when
+// it is non-nil
+func NewPasswordModifyResponse(resultCode int, genPasswd []byte) *ExtendedValueResponse {
+	r := NewExtendedValueResponse(resultCode, nil)
+	// PasswdModifyResponseValue ::= SEQUENCE { genPasswd [0] OCTET STRING OPTIONAL }
+	if genPasswd != nil {
+		r.ResponseValue = berSequence(berOctetString(berClassContext|0, genPasswd))
+	}
+	return r
+}
+
+// PasswordModify routes the Password Modify extended requests (RFC 3062)
+// to handler, which decodes them with Message.GetPasswordModifyRequest
+func (h *RouteMux) PasswordModify(handler HandlerFunc) *route {
+	return h.Extended(handler).RequestName(NoticeOfPasswordModify)
+}
@@ -0,0 +1,353 @@
+package ldapserver
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// PasswordPolicyError is an error of the password policy response control
+// (draft-behera-ldap-password-policy)
+type PasswordPolicyError int
+
+const (
+	PasswordExpired             PasswordPolicyError = 0
+	AccountLocked               PasswordPolicyError = 1
+	ChangeAfterReset            PasswordPolicyError = 2
+	PasswordModNotAllowed       PasswordPolicyError = 3
+	MustSupplyOldPassword       PasswordPolicyError = 4
+	InsufficientPasswordQuality PasswordPolicyError = 5
+	PasswordTooShort            PasswordPolicyError = 6
+	PasswordTooYoung            PasswordPolicyError = 7
+	PasswordInHistory           PasswordPolicyError = 8
+)
+
+var passwordPolicyErrorNames = map[PasswordPolicyError]string{
+	PasswordExpired:             "passwordExpired",
+	AccountLocked:               "accountLocked",
+	ChangeAfterReset:            "changeAfterReset",
+	PasswordModNotAllowed:       "passwordModNotAllowed",
+	MustSupplyOldPassword:       "mustSupplyOldPassword",
+	InsufficientPasswordQuality: "insufficientPasswordQuality",
+	PasswordTooShort:            "passwordTooShort",
+	PasswordTooYoung:            "passwordTooYoung",
+	PasswordInHistory:           "passwordInHistory",
+}
+
+func (e PasswordPolicyError) String() string {
+	if name, ok := passwordPolicyErrorNames[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("PasswordPolicyError(%d)", int(e))
+}
+
+// ResultCode returns the LDAP result code answering an operation failing
+// with e
+func (e PasswordPolicyError) ResultCode() int {
+	switch e {
+	case PasswordExpired, AccountLocked:
+		return LDAPResultInvalidCredentials
+	case ChangeAfterReset, PasswordModNotAllowed:
+		return LDAPResultInsufficientAccessRights
+	case MustSupplyOldPassword:
+		return LDAPResultUnwillingToPerform
+	}
+	return LDAPResultConstraintViolation
+}
+
+// PasswordPolicyViolation is the error returned when a password policy
+// refuses an operation
+type PasswordPolicyViolation struct {
+	Code              PasswordPolicyError
+	DiagnosticMessage string
+}
+
+func (v *PasswordPolicyViolation) Error() string {
+	return fmt.Sprintf("%s: %s", v.Code, v.DiagnosticMessage)
+}
+
+// PasswordQuality checks the quality of new passwords. Zero fields disable
+// the corresponding check.
+type PasswordQuality struct {
+	MinLength  int // in characters
+	MaxLength  int // in characters
+	MinUpper   int // uppercase letters
+	MinLower   int // lowercase letters
+	MinDigits  int // decimal digits
+	MinSpecial int // characters which are neither letters nor digits
+	MinClasses int // character classes among uppercase, lowercase, digits and special
+
+	// DenyList holds passwords which are refused, case insensitively
+	DenyList []string
+
+	// Denied, if non-nil, reports whether the password is refused, for
+	// instance because it is found in a dictionary
+	Denied func(password string) bool
+
+	// History, if non-nil, reports whether the password was already used
+	// by the account dn. For Password Modify requests, dn is the
+	// userIdentity without its "dn:" prefix, or the authorization identity
+	// of the client when absent; an identity which is not a DN is passed
+	// as an authzId, "u:" followed by a user name.
+	History func(dn string, password []byte) (bool, error)
+}
+
+// Check returns a *PasswordPolicyViolation when password, the new password
+// of the account dn, does not meet the policy
+func (q *PasswordQuality) Check(dn string, password []byte) error {
+	if !utf8.Valid(password) {
+		return &PasswordPolicyViolation{InsufficientPasswordQuality, "password is not valid UTF-8"}
+	}
+	s := string(password)
+
+	length := utf8.RuneCountInString(s)
+	if q.MinLength > 0 && length < q.MinLength {
+		return &PasswordPolicyViolation{PasswordTooShort, fmt.Sprintf("password must have at least %d characters", q.MinLength)}
+	}
+	if q.MaxLength > 0 && length > q.MaxLength {
+		return &PasswordPolicyViolation{InsufficientPasswordQuality, fmt.Sprintf("password must have at most %d characters", q.MaxLength)}
+	}
+
+	var upper, lower, digits, special int
+	for _, r := range s {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		case unicode.IsDigit(r):
+			digits++
+		case !unicode.IsLetter(r):
+			special++
+		}
+	}
+	classes := 0
+	for _, n := range []int{upper, lower, digits, special} {
+		if n > 0 {
+			classes++
+		}
+	}
+	switch {
+	case upper < q.MinUpper:
+		return &PasswordPolicyViolation{InsufficientPasswordQuality, fmt.Sprintf("password must have at least %d uppercase letters", q.MinUpper)}
+	case lower < q.MinLower:
+		return &PasswordPolicyViolation{InsufficientPasswordQuality, fmt.Sprintf("password must have at least %d lowercase letters", q.MinLower)}
+	case digits < q.MinDigits:
+		return &PasswordPolicyViolation{InsufficientPasswordQuality, fmt.Sprintf("password must have at least %d digits", q.MinDigits)}
+	case special < q.MinSpecial:
+		return &PasswordPolicyViolation{InsufficientPasswordQuality, fmt.Sprintf("password must have at least %d special characters", q.MinSpecial)}
+	case classes < q.MinClasses:
+		return &PasswordPolicyViolation{InsufficientPasswordQuality, fmt.Sprintf("password must mix at least %d character classes", q.MinClasses)}
+	}
+
+	for _, denied := range q.DenyList {
+		if strings.EqualFold(denied, s) {
+			return &PasswordPolicyViolation{InsufficientPasswordQuality, "password is too common"}
+		}
+	}
+	if q.Denied != nil && q.Denied(s) {
+		return &PasswordPolicyViolation{InsufficientPasswordQuality, "password is too common"}
+	}
+
+	if q.History != nil {
+		used, err := q.History(dn, password)
+		if err != nil {
+			return err
+		}
+		if used {
+			return &PasswordPolicyViolation{PasswordInHistory, "password was used recently"}
+		}
+	}
+	return nil
+}
+
+// Middleware returns a middleware checking the new passwords of Password
+// Modify extended operations, and the userPassword values of Add requests
+// and modifications, before they reach the routed handlers. Refused requests are answered with the
+// result code of the policy error, and the password policy response
+// control when the client sent the request control.
+func (q *PasswordQuality) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w ResponseWriter, m *Message) {
+			if err := q.checkRequest(m); err != nil {
+				WritePasswordPolicyFailure(w, m, err)
+				return
+			}
+			next(w, m)
+		}
+	}
+}
+
+// checkRequest checks the new passwords carried by m
+func (q *PasswordQuality) checkRequest(m *Message) error {
+	switch r := m.ProtocolOp().(type) {
+	case ldap.AddRequest:
+		for _, a := range r.Attributes() {
+			if !strings.EqualFold(string(a.Type_()), "userPassword") {
+				continue
+			}
+			for _, v := range a.Vals() {
+				if err := q.Check(string(r.Entry()), []byte(v)); err != nil {
+					return err
+				}
+			}
+		}
+	case ldap.ModifyRequest:
+		for _, change := range r.Changes() {
+			modification := change.Modification()
+			if !strings.EqualFold(string(modification.Type_()), "userPassword") {
+				continue
+			}
+			switch int(change.Operation()) {
+			case ModifyRequestChangeOperationAdd, ModifyRequestChangeOperationReplace:
+				for _, v := range modification.Vals() {
+					if err := q.Check(string(r.Object()), []byte(v)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	case ldap.ExtendedRequest:
+		if r.RequestName() != NoticeOfPasswordModify || r.RequestValue() == nil {
+			return nil
+		}
+		req, err := parsePasswordModifyValue([]byte(*r.RequestValue()))
+		if err != nil || req.NewPassword == nil {
+			// left to the handler, which may generate the password
+			return nil
+		}
+		return q.Check(passwordModifyDN(m, req), req.NewPassword)
+	}
+	return nil
+}
+
+// passwordModifyDN returns the account whose password the Password Modify
+// request m changes: the DN of its userIdentity, which may be an authzId,
+// or of the authorization identity of the client when absent. Identities
+// which are not DNs are returned as authzIds.
+func passwordModifyDN(m *Message, req PasswordModifyValue) string {
+	identity := req.UserIdentity
+	if identity == "" {
+		identity = m.AuthzID()
+	}
+	if strings.HasPrefix(identity, "dn:") {
+		return identity[len("dn:"):]
+	}
+	return identity
+}
+
+// PasswordModifyValue is the value of a Password Modify extended request
+// (RFC 3062), absent fields are nil or empty
+type PasswordModifyValue struct {
+	UserIdentity string
+	OldPassword  []byte
+	NewPassword  []byte
+}
+
+// parsePasswordModifyValue decodes PasswdModifyRequestValue ::= SEQUENCE {
+// userIdentity [0] OCTET STRING OPTIONAL, oldPasswd [1] OCTET STRING
+// OPTIONAL, newPasswd [2] OCTET STRING OPTIONAL }
+func parsePasswordModifyValue(value []byte) (PasswordModifyValue, error) {
+	var v PasswordModifyValue
+	if len(value) == 0 {
+		return v, nil
+	}
+	seq, _, err := berRead(value)
+	if err != nil || seq.tag != berTagSequence {
+		return v, errors.New("malformed password modify request value")
+	}
+	children, err := berChildren(seq.data)
+	if err != nil {
+		return v, errors.New("malformed password modify request value")
+	}
+	for _, child := range children {
+		switch child.tag {
+		case berClassContext | 0:
+			v.UserIdentity = string(child.data)
+		case berClassContext | 1:
+			v.OldPassword = child.data
+		case berClassContext | 2:
+			v.NewPassword = child.data
+		default:
+			return v, errors.New("malformed password modify request value")
+		}
+	}
+	return v, nil
+}
+
+// WritePasswordPolicyFailure answers the bind, add, modify or password
+// modify request m refused with err, a *PasswordPolicyViolation. The password
+// policy response control is attached when the request asked for it.
+func WritePasswordPolicyFailure(w ResponseWriter, m *Message, err error) {
+	var violation *PasswordPolicyViolation
+	if !errors.As(err, &violation) {
+		w.Write(NewResponseForRequest(m.ProtocolOp(), LDAPResultOperationsError, err.Error()))
+		return
+	}
+
+	resultCode := violation.Code.ResultCode()
+	if _, ok := requestControl(m, ControlPasswordPolicy); !ok {
+		w.Write(NewResponseForRequest(m.ProtocolOp(), resultCode, violation.DiagnosticMessage))
+		return
+	}
+
+	var protocolOp []byte
+	switch m.ProtocolOp().(type) {
+	case ldap.ExtendedRequest:
+		res := NewExtendedValueResponse(resultCode, nil)
+		res.DiagnosticMessage = violation.DiagnosticMessage
+		protocolOp = res.Bytes()
+	case ldap.AddRequest:
+		protocolOp = berConstructedTLV(berClassApplication|berConstructed|ApplicationAddResponse,
+			encodeLDAPResult(resultCode, "", violation.DiagnosticMessage)...)
+	case ldap.ModifyRequest:
+		protocolOp = berConstructedTLV(berClassApplication|berConstructed|ApplicationModifyResponse,
+			encodeLDAPResult(resultCode, "", violation.DiagnosticMessage)...)
+	case ldap.BindRequest:
+		protocolOp = berConstructedTLV(berClassApplication|berConstructed|ApplicationBindResponse,
+			encodeLDAPResult(resultCode, "", violation.DiagnosticMessage)...)
+	default:
+		w.Write(NewResponseForRequest(m.ProtocolOp(), resultCode, violation.DiagnosticMessage))
+		return
+	}
+	WriteRawWithControls(w, protocolOp, [][]byte{PasswordPolicyResponseControl(-1, -1, violation.Code)})
+}
+
+// PasswordPolicyResponseControl returns the BER encoded password policy
+// response control. timeBeforeExpiration or graceAuthNsRemaining is sent
+// as warning when not negative, and e as error when not negative.
+func PasswordPolicyResponseControl(timeBeforeExpiration int, graceAuthNsRemaining int, e PasswordPolicyError) []byte {
+	var elements [][]byte
+	switch {
+	case timeBeforeExpiration >= 0:
+		elements = append(elements, berConstructedTLV(berClassContext|berConstructed|0,
+			berInteger(berClassContext|0, int64(timeBeforeExpiration))))
+	case graceAuthNsRemaining >= 0:
+		elements = append(elements, berConstructedTLV(berClassContext|berConstructed|0,
+			berInteger(berClassContext|1, int64(graceAuthNsRemaining))))
+	}
+	if e >= 0 {
+		elements = append(elements, berInteger(berClassContext|1, int64(e)))
+	}
+	return berSequence(
+		berOctetString(berTagOctetString, []byte(ControlPasswordPolicy)),
+		berOctetString(berTagOctetString, berSequence(elements...)),
+	)
+}
+
+// requestControl returns the control of type oid sent with m
+func requestControl(m *Message, oid ldap.LDAPOID) (ldap.Control, bool) {
+	if m.LDAPMessage.Controls() == nil {
+		return ldap.Control{}, false
+	}
+	for _, c := range *m.LDAPMessage.Controls() {
+		if c.ControlType() == oid {
+			return c, true
+		}
+	}
+	return ldap.Control{}, false
+}
@@ -0,0 +1,154 @@
+package ldapserver
+
+import "testing"
+
+func testPasswordQuality() *PasswordQuality {
+	return &PasswordQuality{
+		MinLength:  8,
+		MaxLength:  16,
+		MinUpper:   1,
+		MinDigits:  1,
+		MinClasses: 2,
+		DenyList:   []string{"Password123"},
+		Denied:     func(password string) bool { return password == "Qwerty123" },
+		History: func(dn string, password []byte) (bool, error) {
+			return dn == "uid=alice,dc=example,dc=com" && string(password) == "Reused123", nil
+		},
+	}
+}
+
+func TestPasswordQualityCheck(t *testing.T) {
+	q := testPasswordQuality()
+	tests := []struct {
+		name     string
+		password string
+		dn       string
+		ok       bool
+		code     PasswordPolicyError
+	}{
+		{"valid", "Secret123", "", true, 0},
+		{"too short", "Sh0rt", "", false, PasswordTooShort},
+		{"too long", "VeryLongPassword1234", "", false, InsufficientPasswordQuality},
+		{"no uppercase", "secret123", "", false, InsufficientPasswordQuality},
+		{"no digit", "SecretPwd", "", false, InsufficientPasswordQuality},
+		{"deny list", "PASSWORD123", "", false, InsufficientPasswordQuality},
+		{"denied", "Qwerty123", "", false, InsufficientPasswordQuality},
+		{"invalid UTF-8", "Secret123\xff", "", false, InsufficientPasswordQuality},
+		{"in history", "Reused123", "uid=alice,dc=example,dc=com", false, PasswordInHistory},
+		{"history of another account", "Reused123", "uid=bob,dc=example,dc=com", true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := q.Check(tt.dn, []byte(tt.password))
+			if tt.ok {
+				if err != nil {
+					t.Errorf("password refused: %s", err)
+				}
+				return
+			}
+			violation, ok := err.(*PasswordPolicyViolation)
+			if !ok {
+				t.Fatalf("error %v, want a PasswordPolicyViolation", err)
+			}
+			if violation.Code != tt.code {
+				t.Errorf("policy error %s, want %s", violation.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyErrorResultCode(t *testing.T) {
+	tests := []struct {
+		code PasswordPolicyError
+		want int
+	}{
+		{PasswordExpired, LDAPResultInvalidCredentials},
+		{AccountLocked, LDAPResultInvalidCredentials},
+		{ChangeAfterReset, LDAPResultInsufficientAccessRights},
+		{PasswordModNotAllowed, LDAPResultInsufficientAccessRights},
+		{MustSupplyOldPassword, LDAPResultUnwillingToPerform},
+		{InsufficientPasswordQuality, LDAPResultConstraintViolation},
+		{PasswordTooShort, LDAPResultConstraintViolation},
+		{PasswordTooYoung, LDAPResultConstraintViolation},
+		{PasswordInHistory, LDAPResultConstraintViolation},
+	}
+	for _, tt := range tests {
+		if got := tt.code.ResultCode(); got != tt.want {
+			t.Errorf("%s answered with %d, want %d", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestPasswordQualityMiddleware(t *testing.T) {
+	attribute := func(name string, values ...string) []byte {
+		encoded := make([][]byte, len(values))
+		for i, v := range values {
+			encoded[i] = berOctetString(berTagOctetString, []byte(v))
+		}
+		return berSequence(berOctetString(berTagOctetString, []byte(name)), berConstructedTLV(berTagSet, encoded...))
+	}
+	add := func(a []byte) []byte {
+		return berConstructedTLV(berClassApplication|berConstructed|ApplicationAddRequest,
+			berOctetString(berTagOctetString, []byte("uid=alice,dc=example,dc=com")),
+			berSequence(attribute("objectClass", "person"), a),
+		)
+	}
+	modify := func(operation int, a []byte) []byte {
+		return berConstructedTLV(berClassApplication|berConstructed|ApplicationModifyRequest,
+			berOctetString(berTagOctetString, []byte("uid=alice,dc=example,dc=com")),
+			berSequence(berSequence(berInteger(berTagEnumerated, int64(operation)), a)),
+		)
+	}
+	passwordModify := func(identity, password string) []byte {
+		value := berSequence(
+			berOctetString(berClassContext|0, []byte(identity)),
+			berOctetString(berClassContext|2, []byte(password)),
+		)
+		return berConstructedTLV(berClassApplication|berConstructed|ApplicationExtendedRequest,
+			berOctetString(berClassContext|0, []byte(NoticeOfPasswordModify)),
+			berOctetString(berClassContext|1, value),
+		)
+	}
+
+	tests := []struct {
+		name    string
+		request []byte
+		code    int
+	}{
+		{"add", add(attribute("userPassword", "Secret123")), LDAPResultSuccess},
+		{"add weak", add(attribute("userPassword", "secret")), LDAPResultConstraintViolation},
+		{"add without password", add(attribute("cn", "secret")), LDAPResultSuccess},
+		{"modify replace weak", modify(ModifyRequestChangeOperationReplace, attribute("userPassword", "secret")), LDAPResultConstraintViolation},
+		{"modify add weak", modify(ModifyRequestChangeOperationAdd, attribute("userPassword", "secret")), LDAPResultConstraintViolation},
+		{"modify delete weak", modify(ModifyRequestChangeOperationDelete, attribute("userPassword", "secret")), LDAPResultSuccess},
+		{"password modify", passwordModify("dn:uid=alice,dc=example,dc=com", "Secret123"), LDAPResultSuccess},
+		{"password modify weak", passwordModify("dn:uid=alice,dc=example,dc=com", "secret"), LDAPResultConstraintViolation},
+		{"password modify authzId DN in history", passwordModify("dn:uid=alice,dc=example,dc=com", "Reused123"), LDAPResultConstraintViolation},
+		{"password modify DN in history", passwordModify("uid=alice,dc=example,dc=com", "Reused123"), LDAPResultConstraintViolation},
+		{"password modify user name", passwordModify("u:alice", "Reused123"), LDAPResultSuccess},
+	}
+	handler := testPasswordQuality().Middleware()(func(w ResponseWriter, m *Message) {
+		w.Write(NewResponseForRequest(m.ProtocolOp(), LDAPResultSuccess, ""))
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewResponseRecorder()
+			handler(w, testMessage(t, tt.request))
+			if code := w.ResultCode(); code != tt.code {
+				t.Errorf("result code %d, want %d", code, tt.code)
+			}
+		})
+	}
+
+	// the response control is attached when the client asked for it
+	msg, err := decodeMessage(encodeRawMessage(1, add(attribute("userPassword", "secret")),
+		[][]byte{berSequence(berOctetString(berTagOctetString, []byte(ControlPasswordPolicy)))}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewResponseRecorder()
+	handler(w, &Message{LDAPMessage: &msg})
+	if controls := w.Controls(); len(controls) != 1 || controls[0].ControlType() != ControlPasswordPolicy {
+		t.Errorf("response controls %v, want the password policy control", controls)
+	}
+}
@@ -0,0 +1,43 @@
+package ldapserver
+
+import (
+	"context"
+	"runtime/pprof"
+	"strconv"
+)
+
+// pprof labels set around the handlers when the server ProfileLabels is set
+const (
+	ProfileLabelOperation = "ldap_op"    // the operation, such as SearchRequest
+	ProfileLabelRoute     = "ldap_route" // the label of the RouteMux route, or its index
+)
+
+// serveHandler passes m to handler, with the operation pprof label when
+// the server ProfileLabels is set
+func (s *Server) serveHandler(handler Handler, w ResponseWriter, m *Message) {
+	if !s.ProfileLabels {
+		handler.ServeLDAP(w, m)
+		return
+	}
+	pprof.Do(m.Context(), pprof.Labels(ProfileLabelOperation, m.ProtocolOpName()), func(ctx context.Context) {
+		m.ctx = ctx
+		handler.ServeLDAP(w, m)
+	})
+}
+
+// serve runs the handler of the route number i, with the route pprof
+// label when the request is served with the operation label
+func (r *route) serve(i int, w ResponseWriter, m *Message) {
+	if _, ok := pprof.Label(m.Context(), ProfileLabelOperation); !ok {
+		r.handler(w, m)
+		return
+	}
+	name := r.label
+	if name == "" {
+		name = strconv.Itoa(i)
+	}
+	pprof.Do(m.Context(), pprof.Labels(ProfileLabelRoute, name), func(ctx context.Context) {
+		m.ctx = ctx
+		r.handler(w, m)
+	})
+}
@@ -0,0 +1,295 @@
+package ldapserver
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// Proxy is a Handler forwarding requests to upstream directories. Each
+// client connection gets its own upstream connections, dialed on first
+// use, so binds apply to the client only. Operations of a client are
+// forwarded one at a time.
+type Proxy struct {
+	// Upstreams dial the upstream directories. The first one is the
+	// primary, which receives binds and writes, the others are replicas
+	// sharing the reads. Without replicas, all requests go to the primary.
+	Upstreams []func() (*ClientConn, error)
+
+	// ReadYourWrites, if non-zero, sends the reads a client makes within
+	// this time after a write to the primary, so it does not observe stale
+	// entries on a lagging replica
+	ReadYourWrites time.Duration
+
+	// Timeout, if non-zero, bounds the time given to each upstream
+	// operation. Operations are also bounded by the deadline of the
+	// request, see Message.Deadline.
+	Timeout time.Duration
+
+	// Referrals, if non-nil, has the proxy chase the referrals returned by
+	// the upstream directories instead of passing them to the clients
+	Referrals *ReferralPolicy
+
+	mu       sync.Mutex
+	sessions map[*client]*proxySession
+	next     uint32 // replica assigned to the next session
+}
+
+// proxySession holds the upstream connections of a client
+type proxySession struct {
+	mu        sync.Mutex
+	conns     []*ClientConn
+	connBinds []int // bind generation each connection is authenticated with
+	binds     int   // incremented on each successful bind
+	bindDN    string
+	password  []byte
+	pinned    bool // the bind can not be replayed on replicas and redialed connections
+	replica   int
+	lastWrite time.Time
+}
+
+// SupportedControls returns the registered control types, forwarded with
+// the requests, the upstream directory refuses those it does not support.
+// Proxied Authorization is handled by the server.
+func (p *Proxy) SupportedControls() []ldap.LDAPOID {
+	var oids []ldap.LDAPOID
+	for _, oid := range RegisteredControls() {
+		if oid != ControlProxiedAuthz {
+			oids = append(oids, oid)
+		}
+	}
+	return oids
+}
+
+// ServeLDAP forwards the request m, and writes back the upstream responses
+func (p *Proxy) ServeLDAP(w ResponseWriter, m *Message) {
+	po := m.ProtocolOp()
+	switch po.(type) {
+	case ldap.AbandonRequest:
+		// operations are forwarded synchronously, there is nothing to
+		// abandon upstream
+		return
+	case ldap.ExtendedRequest:
+		// StartTLS secures the client connection, not the upstream ones
+		if canStartTLS(m) {
+			HandleStartTLS(w, m)
+			return
+		}
+		if isStartTLS(m.LDAPMessage) {
+			res := NewExtendedResponse(LDAPResultUnwillingToPerform)
+			res.SetDiagnosticMessage("StartTLS is not supported by the proxy")
+			w.Write(res)
+			return
+		}
+	}
+	if len(p.Upstreams) == 0 {
+		w.Write(NewResponseForRequest(po, LDAPResultUnavailable, "no upstream directory"))
+		return
+	}
+
+	protocolOp, controls, err := encodeRequest(m)
+	if err != nil {
+		w.Write(NewResponseForRequest(po, LDAPResultOperationsError, err.Error()))
+		return
+	}
+
+	s := p.session(m.Client)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	timeout, err := UpstreamTimeout(m, p.Timeout)
+	if err != nil {
+		resultCode, diagnosticMessage := UpstreamError(err)
+		w.Write(NewResponseForRequest(po, resultCode, diagnosticMessage))
+		return
+	}
+
+	// the primary connection authenticated by a SASL bind was lost: the
+	// requests would run anonymously upstream, they fail until the client
+	// binds again
+	if _, ok := po.(ldap.BindRequest); !ok && s.pinned && s.conns[0] == nil {
+		m.logAt(LogLevelWarn, "upstream connection authenticated by a SASL bind lost")
+		w.Write(NewResponseForRequest(po, LDAPResultUnavailable, "upstream connection lost, bind again"))
+		return
+	}
+
+	upstream := p.upstreamFor(s, m)
+	conn, err := p.conn(s, upstream)
+	if err != nil && m.DontUseCopy() {
+		m.logAt(LogLevelWarn, "primary directory unavailable for a Don't Use Copy request: %s", err)
+		w.Write(NewResponseForRequest(po, ErrOnlyCopies.ResultCode, ErrOnlyCopies.DiagnosticMessage))
+		return
+	}
+	if err != nil {
+		w.Write(NewResponseForRequest(po, LDAPResultUnavailable, fmt.Sprintf("upstream directory unavailable: %s", err)))
+		return
+	}
+
+	conn.Timeout = timeout
+	responses, err := conn.Do(protocolOp, controls)
+	if err != nil {
+		// the connection is not usable anymore, it is dialed again by the
+		// next request
+		conn.Close()
+		s.conns[upstream] = nil
+		resultCode, diagnosticMessage := UpstreamError(err)
+		w.Write(NewResponseForRequest(po, resultCode, diagnosticMessage))
+		return
+	}
+
+	if isWriteRequest(po) {
+		s.lastWrite = time.Now()
+	}
+	if r, ok := po.(ldap.BindRequest); ok {
+		p.bound(s, r, responses[len(responses)-1])
+	} else if p.Referrals != nil {
+		responses = p.chaseReferrals(m, s, protocolOp, controls, timeout, responses)
+	}
+
+	for i := range responses {
+		w.WriteMessage(&responses[i])
+	}
+}
+
+// session returns the session of c, created on first use and closed with
+// the client connection
+func (p *Proxy) session(c *client) *proxySession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.sessions[c]; ok {
+		return s
+	}
+	if p.sessions == nil {
+		p.sessions = make(map[*client]*proxySession)
+	}
+
+	s := &proxySession{
+		conns:     make([]*ClientConn, len(p.Upstreams)),
+		connBinds: make([]int, len(p.Upstreams)),
+	}
+	if len(p.Upstreams) > 1 {
+		s.replica = 1 + int(atomic.AddUint32(&p.next, 1)-1)%(len(p.Upstreams)-1)
+	}
+	p.sessions[c] = s
+
+	if c != nil && c.closing != nil {
+		go func() {
+			<-c.closing
+			p.mu.Lock()
+			delete(p.sessions, c)
+			p.mu.Unlock()
+			s.close()
+		}()
+	}
+	return s
+}
+
+// upstreamFor returns the index of the upstream serving m, requests with
+// the Don't Use Copy control are served by the primary
+func (p *Proxy) upstreamFor(s *proxySession, m *Message) int {
+	switch m.ProtocolOp().(type) {
+	case ldap.SearchRequest, ldap.CompareRequest:
+	default:
+		return 0
+	}
+	if s.replica == 0 || s.pinned || m.DontUseCopy() {
+		return 0
+	}
+	if p.ReadYourWrites != 0 && time.Since(s.lastWrite) < p.ReadYourWrites {
+		return 0
+	}
+	return s.replica
+}
+
+// conn returns the connection to the upstream i, dialed and authenticated
+// as the client when needed
+func (p *Proxy) conn(s *proxySession, i int) (*ClientConn, error) {
+	if s.conns[i] == nil {
+		conn, err := p.Upstreams[i]()
+		if err != nil {
+			return nil, err
+		}
+		s.conns[i] = conn
+		s.connBinds[i] = 0
+	}
+	conn := s.conns[i]
+	conn.Timeout = p.Timeout
+
+	// binds are sent to the primary, and replayed on replicas and
+	// redialed connections
+	if s.connBinds[i] != s.binds && !s.pinned {
+		if err := conn.Bind(s.bindDN, string(s.password)); err != nil {
+			conn.Close()
+			s.conns[i] = nil
+			return nil, err
+		}
+		s.connBinds[i] = s.binds
+	}
+	return conn, nil
+}
+
+// bound records the identity of a bind forwarded to the primary
+func (p *Proxy) bound(s *proxySession, r ldap.BindRequest, result ldap.LDAPMessage) {
+	s.binds++
+	s.connBinds[0] = s.binds
+	s.bindDN, s.password, s.pinned = "", nil, false
+
+	res, ok := result.ProtocolOp().(ldap.BindResponse)
+	if !ok || int(res.ResultCode()) != LDAPResultSuccess {
+		// a failed bind leaves the connection anonymous
+		return
+	}
+	if r.AuthenticationChoice() != "simple" {
+		s.pinned = true
+		return
+	}
+	s.bindDN = string(r.Name())
+	s.password = []byte(r.AuthenticationSimple())
+}
+
+func (s *proxySession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, conn := range s.conns {
+		if conn != nil {
+			conn.Close()
+			s.conns[i] = nil
+		}
+	}
+}
+
+// encodeRequest returns the BER encoded protocolOp and controls of m
+func encodeRequest(m *Message) ([]byte, [][]byte, error) {
+	data, err := m.Write()
+	if err != nil {
+		return nil, nil, err
+	}
+	message, _, err := berRead(data.Bytes())
+	if err != nil {
+		return nil, nil, err
+	}
+	children, err := berChildren(message.data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(children) < 2 {
+		return nil, nil, errors.New("malformed LDAP message")
+	}
+
+	protocolOp := berTLV(children[1].tag, children[1].data)
+	var controls [][]byte
+	if len(children) > 2 {
+		elements, err := berChildren(children[2].data)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, c := range elements {
+			controls = append(controls, berTLV(c.tag, c.data))
+		}
+	}
+	return protocolOp, controls, nil
+}
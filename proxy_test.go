@@ -0,0 +1,65 @@
+package ldapserver
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestProxySASLSessionLost(t *testing.T) {
+	upstream := NewServer()
+	upstream.Handle(successHandler{})
+	upstreamAddr := serveTest(t, upstream)
+	defer upstream.Stop()
+
+	var mu sync.Mutex
+	var conns []*ClientConn
+	dials := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(conns)
+	}
+	proxy := &Proxy{Upstreams: []func() (*ClientConn, error){func() (*ClientConn, error) {
+		c, err := DialClient("tcp", upstreamAddr.String())
+		if err == nil {
+			mu.Lock()
+			conns = append(conns, c)
+			mu.Unlock()
+		}
+		return c, err
+	}}}
+	s := NewServer()
+	s.Handle(proxy)
+	addr := serveTest(t, s)
+	defer s.Stop()
+	c := dialTest(t, addr)
+	defer c.Close()
+
+	if err := c.SASLBind(&SASLExternalClient{}); err != nil {
+		t.Fatal(err)
+	}
+	// the upstream connection authenticated by the SASL bind breaks
+	mu.Lock()
+	conns[0].Close()
+	mu.Unlock()
+	search := SearchParams{BaseDN: "dc=example,dc=com"}
+	if _, err := c.Search(search); err == nil {
+		t.Fatal("search over the broken upstream connection succeeded")
+	}
+	// the SASL bind can not be replayed, the search must not run anonymously
+	if _, err := c.Search(search); resultCode(err) != LDAPResultUnavailable {
+		t.Errorf("search after the connection loss: %v, want unavailable", err)
+	}
+	if n := dials(); n != 1 {
+		t.Errorf("upstream dialed %d times before the client bound again", n)
+	}
+
+	if err := c.Bind("cn=alice,dc=example,dc=com", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Search(search); err != nil {
+		t.Errorf("search after binding again: %s", err)
+	}
+	if n := dials(); n != 2 {
+		t.Errorf("upstream dialed %d times, want 2", n)
+	}
+}
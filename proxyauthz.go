@@ -0,0 +1,112 @@
+package ldapserver
+
+import (
+	"strings"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// decodeProxiedAuthz decodes the value of a ProxiedAuthorization control,
+// the authorization identity itself (RFC 4370): "dn:" followed by a DN,
+// "u:" followed by a user name, or empty for the anonymous identity
+func decodeProxiedAuthz(criticality bool, value []byte) (string, error) {
+	if !criticality {
+		return "", NewResultError(LDAPResultProtocolError, "proxied authorization control must be critical")
+	}
+	if value == nil {
+		return "", NewResultError(LDAPResultProtocolError, "proxied authorization control must have a value")
+	}
+	authzID := string(value)
+	switch {
+	case authzID == "", strings.HasPrefix(authzID, "u:"):
+	case strings.HasPrefix(authzID, "dn:"):
+		if _, err := ParseDN(authzID[len("dn:"):]); err != nil {
+			return "", NewResultError(LDAPResultProtocolError, "invalid proxied authorization DN: "+err.Error())
+		}
+	default:
+		return "", NewResultError(LDAPResultProtocolError, "invalid proxied authorization identity "+authzID)
+	}
+	return authzID, nil
+}
+
+// checkProxiedAuthz returns the response to a request with a
+// ProxiedAuthorization control which can't be honored: malformed, sent with
+// a bind, not supported or denied by the server ProxyAuthorization.
+// Approved requests are processed with the control authorization identity,
+// see AuthzID.
+func (s *Server) checkProxiedAuthz(m *Message) ldap.ProtocolOp {
+	c, ok := requestControl(m, ControlProxiedAuthz)
+	if !ok {
+		return nil
+	}
+	switch m.ProtocolOp().(type) {
+	case ldap.BindRequest:
+		return NewResponseForRequest(m.ProtocolOp(), LDAPResultProtocolError, "proxied authorization does not apply to binds")
+	}
+	if s.ProxyAuthorization == nil {
+		return NewResponseForRequest(m.ProtocolOp(), LDAPResultUnavailableCriticalExtension, "proxied authorization is not supported")
+	}
+	authzID, err := decodeProxiedAuthz(c.Criticality(), controlValue(c))
+	if err != nil {
+		resultCode, diagnosticMessage := DefaultErrorMapper(err)
+		return NewResponseForRequest(m.ProtocolOp(), resultCode, diagnosticMessage)
+	}
+
+	identity := boundAuthzID(m)
+	if !s.ProxyAuthorization(m, identity, authzID) {
+		m.logAt(LogLevelInfo, "proxied authorization of %q as %q denied", identity, authzID)
+		return NewResponseForRequest(m.ProtocolOp(), LDAPResultAuthorizationDenied, "proxied authorization denied")
+	}
+	m.authzID = &authzID
+	return nil
+}
+
+// AuthzID returns the authorization identity of the request m, in the RFC
+// 4513 authzId form: "dn:" followed by a DN, "u:" followed by a user name,
+// or empty for anonymous. It is the identity of its approved
+// ProxiedAuthorization control if any, the bound identity of the client
+// otherwise.
+func (m *Message) AuthzID() string {
+	if m.authzID != nil {
+		return *m.authzID
+	}
+	return boundAuthzID(m)
+}
+
+// authzDN returns the DN of the authorization identity of m, see AuthzID,
+// empty when it is anonymous or not a DN
+func authzDN(m *Message) string {
+	if id := m.AuthzID(); strings.HasPrefix(id, "dn:") {
+		return id[3:]
+	}
+	return ""
+}
+
+// ProxiedAuthz reports whether m is processed with the authorization
+// identity of its ProxiedAuthorization control
+func (m *Message) ProxiedAuthz() bool {
+	return m.authzID != nil
+}
+
+// boundAuthzID returns the authorization identity the client of m is bound
+// with, as AuthzID: from its BindState, or its ACL BindEntry set by the
+// application
+func boundAuthzID(m *Message) string {
+	if m.Client == nil {
+		return ""
+	}
+	state := m.Client.BindState()
+	identity := m.Client.ACL().BindEntry
+	switch state.Method {
+	case BindSimple:
+		identity = state.DN
+	case BindSASL:
+		if state.Identity != "" {
+			identity = state.Identity
+		}
+	}
+	if identity == "" || strings.HasPrefix(identity, "dn:") || strings.HasPrefix(identity, "u:") {
+		return identity
+	}
+	return "dn:" + identity
+}
@@ -0,0 +1,317 @@
+package ldapserver
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// ReferralCredentials selects the identity the proxy binds with on the
+// directories it is referred to. With ReferralRebind the password of the
+// client is only sent to the directories accepted by ReferralPolicy.Allow,
+// which is then required, over ldaps or a connection upgraded with
+// StartTLS; the referrals are not followed otherwise.
+type ReferralCredentials int
+
+const (
+	ReferralAnonymous ReferralCredentials = iota // do not bind
+	ReferralRebind                               // replay the simple bind of the client, anonymous after SASL binds
+)
+
+// ReferralPolicy makes a Proxy chase the referrals returned by the
+// upstream directories, see Proxy.Referrals. Continuation references of
+// searches are replaced with the entries of the referred searches, and
+// operations answered with a referral are sent again to the referred
+// directory, so simple clients get a referral-free view. Referrals which
+// are not followed are dropped.
+type ReferralPolicy struct {
+	// MaxHops bounds the chain of referrals followed for a request, 3 if
+	// zero. A referral to an already visited server and DN is not followed.
+	MaxHops int
+
+	// Credentials selects the identity bound on the referred directories
+	Credentials ReferralCredentials
+
+	// Allow, if non-nil, reports whether the referral URL may be followed,
+	// to restrict the hosts the proxy connects to. With ReferralRebind no
+	// referral is followed if nil.
+	Allow func(u *url.URL) bool
+
+	// Dial, if non-nil, connects to the directory of the referral URL.
+	// By default ldap URLs are dialed over TCP and ldaps ones over TLS,
+	// verified against the host name.
+	Dial func(u *url.URL) (*ClientConn, error)
+}
+
+func (r *ReferralPolicy) maxHops() int {
+	if r.MaxHops > 0 {
+		return r.MaxHops
+	}
+	return 3
+}
+
+func (r *ReferralPolicy) dial(u *url.URL) (*ClientConn, error) {
+	if r.Dial != nil {
+		return r.Dial(u)
+	}
+	host := u.Host
+	switch strings.ToLower(u.Scheme) {
+	case "ldap":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "389")
+		}
+		return DialClient("tcp", host)
+	case "ldaps":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "636")
+		}
+		return DialClientTLS("tcp", host, &tls.Config{ServerName: u.Hostname()})
+	}
+	return nil, fmt.Errorf("unsupported referral scheme %q", u.Scheme)
+}
+
+// referralChase is the chasing of the referrals of one request
+type referralChase struct {
+	policy   *ReferralPolicy
+	m        *Message
+	s        *proxySession
+	controls [][]byte
+	timeout  time.Duration
+	visited  map[string]bool
+}
+
+// chaseReferrals returns the responses to the request protocolOp with the
+// referrals they hold followed
+func (p *Proxy) chaseReferrals(m *Message, s *proxySession, protocolOp []byte, controls [][]byte, timeout time.Duration, responses []ldap.LDAPMessage) []ldap.LDAPMessage {
+	chase := &referralChase{
+		policy:   p.Referrals,
+		m:        m,
+		s:        s,
+		controls: controls,
+		timeout:  timeout,
+		visited:  make(map[string]bool),
+	}
+	return chase.responses(protocolOp, responses, 1)
+}
+
+// responses returns responses, to the request protocolOp, with the
+// continuation references replaced with the entries they lead to, and
+// the referral result replaced with the response of the referred server
+func (c *referralChase) responses(protocolOp []byte, responses []ldap.LDAPMessage, hop int) []ldap.LDAPMessage {
+	if len(responses) == 0 {
+		return responses
+	}
+	var chased []ldap.LDAPMessage
+	for _, response := range responses[:len(responses)-1] {
+		refs, ok := response.ProtocolOp().(ldap.SearchResultReference)
+		if !ok {
+			chased = append(chased, response)
+			continue
+		}
+		for _, ref := range refs {
+			// the first URL reaching the referred subtree is enough
+			if followed := c.follow(protocolOp, string(ref), true, hop); followed != nil {
+				chased = append(chased, followed[:len(followed)-1]...)
+				break
+			}
+		}
+	}
+
+	last := responses[len(responses)-1]
+	if code, refs := resultReferrals(&last); code == LDAPResultReferral {
+		for _, ref := range refs {
+			if followed := c.follow(protocolOp, ref, false, hop); followed != nil {
+				return append(chased, followed...)
+			}
+		}
+	}
+	return append(chased, last)
+}
+
+// follow sends the request protocolOp to the directory of the referral
+// ref, a continuation reference of a search or the referral of a result,
+// and returns its chased responses, nil when it is not followed
+func (c *referralChase) follow(protocolOp []byte, ref string, continuation bool, hop int) []ldap.LDAPMessage {
+	if hop > c.policy.maxHops() {
+		c.m.logAt(LogLevelDebug, "referral %s not followed: more than %d hops", ref, c.policy.maxHops())
+		return nil
+	}
+	u, dn, scope, err := parseLDAPURL(ref)
+	if err != nil {
+		c.m.logAt(LogLevelDebug, "referral %s not followed: %s", ref, err)
+		return nil
+	}
+	// the credentials of the client are never sent to any host
+	rebind := c.policy.Credentials == ReferralRebind && c.s.bindDN != "" && !c.s.pinned
+	if (c.policy.Allow == nil && rebind) || (c.policy.Allow != nil && !c.policy.Allow(u)) {
+		c.m.logAt(LogLevelDebug, "referral %s not allowed", ref)
+		return nil
+	}
+	key := strings.ToLower(u.Host) + "/" + NormalizeDN(dn)
+	if c.visited[key] {
+		c.m.logAt(LogLevelDebug, "referral %s not followed: loop", ref)
+		return nil
+	}
+	c.visited[key] = true
+
+	if continuation && scope < 0 {
+		// RFC 4511 section 4.5.3, a one level search continues with a
+		// base search of the referred entry
+		if r, ok := c.m.ProtocolOp().(ldap.SearchRequest); ok && int(r.Scope()) == SearchRequestSingleLevel {
+			scope = SearchRequestScopeBaseObject
+		}
+	}
+	op, err := rewriteRequest(protocolOp, dn, scope)
+	if err != nil {
+		c.m.logAt(LogLevelDebug, "referral %s not followed: %s", ref, err)
+		return nil
+	}
+
+	conn, err := c.policy.dial(u)
+	if err != nil {
+		c.m.logAt(LogLevelWarn, "referral %s not followed: %s", ref, err)
+		return nil
+	}
+	defer conn.Close()
+	conn.Timeout = c.timeout
+	if rebind {
+		if _, ok := conn.TLSConnectionState(); !ok {
+			if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+				c.m.logAt(LogLevelWarn, "referral %s not followed: StartTLS failed: %s", ref, err)
+				return nil
+			}
+		}
+		if err := conn.Bind(c.s.bindDN, string(c.s.password)); err != nil {
+			c.m.logAt(LogLevelWarn, "referral %s not followed: bind failed: %s", ref, err)
+			return nil
+		}
+	}
+	responses, err := conn.Do(op, c.controls)
+	if err != nil || len(responses) == 0 {
+		c.m.logAt(LogLevelWarn, "referral %s not followed: %v", ref, err)
+		return nil
+	}
+	return c.responses(op, responses, hop+1)
+}
+
+// parseLDAPURL parses the LDAP URL ref (RFC 4516), returning its DN, empty
+// when absent, and its scope, -1 when absent
+func parseLDAPURL(ref string) (u *url.URL, dn string, scope int, err error) {
+	u, err = url.Parse(ref)
+	if err != nil {
+		return nil, "", -1, err
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "ldap", "ldaps":
+	default:
+		return nil, "", -1, fmt.Errorf("unsupported referral scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, "", -1, errors.New("referral without host")
+	}
+	dn = strings.TrimPrefix(u.Path, "/")
+	if _, err := ParseDN(dn); err != nil {
+		return nil, "", -1, err
+	}
+
+	// the query is attributes?scope?filter?extensions
+	scope = -1
+	if parts := strings.Split(u.RawQuery, "?"); len(parts) > 1 {
+		switch strings.ToLower(parts[1]) {
+		case "base":
+			scope = SearchRequestScopeBaseObject
+		case "one":
+			scope = SearchRequestSingleLevel
+		case "sub":
+			scope = SearchRequestHomeSubtree
+		}
+	}
+	return u, dn, scope, nil
+}
+
+// rewriteRequest returns the request protocolOp targeting dn instead, when
+// non-empty, and for searches with the scope, when not negative
+func rewriteRequest(protocolOp []byte, dn string, scope int) ([]byte, error) {
+	if dn == "" && scope < 0 {
+		return protocolOp, nil
+	}
+	op, _, err := berRead(protocolOp)
+	if err != nil {
+		return nil, err
+	}
+	switch op.tag & 0x1f {
+	case ApplicationDelRequest:
+		if dn == "" {
+			return protocolOp, nil
+		}
+		return berOctetString(op.tag, []byte(dn)), nil
+	case ApplicationSearchRequest, ApplicationModifyRequest, ApplicationAddRequest, ApplicationModifyDNRequest, ApplicationCompareRequest:
+	default:
+		return protocolOp, nil
+	}
+	children, err := berChildren(op.data)
+	if err != nil {
+		return nil, err
+	}
+	if len(children) == 0 {
+		return nil, errBERTruncated
+	}
+	elements := make([][]byte, len(children))
+	for i, child := range children {
+		switch {
+		case i == 0 && dn != "":
+			elements[i] = berOctetString(child.tag, []byte(dn))
+		case i == 1 && scope >= 0 && op.tag&0x1f == ApplicationSearchRequest:
+			elements[i] = berInteger(child.tag, int64(scope))
+		default:
+			elements[i] = berTLV(child.tag, child.data)
+		}
+	}
+	return berConstructedTLV(op.tag, elements...), nil
+}
+
+// resultReferrals returns the result code of the response m, and the
+// referral URLs of its result
+func resultReferrals(m *ldap.LDAPMessage) (int, []string) {
+	data, err := m.Write()
+	if err != nil {
+		return 0, nil
+	}
+	message, _, err := berRead(data.Bytes())
+	if err != nil {
+		return 0, nil
+	}
+	children, err := berChildren(message.data)
+	if err != nil || len(children) < 2 {
+		return 0, nil
+	}
+	result, err := berChildren(children[1].data)
+	if err != nil || len(result) == 0 || result[0].tag != berTagEnumerated {
+		return 0, nil
+	}
+	code, err := berParseInteger(result[0].data)
+	if err != nil {
+		return 0, nil
+	}
+	var refs []string
+	for _, element := range result[1:] {
+		// Referral ::= [3] SEQUENCE OF URI
+		if element.tag != berClassContext|berConstructed|3 {
+			continue
+		}
+		uris, err := berChildren(element.data)
+		if err != nil {
+			break
+		}
+		for _, uri := range uris {
+			refs = append(refs, string(uri.data))
+		}
+	}
+	return int(code), refs
+}
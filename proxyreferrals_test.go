@@ -0,0 +1,144 @@
+package ldapserver
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+func TestParseLDAPURL(t *testing.T) {
+	tests := []struct {
+		ref   string
+		host  string
+		dn    string
+		scope int
+		err   bool
+	}{
+		{"ldap://ldap.example.com/ou=people,dc=example,dc=com", "ldap.example.com", "ou=people,dc=example,dc=com", -1, false},
+		{"ldaps://ldap.example.com:1636/dc=example??one", "ldap.example.com:1636", "dc=example", SearchRequestSingleLevel, false},
+		{"LDAP://ldap.example.com/??base", "ldap.example.com", "", SearchRequestScopeBaseObject, false},
+		{"ldap://ldap.example.com/ou=people?cn?sub?(cn=a)", "ldap.example.com", "ou=people", SearchRequestHomeSubtree, false},
+		{"http://ldap.example.com/dc=example", "", "", -1, true},
+		{"ldap:///dc=example", "", "", -1, true},
+		{"ldap://ldap.example.com/cn", "", "", -1, true},
+	}
+	for _, tt := range tests {
+		u, dn, scope, err := parseLDAPURL(tt.ref)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseLDAPURL(%q) succeeded", tt.ref)
+			}
+			continue
+		}
+		if err != nil || u.Host != tt.host || dn != tt.dn || scope != tt.scope {
+			t.Errorf("parseLDAPURL(%q) = %v, %q, %d, %v", tt.ref, u, dn, scope, err)
+		}
+	}
+}
+
+func TestRewriteRequest(t *testing.T) {
+	search := testSearchRequest("dc=example", 10, 0)
+	rewritten, err := rewriteRequest(search, "ou=people,dc=other", SearchRequestScopeBaseObject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := testMessage(t, rewritten).ProtocolOp().(ldap.SearchRequest)
+	if !ok || string(r.BaseObject()) != "ou=people,dc=other" || int(r.Scope()) != SearchRequestScopeBaseObject || int(r.SizeLimit()) != 10 {
+		t.Errorf("rewritten search %+v", r)
+	}
+
+	del := berOctetString(berClassApplication|ApplicationDelRequest, []byte("cn=a,dc=example"))
+	if rewritten, err := rewriteRequest(del, "cn=a,dc=other", SearchRequestHomeSubtree); err != nil || !reflect.DeepEqual(rewritten, berOctetString(berClassApplication|ApplicationDelRequest, []byte("cn=a,dc=other"))) {
+		t.Errorf("rewritten delete %x, %v", rewritten, err)
+	}
+	if rewritten, err := rewriteRequest(search, "", -1); err != nil || !reflect.DeepEqual(rewritten, search) {
+		t.Errorf("request without target rewritten: %x, %v", rewritten, err)
+	}
+}
+
+func TestProxyReferrals(t *testing.T) {
+	// the referred directory answers with the entry it was asked for
+	referred := NewRouteMux()
+	referred.Search(func(w ResponseWriter, m *Message) {
+		r := m.GetSearchRequest()
+		WriteEntries(w, []Entry{*NewEntry(string(r.BaseObject())).Add("objectClass", "organizationalUnit")})
+		w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+	})
+	referred.Delete(func(w ResponseWriter, m *Message) {
+		w.Write(NewDeleteResponse(LDAPResultSuccess))
+	})
+	referredServer := NewServer()
+	referredServer.Handle(referred)
+	referredAddr := serveTest(t, referredServer)
+	defer referredServer.Stop()
+	ref := "ldap://" + referredAddr.String() + "/ou=b,dc=example"
+
+	// the upstream directory refers to it
+	upstream := NewRouteMux()
+	upstream.Search(func(w ResponseWriter, m *Message) {
+		WriteEntries(w, []Entry{*NewEntry("ou=a,dc=example").Add("objectClass", "organizationalUnit")})
+		w.(RawWriter).WriteRaw(berConstructedTLV(berClassApplication|berConstructed|ApplicationSearchResultReference,
+			berOctetString(berTagOctetString, []byte(ref))))
+		w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+	})
+	upstream.Delete(func(w ResponseWriter, m *Message) {
+		w.(RawWriter).WriteRaw(berConstructedTLV(berClassApplication|berConstructed|ApplicationDelResponse,
+			berInteger(berTagEnumerated, LDAPResultReferral),
+			berOctetString(berTagOctetString, nil),
+			berOctetString(berTagOctetString, nil),
+			berConstructedTLV(berClassContext|berConstructed|3, berOctetString(berTagOctetString, []byte(ref))),
+		))
+	})
+	upstreamServer := NewServer()
+	upstreamServer.Handle(upstream)
+	upstreamAddr := serveTest(t, upstreamServer)
+	defer upstreamServer.Stop()
+
+	tests := []struct {
+		name    string
+		allow   bool
+		entries []string
+		code    int
+	}{
+		{"followed", true, []string{"ou=a,dc=example", "ou=b,dc=example"}, LDAPResultSuccess},
+		{"not allowed", false, []string{"ou=a,dc=example"}, LDAPResultReferral},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := &Proxy{
+				Upstreams: []func() (*ClientConn, error){func() (*ClientConn, error) {
+					return DialClient("tcp", upstreamAddr.String())
+				}},
+				Referrals: &ReferralPolicy{Allow: func(u *url.URL) bool { return tt.allow }},
+			}
+			s := NewServer()
+			s.Handle(proxy)
+			addr := serveTest(t, s)
+			defer s.Stop()
+			c := dialTest(t, addr)
+			defer c.Close()
+
+			entries, err := c.Search(SearchParams{BaseDN: "dc=example"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var dns []string
+			for _, e := range entries {
+				dns = append(dns, e.DN)
+			}
+			if !reflect.DeepEqual(dns, tt.entries) {
+				t.Errorf("entries %v, want %v", dns, tt.entries)
+			}
+
+			responses, err := c.Do(berOctetString(berClassApplication|ApplicationDelRequest, []byte("cn=x,dc=example")), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code, _ := resultCodeOf(&responses[len(responses)-1]); code != tt.code {
+				t.Errorf("delete result code %d, want %d", code, tt.code)
+			}
+		})
+	}
+}
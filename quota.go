@@ -0,0 +1,150 @@
+package ldapserver
+
+import (
+	"fmt"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// EntryCounter is implemented by the backends able to count their entries,
+// so naming context quotas can be enforced
+type EntryCounter interface {
+	// CountEntries returns the number of entries under base: its children
+	// with SearchRequestSingleLevel, all its descendants and itself with
+	// SearchRequestHomeSubtree
+	CountEntries(base string, scope int) (int, error)
+}
+
+// Quota limits the size of a naming context, for multi-tenant hosting.
+// Writes which would exceed it are answered with adminLimitExceeded.
+type Quota struct {
+	MaxEntries  int // optional number of entries of the context, its suffix included
+	MaxChildren int // optional number of children of any entry
+
+	// Counter counts the entries of the context, the context Handler is
+	// used if nil, and must then implement EntryCounter
+	Counter EntryCounter
+}
+
+// counter returns the EntryCounter of the quota of nc, nil when there is
+// none
+func (nc *NamingContext) counter() EntryCounter {
+	if nc.Quota.Counter != nil {
+		return nc.Quota.Counter
+	}
+	counter, _ := nc.Handler.(EntryCounter)
+	return counter
+}
+
+// CheckEntries returns an adminLimitExceeded *ResultError when adding
+// entries to the naming context suffix holding n entries exceeds
+// MaxEntries, nil otherwise
+func (q *Quota) CheckEntries(suffix string, n int, added int) error {
+	if q.MaxEntries > 0 && n+added > q.MaxEntries {
+		return NewResultError(LDAPResultAdminLimitExceeded, fmt.Sprintf("naming context %s can not hold more than %d entries", suffix, q.MaxEntries))
+	}
+	return nil
+}
+
+// CheckChildren returns an adminLimitExceeded *ResultError when adding an
+// entry under parent holding n children exceeds MaxChildren, nil otherwise
+func (q *Quota) CheckChildren(parent string, n int) error {
+	if q.MaxChildren > 0 && n+1 > q.MaxChildren {
+		return NewResultError(LDAPResultAdminLimitExceeded, fmt.Sprintf("%s can not have more than %d children", parent, q.MaxChildren))
+	}
+	return nil
+}
+
+// serveWithQuota passes m to the context handler, refusing the additions
+// exceeding the context quota. The writes adding entries are serialized so
+// concurrent additions can't exceed it together.
+func (nc *NamingContext) serveWithQuota(w ResponseWriter, m *Message) {
+	var parent, moved string
+	added := 0
+	switch r := m.ProtocolOp().(type) {
+	case ldap.AddRequest:
+		dn, err := ParseDN(string(r.Entry()))
+		if err != nil {
+			w.Write(NewResponseForRequest(r, LDAPResultInvalidDNSyntax, err.Error()))
+			return
+		}
+		added = 1
+		if dn.Parent().IsDescendantOf(nc.suffix, true) {
+			parent = dn.Parent().String()
+		}
+	case ldap.ModifyDNRequest:
+		if r.NewSuperior() == nil {
+			break
+		}
+		entry, err := ParseDN(string(r.Entry()))
+		if err != nil {
+			w.Write(NewResponseForRequest(r, LDAPResultInvalidDNSyntax, err.Error()))
+			return
+		}
+		dn, err := ParseDN(string(*r.NewSuperior()))
+		if err != nil {
+			w.Write(NewResponseForRequest(r, LDAPResultInvalidDNSyntax, err.Error()))
+			return
+		}
+		if dn.IsDescendantOf(nc.suffix, true) && !dn.Equal(entry.Parent()) {
+			parent = dn.String()
+		}
+		// a subtree moved within the context does not change its size,
+		// one moved into it adds its entries
+		if parent != "" && !entry.IsDescendantOf(nc.suffix, true) {
+			moved = entry.String()
+		}
+	}
+	if parent == "" && added == 0 {
+		nc.Handler.ServeLDAP(w, m)
+		return
+	}
+
+	nc.quotaMu.Lock()
+	defer nc.quotaMu.Unlock()
+	if err := nc.checkQuota(parent, added, moved); err != nil {
+		if re, ok := err.(*ResultError); ok {
+			m.logAt(LogLevelInfo, "quota of %s exceeded: %s", nc.Suffix, re.DiagnosticMessage)
+			w.Write(NewResponseForRequest(m.ProtocolOp(), re.ResultCode, re.DiagnosticMessage))
+			return
+		}
+		m.logAt(LogLevelError, "can not check the quota of %s: %s", nc.Suffix, err)
+		w.Write(NewResponseForRequest(m.ProtocolOp(), LDAPResultOther, "can not check the naming context quota"))
+		return
+	}
+	nc.Handler.ServeLDAP(w, m)
+}
+
+// checkQuota returns a *ResultError when adding added entries, or moving
+// the subtree moved, under parent exceeds the quota, another error when
+// the entries can't be counted
+func (nc *NamingContext) checkQuota(parent string, added int, moved string) error {
+	counter := nc.counter()
+	if moved != "" && nc.Quota.MaxEntries > 0 {
+		n, err := counter.CountEntries(moved, SearchRequestHomeSubtree)
+		if err != nil {
+			return err
+		}
+		added += n
+	}
+	if added > 0 && nc.Quota.MaxEntries > 0 {
+		n, err := counter.CountEntries(nc.Suffix, SearchRequestHomeSubtree)
+		if err != nil {
+			return err
+		}
+		if err := nc.Quota.CheckEntries(nc.Suffix, n, added); err != nil {
+			return err
+		}
+	}
+	// the suffix itself has no parent in the context
+	if parent != "" && nc.Quota.MaxChildren > 0 {
+		n, err := counter.CountEntries(parent, SearchRequestSingleLevel)
+		if err != nil {
+			return err
+		}
+		if err := nc.Quota.CheckChildren(parent, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
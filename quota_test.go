@@ -0,0 +1,52 @@
+package ldapserver
+
+import "testing"
+
+// countsCounter is an EntryCounter returning the counts of its map, by
+// base and scope
+type countsCounter map[string]int
+
+func (c countsCounter) CountEntries(base string, scope int) (int, error) {
+	return c[scopeName(scope)+" "+base], nil
+}
+
+func TestQuotaModifyDN(t *testing.T) {
+	nc := &NamingContext{
+		Suffix:  "dc=example,dc=com",
+		Handler: successHandler{},
+		Quota: &Quota{MaxEntries: 3, MaxChildren: 2, Counter: countsCounter{
+			"sub dc=example,dc=com":         2,
+			"one dc=example,dc=com":         1,
+			"one ou=full,dc=example,dc=com": 2,
+			"sub ou=x,dc=example,dc=net":    2,
+		}},
+	}
+	nc.suffix, _ = ParseDN(nc.Suffix)
+	modifyDN := func(entry string, newSuperior string) []byte {
+		return berConstructedTLV(berClassApplication|berConstructed|ApplicationModifyDNRequest,
+			berOctetString(berTagOctetString, []byte(entry)),
+			berOctetString(berTagOctetString, []byte("cn=a")),
+			berBoolean(berTagBoolean, true),
+			berOctetString(berClassContext|0, []byte(newSuperior)),
+		)
+	}
+	tests := []struct {
+		name    string
+		request []byte
+		code    int
+	}{
+		{"within the context", modifyDN("cn=a,ou=y,dc=example,dc=com", "dc=example,dc=com"), LDAPResultSuccess},
+		{"same parent", modifyDN("cn=a,ou=full,dc=example,dc=com", "ou=full,dc=example,dc=com"), LDAPResultSuccess},
+		{"beyond MaxChildren", modifyDN("cn=a,dc=example,dc=com", "ou=full,dc=example,dc=com"), LDAPResultAdminLimitExceeded},
+		{"subtree beyond MaxEntries", modifyDN("ou=x,dc=example,dc=net", "dc=example,dc=com"), LDAPResultAdminLimitExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := NewResponseRecorder()
+			nc.serveWithQuota(rec, testMessage(t, tt.request))
+			if code := rec.ResultCode(); code != tt.code {
+				t.Errorf("result code %d, want %d", code, tt.code)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package ldapserver
+
+import (
+	"context"
+	"time"
+)
+
+// Readiness is the state of the server in its lifecycle, for the service
+// discovery and load balancers it is registered with. It only moves
+// forward: starting, ready, draining then stopped.
+type Readiness int
+
+const (
+	ReadinessStarting Readiness = iota // not serving any listener yet
+	ReadinessReady                     // serving its first listener
+	ReadinessDraining                  // stopping, clients are still served during DrainDelay
+	ReadinessStopped                   // all client connections are closed
+)
+
+func (r Readiness) String() string {
+	switch r {
+	case ReadinessStarting:
+		return "starting"
+	case ReadinessReady:
+		return "ready"
+	case ReadinessDraining:
+		return "draining"
+	case ReadinessStopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+// ReadinessChanged is emitted when the server moves to a new Readiness
+type ReadinessChanged struct {
+	State Readiness
+}
+
+func (ReadinessChanged) event() {}
+
+// Readiness returns the current state of the server
+func (s *Server) Readiness() Readiness {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readiness
+}
+
+// ReadinessReached returns a channel closed once the server reached state,
+// or a later one:
+//
+//	<-srv.ReadinessReached(ldapserver.ReadinessReady)
+//	consul.Agent().ServiceRegister(registration)
+func (s *Server) ReadinessReached(state Readiness) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.readinessChans == nil {
+		s.readinessChans = make(map[Readiness]chan struct{})
+	}
+	ch, ok := s.readinessChans[state]
+	if !ok {
+		ch = make(chan struct{})
+		s.readinessChans[state] = ch
+	}
+	if s.readiness >= state {
+		select {
+		case <-ch:
+		default:
+			close(ch)
+		}
+	}
+	return ch
+}
+
+// setReadiness moves the server to state, unless it already reached it,
+// and notifies the OnReadiness callback, the event bus subscribers and the
+// ReadinessReached channels
+func (s *Server) setReadiness(state Readiness) {
+	// callbacks are serialized, so they see the transitions in order
+	s.readinessMu.Lock()
+	defer s.readinessMu.Unlock()
+
+	s.mu.Lock()
+	if state <= s.readiness {
+		s.mu.Unlock()
+		return
+	}
+	s.readiness = state
+	s.mu.Unlock()
+
+	s.logAt(LogLevelInfo, "server %s", state)
+	if s.OnReadiness != nil {
+		s.OnReadiness(state)
+	}
+	s.events.emit(ReadinessChanged{State: state})
+
+	s.mu.Lock()
+	for reached, ch := range s.readinessChans {
+		if reached <= state {
+			select {
+			case <-ch:
+			default:
+				close(ch)
+			}
+		}
+	}
+	s.mu.Unlock()
+}
+
+// drain moves the server to draining, once OnReadiness returned the
+// clients are still served for DrainDelay, or until ctx is done, before
+// they are sent the Notice of Disconnection
+func (s *Server) drain(ctx context.Context) {
+	select {
+	case <-s.chDone:
+		// already stopped, by Close for instance
+		return
+	default:
+	}
+	s.drainOnce.Do(func() {
+		s.setReadiness(ReadinessDraining)
+		s.drainDeadline = time.Now().Add(s.DrainDelay)
+	})
+	wait := time.Until(s.drainDeadline)
+	if wait <= 0 {
+		return
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,102 @@
+package ldapserver
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// isClosed reports whether ch is closed
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	stops := []struct {
+		name  string
+		stop  func(s *Server)
+		delay bool // DrainDelay applied
+	}{
+		{"Stop", func(s *Server) { s.Stop() }, true},
+		{"Close", func(s *Server) { s.Close() }, false},
+	}
+	for _, tt := range stops {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var states, events []Readiness
+			s := NewServer()
+			s.Handle(NewRouteMux())
+			s.DrainDelay = 200 * time.Millisecond
+			if !tt.delay {
+				s.DrainDelay = time.Hour
+			}
+			s.OnReadiness = func(state Readiness) {
+				mu.Lock()
+				states = append(states, state)
+				mu.Unlock()
+			}
+			s.Events().Subscribe(func(e Event) {
+				if e, ok := e.(ReadinessChanged); ok {
+					mu.Lock()
+					events = append(events, e.State)
+					mu.Unlock()
+				}
+			})
+
+			if state := s.Readiness(); state != ReadinessStarting {
+				t.Errorf("state %s before serving", state)
+			}
+			if !isClosed(s.ReadinessReached(ReadinessStarting)) {
+				t.Error("starting not reached before serving")
+			}
+			ready, stopped := s.ReadinessReached(ReadinessReady), s.ReadinessReached(ReadinessStopped)
+			if isClosed(ready) {
+				t.Error("ready reached before serving")
+			}
+
+			serveTest(t, s)
+			if !isClosed(ready) || s.Readiness() != ReadinessReady {
+				t.Errorf("state %s once serving", s.Readiness())
+			}
+
+			start := time.Now()
+			tt.stop(s)
+			if elapsed := time.Since(start); (elapsed >= 200*time.Millisecond) != tt.delay {
+				t.Errorf("stopped in %s, DrainDelay applied %t", elapsed, tt.delay)
+			}
+			if !isClosed(stopped) || s.Readiness() != ReadinessStopped {
+				t.Errorf("state %s once stopped", s.Readiness())
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			want := []Readiness{ReadinessReady, ReadinessDraining, ReadinessStopped}
+			if !reflect.DeepEqual(states, want) {
+				t.Errorf("OnReadiness called with %v, want %v", states, want)
+			}
+			if !reflect.DeepEqual(events, want) {
+				t.Errorf("events emitted for %v, want %v", events, want)
+			}
+		})
+	}
+}
+
+func TestReadinessString(t *testing.T) {
+	for state, want := range map[Readiness]string{
+		ReadinessStarting: "starting",
+		ReadinessReady:    "ready",
+		ReadinessDraining: "draining",
+		ReadinessStopped:  "stopped",
+		Readiness(9):      "unknown",
+	} {
+		if s := state.String(); s != want {
+			t.Errorf("%d.String() = %q, want %q", state, s, want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package ldapserver
+
+import (
+	"sync"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// ResponseRecorder is a ResponseWriter recording the responses written by
+// a handler, so handlers can be unit tested without a connection:
+//
+//	w := ldapserver.NewResponseRecorder()
+//	handleSearch(w, m)
+//	if w.ResultCode() != ldapserver.LDAPResultSuccess || len(w.Entries()) != 2 {
+//		t.Fail()
+//	}
+type ResponseRecorder struct {
+	MessageID int // message ID set on recorded messages
+
+	mu       sync.Mutex
+	messages []*ldap.LDAPMessage
+	terminal *ldap.LDAPMessage
+	errors   []error
+}
+
+// NewResponseRecorder returns an initialized ResponseRecorder
+func NewResponseRecorder() *ResponseRecorder {
+	return &ResponseRecorder{MessageID: 1}
+}
+
+func (r *ResponseRecorder) Write(po ldap.ProtocolOp) {
+	m := ldap.NewLDAPMessageWithProtocolOp(po)
+	r.WriteMessage(m)
+}
+
+func (r *ResponseRecorder) WriteMessage(m *ldap.LDAPMessage) {
+	ldap.SetMessageID(m, r.MessageID)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, m)
+	if isTerminalResponse(m.ProtocolOp()) {
+		r.terminal = m
+	}
+}
+
+func (r *ResponseRecorder) WriteRaw(protocolOp []byte) {
+	r.record(berSequence(berInteger(berTagInteger, int64(r.MessageID)), protocolOp))
+}
+
+func (r *ResponseRecorder) WriteRawWithControls(protocolOp []byte, controls [][]byte) {
+	r.record(encodeRawMessage(r.MessageID, protocolOp, controls))
+}
+
+func (r *ResponseRecorder) WriteEntries(entries []Entry) error {
+	var buf []byte
+	for i := range entries {
+		buf = appendSearchResultEntryMessage(buf, r.MessageID, &entries[i])
+	}
+	r.record(buf)
+	return nil
+}
+
+// record decodes and records BER encoded LDAPMessages
+func (r *ResponseRecorder) record(encoded []byte) {
+	for len(encoded) > 0 {
+		_, rest, err := berRead(encoded)
+		if err != nil {
+			r.fail(0, err)
+			return
+		}
+		packet := encoded[:len(encoded)-len(rest)]
+		m, err := decodeMessage(packet)
+		if err != nil {
+			r.fail(decodeErrorOffset(packet), err)
+			return
+		}
+		r.WriteMessage(&m)
+		encoded = rest
+	}
+}
+
+// fail records the error decoding a message at offset
+func (r *ResponseRecorder) fail(offset int, err error) {
+	r.mu.Lock()
+	r.errors = append(r.errors, &DecodeError{Offset: offset, Err: err})
+	r.mu.Unlock()
+}
+
+// Messages returns all the recorded messages, in order
+func (r *ResponseRecorder) Messages() []*ldap.LDAPMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*ldap.LDAPMessage(nil), r.messages...)
+}
+
+// Entries returns the recorded search result entries
+func (r *ResponseRecorder) Entries() []Entry {
+	var entries []Entry
+	for _, m := range r.Messages() {
+		if e, ok := m.ProtocolOp().(ldap.SearchResultEntry); ok {
+			entries = append(entries, entryFromSearchResultEntry(&e))
+		}
+	}
+	return entries
+}
+
+// Result returns the last terminal response written (a SearchResultDone,
+// a BindResponse...), or nil when there is none
+func (r *ResponseRecorder) Result() ldap.ProtocolOp {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.terminal == nil {
+		return nil
+	}
+	return r.terminal.ProtocolOp()
+}
+
+// ResultCode returns the result code of the terminal response, or -1 when
+// there is none
+func (r *ResponseRecorder) ResultCode() int {
+	r.mu.Lock()
+	terminal := r.terminal
+	r.mu.Unlock()
+	if terminal == nil {
+		return -1
+	}
+
+	code, err := resultCodeOf(terminal)
+	if err != nil {
+		return -1
+	}
+	return code
+}
+
+// Controls returns the controls attached to the terminal response
+func (r *ResponseRecorder) Controls() ldap.Controls {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.terminal == nil || r.terminal.Controls() == nil {
+		return nil
+	}
+	return *r.terminal.Controls()
+}
+
+// Errors returns the errors met decoding raw responses
+func (r *ResponseRecorder) Errors() []error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]error(nil), r.errors...)
+}
+
+func (r *ResponseRecorder) responded() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.terminal != nil
+}
+
+// resultCodeOf returns the resultCode of a response message, the first
+// element of every LDAPResult based protocolOp
+func resultCodeOf(m *ldap.LDAPMessage) (int, error) {
+	data, err := m.Write()
+	if err != nil {
+		return 0, err
+	}
+	message, _, err := berRead(data.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	children, err := berChildren(message.data)
+	if err != nil {
+		return 0, err
+	}
+	if len(children) < 2 {
+		return 0, errBERTruncated
+	}
+	result, err := berChildren(children[1].data)
+	if err != nil {
+		return 0, err
+	}
+	if len(result) == 0 || result[0].tag != berTagEnumerated {
+		return 0, errBERTruncated
+	}
+	code, err := berParseInteger(result[0].data)
+	return int(code), err
+}
@@ -0,0 +1,206 @@
+package ldapserver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// LogRequests returns a middleware logging each request once handled to
+// the server Logger, at level, with its duration and its parameters
+// rendered by DescribeRequest. Messages not read by a Server, as in tests,
+// are not logged.
+//
+//	routes.Use(ldapserver.LogRequests(ldapserver.LogLevelInfo))
+func LogRequests(level LogLevel) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w ResponseWriter, m *Message) {
+			start := time.Now()
+			next(w, m)
+			m.logAt(level, "%s (%s)", DescribeRequest(m), time.Since(start))
+		}
+	}
+}
+
+// DescribeRequest renders the parameters of the request m as a readable
+// string, such as:
+//
+//	SearchRequest base="dc=example,dc=com" scope=sub filter=(&(objectClass=person)(cn=j*)) attributes=[cn mail]
+//
+// Bind credentials and compared values are never included.
+func DescribeRequest(m *Message) string {
+	return describeRequest(m, escapeFilterValue)
+}
+
+// describeRequest renders the request m as DescribeRequest does, with the
+// filter assertion values rendered by value
+func describeRequest(m *Message, value func(v string) string) string {
+	switch r := m.ProtocolOp().(type) {
+	case ldap.BindRequest:
+		return fmt.Sprintf("BindRequest dn=%q method=%s", r.Name(), r.AuthenticationChoice())
+	case ldap.SearchRequest:
+		attributes := make([]string, len(r.Attributes()))
+		for i, a := range r.Attributes() {
+			attributes[i] = string(a)
+		}
+		return fmt.Sprintf("SearchRequest base=%q scope=%s filter=%s attributes=[%s]",
+			r.BaseObject(), scopeName(int(r.Scope())), filterString(r.Filter(), value), strings.Join(attributes, " "))
+	case ldap.AddRequest:
+		attributes := make([]string, len(r.Attributes()))
+		for i, a := range r.Attributes() {
+			attributes[i] = string(a.Type_())
+		}
+		return fmt.Sprintf("AddRequest dn=%q attributes=[%s]", r.Entry(), strings.Join(attributes, " "))
+	case ldap.ModifyRequest:
+		changes := make([]string, len(r.Changes()))
+		for i, change := range r.Changes() {
+			changes[i] = modifyOperationName(int(change.Operation())) + ":" + string(change.Modification().Type_())
+		}
+		return fmt.Sprintf("ModifyRequest dn=%q changes=[%s]", r.Object(), strings.Join(changes, " "))
+	case ldap.DelRequest:
+		return fmt.Sprintf("DelRequest dn=%q", string(r))
+	case ldap.ModifyDNRequest:
+		s := fmt.Sprintf("ModifyDNRequest dn=%q newrdn=%q deleteoldrdn=%t", r.Entry(), r.NewRDN(), bool(r.DeleteOldRDN()))
+		if r.NewSuperior() != nil {
+			s += fmt.Sprintf(" newsuperior=%q", string(*r.NewSuperior()))
+		}
+		return s
+	case ldap.CompareRequest:
+		return fmt.Sprintf("CompareRequest dn=%q attribute=%s", r.Entry(), r.Ava().AttributeDesc())
+	case ldap.ExtendedRequest:
+		return fmt.Sprintf("ExtendedRequest name=%s", r.RequestName())
+	case ldap.AbandonRequest:
+		return fmt.Sprintf("AbandonRequest messageID=%d", int(r))
+	}
+	return m.ProtocolOpName()
+}
+
+func scopeName(scope int) string {
+	switch scope {
+	case SearchRequestScopeBaseObject:
+		return "base"
+	case SearchRequestSingleLevel:
+		return "one"
+	case SearchRequestHomeSubtree:
+		return "sub"
+	}
+	return fmt.Sprintf("scope(%d)", scope)
+}
+
+func modifyOperationName(operation int) string {
+	switch operation {
+	case ModifyRequestChangeOperationAdd:
+		return "add"
+	case ModifyRequestChangeOperationDelete:
+		return "delete"
+	case ModifyRequestChangeOperationReplace:
+		return "replace"
+	}
+	return fmt.Sprintf("operation(%d)", operation)
+}
+
+// FilterString returns the RFC 4515 string representation of a filter,
+// to log the filter of a search request for instance
+func FilterString(f ldap.Filter) string {
+	return filterString(f, escapeFilterValue)
+}
+
+// filterString returns the string representation of f, with the assertion
+// values rendered by value
+func filterString(f ldap.Filter, value func(v string) string) string {
+	var b strings.Builder
+	writeFilter(&b, f, value)
+	return b.String()
+}
+
+func writeFilter(b *strings.Builder, f ldap.Filter, value func(v string) string) {
+	b.WriteByte('(')
+	switch f := f.(type) {
+	case ldap.FilterAnd:
+		b.WriteByte('&')
+		for _, child := range f {
+			writeFilter(b, child, value)
+		}
+	case ldap.FilterOr:
+		b.WriteByte('|')
+		for _, child := range f {
+			writeFilter(b, child, value)
+		}
+	case ldap.FilterNot:
+		b.WriteByte('!')
+		writeFilter(b, f.Filter, value)
+	case ldap.FilterEqualityMatch:
+		fmt.Fprintf(b, "%s=%s", f.AttributeDesc(), value(string(f.AssertionValue())))
+	case ldap.FilterApproxMatch:
+		fmt.Fprintf(b, "%s~=%s", f.AttributeDesc(), value(string(f.AssertionValue())))
+	case ldap.FilterGreaterOrEqual:
+		fmt.Fprintf(b, "%s>=%s", f.AttributeDesc(), value(string(f.AssertionValue())))
+	case ldap.FilterLessOrEqual:
+		fmt.Fprintf(b, "%s<=%s", f.AttributeDesc(), value(string(f.AssertionValue())))
+	case ldap.FilterPresent:
+		fmt.Fprintf(b, "%s=*", string(f))
+	case ldap.FilterSubstrings:
+		b.WriteString(string(f.Type_()))
+		b.WriteByte('=')
+		substrings := f.Substrings()
+		if len(substrings) == 0 || !isSubstringInitial(substrings[0]) {
+			b.WriteByte('*')
+		}
+		for _, s := range substrings {
+			switch s := s.(type) {
+			case ldap.SubstringInitial:
+				b.WriteString(value(string(s)))
+				b.WriteByte('*')
+			case ldap.SubstringAny:
+				b.WriteString(value(string(s)))
+				b.WriteByte('*')
+			case ldap.SubstringFinal:
+				b.WriteString(value(string(s)))
+			}
+		}
+	case ldap.FilterExtensibleMatch:
+		if f.Type_() != nil {
+			b.WriteString(string(*f.Type_()))
+		}
+		if f.DnAttributes() {
+			b.WriteString(":dn")
+		}
+		if f.MatchingRule() != nil {
+			b.WriteByte(':')
+			b.WriteString(string(*f.MatchingRule()))
+		}
+		b.WriteString(":=")
+		b.WriteString(value(string(f.MatchValue())))
+	default:
+		fmt.Fprintf(b, "?%T", f)
+	}
+	b.WriteByte(')')
+}
+
+func isSubstringInitial(s ldap.Substring) bool {
+	_, ok := s.(ldap.SubstringInitial)
+	return ok
+}
+
+// redactFilterValue renders every assertion value as "<redacted>", for the
+// filters kept in journals
+func redactFilterValue(v string) string {
+	return "<redacted>"
+}
+
+// escapeFilterValue escapes an assertion value as required by RFC 4515,
+// control characters are escaped too
+func escapeFilterValue(v string) string {
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c < 0x20 || c == 0x7f || c == '*' || c == '(' || c == ')' || c == '\\' {
+			fmt.Fprintf(&b, "\\%02x", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
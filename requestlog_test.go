@@ -0,0 +1,39 @@
+package ldapserver
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogRequests(t *testing.T) {
+	logged := make(chan string, 10)
+	s := NewServer()
+	s.Logger = LoggerFunc(func(level LogLevel, msg string, fields ...LogField) {
+		if level == LogLevelWarn {
+			logged <- msg
+		}
+	})
+	routes := NewRouteMux()
+	routes.Use(LogRequests(LogLevelWarn))
+	routes.Search(func(w ResponseWriter, m *Message) {
+		w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+	})
+	s.Handle(routes)
+	addr := serveTest(t, s)
+	defer s.Stop()
+	c := dialTest(t, addr)
+	defer c.Close()
+
+	if _, err := c.Search(SearchParams{BaseDN: "dc=example,dc=com", Filter: "(cn=alice)"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-logged:
+		if !strings.HasPrefix(msg, `SearchRequest base="dc=example,dc=com"`) {
+			t.Errorf("logged %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("request not logged to the server Logger")
+	}
+}
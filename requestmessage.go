@@ -0,0 +1,63 @@
+package ldapserver
+
+import ldap "github.com/ps78674/goldap/message"
+
+// NewSimpleBindRequest returns an LDAPv3 simple BindRequest
+func NewSimpleBindRequest(dn string, password string) ldap.BindRequest {
+	r := ldap.BindRequest{}
+	r.SetVersion(3)
+	r.SetName(ldap.LDAPDN(dn))
+	r.SetAuthentication(ldap.OCTETSTRING(password))
+	return r
+}
+
+// NewSASLBindRequest returns an LDAPv3 SASL BindRequest, credentials are
+// omitted when nil
+func NewSASLBindRequest(mechanism string, credentials []byte) ldap.BindRequest {
+	c := ldap.SaslCredentials{}
+	c.SetMechanism(ldap.LDAPString(mechanism))
+	if credentials != nil {
+		c.SetCredentials(ldap.OCTETSTRING(credentials))
+	}
+	r := ldap.BindRequest{}
+	r.SetVersion(3)
+	r.SetAuthentication(c)
+	return r
+}
+
+// NewSearchRequest returns the SearchRequest described by params, the
+// error reports an invalid filter
+func NewSearchRequest(params SearchParams) (ldap.SearchRequest, error) {
+	r := ldap.SearchRequest{}
+	filter := params.Filter
+	if filter == "" {
+		filter = "(objectClass=*)"
+	}
+	f, err := ParseFilter(filter)
+	if err != nil {
+		return r, err
+	}
+	attributes := make(ldap.AttributeSelection, len(params.Attributes))
+	for i, a := range params.Attributes {
+		attributes[i] = ldap.LDAPString(a)
+	}
+	r.SetBaseObject(ldap.LDAPDN(params.BaseDN))
+	r.SetScope(ldap.ENUMERATED(params.Scope))
+	r.SetDerefAliases(ldap.ENUMERATED(params.DerefAliases))
+	r.SetSizeLimit(ldap.INTEGER(params.SizeLimit))
+	r.SetTimeLimit(ldap.INTEGER(params.TimeLimit))
+	r.SetTypesOnly(ldap.BOOLEAN(params.TypesOnly))
+	r.SetFilter(f)
+	r.SetAttributes(attributes)
+	return r, nil
+}
+
+// NewExtendedRequest returns an ExtendedRequest, value is omitted when nil
+func NewExtendedRequest(name ldap.LDAPOID, value []byte) ldap.ExtendedRequest {
+	r := ldap.ExtendedRequest{}
+	r.SetRequestName(name)
+	if value != nil {
+		r.SetRequestValue(ldap.OCTETSTRING(value))
+	}
+	return r
+}
@@ -20,6 +20,12 @@ func NewExtendedResponse(resultCode int) ldap.ExtendedResponse {
 	return r
 }
 
+func NewModifyDNResponse(resultCode int) ldap.ModifyDNResponse {
+	r := ldap.ModifyDNResponse{}
+	r.SetResultCode(resultCode)
+	return r
+}
+
 func NewCompareResponse(resultCode int) ldap.CompareResponse {
 	r := ldap.CompareResponse{}
 	r.SetResultCode(resultCode)
@@ -55,3 +61,83 @@ func NewSearchResultEntry(objectname string) ldap.SearchResultEntry {
 	r.SetObjectName(objectname)
 	return r
 }
+
+// NewResponseForRequest returns a response of the type answering the request
+// operation, carrying resultCode and diagnosticMessage. It returns nil for
+// operations without response (AbandonRequest, UnbindRequest).
+func NewResponseForRequest(request ldap.ProtocolOp, resultCode int, diagnosticMessage string) ldap.ProtocolOp {
+	switch request.(type) {
+	case ldap.BindRequest:
+		r := NewBindResponse(resultCode)
+		r.SetDiagnosticMessage(diagnosticMessage)
+		return r
+	case ldap.SearchRequest:
+		r := NewSearchResultDoneResponse(resultCode)
+		r.SetDiagnosticMessage(diagnosticMessage)
+		return r
+	case ldap.ModifyRequest:
+		r := NewModifyResponse(resultCode)
+		r.SetDiagnosticMessage(diagnosticMessage)
+		return r
+	case ldap.AddRequest:
+		r := NewAddResponse(resultCode)
+		r.SetDiagnosticMessage(diagnosticMessage)
+		return r
+	case ldap.DelRequest:
+		r := NewDeleteResponse(resultCode)
+		r.SetDiagnosticMessage(diagnosticMessage)
+		return r
+	case ldap.ModifyDNRequest:
+		r := NewModifyDNResponse(resultCode)
+		r.SetDiagnosticMessage(diagnosticMessage)
+		return r
+	case ldap.CompareRequest:
+		r := NewCompareResponse(resultCode)
+		r.SetDiagnosticMessage(diagnosticMessage)
+		return r
+	case ldap.ExtendedRequest:
+		r := NewExtendedResponse(resultCode)
+		r.SetDiagnosticMessage(diagnosticMessage)
+		return r
+	case ldap.AbandonRequest, ldap.UnbindRequest:
+		return nil
+	}
+	r := NewResponse(resultCode)
+	r.SetDiagnosticMessage(diagnosticMessage)
+	return r
+}
+
+// ExtendedValueResponse is an ExtendedResponse carrying a responseValue,
+// it is written with WriteRaw(w, r.Bytes())
+type ExtendedValueResponse struct {
+	ResultCode        int
+	MatchedDN         string
+	DiagnosticMessage string
+	ResponseName      ldap.LDAPOID
+	ResponseValue     []byte // omitted when nil
+}
+
+func NewExtendedValueResponse(resultCode int, responseValue []byte) *ExtendedValueResponse {
+	return &ExtendedValueResponse{ResultCode: resultCode, ResponseValue: responseValue}
+}
+
+// Bytes returns the BER encoding of the response protocolOp
+func (r *ExtendedValueResponse) Bytes() []byte {
+	elements := encodeLDAPResult(r.ResultCode, r.MatchedDN, r.DiagnosticMessage)
+	if r.ResponseName != "" {
+		elements = append(elements, berOctetString(berClassContext|10, []byte(r.ResponseName)))
+	}
+	if r.ResponseValue != nil {
+		elements = append(elements, berOctetString(berClassContext|11, r.ResponseValue))
+	}
+	return berConstructedTLV(berClassApplication|berConstructed|ApplicationExtendedResponse, elements...)
+}
+
+// encodeLDAPResult returns the BER encoded components of a LDAPResult
+func encodeLDAPResult(resultCode int, matchedDN string, diagnosticMessage string) [][]byte {
+	return [][]byte{
+		berInteger(berTagEnumerated, int64(resultCode)),
+		berOctetString(berTagOctetString, []byte(matchedDN)),
+		berOctetString(berTagOctetString, []byte(diagnosticMessage)),
+	}
+}
@@ -0,0 +1,225 @@
+package ldapserver
+
+import (
+	"sort"
+	"strings"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// SetRootDSE makes the server answer the base searches of the empty DN
+// itself, before the Handler, with a RootDSE derived from the features in
+// use:
+//
+//   - namingContexts, the contexts mounted on a ContextMux Handler visible
+//     to the client
+//   - supportedLDAPVersion, 3, and 2 with AllowLDAPv2
+//   - supportedExtension, those the Handler reports, such as a RouteMux,
+//     and StartTLS with a TLSConfig
+//   - supportedControl, those the Handler reports, see
+//     RouteMux.SupportControls, and ProxiedAuthorization with a
+//     ProxyAuthorization hook
+//   - supportedSASLMechanisms, those of the SASL routed with
+//     RouteMux.SASLBind
+//
+// The RootDSEInfo attributes, such as vendorName, are added to them. attrs
+// adds attributes or replaces the derived ones, by case insensitive name;
+// an attribute with no values is removed. A nil attrs keeps the derived
+// attributes only. RootDSE searches then never reach the Handler, nor the
+// ContextMux RootDSE hook.
+func (s *Server) SetRootDSE(attrs map[string][]string) {
+	overrides := make(map[string][]string, len(attrs))
+	for name, values := range attrs {
+		overrides[name] = append([]string(nil), values...)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rootDSE = overrides
+}
+
+// namingContextLister is implemented by the handlers serving naming
+// contexts, such as ContextMux
+type namingContextLister interface {
+	visibleNamingContexts(serverName string) []string
+}
+
+// saslMechanismLister is implemented by the handlers serving SASL binds,
+// such as RouteMux
+type saslMechanismLister interface {
+	SupportedSASLMechanisms() []string
+}
+
+// rootDSEAttributes returns the RootDSE attributes set with SetRootDSE,
+// nil when the server does not serve the RootDSE itself
+func (s *Server) rootDSEAttributes() map[string][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rootDSE
+}
+
+// serveBuiltin answers the requests for the entries the server serves
+// itself, the RootDSE and the subschema subentry, it returns false for the
+// requests to pass to the Handler
+func (s *Server) serveBuiltin(handler Handler, w ResponseWriter, m *Message) bool {
+	return s.serveRootDSE(handler, w, m) || s.serveSubschema(w, m)
+}
+
+// serveRootDSE answers the RootDSE search m when SetRootDSE was called, it
+// returns false otherwise
+func (s *Server) serveRootDSE(handler Handler, w ResponseWriter, m *Message) bool {
+	if !isRootDSESearch(m.ProtocolOp()) {
+		return false
+	}
+	overrides := s.rootDSEAttributes()
+	if overrides == nil {
+		return false
+	}
+
+	e := Entry{DN: ""}
+	add := func(name string, values ...string) {
+		if len(values) == 0 {
+			return
+		}
+		attribute := EntryAttribute{Name: name}
+		for _, v := range values {
+			attribute.Values = append(attribute.Values, []byte(v))
+		}
+		e.Attributes = append(e.Attributes, attribute)
+	}
+
+	add("objectClass", "top")
+	if lister, ok := handler.(namingContextLister); ok {
+		add("namingContexts", lister.visibleNamingContexts(m.Client.ServerName())...)
+	}
+	if s.AllowLDAPv2 {
+		add("supportedLDAPVersion", "2", "3")
+	} else {
+		add("supportedLDAPVersion", "3")
+	}
+	add("supportedExtension", s.supportedExtensions(handler)...)
+	add("supportedControl", s.supportedControls(handler)...)
+	if lister, ok := handler.(saslMechanismLister); ok {
+		add("supportedSASLMechanisms", lister.SupportedSASLMechanisms()...)
+	}
+	if schema, dn := s.subschema(); schema != nil {
+		add("subschemaSubentry", dn.String())
+	}
+
+	replace := func(attrs map[string][]string) {
+		names := make([]string, 0, len(attrs))
+		for name := range attrs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			e.Attributes = removeAttribute(e.Attributes, name)
+			add(name, attrs[name]...)
+		}
+	}
+	if s.RootDSEInfo != nil {
+		replace(s.rootDSEInfo(s.RootDSEInfo))
+	}
+	replace(overrides)
+
+	// the RootDSE attributes but objectClass are operational (RFC 4512
+	// section 5.1), all of them are returned to the searches listing none
+	r := m.GetSearchRequest()
+	isOperational := func(name string) bool { return name != "objectclass" }
+	if len(r.Attributes()) == 0 {
+		isOperational = func(name string) bool { return false }
+	}
+	e = selectAttributes(e, r, isOperational)
+	if err := WriteEntries(w, []Entry{e}); err != nil {
+		return true
+	}
+	w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+	return true
+}
+
+// supportedExtensions returns the RootDSE supportedExtension values
+func (s *Server) supportedExtensions(handler Handler) []string {
+	var oids []string
+	if lister, ok := handler.(extensionLister); ok {
+		for _, oid := range lister.SupportedExtensions() {
+			oids = append(oids, string(oid))
+		}
+	}
+	if s.TLSConfig != nil {
+		found := false
+		for _, oid := range oids {
+			found = found || oid == string(NoticeOfStartTLS)
+		}
+		if !found {
+			oids = append(oids, string(NoticeOfStartTLS))
+		}
+	}
+	return oids
+}
+
+// supportedControls returns the RootDSE supportedControl values, those
+// the Handler reports, such as a RouteMux, and ProxiedAuthorization with a
+// ProxyAuthorization hook
+func (s *Server) supportedControls(handler Handler) []string {
+	var oids []string
+	seen := make(map[ldap.LDAPOID]bool)
+	add := func(oid ldap.LDAPOID) {
+		if !seen[oid] {
+			seen[oid] = true
+			oids = append(oids, string(oid))
+		}
+	}
+	if lister, ok := handler.(controlLister); ok {
+		for _, oid := range lister.SupportedControls() {
+			add(oid)
+		}
+	}
+	if s.ProxyAuthorization != nil {
+		add(ControlProxiedAuthz)
+	}
+	sort.Strings(oids)
+	return oids
+}
+
+// removeAttribute returns attributes without the attribute name
+func removeAttribute(attributes []EntryAttribute, name string) []EntryAttribute {
+	kept := attributes[:0]
+	for _, a := range attributes {
+		if !strings.EqualFold(a.Name, name) {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+// visibleNamingContexts returns the suffixes of the contexts visible to
+// the clients which sent the SNI name serverName
+func (mux *ContextMux) visibleNamingContexts(serverName string) []string {
+	return mux.namingContexts(func(nc *NamingContext) bool { return nc.visible(serverName) })
+}
+
+// SupportedSASLMechanisms returns the SASL mechanisms served by the Default
+// handler and the naming contexts handlers which report them
+func (mux *ContextMux) SupportedSASLMechanisms() []string {
+	handlers := []Handler{mux.Default}
+	mux.mu.RLock()
+	for _, nc := range mux.contexts {
+		handlers = append(handlers, nc.Handler)
+	}
+	mux.mu.RUnlock()
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, h := range handlers {
+		lister, ok := h.(saslMechanismLister)
+		if !ok {
+			continue
+		}
+		for _, name := range lister.SupportedSASLMechanisms() {
+			if !seen[strings.ToUpper(name)] {
+				seen[strings.ToUpper(name)] = true
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,82 @@
+package ldapserver
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+// RootDSEInfo configures the vendor and implementation specific attributes
+// of the RootDSE served with SetRootDSE, identifying the server to fleet
+// inventory tools. The attributes set with SetRootDSE replace them.
+type RootDSEInfo struct {
+	// VendorName is the vendorName value (RFC 3045), omitted if empty
+	VendorName string
+
+	// VendorVersion is the vendorVersion value, the version of the main
+	// module of the binary if empty, omitted if it is unknown too
+	VendorVersion string
+
+	// BuildInfo adds goVersion, the Go release the server was built with,
+	// and buildPath and buildVersion, the path and version of its main
+	// module when known
+	BuildInfo bool
+
+	// Uptime adds startTime, in generalized time, and uptime, in seconds
+	Uptime bool
+
+	// Connections adds currentConnections and totalConnections, the
+	// connections being served and accepted since start
+	Connections bool
+
+	// Attributes, if non-nil, returns more attributes on each RootDSE
+	// search, by name
+	Attributes func() map[string][]string
+}
+
+// rootDSEInfo returns the attributes of the RootDSE configured by info
+func (s *Server) rootDSEInfo(info *RootDSEInfo) map[string][]string {
+	attrs := make(map[string][]string)
+	set := func(name string, value string) {
+		if value != "" {
+			attrs[name] = []string{value}
+		}
+	}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		build = &debug.BuildInfo{}
+	}
+	set("vendorName", info.VendorName)
+	if info.VendorVersion != "" {
+		set("vendorVersion", info.VendorVersion)
+	} else if build.Main.Version != "(devel)" {
+		set("vendorVersion", build.Main.Version)
+	}
+	if info.BuildInfo {
+		set("goVersion", runtime.Version())
+		set("buildPath", build.Main.Path)
+		set("buildVersion", build.Main.Version)
+	}
+	if info.Uptime {
+		s.mu.Lock()
+		started := s.started
+		s.mu.Unlock()
+		if !started.IsZero() {
+			set("startTime", started.UTC().Format("20060102150405Z"))
+			set("uptime", strconv.FormatInt(int64(time.Since(started)/time.Second), 10))
+		}
+	}
+	if info.Connections {
+		stats := s.Stats()
+		set("currentConnections", strconv.Itoa(stats.Connections))
+		set("totalConnections", strconv.Itoa(stats.TotalConnections))
+	}
+	if info.Attributes != nil {
+		for name, values := range info.Attributes() {
+			attrs[name] = values
+		}
+	}
+	return attrs
+}
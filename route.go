@@ -1,7 +1,11 @@
 package ldapserver
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"strings"
+	"time"
 
 	ldap "github.com/ps78674/goldap/message"
 )
@@ -24,10 +28,39 @@ const (
 // Handler object that calls f.
 type HandlerFunc func(ResponseWriter, *Message)
 
+// ErrorHandlerFunc is a handler returning an error, which is translated
+// into a response by RouteMux.HandleErrors
+type ErrorHandlerFunc func(ResponseWriter, *Message) error
+
+// ErrorMapper translates an error returned by a handler into the result
+// code and diagnostic message of the response
+type ErrorMapper func(err error) (resultCode int, diagnosticMessage string)
+
+// DefaultErrorMapper answers ResultError with their result code, and any
+// other error with operationsError
+func DefaultErrorMapper(err error) (int, string) {
+	var re *ResultError
+	if errors.As(err, &re) {
+		return re.ResultCode, re.DiagnosticMessage
+	}
+	return LDAPResultOperationsError, err.Error()
+}
+
+// Middleware wraps the handling of requests, to run code before and after
+// the routed handler, see RouteMux.Use
+type Middleware func(next HandlerFunc) HandlerFunc
+
 // RouteMux manages all routes
 type RouteMux struct {
 	routes        []*route
 	notFoundRoute *route
+	middlewares   []Middleware
+	sasl          []*SASL        // see SASLBind
+	controls      []ldap.LDAPOID // see SupportControls
+
+	// ErrorMapper translates the errors returned by handlers registered
+	// with HandleErrors, DefaultErrorMapper is used if nil
+	ErrorMapper ErrorMapper
 }
 
 type route struct {
@@ -43,6 +76,15 @@ type route struct {
 	uScope      bool
 	sAuthChoice string
 	uAuthChoice bool
+	sAttribute  string
+	uAttribute  bool
+	sSuffix     DN
+	uSuffix     bool
+	transform   EntryTransform
+	timeout     time.Duration
+	config      bool // the ConfigBackend modify route, served in read-only and maintenance modes
+
+	filterConditions []func(f ldap.Filter) bool // see FilterEquality and FilterAttribute
 }
 
 // Match return true when the *Message matches the route
@@ -51,6 +93,9 @@ func (r *route) Match(m *Message) bool {
 	if m.ProtocolOpName() != r.operation {
 		return false
 	}
+	if !r.matchConditions(m) {
+		return false
+	}
 
 	switch v := m.ProtocolOp().(type) {
 	case ldap.BindRequest:
@@ -67,6 +112,26 @@ func (r *route) Match(m *Message) bool {
 		}
 		return true
 
+	case ldap.CompareRequest:
+		if r.uAttribute == true {
+			attribute := strings.ToLower(string(v.Ava().AttributeDesc()))
+			if i := strings.IndexByte(attribute, ';'); i >= 0 {
+				attribute = attribute[:i]
+			}
+			if attribute != r.sAttribute {
+				return false
+			}
+		}
+		return true
+
+	case ldap.ModifyRequest:
+		if r.uBasedn == true {
+			if strings.ToLower(string(v.Object())) != r.sBasedn {
+				return false
+			}
+		}
+		return true
+
 	case ldap.SearchRequest:
 		if r.uBasedn == true {
 			if strings.ToLower(string(v.BaseObject())) != r.sBasedn {
@@ -95,6 +160,7 @@ func (r *route) Label(label string) *route {
 	return r
 }
 
+// BaseDn matches the search base, or the modified entry of a ModifyRequest
 func (r *route) BaseDn(dn string) *route {
 	r.sBasedn = strings.ToLower(dn)
 	r.uBasedn = true
@@ -113,12 +179,34 @@ func (r *route) Filter(pattern string) *route {
 	return r
 }
 
+// Attribute matches the attribute of a CompareRequest, options excepted,
+// to route password compares to an authentication handler for instance
+func (r *route) Attribute(name string) *route {
+	r.sAttribute = strings.ToLower(name)
+	r.uAttribute = true
+	return r
+}
+
 func (r *route) Scope(scope int) *route {
 	r.sScope = scope
 	r.uScope = true
 	return r
 }
 
+// TransformEntries applies f to the search result entries written by the
+// route handler, before the server EntryTransform
+func (r *route) TransformEntries(f EntryTransform) *route {
+	r.transform = f
+	return r
+}
+
+// Timeout sets the deadline of the Context of the requests served by the
+// route handler, see Message.Deadline
+func (r *route) Timeout(d time.Duration) *route {
+	r.timeout = d
+	return r
+}
+
 func (r *route) RequestName(name ldap.LDAPOID) *route {
 	r.exoName = string(name)
 	return r
@@ -135,17 +223,64 @@ type Handler interface {
 	ServeLDAP(w ResponseWriter, r *Message)
 }
 
+// Use adds middlewares wrapping the handling of all requests, the first one
+// added is the outermost
+func (h *RouteMux) Use(middlewares ...Middleware) {
+	h.middlewares = append(h.middlewares, middlewares...)
+}
+
 // ServeLDAP dispatches the request to the handler whose
-// pattern most closely matches the request request Message.
+// pattern most closely matches the request request Message. The first
+// route added matching the request serves it, unless it is a BaseDnSuffix
+// or filter route: the most specific of the BaseDnSuffix and filter routes
+// matching the request wins then, an exact BaseDn, then the longest
+// BaseDnSuffix, then the route with the most conditions, the first added
+// among equals.
 func (h *RouteMux) ServeLDAP(w ResponseWriter, r *Message) {
+	if len(h.middlewares) == 0 {
+		h.serve(w, r)
+		return
+	}
+	handler := HandlerFunc(h.serve)
+	for i := len(h.middlewares) - 1; i >= 0; i-- {
+		handler = h.middlewares[i](handler)
+	}
+	handler(w, r)
+}
 
-	//find a matching Route
-	for _, route := range h.routes {
+// match returns the index of the route serving r, or -1 when none does
+func (h *RouteMux) match(r *Message) int {
+	//find the first matching Route, or the most specific matching one
+	//when it is a BaseDnSuffix or filter route
+	best := -1
+	for i, route := range h.routes {
 
 		//if the route don't match, skip it
 		if route.Match(r) == false {
 			continue
 		}
+		if best < 0 {
+			best = i
+			if !route.ranked() {
+				break
+			}
+		} else if route.ranked() && route.moreSpecific(h.routes[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// routesToConfig reports whether r is served by the ConfigBackend modify
+// route
+func (h *RouteMux) routesToConfig(r *Message) bool {
+	best := h.match(r)
+	return best >= 0 && h.routes[best].config
+}
+
+func (h *RouteMux) serve(w ResponseWriter, r *Message) {
+	if best := h.match(r); best >= 0 {
+		i, route := best, h.routes[best]
 
 		// if route.label != "" {
 		// 	log.Printf("")
@@ -154,17 +289,40 @@ func (h *RouteMux) ServeLDAP(w ResponseWriter, r *Message) {
 		// 	// Logger.Printf(" ROUTE MATCH ; %s", runtime.FuncForPC(reflect.ValueOf(route.handler).Pointer()).Name())
 		// }
 
-		route.handler(w, r)
+		if route.timeout != 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), route.timeout)
+			defer cancel()
+			r.ctx = ctx
+		}
+		route.serve(i, newTransformWriter(w, r, route.transform), r)
 		return
 	}
 
 	// Catch a AbandonRequest not handled by user
 	switch v := r.ProtocolOp().(type) {
 	case ldap.AbandonRequest:
-		// retreive the request to abandon, and send a abort signal to it
-		if requestToAbandon, ok := r.Client.GetMessageByID(int(v)); ok {
-			requestToAbandon.Abandon()
+		// abandons have no response (RFC 4511 section 4.11)
+		HandleAbandon(w, r)
+		return
+	case ldap.ExtendedRequest:
+		if v.RequestName() == NoticeOfCancel {
+			handleCancel(w, r)
+			return
 		}
+		if v.RequestName() == NoticeOfWhoAmI {
+			HandleWhoAmI(w, r)
+			return
+		}
+		if canStartTLS(r) {
+			HandleStartTLS(w, r)
+			return
+		}
+		// RFC 4511 section 4.12, unrecognized requestNames are answered
+		// with protocolError
+		res := NewExtendedResponse(LDAPResultProtocolError)
+		res.SetDiagnosticMessage(unsupportedExtensionMessage(v.RequestName(), h.SupportedExtensions()))
+		w.Write(res)
+		return
 	}
 
 	if h.notFoundRoute != nil {
@@ -176,6 +334,72 @@ func (h *RouteMux) ServeLDAP(w ResponseWriter, r *Message) {
 	}
 }
 
+// SupportedExtensions returns the requestNames of the extended operations
+// served, those routed and the ones handled by default, as listed in the
+// RootDSE supportedExtension attribute
+func (h *RouteMux) SupportedExtensions() []ldap.LDAPOID {
+	oids := []ldap.LDAPOID{NoticeOfCancel, NoticeOfWhoAmI}
+	seen := map[ldap.LDAPOID]bool{NoticeOfCancel: true, NoticeOfWhoAmI: true}
+	for _, route := range h.routes {
+		oid := ldap.LDAPOID(route.exoName)
+		if route.operation != EXTENDED || oid == "" || seen[oid] {
+			continue
+		}
+		seen[oid] = true
+		oids = append(oids, oid)
+	}
+	return oids
+}
+
+// SupportControls declares that the routed handlers honor the request
+// controls oids, the Relax Rules and No-Op controls are refused otherwise
+func (h *RouteMux) SupportControls(oids ...ldap.LDAPOID) {
+	for _, oid := range oids {
+		if !supportsControl(h, oid) {
+			h.controls = append(h.controls, oid)
+		}
+	}
+}
+
+// SupportedControls returns the request controls declared with
+// SupportControls
+func (h *RouteMux) SupportedControls() []ldap.LDAPOID {
+	return append([]ldap.LDAPOID(nil), h.controls...)
+}
+
+func unsupportedExtensionMessage(name ldap.LDAPOID, supported []ldap.LDAPOID) string {
+	names := make([]string, len(supported))
+	for i, oid := range supported {
+		names[i] = string(oid)
+	}
+	return fmt.Sprintf("extended operation %s is not supported, supported extensions are %s (see supportedExtension in the RootDSE)",
+		name, strings.Join(names, ", "))
+}
+
+// HandleErrors adapts an error returning handler to a HandlerFunc. When the
+// handler returns an error without having written the final response, the
+// error is translated into one by the RouteMux ErrorMapper.
+func (h *RouteMux) HandleErrors(f ErrorHandlerFunc) HandlerFunc {
+	return func(w ResponseWriter, r *Message) {
+		err := f(w, r)
+		if err == nil {
+			return
+		}
+		if rw, ok := w.(interface{ responded() bool }); ok && rw.responded() {
+			return
+		}
+
+		mapper := h.ErrorMapper
+		if mapper == nil {
+			mapper = DefaultErrorMapper
+		}
+		resultCode, diagnosticMessage := mapper(err)
+		if res := NewResponseForRequest(r.ProtocolOp(), resultCode, diagnosticMessage); res != nil {
+			w.Write(res)
+		}
+	}
+}
+
 // Adds a new Route to the Handler
 func (h *RouteMux) addRoute(r *route) {
 	//and finally append to the list of Routes
@@ -0,0 +1,79 @@
+package ldapserver
+
+import "testing"
+
+func TestRouteMuxOrder(t *testing.T) {
+	type routeSpec struct {
+		name  string
+		setup func(r *route)
+	}
+	plain := func(r *route) {}
+	suffix := func(dn string) func(r *route) {
+		return func(r *route) { r.BaseDnSuffix(dn) }
+	}
+	tests := []struct {
+		name   string
+		routes []routeSpec
+		base   string
+		want   string
+	}{
+		{"first plain route", []routeSpec{
+			{"all", plain},
+			{"people", suffix("ou=people,dc=example,dc=com")},
+		}, "uid=a,ou=people,dc=example,dc=com", "all"},
+		{"first suffix route", []routeSpec{
+			{"people", suffix("ou=people,dc=example,dc=com")},
+			{"all", plain},
+		}, "uid=a,ou=people,dc=example,dc=com", "people"},
+		{"longest suffix", []routeSpec{
+			{"example", suffix("dc=example,dc=com")},
+			{"people", suffix("ou=people,dc=example,dc=com")},
+		}, "uid=a,ou=people,dc=example,dc=com", "people"},
+		{"suffix not matching", []routeSpec{
+			{"people", suffix("ou=people,dc=example,dc=com")},
+			{"example", suffix("dc=example,dc=com")},
+		}, "ou=groups,dc=example,dc=com", "example"},
+		{"exact base before suffix", []routeSpec{
+			{"example", suffix("dc=example,dc=com")},
+			{"base", func(r *route) { r.BaseDn("ou=people,dc=example,dc=com").BaseDnSuffix("dc=example,dc=com") }},
+		}, "ou=people,dc=example,dc=com", "base"},
+		{"filter route", []routeSpec{
+			{"example", suffix("dc=example,dc=com")},
+			{"present", func(r *route) { r.BaseDnSuffix("dc=example,dc=com").FilterAttribute("objectClass") }},
+			{"all", plain},
+		}, "dc=example,dc=com", "present"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var served string
+			mux := NewRouteMux()
+			for _, spec := range tt.routes {
+				name := spec.name
+				spec.setup(mux.Search(func(w ResponseWriter, m *Message) {
+					served = name
+				}))
+			}
+			mux.ServeLDAP(NewResponseRecorder(), testMessage(t, testSearchRequest(tt.base, 0, 0)))
+			if served != tt.want {
+				t.Errorf("served by %q, want %q", served, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteMuxAbandon(t *testing.T) {
+	abandon := berInteger(berClassApplication|ApplicationAbandonRequest, 2)
+	for _, notFound := range []bool{false, true} {
+		mux := NewRouteMux()
+		if notFound {
+			mux.NotFound(func(w ResponseWriter, m *Message) {
+				w.Write(NewResponse(LDAPResultUnwillingToPerform))
+			})
+		}
+		w := NewResponseRecorder()
+		mux.ServeLDAP(w, testMessage(t, abandon))
+		if n := len(w.Messages()); n != 0 {
+			t.Errorf("notFound route %t: abandon answered with %d messages", notFound, n)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package ldapserver
+
+import (
+	"fmt"
+	"strings"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// BaseDnSuffix matches the requests targeting dn or an entry below it: the
+// search base, or the added, modified, deleted or compared entry. Among the
+// BaseDnSuffix and filter routes matching a request, the one with the
+// longest suffix wins, see RouteMux.ServeLDAP. BaseDnSuffix panics when dn is not a valid DN.
+func (r *route) BaseDnSuffix(dn string) *route {
+	suffix, err := ParseDN(dn)
+	if err != nil {
+		panic(fmt.Sprintf("ldapserver: invalid route suffix %q: %s", dn, err))
+	}
+	r.sSuffix = suffix
+	r.uSuffix = true
+	return r
+}
+
+// FilterEquality matches the searches whose filter requires attribute to
+// equal value: the filter is this equality assertion, or an AND of filters
+// holding it, such as (&(objectClass=person)(uid=jdoe)) for
+// FilterEquality("objectClass", "person"). Values are compared ignoring
+// case.
+func (r *route) FilterEquality(attribute, value string) *route {
+	r.filterConditions = append(r.filterConditions, func(f ldap.Filter) bool {
+		return filterRequiresEquality(f, attribute, value)
+	})
+	return r
+}
+
+// FilterAttribute matches the searches whose filter asserts anything about
+// attribute, at any depth
+func (r *route) FilterAttribute(attribute string) *route {
+	r.filterConditions = append(r.filterConditions, func(f ldap.Filter) bool {
+		return filterMentions(f, attribute)
+	})
+	return r
+}
+
+// matchConditions reports whether m matches the BaseDnSuffix and filter
+// conditions of the route
+func (r *route) matchConditions(m *Message) bool {
+	if r.uSuffix {
+		dns := requestDNs(m.ProtocolOp())
+		if _, ok := m.ProtocolOp().(ldap.BindRequest); ok || len(dns) == 0 {
+			return false
+		}
+		dn, err := ParseDN(dns[0])
+		if err != nil || !dn.IsDescendantOf(r.sSuffix, true) {
+			return false
+		}
+	}
+	if len(r.filterConditions) > 0 {
+		search, ok := m.ProtocolOp().(ldap.SearchRequest)
+		if !ok {
+			return false
+		}
+		for _, condition := range r.filterConditions {
+			if !condition(search.Filter()) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// moreSpecific reports whether the route r is more specific than other:
+// an exact BaseDn beats a BaseDnSuffix, a longer suffix beats a shorter
+// one, then the route with more conditions wins
+func (r *route) moreSpecific(other *route) bool {
+	if r.uBasedn != other.uBasedn {
+		return r.uBasedn
+	}
+	if len(r.sSuffix) != len(other.sSuffix) {
+		return len(r.sSuffix) > len(other.sSuffix)
+	}
+	return r.conditions() > other.conditions()
+}
+
+// ranked reports whether the route is ranked by specificity against the
+// other BaseDnSuffix and filter routes matching a request, instead of
+// serving it for having been added first
+func (r *route) ranked() bool {
+	return r.uSuffix || len(r.filterConditions) > 0
+}
+
+// conditions returns the number of conditions of the route
+func (r *route) conditions() int {
+	n := len(r.filterConditions)
+	for _, set := range []bool{r.uSuffix, r.uBasedn, r.uFilter, r.uScope, r.uAuthChoice, r.uAttribute, r.exoName != ""} {
+		if set {
+			n++
+		}
+	}
+	return n
+}
+
+// filterRequiresEquality reports whether f only matches entries whose
+// attribute equals value
+func filterRequiresEquality(f ldap.Filter, attribute, value string) bool {
+	switch f := f.(type) {
+	case ldap.FilterEqualityMatch:
+		return strings.EqualFold(attributeType(string(f.AttributeDesc())), attribute) &&
+			strings.EqualFold(string(f.AssertionValue()), value)
+	case ldap.FilterAnd:
+		for _, child := range f {
+			if filterRequiresEquality(child, attribute, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// filterMentions reports whether f asserts anything about attribute
+func filterMentions(f ldap.Filter, attribute string) bool {
+	var name string
+	switch f := f.(type) {
+	case ldap.FilterAnd:
+		for _, child := range f {
+			if filterMentions(child, attribute) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterOr:
+		for _, child := range f {
+			if filterMentions(child, attribute) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return filterMentions(f.Filter, attribute)
+	case ldap.FilterPresent:
+		name = string(f)
+	case ldap.FilterEqualityMatch:
+		name = string(f.AttributeDesc())
+	case ldap.FilterApproxMatch:
+		name = string(f.AttributeDesc())
+	case ldap.FilterGreaterOrEqual:
+		name = string(f.AttributeDesc())
+	case ldap.FilterLessOrEqual:
+		name = string(f.AttributeDesc())
+	case ldap.FilterSubstrings:
+		name = string(f.Type_())
+	case ldap.FilterExtensibleMatch:
+		if f.Type_() != nil {
+			name = string(*f.Type_())
+		}
+	}
+	return strings.EqualFold(attributeType(name), attribute)
+}
@@ -0,0 +1,167 @@
+package ldapserver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// RouteSpec describes a route in data, to define the routing of a RouteMux
+// from an application configuration file, see RouteMux.AddRoutes. Empty
+// conditions match any request.
+type RouteSpec struct {
+	// Operation is the request type: bind, search, add, delete, modify,
+	// compare, extended, abandon, or notFound for the fallback route
+	Operation string `json:"operation" yaml:"operation"`
+
+	// Handler is the name of the handler serving the route
+	Handler string `json:"handler" yaml:"handler"`
+
+	Label                string   `json:"label" yaml:"label"`
+	BaseDN               string   `json:"baseDN" yaml:"baseDN"`                             // search base or modified entry
+	Scope                string   `json:"scope" yaml:"scope"`                               // base, one or sub
+	Filter               string   `json:"filter" yaml:"filter"`                             // search filter
+	AuthenticationChoice string   `json:"authenticationChoice" yaml:"authenticationChoice"` // simple or sasl
+	Attribute            string   `json:"attribute" yaml:"attribute"`                       // compared attribute
+	RequestName          string   `json:"requestName" yaml:"requestName"`                   // extended request OID
+	Timeout              Duration `json:"timeout" yaml:"timeout"`
+
+	BaseDNSuffix   string            `json:"baseDNSuffix" yaml:"baseDNSuffix"`     // search base or target entry at or below this DN
+	FilterEquality map[string]string `json:"filterEquality" yaml:"filterEquality"` // equality assertions required by the search filter, by attribute
+}
+
+// AddRoutes adds the routes described by specs, in order, looking their
+// handlers up by name in handlers. No route is added when a spec is
+// invalid, or when several specs describe the notFound route.
+func (h *RouteMux) AddRoutes(specs []RouteSpec, handlers map[string]HandlerFunc) error {
+	routes := make([]*route, len(specs))
+	notFound := -1
+	for i, spec := range specs {
+		r, err := spec.route(handlers)
+		if err != nil {
+			return fmt.Errorf("route %d: %s", i, err)
+		}
+		if r.operation == "" {
+			if notFound >= 0 {
+				return fmt.Errorf("route %d: notFound route already defined by route %d", i, notFound)
+			}
+			notFound = i
+		}
+		routes[i] = r
+	}
+
+	for _, r := range routes {
+		if r.operation == "" {
+			h.notFoundRoute = r
+		} else {
+			h.addRoute(r)
+		}
+	}
+	return nil
+}
+
+// route returns the route described by spec
+func (spec RouteSpec) route(handlers map[string]HandlerFunc) (*route, error) {
+	handler, ok := handlers[spec.Handler]
+	if !ok || handler == nil {
+		return nil, fmt.Errorf("unknown handler %q", spec.Handler)
+	}
+	r := &route{handler: handler}
+
+	operations := map[string]string{
+		"bind":     BIND,
+		"search":   SEARCH,
+		"add":      ADD,
+		"delete":   DELETE,
+		"modify":   MODIFY,
+		"compare":  COMPARE,
+		"extended": EXTENDED,
+		"abandon":  ABANDON,
+		"notfound": "",
+	}
+	operation, ok := operations[strings.ToLower(spec.Operation)]
+	if !ok {
+		return nil, fmt.Errorf("unknown operation %q", spec.Operation)
+	}
+	r.operation = operation
+
+	// conditions the operation does not check would silently match any
+	// request of the route
+	conditions := []struct {
+		value      string
+		name       string
+		operations []string
+	}{
+		{spec.BaseDN, "baseDN", []string{SEARCH, MODIFY}},
+		{spec.BaseDNSuffix, "baseDNSuffix", []string{SEARCH, ADD, DELETE, MODIFY, COMPARE}},
+		{spec.Scope, "scope", []string{SEARCH}},
+		{spec.Filter, "filter", []string{SEARCH}},
+		{spec.AuthenticationChoice, "authenticationChoice", []string{BIND}},
+		{spec.Attribute, "attribute", []string{COMPARE}},
+		{spec.RequestName, "requestName", []string{EXTENDED}},
+	}
+	if len(spec.FilterEquality) > 0 && operation != SEARCH {
+		return nil, fmt.Errorf("filterEquality does not apply to %s routes", spec.Operation)
+	}
+	for _, c := range conditions {
+		if c.value == "" {
+			continue
+		}
+		allowed := false
+		for _, op := range c.operations {
+			allowed = allowed || op == operation
+		}
+		if !allowed {
+			return nil, fmt.Errorf("%s does not apply to %s routes", c.name, spec.Operation)
+		}
+	}
+
+	if spec.Label != "" {
+		r.Label(spec.Label)
+	}
+	if spec.BaseDN != "" {
+		r.BaseDn(spec.BaseDN)
+	}
+	if spec.BaseDNSuffix != "" {
+		if _, err := ParseDN(spec.BaseDNSuffix); err != nil {
+			return nil, fmt.Errorf("invalid baseDNSuffix %q: %s", spec.BaseDNSuffix, err)
+		}
+		r.BaseDnSuffix(spec.BaseDNSuffix)
+	}
+	for attribute, value := range spec.FilterEquality {
+		r.FilterEquality(attribute, value)
+	}
+	if spec.Scope != "" {
+		scopes := map[string]int{
+			"base": SearchRequestScopeBaseObject,
+			"one":  SearchRequestSingleLevel,
+			"sub":  SearchRequestHomeSubtree,
+		}
+		scope, ok := scopes[strings.ToLower(spec.Scope)]
+		if !ok {
+			return nil, fmt.Errorf("unknown scope %q", spec.Scope)
+		}
+		r.Scope(scope)
+	}
+	if spec.Filter != "" {
+		r.Filter(spec.Filter)
+	}
+	if spec.AuthenticationChoice != "" {
+		r.AuthenticationChoice(spec.AuthenticationChoice)
+	}
+	if spec.Attribute != "" {
+		r.Attribute(spec.Attribute)
+	}
+	if operation == EXTENDED {
+		if spec.RequestName == "" {
+			return nil, fmt.Errorf("extended route without requestName")
+		}
+		r.RequestName(ldap.LDAPOID(spec.RequestName))
+	}
+	if spec.Timeout != 0 {
+		r.Timeout(time.Duration(spec.Timeout))
+	}
+	return r, nil
+}
@@ -0,0 +1,29 @@
+package ldapserver
+
+import "testing"
+
+func TestAddRoutesNotFound(t *testing.T) {
+	handlers := map[string]HandlerFunc{
+		"search":   func(w ResponseWriter, m *Message) {},
+		"notFound": func(w ResponseWriter, m *Message) {},
+	}
+	routes := NewRouteMux()
+	err := routes.AddRoutes([]RouteSpec{
+		{Operation: "notFound", Handler: "notFound"},
+		{Operation: "search", Handler: "search"},
+		{Operation: "notFound", Handler: "notFound"},
+	}, handlers)
+	if err == nil {
+		t.Fatal("second notFound route accepted")
+	}
+	if routes.notFoundRoute != nil || len(routes.routes) != 0 {
+		t.Error("routes added despite the error")
+	}
+
+	if err := routes.AddRoutes([]RouteSpec{{Operation: "notFound", Handler: "notFound"}}, handlers); err != nil {
+		t.Fatal(err)
+	}
+	if routes.notFoundRoute == nil {
+		t.Error("notFound route not added")
+	}
+}
@@ -0,0 +1,278 @@
+package ldapserver
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// SASLMechanism is a SASL authentication mechanism, see SASL
+type SASLMechanism interface {
+	// Name returns the mechanism name, such as "EXTERNAL"
+	Name() string
+
+	// Start begins an exchange with the client sending the bind request m
+	Start(m *Message) (SASLExchange, error)
+}
+
+// SASLExchange is a SASL authentication in progress, it may span several
+// bind requests
+type SASLExchange interface {
+	// Step handles the credentials of a bind request, nil when the client
+	// sent none. It returns the credentials sent back to the client, and
+	// once the authentication completes, done and the identity (a DN) the
+	// client is authenticated as. A *ResultError is answered with its
+	// result code, other errors with invalidCredentials.
+	Step(credentials []byte) (challenge []byte, done bool, identity string, err error)
+}
+
+// SASL serves SASL binds with a set of mechanisms, keeping the state of
+// the exchanges in progress of each client across bind requests:
+//
+//	sasl := &ldap.SASL{Mechanisms: []ldap.SASLMechanism{&ldap.SASLExternal{}}}
+//	routes.Bind(sasl.HandleBind).AuthenticationChoice("sasl")
+//
+// The client ACL BindEntry is set to the authenticated identity.
+type SASL struct {
+	Mechanisms []SASLMechanism
+
+	mu        sync.Mutex
+	exchanges map[*client]*saslState
+}
+
+// saslState is the exchange in progress of a client
+type saslState struct {
+	mechanism string
+	exchange  SASLExchange
+}
+
+// MechanismNames returns the names of the mechanisms, as listed in the
+// RootDSE supportedSASLMechanisms attribute
+func (s *SASL) MechanismNames() []string {
+	names := make([]string, len(s.Mechanisms))
+	for i, mechanism := range s.Mechanisms {
+		names[i] = mechanism.Name()
+	}
+	return names
+}
+
+// SASLBind routes the SASL binds to s, its mechanisms are listed in the
+// RootDSE served by Server.SetRootDSE
+func (h *RouteMux) SASLBind(s *SASL) *route {
+	h.sasl = append(h.sasl, s)
+	return h.Bind(s.HandleBind).AuthenticationChoice("sasl")
+}
+
+// SupportedSASLMechanisms returns the names of the mechanisms of the SASL
+// routed with SASLBind
+func (h *RouteMux) SupportedSASLMechanisms() []string {
+	var names []string
+	for _, s := range h.sasl {
+		names = append(names, s.MechanismNames()...)
+	}
+	return names
+}
+
+// HandleBind serves a SASL bind request
+func (s *SASL) HandleBind(w ResponseWriter, m *Message) {
+	mechanism, credentials, err := parseSASLCredentials(m)
+	if err != nil {
+		writeBindResponse(w, LDAPResultProtocolError, err.Error(), nil)
+		return
+	}
+
+	// a new bind, or a bind with no credentials, starts a new exchange
+	state := s.state(m.Client)
+	if state == nil || state.mechanism != mechanism || credentials == nil {
+		state, err = s.start(m, mechanism)
+		if err != nil {
+			s.finish(m, "")
+			resultCode, diagnosticMessage := saslError(err)
+			writeBindResponse(w, resultCode, diagnosticMessage, nil)
+			return
+		}
+	}
+
+	challenge, done, identity, err := state.exchange.Step(credentials)
+	switch {
+	case err != nil:
+		s.finish(m, "")
+		resultCode, diagnosticMessage := saslError(err)
+		m.logAt(LogLevelInfo, "SASL %s bind failed: %s", mechanism, err)
+		writeBindResponse(w, resultCode, diagnosticMessage, nil)
+	case !done:
+		writeBindResponse(w, LDAPResultSaslBindInProgress, "", challenge)
+	default:
+		s.finish(m, identity)
+		m.logAt(LogLevelInfo, "SASL %s bind as %q", mechanism, identity)
+		writeBindResponse(w, LDAPResultSuccess, "", challenge)
+	}
+}
+
+// state returns the exchange in progress of c, or nil
+func (s *SASL) state(c *client) *saslState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exchanges[c]
+}
+
+// start begins an exchange of the mechanism named name for the client
+// sending m
+func (s *SASL) start(m *Message, name string) (*saslState, error) {
+	var mechanism SASLMechanism
+	for _, candidate := range s.Mechanisms {
+		if strings.EqualFold(candidate.Name(), name) {
+			mechanism = candidate
+			break
+		}
+	}
+	if mechanism == nil {
+		return nil, NewResultError(LDAPResultAuthMethodNotSupported, fmt.Sprintf("SASL mechanism %s is not supported", name))
+	}
+	exchange, err := mechanism.Start(m)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &saslState{mechanism: name, exchange: exchange}
+	c := m.Client
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.exchanges == nil {
+		s.exchanges = make(map[*client]*saslState)
+	}
+	if _, ok := s.exchanges[c]; !ok && c != nil && c.closing != nil {
+		go func() {
+			<-c.closing
+			s.mu.Lock()
+			delete(s.exchanges, c)
+			s.mu.Unlock()
+		}()
+	}
+	s.exchanges[c] = state
+	return state, nil
+}
+
+// finish ends the exchange of the client sending m, which is authenticated
+// as identity, anonymous if empty
+func (s *SASL) finish(m *Message, identity string) {
+	s.mu.Lock()
+	delete(s.exchanges, m.Client)
+	s.mu.Unlock()
+	if m.Client != nil {
+		acl := m.Client.ACL()
+		acl.BindEntry = identity
+		m.Client.SetACL(acl)
+	}
+}
+
+// saslError returns the result code and diagnostic message answering err
+func saslError(err error) (int, string) {
+	var re *ResultError
+	if errors.As(err, &re) {
+		return re.ResultCode, re.DiagnosticMessage
+	}
+	return LDAPResultInvalidCredentials, ""
+}
+
+// parseSASLCredentials returns the mechanism and credentials of the SASL
+// bind request m, credentials are nil when absent
+func parseSASLCredentials(m *Message) (string, []byte, error) {
+	protocolOp, _, err := encodeRequest(m)
+	if err != nil {
+		return "", nil, err
+	}
+	request, _, err := berRead(protocolOp)
+	if err != nil || request.tag != berClassApplication|berConstructed|ApplicationBindRequest {
+		return "", nil, errors.New("malformed bind request")
+	}
+	fields, err := berChildren(request.data)
+	if err != nil || len(fields) < 3 || fields[2].tag != berClassContext|berConstructed|3 {
+		return "", nil, errors.New("not a SASL bind request")
+	}
+	saslCredentials, err := berChildren(fields[2].data)
+	if err != nil || len(saslCredentials) == 0 || saslCredentials[0].tag != berTagOctetString {
+		return "", nil, errors.New("malformed SASL credentials")
+	}
+	var credentials []byte
+	if len(saslCredentials) > 1 {
+		credentials = append([]byte{}, saslCredentials[1].data...)
+	}
+	return string(saslCredentials[0].data), credentials, nil
+}
+
+// writeBindResponse answers a bind, with the serverSaslCreds when non-nil
+func writeBindResponse(w ResponseWriter, resultCode int, diagnosticMessage string, serverSaslCreds []byte) {
+	elements := encodeLDAPResult(resultCode, "", diagnosticMessage)
+	if serverSaslCreds != nil {
+		elements = append(elements, berOctetString(berClassContext|7, serverSaslCreds))
+	}
+	WriteRaw(w, berConstructedTLV(berClassApplication|berConstructed|ApplicationBindResponse, elements...))
+}
+
+// SASLExternal is the EXTERNAL mechanism (RFC 4422 appendix A),
+// authenticating the clients with the certificate they presented during
+// the TLS handshake
+type SASLExternal struct {
+	// Identity maps the connection state of a client which presented a
+	// verified certificate to its DN. The certificate subject is used if
+	// nil.
+	Identity func(state tls.ConnectionState) (string, error)
+
+	// Authorize reports whether identity may act as authzid, the
+	// authorization identity the client requested. Only authzid naming
+	// identity ("dn:" followed by the DN) is allowed if nil.
+	Authorize func(identity string, authzid string) bool
+}
+
+func (e *SASLExternal) Name() string {
+	return "EXTERNAL"
+}
+
+func (e *SASLExternal) Start(m *Message) (SASLExchange, error) {
+	if m.Client == nil {
+		return nil, NewResultError(LDAPResultInappropriateAuthentication, "no TLS client certificate")
+	}
+	conn, ok := m.Client.GetConn().(*tls.Conn)
+	if !ok {
+		return nil, NewResultError(LDAPResultInappropriateAuthentication, "no TLS client certificate")
+	}
+	state := conn.ConnectionState()
+	if len(state.VerifiedChains) == 0 {
+		return nil, NewResultError(LDAPResultInappropriateAuthentication, "no TLS client certificate")
+	}
+
+	var identity string
+	if e.Identity != nil {
+		var err error
+		if identity, err = e.Identity(state); err != nil {
+			return nil, err
+		}
+	} else {
+		identity = state.VerifiedChains[0][0].Subject.String()
+	}
+	return &externalExchange{mechanism: e, identity: identity}, nil
+}
+
+type externalExchange struct {
+	mechanism *SASLExternal
+	identity  string
+}
+
+func (x *externalExchange) Step(credentials []byte) ([]byte, bool, string, error) {
+	authzid := string(credentials)
+	if authzid != "" {
+		authorize := x.mechanism.Authorize
+		if authorize == nil {
+			authorize = func(identity string, authzid string) bool {
+				return strings.HasPrefix(authzid, "dn:") && NormalizeDN(authzid[3:]) == NormalizeDN(identity)
+			}
+		}
+		if !authorize(x.identity, authzid) {
+			return nil, false, "", NewResultError(LDAPResultInvalidCredentials, "authorization identity not allowed")
+		}
+	}
+	return nil, true, x.identity, nil
+}
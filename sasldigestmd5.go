@@ -0,0 +1,198 @@
+package ldapserver
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SASLDigestMD5 is the DIGEST-MD5 mechanism (RFC 2831), authenticating the
+// clients with a shared password without sending it. Only the "auth"
+// quality of protection is offered, no integrity or confidentiality
+// layer is installed.
+type SASLDigestMD5 struct {
+	// Realm is the realm the server announces, the host name it serves
+	// for instance. The clients must authenticate in it when set.
+	Realm string
+
+	// Hosts are the names of the server, one of them must be the host of
+	// the "ldap/host" digest-uri of the clients. Any host is accepted if
+	// empty.
+	Hosts []string
+
+	// Password returns the password of username in realm, and the
+	// identity (a DN) the client is authenticated as. A nil error with
+	// an empty identity refuses the user.
+	Password func(username, realm string) (password, identity string, err error)
+
+	// Authorize reports whether identity may act as authzid, the
+	// authorization identity the client requested. Requests naming an
+	// authorization identity are refused if nil.
+	Authorize func(identity string, authzid string) bool
+}
+
+func (d *SASLDigestMD5) Name() string {
+	return "DIGEST-MD5"
+}
+
+func (d *SASLDigestMD5) Start(m *Message) (SASLExchange, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, NewResultError(LDAPResultOther, err.Error())
+	}
+	return &digestMD5Exchange{mechanism: d, nonce: base64.RawStdEncoding.EncodeToString(nonce)}, nil
+}
+
+// digestMD5Exchange is a DIGEST-MD5 exchange: the challenge, the client
+// response, then the rspauth sent with the success
+type digestMD5Exchange struct {
+	mechanism  *SASLDigestMD5
+	nonce      string
+	challenged bool
+}
+
+func (x *digestMD5Exchange) Step(credentials []byte) ([]byte, bool, string, error) {
+	if !x.challenged {
+		x.challenged = true
+		challenge := fmt.Sprintf(`nonce="%s",qop="auth",charset=utf-8,algorithm=md5-sess`, x.nonce)
+		if x.mechanism.Realm != "" {
+			challenge = fmt.Sprintf(`realm="%s",`, x.mechanism.Realm) + challenge
+		}
+		return []byte(challenge), false, "", nil
+	}
+
+	directives, err := parseDigestDirectives(string(credentials))
+	if err != nil {
+		return nil, false, "", err
+	}
+	username, realm, authzid := directives["username"], directives["realm"], directives["authzid"]
+	switch {
+	case username == "":
+		return nil, false, "", errors.New("missing username")
+	case directives["nonce"] != x.nonce:
+		return nil, false, "", errors.New("nonce mismatch")
+	case directives["qop"] != "" && directives["qop"] != "auth":
+		return nil, false, "", NewResultError(LDAPResultAuthMethodNotSupported, "only the auth qop is supported")
+	case directives["nc"] != "00000001":
+		return nil, false, "", errors.New("unexpected nonce count")
+	case directives["cnonce"] == "" || directives["digest-uri"] == "" || directives["response"] == "":
+		return nil, false, "", errors.New("incomplete digest response")
+	case x.mechanism.Realm != "" && realm != x.mechanism.Realm:
+		return nil, false, "", errors.New("realm mismatch")
+	}
+	if err := x.mechanism.checkDigestURI(directives["digest-uri"]); err != nil {
+		return nil, false, "", err
+	}
+
+	password, identity, err := x.mechanism.Password(username, realm)
+	if err != nil {
+		return nil, false, "", err
+	}
+	if identity == "" {
+		return nil, false, "", errors.New("unknown user " + username)
+	}
+
+	expected := digestMD5Response(directives, password, "AUTHENTICATE:")
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(directives["response"]))) != 1 {
+		return nil, false, "", errors.New("invalid digest response")
+	}
+	if authzid != "" && (x.mechanism.Authorize == nil || !x.mechanism.Authorize(identity, authzid)) {
+		return nil, false, "", NewResultError(LDAPResultInvalidCredentials, "authorization identity not allowed")
+	}
+
+	rspauth := "rspauth=" + digestMD5Response(directives, password, ":")
+	return []byte(rspauth), true, identity, nil
+}
+
+// checkDigestURI checks that the digest-uri of a client response names the
+// ldap service of one of the Hosts (RFC 2831 section 2.1.2.1)
+func (d *SASLDigestMD5) checkDigestURI(uri string) error {
+	// digest-uri is serv-type "/" host [ "/" serv-name ]
+	parts := strings.Split(uri, "/")
+	if len(parts) < 2 || len(parts) > 3 || !strings.EqualFold(parts[0], "ldap") || parts[1] == "" {
+		return fmt.Errorf("invalid digest-uri %q", uri)
+	}
+	if len(d.Hosts) == 0 {
+		return nil
+	}
+	for _, host := range d.Hosts {
+		if strings.EqualFold(parts[1], host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("digest-uri %q does not name this server", uri)
+}
+
+// digestMD5Response returns the response-value of RFC 2831 section 2.1.2.1
+// for the directives of the client, a2Prefix is "AUTHENTICATE:" for the
+// client response and ":" for rspauth
+func digestMD5Response(directives map[string]string, password, a2Prefix string) string {
+	h := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return string(sum[:])
+	}
+	hexH := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+
+	a1 := h(directives["username"]+":"+directives["realm"]+":"+password) + ":" + directives["nonce"] + ":" + directives["cnonce"]
+	if directives["authzid"] != "" {
+		a1 += ":" + directives["authzid"]
+	}
+	a2 := a2Prefix + directives["digest-uri"]
+	qop := directives["qop"]
+	if qop == "" {
+		qop = "auth"
+	}
+	return hexH(hexH(a1) + ":" + directives["nonce"] + ":" + directives["nc"] + ":" + directives["cnonce"] + ":" + qop + ":" + hexH(a2))
+}
+
+// parseDigestDirectives parses the comma separated name=value directives
+// of a digest response, values may be quoted strings
+func parseDigestDirectives(s string) (map[string]string, error) {
+	directives := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return directives, nil
+		}
+		i := strings.IndexByte(s, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("malformed digest directive %q", s)
+		}
+		name := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = strings.TrimLeft(s[i+1:], " \t")
+
+		var value strings.Builder
+		if strings.HasPrefix(s, `"`) {
+			j := 1
+			for ; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				value.WriteByte(s[j])
+			}
+			if j == len(s) {
+				return nil, fmt.Errorf("unterminated value of digest directive %s", name)
+			}
+			s = s[j+1:]
+		} else {
+			j := strings.IndexByte(s, ',')
+			if j < 0 {
+				j = len(s)
+			}
+			value.WriteString(strings.TrimSpace(s[:j]))
+			s = s[j:]
+		}
+		if _, ok := directives[name]; ok {
+			return nil, fmt.Errorf("duplicate digest directive %s", name)
+		}
+		directives[name] = value.String()
+	}
+}
@@ -0,0 +1,492 @@
+package ldapserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// AttributeType is an attribute type definition (RFC 4512 section 4.1.2)
+type AttributeType struct {
+	OID                string
+	Names              []string
+	Description        string
+	Obsolete           bool
+	Superior           string
+	Equality           string
+	Ordering           string
+	Substring          string
+	Syntax             string // syntax OID, with its optional {length} bound
+	SingleValue        bool
+	Collective         bool
+	NoUserModification bool
+	Usage              string // userApplications if empty
+
+	// Definition is the definition text, as served in attributeTypes
+	Definition string
+}
+
+// ObjectClassKind is the kind of an object class
+type ObjectClassKind string
+
+const (
+	ObjectClassStructural ObjectClassKind = "STRUCTURAL"
+	ObjectClassAuxiliary  ObjectClassKind = "AUXILIARY"
+	ObjectClassAbstract   ObjectClassKind = "ABSTRACT"
+)
+
+// ObjectClass is an object class definition (RFC 4512 section 4.1.1)
+type ObjectClass struct {
+	OID         string
+	Names       []string
+	Description string
+	Obsolete    bool
+	Superiors   []string
+	Kind        ObjectClassKind // STRUCTURAL if empty
+	Must        []string
+	May         []string
+
+	// Definition is the definition text, as served in objectClasses
+	Definition string
+}
+
+// Schema holds attribute types and object classes definitions, loaded from
+// RFC 4512 descriptions, and served by the server subschema subentry, see
+// Server.SetSchema
+type Schema struct {
+	mu             sync.RWMutex
+	attributeTypes []AttributeType
+	objectClasses  []ObjectClass
+	names          map[string]int // attribute types by lowercase name and OID
+	classNames     map[string]int // object classes by lowercase name and OID
+}
+
+// NewSchema returns an empty Schema
+func NewSchema() *Schema {
+	return &Schema{names: make(map[string]int), classNames: make(map[string]int)}
+}
+
+// AddAttributeType adds the attribute type described by def, such as:
+//
+//	( 0.9.2342.19200300.100.1.1 NAME 'uid' EQUALITY caseIgnoreMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15{256} )
+//
+// A definition with the OID of a known type replaces it.
+func (s *Schema) AddAttributeType(def string) error {
+	d, err := parseSchemaDescription(def)
+	if err != nil {
+		return err
+	}
+	t := AttributeType{
+		OID:                d.oid,
+		Names:              d.values["NAME"],
+		Description:        d.value("DESC"),
+		Obsolete:           d.flag("OBSOLETE"),
+		Superior:           d.value("SUP"),
+		Equality:           d.value("EQUALITY"),
+		Ordering:           d.value("ORDERING"),
+		Substring:          d.value("SUBSTR"),
+		Syntax:             d.value("SYNTAX"),
+		SingleValue:        d.flag("SINGLE-VALUE"),
+		Collective:         d.flag("COLLECTIVE"),
+		NoUserModification: d.flag("NO-USER-MODIFICATION"),
+		Usage:              d.value("USAGE"),
+		Definition:         d.text,
+	}
+	if t.Syntax == "" && t.Superior == "" {
+		return fmt.Errorf("attribute type %s: SYNTAX or SUP required", t.OID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	i, ok := s.names[strings.ToLower(t.OID)]
+	if ok {
+		s.attributeTypes[i] = t
+	} else {
+		i = len(s.attributeTypes)
+		s.attributeTypes = append(s.attributeTypes, t)
+	}
+	for _, key := range append([]string{t.OID}, t.Names...) {
+		s.names[strings.ToLower(key)] = i
+	}
+	return nil
+}
+
+// AddObjectClass adds the object class described by def, such as:
+//
+//	( 2.5.6.6 NAME 'person' SUP top STRUCTURAL MUST ( sn $ cn ) MAY ( userPassword $ telephoneNumber ) )
+//
+// A definition with the OID of a known class replaces it.
+func (s *Schema) AddObjectClass(def string) error {
+	d, err := parseSchemaDescription(def)
+	if err != nil {
+		return err
+	}
+	c := ObjectClass{
+		OID:         d.oid,
+		Names:       d.values["NAME"],
+		Description: d.value("DESC"),
+		Obsolete:    d.flag("OBSOLETE"),
+		Superiors:   d.values["SUP"],
+		Must:        d.values["MUST"],
+		May:         d.values["MAY"],
+		Definition:  d.text,
+	}
+	for _, kind := range []ObjectClassKind{ObjectClassStructural, ObjectClassAuxiliary, ObjectClassAbstract} {
+		if d.flag(string(kind)) {
+			c.Kind = kind
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.init()
+	i, ok := s.classNames[strings.ToLower(c.OID)]
+	if ok {
+		s.objectClasses[i] = c
+	} else {
+		i = len(s.objectClasses)
+		s.objectClasses = append(s.objectClasses, c)
+	}
+	for _, key := range append([]string{c.OID}, c.Names...) {
+		s.classNames[strings.ToLower(key)] = i
+	}
+	return nil
+}
+
+func (s *Schema) init() {
+	if s.names == nil {
+		s.names = make(map[string]int)
+		s.classNames = make(map[string]int)
+	}
+}
+
+// Load adds the definitions read from r, in the OpenLDAP schema file
+// syntax (attributetype and objectclass keywords) or as LDIF attributes
+// (attributeTypes:, objectClasses:, olcAttributeTypes:...). Definitions may
+// span several lines, lines starting with # are comments.
+func (s *Schema) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	var kind, def string
+	depth, line := 0, 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(text, "#") {
+			continue
+		}
+		if kind == "" {
+			if text == "" {
+				continue
+			}
+			keyword := strings.Fields(text)[0]
+			switch strings.ToLower(strings.TrimSuffix(keyword, ":")) {
+			case "attributetype", "attributetypes", "olcattributetypes":
+				kind = "attributetype"
+			case "objectclass", "objectclasses", "olcobjectclasses":
+				kind = "objectclass"
+			default:
+				// other directives and attributes are not schema definitions
+				continue
+			}
+			text = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(text[len(keyword):]), ":"))
+			// cn=config definitions are prefixed with their {index}
+			if strings.HasPrefix(text, "{") {
+				if end := strings.IndexByte(text, '}'); end > 0 {
+					text = strings.TrimSpace(text[end+1:])
+				}
+			}
+		}
+		def += " " + text
+		depth += parenDepth(text)
+		if depth > 0 {
+			continue
+		}
+
+		var err error
+		if kind == "attributetype" {
+			err = s.AddAttributeType(def)
+		} else {
+			err = s.AddObjectClass(def)
+		}
+		if err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+		kind, def, depth = "", "", 0
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if kind != "" {
+		return fmt.Errorf("line %d: unterminated %s definition", line, kind)
+	}
+	return nil
+}
+
+// parenDepth returns the opening minus the closing parentheses of s,
+// outside quoted strings
+func parenDepth(s string) int {
+	depth, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\'':
+			quoted = !quoted
+		case '(':
+			if !quoted {
+				depth++
+			}
+		case ')':
+			if !quoted {
+				depth--
+			}
+		}
+	}
+	return depth
+}
+
+// AttributeType returns the attribute type named name, or with the OID
+// name
+func (s *Schema) AttributeType(name string) (AttributeType, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, ok := s.names[strings.ToLower(name)]
+	if !ok {
+		return AttributeType{}, false
+	}
+	return s.attributeTypes[i], true
+}
+
+// ObjectClass returns the object class named name, or with the OID name
+func (s *Schema) ObjectClass(name string) (ObjectClass, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	i, ok := s.classNames[strings.ToLower(name)]
+	if !ok {
+		return ObjectClass{}, false
+	}
+	return s.objectClasses[i], true
+}
+
+// AttributeTypes returns the attribute types, in the order they were added
+func (s *Schema) AttributeTypes() []AttributeType {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]AttributeType(nil), s.attributeTypes...)
+}
+
+// ObjectClasses returns the object classes, in the order they were added
+func (s *Schema) ObjectClasses() []ObjectClass {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]ObjectClass(nil), s.objectClasses...)
+}
+
+// schemaDescription is a parsed RFC 4512 definition: its OID and the
+// values of its fields, by keyword
+type schemaDescription struct {
+	text   string
+	oid    string
+	values map[string][]string
+}
+
+func (d *schemaDescription) value(keyword string) string {
+	if values := d.values[keyword]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (d *schemaDescription) flag(keyword string) bool {
+	_, ok := d.values[keyword]
+	return ok
+}
+
+// schemaFlags are the keywords of the definitions which take no value
+var schemaFlags = map[string]bool{
+	"OBSOLETE":             true,
+	"SINGLE-VALUE":         true,
+	"COLLECTIVE":           true,
+	"NO-USER-MODIFICATION": true,
+	"STRUCTURAL":           true,
+	"AUXILIARY":            true,
+	"ABSTRACT":             true,
+}
+
+// parseSchemaDescription parses the RFC 4512 definition def:
+//
+//	( oid KEYWORD value KEYWORD ( value $ value ) FLAG ... )
+//
+// where values are OIDs, names or quoted strings
+func parseSchemaDescription(def string) (*schemaDescription, error) {
+	tokens, err := schemaTokens(def)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) < 3 || tokens[0] != "(" || tokens[len(tokens)-1] != ")" {
+		return nil, errors.New("definition must be enclosed in parentheses")
+	}
+	tokens = tokens[1 : len(tokens)-1]
+	d := &schemaDescription{
+		text:   "( " + strings.Join(tokens, " ") + " )",
+		oid:    tokens[0],
+		values: make(map[string][]string),
+	}
+	if d.oid == "(" || d.oid == ")" || strings.HasPrefix(d.oid, "'") {
+		return nil, errors.New("definition must start with an OID")
+	}
+
+	for i := 1; i < len(tokens); {
+		keyword := strings.ToUpper(tokens[i])
+		i++
+		if schemaFlags[keyword] {
+			d.values[keyword] = nil
+			continue
+		}
+		if i >= len(tokens) {
+			return nil, fmt.Errorf("%s: missing value", keyword)
+		}
+		if tokens[i] != "(" {
+			d.values[keyword] = []string{unquoteSchemaToken(tokens[i])}
+			i++
+			continue
+		}
+		var values []string
+		for i++; i < len(tokens) && tokens[i] != ")"; i++ {
+			if tokens[i] != "$" {
+				values = append(values, unquoteSchemaToken(tokens[i]))
+			}
+		}
+		if i >= len(tokens) {
+			return nil, fmt.Errorf("%s: unterminated list", keyword)
+		}
+		i++
+		d.values[keyword] = values
+	}
+	return d, nil
+}
+
+// schemaTokens splits def into parentheses, dollars, quoted strings, kept
+// with their quotes, and words
+func schemaTokens(def string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(def); {
+		switch c := def[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(' || c == ')' || c == '$':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '\'':
+			end := strings.IndexByte(def[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated quoted string")
+			}
+			tokens = append(tokens, def[i:i+end+2])
+			i += end + 2
+		default:
+			start := i
+			for i < len(def) && !strings.ContainsRune(" \t\n\r()$'", rune(def[i])) {
+				i++
+			}
+			tokens = append(tokens, def[start:i])
+		}
+	}
+	return tokens, nil
+}
+
+// unquoteSchemaToken returns the value of a quoted string token, with the
+// \27 and \5C escapes replaced
+func unquoteSchemaToken(token string) string {
+	if len(token) < 2 || token[0] != '\'' {
+		return token
+	}
+	token = token[1 : len(token)-1]
+	token = strings.ReplaceAll(token, `\27`, "'")
+	return strings.ReplaceAll(token, `\5C`, `\`)
+}
+
+// SetSchema makes the server serve schema in the subschema subentry dn,
+// "cn=Subschema" if empty: base searches of dn are answered before the
+// Handler with its attributeTypes and objectClasses, operational
+// attributes returned when requested by name or with "+". The RootDSE
+// served by SetRootDSE then lists dn in subschemaSubentry. A nil schema
+// stops serving it.
+func (s *Server) SetSchema(schema *Schema, dn string) error {
+	if dn == "" {
+		dn = "cn=Subschema"
+	}
+	parsed, err := ParseDN(dn)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schema, s.schemaDN = schema, parsed
+	return nil
+}
+
+// subschema returns the schema served and its subentry DN, nil if none
+func (s *Server) subschema() (*Schema, DN) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.schema, s.schemaDN
+}
+
+// subschemaOperational lists the operational attributes of the subschema
+// subentry, only returned when requested
+var subschemaOperational = map[string]bool{
+	"attributetypes":    true,
+	"objectclasses":     true,
+	"createtimestamp":   true,
+	"modifytimestamp":   true,
+	"subschemasubentry": true,
+}
+
+// serveSubschema answers the base search m of the subschema subentry set
+// with SetSchema, it returns false for the other requests
+func (s *Server) serveSubschema(w ResponseWriter, m *Message) bool {
+	r, ok := m.ProtocolOp().(ldap.SearchRequest)
+	if !ok || int(r.Scope()) != SearchRequestScopeBaseObject {
+		return false
+	}
+	schema, dn := s.subschema()
+	if schema == nil {
+		return false
+	}
+	base, err := ParseDN(string(r.BaseObject()))
+	if err != nil || !base.Equal(dn) {
+		return false
+	}
+
+	e := Entry{DN: dn.String(), Attributes: []EntryAttribute{
+		{Name: "objectClass", Values: [][]byte{[]byte("top"), []byte("subentry"), []byte("subschema"), []byte("extensibleObject")}},
+		{Name: "cn", Values: [][]byte{[]byte(dn[0][0].Value)}},
+	}}
+	var attributeTypes, objectClasses [][]byte
+	for _, t := range schema.AttributeTypes() {
+		attributeTypes = append(attributeTypes, []byte(t.Definition))
+	}
+	for _, c := range schema.ObjectClasses() {
+		objectClasses = append(objectClasses, []byte(c.Definition))
+	}
+	e.Attributes = append(e.Attributes,
+		EntryAttribute{Name: "attributeTypes", Values: attributeTypes},
+		EntryAttribute{Name: "objectClasses", Values: objectClasses},
+		EntryAttribute{Name: "subschemaSubentry", Values: [][]byte{[]byte(dn.String())}},
+	)
+
+	if matchFilter(&e, r.Filter()) {
+		e = selectAttributes(e, r, func(name string) bool { return subschemaOperational[name] })
+		if err := WriteEntries(w, []Entry{e}); err != nil {
+			return true
+		}
+	}
+	w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+	return true
+}
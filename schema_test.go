@@ -0,0 +1,137 @@
+package ldapserver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSchema = `# OpenLDAP schema file syntax
+attributetype ( 0.9.2342.19200300.100.1.1 NAME ( 'uid' 'userid' )
+	DESC 'user \27s identifier'
+	EQUALITY caseIgnoreMatch
+	SYNTAX 1.3.6.1.4.1.1466.115.121.1.15{256} )
+
+objectclass ( 2.5.6.6 NAME 'person' SUP top STRUCTURAL
+	MUST ( sn $ cn ) MAY ( userPassword $ telephoneNumber ) )
+
+include other.schema
+
+# cn=config LDIF
+olcAttributeTypes: {0}( 1.3.6.1.1.1.1.0 NAME 'uidNumber' EQUALITY integerMatch
+  SYNTAX 1.3.6.1.4.1.1466.115.121.1.27 SINGLE-VALUE )
+olcObjectClasses: {0}( 1.3.6.1.1.1.2.0 NAME 'posixAccount' SUP top AUXILIARY MUST uidNumber )
+`
+
+func TestSchemaLoad(t *testing.T) {
+	s := NewSchema()
+	if err := s.Load(strings.NewReader(testSchema)); err != nil {
+		t.Fatal(err)
+	}
+
+	uid, ok := s.AttributeType("USERID")
+	if !ok {
+		t.Fatal("uid not found by its second name")
+	}
+	want := AttributeType{
+		OID:         "0.9.2342.19200300.100.1.1",
+		Names:       []string{"uid", "userid"},
+		Description: "user 's identifier",
+		Equality:    "caseIgnoreMatch",
+		Syntax:      "1.3.6.1.4.1.1466.115.121.1.15{256}",
+		Definition:  `( 0.9.2342.19200300.100.1.1 NAME ( 'uid' 'userid' ) DESC 'user \27s identifier' EQUALITY caseIgnoreMatch SYNTAX 1.3.6.1.4.1.1466.115.121.1.15{256} )`,
+	}
+	if !reflect.DeepEqual(uid, want) {
+		t.Errorf("uid = %+v, want %+v", uid, want)
+	}
+	if uidNumber, ok := s.AttributeType("1.3.6.1.1.1.1.0"); !ok || !uidNumber.SingleValue || uidNumber.Names[0] != "uidNumber" {
+		t.Errorf("uidNumber = %+v", uidNumber)
+	}
+
+	person, ok := s.ObjectClass("person")
+	if !ok || person.Kind != ObjectClassStructural || !reflect.DeepEqual(person.Must, []string{"sn", "cn"}) || !reflect.DeepEqual(person.May, []string{"userPassword", "telephoneNumber"}) {
+		t.Errorf("person = %+v", person)
+	}
+	if posixAccount, ok := s.ObjectClass("posixaccount"); !ok || posixAccount.Kind != ObjectClassAuxiliary || !reflect.DeepEqual(posixAccount.Must, []string{"uidNumber"}) {
+		t.Errorf("posixAccount = %+v", posixAccount)
+	}
+	if n := len(s.AttributeTypes()); n != 2 {
+		t.Errorf("%d attribute types, want 2", n)
+	}
+
+	// a definition with a known OID replaces it
+	if err := s.AddAttributeType("( 0.9.2342.19200300.100.1.1 NAME 'uid' SUP name )"); err != nil {
+		t.Fatal(err)
+	}
+	if uid, _ := s.AttributeType("uid"); uid.Superior != "name" || len(s.AttributeTypes()) != 2 {
+		t.Errorf("uid not replaced: %+v", uid)
+	}
+}
+
+func TestSchemaErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		err    string
+	}{
+		{"no parentheses", "attributetype 1.2.3 NAME 'a'", "enclosed in parentheses"},
+		{"quoted OID", "objectclass ( 'a' )", "must start with an OID"},
+		{"no syntax", "attributetype ( 1.2.3 NAME 'a' )", "SYNTAX or SUP required"},
+		{"missing value", "objectclass ( 1.2.3 NAME )", "NAME: missing value"},
+		{"unterminated list", "objectclass ( 1.2.3 MUST ( a $ b )", "unterminated"},
+		{"unterminated string", "objectclass ( 1.2.3 NAME 'a )", "unterminated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewSchema().Load(strings.NewReader(tt.schema))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestServeSubschema(t *testing.T) {
+	schema := NewSchema()
+	if err := schema.Load(strings.NewReader(testSchema)); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer()
+	if err := s.SetSchema(schema, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.Handle(NewRouteMux())
+	addr := serveTest(t, s)
+	defer s.Stop()
+	c := dialTest(t, addr)
+	defer c.Close()
+
+	tests := []struct {
+		attributes []string
+		values     map[string]int
+	}{
+		{nil, map[string]int{"objectClass": 4, "cn": 1}},
+		{[]string{"attributeTypes", "objectClasses"}, map[string]int{"attributeTypes": 2, "objectClasses": 2}},
+		{[]string{"+"}, map[string]int{"attributeTypes": 2, "objectClasses": 2, "subschemaSubentry": 1}},
+	}
+	for _, tt := range tests {
+		entries, err := c.Search(SearchParams{BaseDN: "CN=subschema", Scope: SearchRequestScopeBaseObject, Attributes: tt.attributes})
+		if err != nil {
+			t.Fatalf("search of %v: %s", tt.attributes, err)
+		}
+		if len(entries) != 1 {
+			t.Fatalf("search of %v: %d entries", tt.attributes, len(entries))
+		}
+		values := make(map[string]int)
+		for _, a := range entries[0].Attributes {
+			values[a.Name] = len(a.Values)
+		}
+		if !reflect.DeepEqual(values, tt.values) {
+			t.Errorf("search of %v: values %v, want %v", tt.attributes, values, tt.values)
+		}
+	}
+
+	if entries, err := c.Search(SearchParams{BaseDN: "cn=Subschema", Scope: SearchRequestScopeBaseObject, Filter: "(objectClass=person)"}); err != nil || len(entries) != 0 {
+		t.Errorf("filtered search: %d entries, %v", len(entries), err)
+	}
+}
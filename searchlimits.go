@@ -0,0 +1,220 @@
+package ldapserver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// searchLimits returns the size and time limits enforced for the search
+// r, the smaller of those it requests and the server maximums, zero when
+// unlimited
+func (s *Server) searchLimits(r ldap.SearchRequest) (sizeLimit int, timeLimit time.Duration) {
+	sizeLimit, timeLimit = int(r.SizeLimit()), time.Duration(r.TimeLimit())*time.Second
+	if s.MaxSearchSizeLimit > 0 && (sizeLimit <= 0 || s.MaxSearchSizeLimit < sizeLimit) {
+		sizeLimit = s.MaxSearchSizeLimit
+	}
+	if s.MaxSearchTimeLimit > 0 && (timeLimit <= 0 || s.MaxSearchTimeLimit < timeLimit) {
+		timeLimit = s.MaxSearchTimeLimit
+	}
+	if sizeLimit < 0 {
+		sizeLimit = 0
+	}
+	if timeLimit < 0 {
+		timeLimit = 0
+	}
+	return sizeLimit, timeLimit
+}
+
+// limitWriter is a ResponseWriter enforcing the size and time limits of a
+// search: the entries past the size limit are dropped and the search
+// answered with sizeLimitExceeded, and it is answered with
+// timeLimitExceeded when its timer fires. The responses written once the
+// search is answered are dropped.
+type limitWriter struct {
+	ResponseWriter
+	sizeLimit int
+	deadline  time.Time // of the time limit, zero when unlimited
+	cancel    context.CancelFunc
+	timer     *time.Timer
+	timedOut  chan struct{} // closed once the timer fired and answered
+
+	// write orders the writes of the handler and the timer to the client,
+	// mu is never held while writing, so a client slow to read does not
+	// delay the cancelation of the request Context at the time limit
+	write   sync.Mutex
+	mu      sync.Mutex
+	entries int   // entries written
+	done    bool  // set once the search is answered
+	err     error // ErrSizeLimitExceeded or ErrTimeLimitExceeded
+}
+
+// newLimitWriter returns the enforcement of the limits of the search m,
+// with the request Context bounded by its time limit, nil when m is not a
+// search or unlimited. As it replaces the Context of m, it is called
+// before m is registered and visible to the other goroutines.
+func (s *Server) newLimitWriter(m *Message) *limitWriter {
+	r, ok := m.ProtocolOp().(ldap.SearchRequest)
+	if !ok {
+		return nil
+	}
+	sizeLimit, timeLimit := s.searchLimits(r)
+	if sizeLimit == 0 && timeLimit == 0 {
+		return nil
+	}
+
+	l := &limitWriter{sizeLimit: sizeLimit}
+	if timeLimit > 0 {
+		l.deadline = m.received.Add(timeLimit)
+		m.ctx, l.cancel = context.WithDeadline(m.Context(), l.deadline)
+	} else {
+		m.ctx, l.cancel = context.WithCancel(m.Context())
+	}
+	return l
+}
+
+// wrap returns w enforcing the limits of l, whose time limit timer is then
+// armed, or w when l is nil
+func (l *limitWriter) wrap(w ResponseWriter) ResponseWriter {
+	if l == nil {
+		return w
+	}
+	l.ResponseWriter = w
+	if !l.deadline.IsZero() {
+		l.timedOut = make(chan struct{})
+		l.timer = time.AfterFunc(time.Until(l.deadline), l.timeout)
+	}
+	return l
+}
+
+// stop ends the enforcement once the handler returned, or panicked, it
+// waits for the SearchResultDone of a timer which fired. l may be nil, and
+// stop called again.
+func (l *limitWriter) stop() {
+	if l == nil {
+		return
+	}
+	if l.timer != nil && !l.timer.Stop() {
+		<-l.timedOut
+	}
+	l.timer = nil
+	l.mu.Lock()
+	l.done = true
+	l.mu.Unlock()
+	l.cancel()
+}
+
+func (l *limitWriter) timeout() {
+	defer close(l.timedOut)
+	l.mu.Lock()
+	answer := !l.done
+	if answer {
+		l.done, l.err = true, ErrTimeLimitExceeded
+	}
+	l.mu.Unlock()
+	if !answer {
+		return
+	}
+	l.cancel()
+	l.write.Lock()
+	defer l.write.Unlock()
+	l.ResponseWriter.Write(NewSearchResultDoneResponse(LDAPResultTimeLimitExceeded))
+}
+
+// reserve counts n entries against the size limit, the search being
+// answered once terminal is written. It returns how many of them may be
+// written, and the error of the limit exceeded, with exceeded set when
+// they exceed the size limit, so the search is to be answered with
+// sizeLimitExceeded. The request Context is then canceled so the handler
+// stops fetching entries. It is called with write held.
+func (l *limitWriter) reserve(n int, terminal bool) (allowed int, exceeded bool, err error) {
+	l.mu.Lock()
+	switch {
+	case l.done:
+		err = l.err
+	case l.sizeLimit == 0 || l.entries+n <= l.sizeLimit:
+		allowed = n
+		l.entries += n
+		l.done = terminal
+	default:
+		allowed, exceeded = l.sizeLimit-l.entries, true
+		l.entries, l.done, l.err = l.sizeLimit, true, ErrSizeLimitExceeded
+		err = l.err
+	}
+	l.mu.Unlock()
+	if exceeded {
+		l.cancel()
+	}
+	return allowed, exceeded, err
+}
+
+// forward calls write unless the search was answered, counting entry
+// against the size limit, and returns the error of the limit exceeded
+func (l *limitWriter) forward(entry bool, terminal bool, write func()) error {
+	l.write.Lock()
+	defer l.write.Unlock()
+	n := 0
+	if entry {
+		n = 1
+	}
+	_, exceeded, err := l.reserve(n, terminal)
+	if err == nil {
+		write()
+	}
+	if exceeded {
+		l.ResponseWriter.Write(NewSearchResultDoneResponse(LDAPResultSizeLimitExceeded))
+	}
+	return err
+}
+
+func (l *limitWriter) Write(po ldap.ProtocolOp) {
+	_, entry := po.(ldap.SearchResultEntry)
+	l.forward(entry, isTerminalResponse(po), func() { l.ResponseWriter.Write(po) })
+}
+
+func (l *limitWriter) WriteMessage(m *ldap.LDAPMessage) {
+	_, entry := m.ProtocolOp().(ldap.SearchResultEntry)
+	l.forward(entry, isTerminalResponse(m.ProtocolOp()), func() { l.ResponseWriter.WriteMessage(m) })
+}
+
+func (l *limitWriter) WriteRaw(protocolOp []byte) {
+	l.WriteRawWithControls(protocolOp, nil)
+}
+
+func (l *limitWriter) WriteRawWithControls(protocolOp []byte, controls [][]byte) {
+	entry, terminal := false, true
+	if len(protocolOp) > 0 {
+		switch protocolOp[0] {
+		case berClassApplication | berConstructed | ApplicationSearchResultEntry:
+			entry, terminal = true, false
+		case berClassApplication | berConstructed | ApplicationSearchResultReference,
+			berClassApplication | berConstructed | ApplicationIntermediateResponse:
+			terminal = false
+		}
+	}
+	l.forward(entry, terminal, func() { WriteRawWithControls(l.ResponseWriter, protocolOp, controls) })
+}
+
+func (l *limitWriter) WriteEntries(entries []Entry) error {
+	l.write.Lock()
+	defer l.write.Unlock()
+	allowed, exceeded, err := l.reserve(len(entries), false)
+	if allowed > 0 {
+		if err := WriteEntries(l.ResponseWriter, entries[:allowed]); err != nil {
+			return err
+		}
+	}
+	if exceeded {
+		l.ResponseWriter.Write(NewSearchResultDoneResponse(LDAPResultSizeLimitExceeded))
+	}
+	return err
+}
+
+func (l *limitWriter) responded() bool {
+	if rw, ok := l.ResponseWriter.(interface{ responded() bool }); ok {
+		return rw.responded()
+	}
+	return false
+}
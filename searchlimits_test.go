@@ -0,0 +1,131 @@
+package ldapserver
+
+import (
+	"testing"
+	"time"
+)
+
+// testSearchRequest returns the BER encoded subtree search of base with
+// the filter (objectClass=*) and the given limits
+func testSearchRequest(base string, sizeLimit int64, timeLimit int64) []byte {
+	return berConstructedTLV(berClassApplication|berConstructed|ApplicationSearchRequest,
+		berOctetString(berTagOctetString, []byte(base)),
+		berInteger(berTagEnumerated, SearchRequestHomeSubtree),
+		berInteger(berTagEnumerated, 0),
+		berInteger(berTagInteger, sizeLimit),
+		berInteger(berTagInteger, timeLimit),
+		berBoolean(berTagBoolean, false),
+		berOctetString(berClassContext|7, []byte("objectClass")), // (objectClass=*)
+		berSequence(),
+	)
+}
+
+func TestSearchLimits(t *testing.T) {
+	tests := []struct {
+		name                 string
+		maxSize              int
+		maxTime              time.Duration
+		sizeLimit, timeLimit int64
+		wantSize             int
+		wantTime             time.Duration
+	}{
+		{"unlimited", 0, 0, 0, 0, 0, 0},
+		{"requested", 0, 0, 10, 5, 10, 5 * time.Second},
+		{"server maximum", 100, time.Minute, 0, 0, 100, time.Minute},
+		{"smaller request", 100, time.Minute, 10, 5, 10, 5 * time.Second},
+		{"larger request", 100, time.Minute, 1000, 3600, 100, time.Minute},
+		{"negative request", 0, 0, -1, -1, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{MaxSearchSizeLimit: tt.maxSize, MaxSearchTimeLimit: tt.maxTime}
+			m := testMessage(t, testSearchRequest("dc=example,dc=com", tt.sizeLimit, tt.timeLimit))
+			size, timeLimit := s.searchLimits(m.GetSearchRequest())
+			if size != tt.wantSize || timeLimit != tt.wantTime {
+				t.Errorf("limits %d, %s, want %d, %s", size, timeLimit, tt.wantSize, tt.wantTime)
+			}
+		})
+	}
+}
+
+func TestLimitWriterSizeLimit(t *testing.T) {
+	entries := []Entry{
+		*NewEntry("cn=a,dc=example,dc=com").Add("cn", "a"),
+		*NewEntry("cn=b,dc=example,dc=com").Add("cn", "b"),
+		*NewEntry("cn=c,dc=example,dc=com").Add("cn", "c"),
+	}
+	for _, tt := range entryWrites {
+		t.Run(tt.method, func(t *testing.T) {
+			s := &Server{MaxSearchSizeLimit: 2}
+			m := testMessage(t, testSearchRequest("dc=example,dc=com", 0, 0))
+			rec := NewResponseRecorder()
+			l := s.newLimitWriter(m)
+			w := l.wrap(rec)
+			for _, e := range entries {
+				tt.write(w, e)
+			}
+			w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+			l.stop()
+
+			if n := len(rec.Entries()); n != 2 {
+				t.Errorf("%d entries written, want 2", n)
+			}
+			if code := rec.ResultCode(); code != LDAPResultSizeLimitExceeded {
+				t.Errorf("result code %d, want %d", code, LDAPResultSizeLimitExceeded)
+			}
+			if n := len(rec.Messages()); n != 3 {
+				t.Errorf("%d messages written, want 3", n)
+			}
+		})
+	}
+
+	s := &Server{MaxSearchSizeLimit: 2}
+	m := testMessage(t, testSearchRequest("dc=example,dc=com", 0, 0))
+	rec := NewResponseRecorder()
+	l := s.newLimitWriter(m)
+	defer l.stop()
+	if err := WriteEntries(l.wrap(rec), entries); err != ErrSizeLimitExceeded {
+		t.Errorf("WriteEntries error %v, want %v", err, ErrSizeLimitExceeded)
+	}
+	if n := len(rec.Entries()); n != 2 {
+		t.Errorf("%d entries written at once, want 2", n)
+	}
+}
+
+func TestLimitWriterTimeLimit(t *testing.T) {
+	s := &Server{MaxSearchTimeLimit: 10 * time.Millisecond}
+	m := testMessage(t, testSearchRequest("dc=example,dc=com", 0, 0))
+	m.received = time.Now()
+	rec := NewResponseRecorder()
+	l := s.newLimitWriter(m)
+	w := l.wrap(rec)
+
+	select {
+	case <-m.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("request context not canceled at the time limit")
+	}
+	w.Write(testSearchResultEntry(*NewEntry("cn=late,dc=example,dc=com")))
+	w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+	l.stop()
+
+	if n := len(rec.Entries()); n != 0 {
+		t.Errorf("%d entries written after the time limit", n)
+	}
+	if code := rec.ResultCode(); code != LDAPResultTimeLimitExceeded {
+		t.Errorf("result code %d, want %d", code, LDAPResultTimeLimitExceeded)
+	}
+}
+
+func TestLimitWriterUnlimited(t *testing.T) {
+	s := &Server{}
+	rec := NewResponseRecorder()
+	m := testMessage(t, testSearchRequest("dc=example,dc=com", 0, 0))
+	if l := s.newLimitWriter(m); l.wrap(rec) != ResponseWriter(rec) {
+		t.Error("unlimited search writer wrapped")
+	}
+	m = testMessage(t, berOctetString(berClassApplication|ApplicationDelRequest, []byte("cn=foo")))
+	if l := s.newLimitWriter(m); l.wrap(rec) != ResponseWriter(rec) {
+		t.Error("delete request writer wrapped")
+	}
+}
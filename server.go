@@ -2,32 +2,303 @@ package ldapserver
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	ldap "github.com/ps78674/goldap/message"
 )
 
 // Server is an LDAP server.
 type Server struct {
-	Listener     net.Listener
-	ReadTimeout  time.Duration  // optional read timeout
-	WriteTimeout time.Duration  // optional write timeout
-	wg           sync.WaitGroup // group of goroutines (1 by client)
-	chDone       chan bool      // Channel Done, value => shutdown
+	Listener                net.Listener
+	ReadTimeout             time.Duration      // optional read timeout
+	WriteTimeout            time.Duration      // optional write timeout
+	HandshakeTimeout        time.Duration      // optional TLS handshake timeout
+	IdleTimeout             time.Duration      // optional read timeout while a client has no request in flight
+	MaxConnectionOperations int                // optional number of operations a connection runs over its lifetime before it is disconnected
+	RequireTLS              bool               // only accept StartTLS until TLS is established
+	MaxConnections          int                // optional number of connections served at the same time
+	AcceptRate              float64            // optional number of connections accepted per second
+	AcceptBurst             int                // connections accepted at once above AcceptRate
+	NoticeOnRefusal         bool               // send a Notice of Disconnection to the connections refused by the limits above
+	IdleReapInterval        time.Duration      // optional interval of the scans disconnecting the connections idle beyond IdleTimeout
+	IdleNotice              bool               // send a Notice of Disconnection to the idle connections disconnected
+	LogLevel                LogLevel           // minimum level of logged messages
+	DetectTLS               bool               // detect TLS ClientHello on plaintext connections
+	AllowLDAPv2             bool               // pass LDAPv2 binds to the Handler instead of answering protocolError
+	TLSConfig               *tls.Config        // optional TLS configuration used to serve detected TLS clients
+	wg                      sync.WaitGroup     // group of goroutines (1 by client)
+	chDone                  chan bool          // Channel Done, value => shutdown
+	numero                  int64              // number of the last accepted client
+	mu                      sync.Mutex         // protects listeners, addrHandlers and started
+	started                 time.Time          // time the server started serving
+	listeners               []net.Listener     // listeners being served
+	addrHandlers            map[string]Handler // handlers by listening address, see HandleAddr
+	config                  Config             // configuration the server was built from, if any
+	settings                *Settings          // runtime tunables, see Settings()
+	settingsOnce            sync.Once
+	settingsSet             int32          // set once settings is initialized, atomically
+	connections             int64          // number of connections being served
+	acceptLimiter           rateLimiter    // throttles accepted connections
+	events                  EventBus       // lifecycle events, see Events()
+	terminations            terminations   // operations signaled to stop, see Stats()
+	decodeFailures          decodeFailures // PDUs which could not be decoded, see Stats()
+	messageIDReuses         int64          // requests reusing the message ID of a request in flight
+	abandonsNotFound        int64          // AbandonRequests for no request in flight
+	busyResponses           int64          // requests refused by MaxClientRequests
+	refusedConns            int64          // connections refused by MaxConnections or AcceptRate
+	idleReaped              int64          // idle connections disconnected by reapIdle
+	droppedResponses        int64          // intermediate responses of terminated requests not written
+	changes                 ChangeStream   // changes applied by the built-in backends, see Changes()
+
+	clients    map[*client]bool // connections being served, closed by Shutdown and Close
+	stopOnce   sync.Once
+	reaperOnce sync.Once
+
+	stopping        int32                      // set once the listeners are closed by stop
+	drainRequested  int32                      // set once the AdminDrain operation stops the server
+	shutdownHooks   map[ShutdownPhase][]func() // protected by mu, see OnShutdown
+	shutdownMu      sync.Mutex                 // serializes the shutdown phases
+	shutdownReached uint                       // phases reached, protected by shutdownMu
+
+	clientErrors logSampler // applies ClientErrorLogLimit
+
+	readiness      Readiness                   // protected by mu, see Readiness
+	readinessChans map[Readiness]chan struct{} // protected by mu, see ReadinessReached
+	readinessMu    sync.Mutex                  // serializes the readiness transitions
+	drainOnce      sync.Once
+	drainDeadline  time.Time // end of the DrainDelay
+
+	rootDSE  map[string][]string // protected by mu, see SetRootDSE
+	schema   *Schema             // protected by mu, see SetSchema
+	schemaDN DN
 
 	// OnNewConnection, if non-nil, is called on new connections.
 	// If it returns non-nil, the connection is closed.
 	onNewConnection func(c net.Conn) error
 
+	// OnAdmit, if non-nil, is called on new connections with settings
+	// initialized from the server defaults, which it may adjust for this
+	// connection only. If it returns non-nil, the connection is closed.
+	OnAdmit func(c net.Conn, settings *ConnSettings) error
+
+	// Admission, if non-nil, decides asynchronously whether new connections
+	// are served, their first PDU is not read before. AdmissionTimeout, if
+	// non-zero, bounds the decision, connections are refused past it.
+	Admission        Admission
+	AdmissionTimeout time.Duration
+
+	// GreetingDelay, if non-zero, is the time new connections wait before
+	// their first PDU is read. RejectEarlyTalkers disconnects the plaintext
+	// clients sending data that is not BER encoded LDAP before the delay
+	// elapsed and the Admission decision completed, LDAP clients may send
+	// their first request right away.
+	GreetingDelay      time.Duration
+	RejectEarlyTalkers bool
+
+	// SNICertificates are the certificates served to TLS clients requesting
+	// the name of their key with SNI, see GetCertificate. Names are
+	// lowercase, and may be wildcards such as "*.example.com".
+	SNICertificates map[string]*tls.Certificate
+
+	// OnTLSHandshake, if non-nil, is called once a client completes a TLS
+	// handshake, LDAPS or StartTLS, with the connection settings which it
+	// may adjust, for instance from the SNI name. If it returns non-nil,
+	// the handshake fails.
+	OnTLSHandshake func(state tls.ConnectionState, settings *ConnSettings) error
+
+	// ErrorLog specifies an optional logger for connection and server
+	// events. If nil, logging is done via the log package's standard logger.
+	ErrorLog *log.Logger
+
+	// BaseContext, if non-nil, returns the parent of the contexts of the
+	// requests, see Message.Context. It may carry values, or be canceled to
+	// stop all the running requests.
+	BaseContext func() context.Context
+
+	// Logger, if non-nil, receives the log messages with structured fields
+	// instead of ErrorLog. Messages below the LogLevel are not sent.
+	Logger Logger
+
+	// ClientErrorLogLimit bounds the logging of the timeouts, read, decode
+	// and TLS errors of the clients, per client host and error class, so a
+	// scanner hammering the port does not flood the logs
+	ClientErrorLogLimit LogRateLimit
+
+	// Complexity limits, requests exceeding them are answered with
+	// adminLimitExceeded. Zero disables a limit.
+	MaxFilterDepth         int // optional nesting depth of search filters
+	MaxFilterTerms         int // optional number of terms of search filters
+	MaxRequestedAttributes int // optional number of attributes requested by a search
+	MaxModifyChanges       int // optional number of changes of a modify request
+
+	// ReadOnly refuses write operations (add, modify, delete, modifyDN and
+	// password modify) with unwillingToPerform, without reaching the Handler.
+	// Use Settings or the cn=config entry of RouteMux.ConfigBackend, which
+	// stays writable, to switch it while the server runs.
+	ReadOnly bool
+
+	// Maintenance refuses all operations but binds with unavailable and
+	// MaintenanceMessage, for planned failovers. If MaintenanceDisconnect is
+	// non-zero, clients are sent a Notice of Disconnection that long after
+	// their first refused operation. Use Settings to switch it at runtime.
+	Maintenance           bool
+	MaintenanceMessage    string
+	MaintenanceDisconnect time.Duration
+
+	// FilterCost, if non-nil, scores search filters to reject or
+	// deprioritize expensive searches
+	FilterCost *FilterCostPolicy
+
+	// StrictDN rejects requests whose DNs are not valid RFC 4514 DNs, see
+	// ParseDNStrict, with invalidDNSyntax, before they reach the Handler
+	StrictDN bool
+
+	// StrictUTF8 rejects Add and Modify requests with values which are not
+	// valid UTF-8 with invalidAttributeSyntax, and normalizes the others to
+	// NFC before they reach the Handler, binary attributes excepted
+	StrictUTF8 bool
+
+	// MaxValueSize, if non-zero, is the size in bytes of the largest value
+	// accepted by Add and Modify requests, MaxAttributeValueSize overrides
+	// it by lowercase attribute name, zero for unlimited. Larger values are
+	// refused with adminLimitExceeded before reaching the Handler.
+	MaxValueSize          int
+	MaxAttributeValueSize map[string]int
+
+	// MaxMessageSize, if non-zero, is the size in bytes of the largest
+	// request accepted, checked against its BER length before it is read.
+	// The connections sending a larger one are closed, and the request
+	// counted as an oversizeLength decode failure.
+	MaxMessageSize int
+
+	// Drain selects the requests allowed to complete when a client unbinds
+	// or disconnects, the others are abandoned. DrainTimeout, if non-zero,
+	// caps the time given to them before they are abandoned too.
+	Drain        DrainPolicy
+	DrainTimeout time.Duration
+
+	// OnReadiness, if non-nil, is called on each transition of the server
+	// Readiness. On draining, clients are sent the Notice of Disconnection
+	// only once it returned and DrainDelay elapsed, so it can deregister the
+	// server from service discovery (Consul, DNS...) before. DrainDelay is
+	// not applied by Close.
+	OnReadiness func(state Readiness)
+	DrainDelay  time.Duration
+
+	// MaxSearchSizeLimit and MaxSearchTimeLimit, if non-zero, bound the
+	// entries returned by searches and their duration, over the sizeLimit
+	// and timeLimit the clients request. The server enforces the smaller of
+	// both: past the size limit the entries written are dropped and the
+	// search answered with sizeLimitExceeded, at the time limit it is
+	// answered with timeLimitExceeded and the request Context canceled.
+	MaxSearchSizeLimit int
+	MaxSearchTimeLimit time.Duration
+
+	// RootDSEInfo, if non-nil, adds vendor and implementation attributes
+	// to the RootDSE served with SetRootDSE
+	RootDSEInfo *RootDSEInfo
+
+	// EntryTransform, if non-nil, rewrites or drops every search result
+	// entry written by the Handler, before it is encoded
+	EntryTransform EntryTransform
+
+	// ReadAccess, if non-nil, removes from the search result entries the
+	// attributes the client may not read, after EntryTransform and the
+	// route transforms. The search filter components and the Compare
+	// assertions on those attributes are treated as Undefined and
+	// noSuchAttribute, so their values can not be told.
+	ReadAccess ReadAccess
+
+	// SelectAttributes, if true, reduces the search result entries to the
+	// attributes requested by the search, after ReadAccess, so handlers may
+	// write whole entries (RFC 4511 section 4.5.1.8): the user attributes
+	// when none or "*" are requested, the OperationalAttributes when named
+	// or with "+", none with "1.1", and their types only with typesOnly
+	SelectAttributes bool
+
+	// Deduplicate, if not DedupNone, drops the search result entries sent
+	// twice in response to one search, after EntryTransform
+	Deduplicate DedupPolicy
+
+	// AnswerMessageIDReuse answers the requests reusing the message ID of
+	// a request in flight with protocolError instead of disconnecting the
+	// client with a Notice of Disconnection. The answer carries the reused
+	// ID, so the client may take it for the answer of the request in
+	// flight.
+	AnswerMessageIDReuse bool
+
+	// MaxClientRequests is the number of requests a connection may have in
+	// flight, the requests read beyond are answered with busy. Abandon
+	// requests are not limited. Unlimited if zero.
+	MaxClientRequests int
+
+	// ProxyAuthorization, if non-nil, approves the requests of the client
+	// bound as identity to be processed as authzID, the authorization
+	// identity of their ProxiedAuthorization control. Denied requests are
+	// answered with authorizationDenied. If nil, the control is not
+	// supported.
+	ProxyAuthorization func(m *Message, identity, authzID string) bool
+
+	// ProfileLabels sets pprof labels around the handlers, the operation
+	// and the RouteMux route, so CPU profiles attribute the time spent to
+	// them. It has a small cost per request.
+	ProfileLabels bool
+
+	// JournalSize, if non-zero, is the number of operations kept per
+	// connection in a journal of summaries, without credentials, logged
+	// when a handler panics and served by the AdminJournal operation, to
+	// reconstruct what led to a crash or a deadlock. See Journals.
+	JournalSize int
+
+	// MissingResponse configures the response written when a handler
+	// returns without writing a terminal response
+	MissingResponse MissingResponsePolicy
+
 	// Handler handles ldap message received from client
 	// it SHOULD "implement" RequestHandler interface
 	Handler Handler
 }
 
-//NewServer return a LDAP Server
+// DrainPolicy selects the in-flight requests which are let complete when a
+// client connection is torn down
+type DrainPolicy int
+
+const (
+	DrainNone   DrainPolicy = iota // abandon all requests immediately
+	DrainWrites                    // let write operations complete, so committed changes are answered
+	DrainAll                       // let all requests complete
+)
+
+// MissingResponsePolicy configures the response written on behalf of a
+// handler which returned without writing a terminal response, so clients
+// never wait forever for a SearchResultDone
+type MissingResponsePolicy struct {
+	Disabled          bool   // write nothing
+	ResultCode        int    // LDAPResultOperationsError if zero
+	DiagnosticMessage string // a generic message if empty
+}
+
+// ConnSettings holds the policy applied to a single client connection.
+// A zero value disables the corresponding limit.
+type ConnSettings struct {
+	ReadTimeout             time.Duration // read timeout while requests are in flight
+	WriteTimeout            time.Duration // write timeout
+	IdleTimeout             time.Duration // read timeout while no request is in flight, ReadTimeout if zero
+	MaxConnectionOperations int           // number of operations accepted over the connection lifetime before disconnecting
+	RequireTLS              bool          // only StartTLS is accepted until TLS is established
+}
+
+// NewServer return a LDAP Server
 func NewServer() *Server {
 	return &Server{
 		chDone: make(chan bool),
@@ -35,14 +306,72 @@ func NewServer() *Server {
 }
 
 // Handle registers the handler for the server.
-// If a handler already exists for pattern, Handle panics
+// If a handler already exists, Handle panics with ErrHandlerRegistered
 func (s *Server) Handle(h Handler) {
 	if s.Handler != nil {
-		log.Fatalln("error registering request handler: multiple registrations")
+		panic(ErrHandlerRegistered)
 	}
 	s.Handler = h
 }
 
+// HandleAddr registers the handler serving the connections accepted on the
+// listeners bound to addr, instead of the server Handler, for instance to
+// serve write routes on an internal port only. addr is an address given to
+// the ListenAndServe functions, host names match all their addresses.
+// If a handler already exists for addr, HandleAddr panics with
+// ErrHandlerRegistered
+func (s *Server) HandleAddr(addr string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.addrHandlers[addr]; ok {
+		panic(ErrHandlerRegistered)
+	}
+	if s.addrHandlers == nil {
+		s.addrHandlers = make(map[string]Handler)
+	}
+	s.addrHandlers[addr] = h
+}
+
+// handlerFor returns the Handler serving the connections accepted on l
+func (s *Server) handlerFor(l net.Listener) Handler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for addr, h := range s.addrHandlers {
+		if listensOn(l.Addr(), addr) {
+			return h
+		}
+	}
+	return s.Handler
+}
+
+// listensOn reports whether a listener bound to a listens on addr
+func listensOn(a net.Addr, addr string) bool {
+	tcpAddr, ok := a.(*net.TCPAddr)
+	if !ok {
+		return a.String() == addr
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || port != strconv.Itoa(tcpAddr.Port) {
+		return false
+	}
+	if host == "" {
+		return tcpAddr.IP.IsUnspecified()
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.Equal(tcpAddr.IP)
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return false
+	}
+	for _, ip := range ips {
+		if ip.Equal(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
 // ListenAndServe listens on the TCP network address s.Addr and then
 // calls Serve to handle requests on incoming connections.  If
 // s.Addr is blank, ":389" is used.
@@ -51,17 +380,49 @@ func (s *Server) ListenAndServe(addr string, ch chan error, options ...func(*Ser
 	s.Listener, e = net.Listen("tcp", addr)
 
 	if e != nil {
-		ch <- fmt.Errorf("error creating listener: %s", e)
+		ch <- &ListenError{Addr: addr, Err: e}
+		return
+	}
+
+	if e = s.setup([]net.Listener{s.Listener}, options); e != nil {
+		ch <- e
 		return
 	}
 
 	close(ch)
 
-	for _, option := range options {
-		option(s)
+	s.serve()
+}
+
+// ListenAndServeAll resolves the host part of addr and listens on every
+// address it resolves to (IPv4 and IPv6 alike), then serves all of them.
+// A blank host listens on all local addresses. Each address failing to
+// bind is emitted as a ListenFailed event and logged, while the others are
+// served. ch receives the fatal errors only, such as no address bound, and
+// is closed once listening has started or after the error.
+func (s *Server) ListenAndServeAll(addr string, ch chan error, options ...func(*Server)) {
+	host, port, e := net.SplitHostPort(addr)
+	if e != nil {
+		ch <- fmt.Errorf("error parsing address: %s", e)
+		close(ch)
+		return
 	}
 
-	s.serve()
+	addrs := []string{addr}
+	if host != "" {
+		ips, e := net.DefaultResolver.LookupIPAddr(context.Background(), host)
+		if e != nil {
+			ch <- fmt.Errorf("error resolving %s: %s", host, e)
+			close(ch)
+			return
+		}
+		addrs = addrs[:0]
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+		}
+	}
+
+	s.listenAndServe(addrs, nil, true, ch, options)
 }
 
 // ListenAndServeTLS doing the same as ListenAndServe,
@@ -73,84 +434,465 @@ func (s *Server) ListenAndServeTLS(addr string, certFile string, keyFile string,
 		addr = ":636"
 	}
 
-	cert, e := tls.LoadX509KeyPair(certFile, keyFile)
+	tlsConfig, e := s.loadTLSConfig(certFile, keyFile)
 	if e != nil {
-		ch <- fmt.Errorf("error creating certificate chain: %s", e)
+		ch <- e
 		return
 	}
 
-	tlsConfig := tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionSSL30, MaxVersion: tls.VersionTLS12}
-	s.Listener, e = tls.Listen("tcp", addr, &tlsConfig)
+	s.Listener, e = tls.Listen("tcp", addr, tlsConfig)
 	if e != nil {
-		ch <- fmt.Errorf("error creating listener: %s", e)
+		ch <- &ListenError{Addr: addr, Err: e}
+		return
+	}
+
+	if e = s.setup([]net.Listener{s.Listener}, options); e != nil {
+		ch <- e
+		return
+	}
+
+	close(ch)
+
+	s.serve()
+}
+
+// ListenAndServeDual listens on a single TCP address serving both
+// plaintext LDAP and LDAPS: the first bytes sent by each client are
+// sniffed, and connections opening with a TLS ClientHello are served
+// with the given certificate. If addr is blank, ":389" is used.
+func (s *Server) ListenAndServeDual(addr string, certFile string, keyFile string, ch chan error, options ...func(*Server)) {
+
+	if addr == "" {
+		addr = ":389"
+	}
+
+	tlsConfig, e := s.loadTLSConfig(certFile, keyFile)
+	if e != nil {
+		ch <- e
+		return
+	}
+
+	s.Listener, e = net.Listen("tcp", addr)
+	if e != nil {
+		ch <- &ListenError{Addr: addr, Err: e}
+		return
+	}
+
+	s.TLSConfig = tlsConfig
+	s.DetectTLS = true
+
+	if e = s.setup([]net.Listener{s.Listener}, options); e != nil {
+		ch <- e
+		return
+	}
+
+	close(ch)
+
+	s.serve()
+}
+
+// ListenAndServeAddrs listens on the plaintext addresses addrs, where
+// StartTLS is available when the server has a TLSConfig, and on the LDAPS
+// addresses tlsAddrs served with the TLSConfig. All the listeners share
+// the handler, the client numbering, the connection limits and the
+// shutdown of the server. Listening errors are sent on ch, which is
+// closed once all addresses are bound or after the error.
+func (s *Server) ListenAndServeAddrs(addrs []string, tlsAddrs []string, ch chan error, options ...func(*Server)) {
+	s.listenAndServe(addrs, tlsAddrs, false, ch, options)
+}
+
+// listenAndServe listens on the plaintext addresses addrs and the LDAPS
+// addresses tlsAddrs, then serves all of them. When partial is set, the
+// addresses failing to bind are emitted as ListenFailed events and logged
+// while the others are served, otherwise the first one is fatal. Fatal
+// errors are sent on ch, which is closed once listening has started or
+// after the error.
+func (s *Server) listenAndServe(addrs []string, tlsAddrs []string, partial bool, ch chan error, options []func(*Server)) {
+	var listeners []net.Listener
+	fail := func(e error) {
+		for _, l := range listeners {
+			l.Close()
+		}
+		ch <- e
+		close(ch)
+	}
+
+	if len(addrs) == 0 && len(tlsAddrs) == 0 {
+		fail(&ListenError{Err: errors.New("no address configured")})
+		return
+	}
+	if len(tlsAddrs) > 0 && s.TLSConfig == nil {
+		fail(&ListenError{Addr: tlsAddrs[0], Err: errors.New("LDAPS address without TLSConfig")})
 		return
 	}
 
+	all := append(append([]string(nil), addrs...), tlsAddrs...)
+	var failed []*ListenError
+	for i, addr := range all {
+		var l net.Listener
+		var e error
+		if i < len(addrs) {
+			l, e = net.Listen("tcp", addr)
+		} else {
+			l, e = tls.Listen("tcp", addr, s.TLSConfig)
+		}
+		if e == nil {
+			listeners = append(listeners, l)
+			continue
+		}
+		if !partial {
+			fail(&ListenError{Addr: addr, Err: e})
+			return
+		}
+		failed = append(failed, &ListenError{Addr: addr, Err: e})
+		s.events.emit(ListenFailed{Addr: addr, Err: e})
+	}
+	if len(listeners) == 0 {
+		fail(&ListenError{Addr: strings.Join(all, ","), Err: errors.New("no address could be bound")})
+		return
+	}
+
+	if e := s.setup(listeners, options); e != nil {
+		// setup closed the listeners
+		ch <- e
+		close(ch)
+		return
+	}
+
+	s.mu.Lock()
+	s.Listener = listeners[0]
+	s.mu.Unlock()
 	close(ch)
 
+	// logged once the options set the log level
+	for _, e := range failed {
+		s.logAt(LogLevelWarn, "%s", e)
+	}
+	s.serveListeners(listeners)
+}
+
+// Serve accepts connections on l and serves them until the server stops,
+// which returns nil, or l fails. It serves listeners created by the
+// caller: systemd socket activation, unix sockets, PROXY protocol
+// wrappers, in-memory pipes for tests... Connections of tls.NewListener
+// listeners are served as LDAPS. Serve may be called for several
+// listeners at once, and closes l when it returns.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.Listener == nil {
+		s.Listener = l
+	}
+	s.mu.Unlock()
+	return s.serveListener(l)
+}
+
+// loadTLSConfig returns a TLS configuration serving the certificate chain
+// read from certFile and keyFile, or the SNICertificates matching the name
+// requested by the client
+func (s *Server) loadTLSConfig(certFile string, keyFile string) (*tls.Config, error) {
+	cert, e := tls.LoadX509KeyPair(certFile, keyFile)
+	if e != nil {
+		return nil, fmt.Errorf("%w: %s", ErrTLSLoad, e)
+	}
+
+	return &tls.Config{
+		Certificates:   []tls.Certificate{cert},
+		GetCertificate: s.GetCertificate,
+		MinVersion:     tls.VersionSSL30,
+		MaxVersion:     tls.VersionTLS12,
+	}, nil
+}
+
+// setup applies the options and checks the server is able to serve, the
+// listeners are closed when it is not
+func (s *Server) setup(listeners []net.Listener, options []func(*Server)) error {
 	for _, option := range options {
 		option(s)
 	}
 
-	s.serve()
+	for _, l := range listeners {
+		if s.handlerFor(l) == nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return ErrNoHandler
+		}
+	}
+	return nil
 }
 
 // Handle requests messages on the listener
 func (s *Server) serve() {
-	defer s.Listener.Close()
+	if err := s.serveListener(s.Listener); err != nil {
+		s.logAt(LogLevelError, "%s", err)
+	}
+}
 
-	if s.Handler == nil {
-		log.Fatalln("error handling request messages: no request handler defined")
+// serveListeners serves all listeners concurrently, it returns when all
+// of them are done
+func (s *Server) serveListeners(listeners []net.Listener) {
+	var wg sync.WaitGroup
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(l net.Listener) {
+			defer wg.Done()
+			if err := s.serveListener(l); err != nil {
+				s.logAt(LogLevelError, "%s: %s", l.Addr(), err)
+			}
+		}(l)
 	}
+	wg.Wait()
+}
+
+// serveListener accepts connections on l and serves them until the server
+// stops, which returns nil, or the listener fails
+func (s *Server) serveListener(l net.Listener) error {
+	defer l.Close()
 
-	i := 0
+	handler := s.handlerFor(l)
+	if handler == nil {
+		return ErrNoHandler
+	}
+
+	s.mu.Lock()
+	if s.started.IsZero() {
+		s.started = time.Now()
+	}
+	s.listeners = append(s.listeners, l)
+	s.mu.Unlock()
+	s.Settings() // the fields are read once serving
+	s.setReadiness(ReadinessReady)
+
+	if s.IdleReapInterval > 0 {
+		s.reaperOnce.Do(func() { go s.reapIdle() })
+	}
+
+	s.events.emit(ListenerStarted{Addr: l.Addr()})
 
 	for {
 		select {
 		case <-s.chDone:
-			log.Print("stopping server")
-			s.Listener.Close()
-			return
+			s.logf("stopping server")
+			return nil
 		default:
 		}
 
-		rw, err := s.Listener.Accept()
-
-		if s.ReadTimeout != 0 {
-			rw.SetReadDeadline(time.Now().Add(s.ReadTimeout))
-		}
-		if s.WriteTimeout != 0 {
-			rw.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
-		}
+		rw, err := l.Accept()
 		if err != nil {
+			if atomic.LoadInt32(&s.stopping) == 1 {
+				s.logf("stopping server")
+				return nil
+			}
 			if opErr, ok := err.(*net.OpError); ok && opErr.Timeout() {
 				continue
 			}
-			log.Println(err)
+			if errors.Is(err, net.ErrClosed) {
+				return ErrListenerClosed
+			}
+			s.logf("%s", err)
+			continue
+		}
+
+		limits := s.limits()
+		if !s.acceptLimiter.allow(limits.AcceptRate, limits.AcceptBurst) {
+			s.logAt(LogLevelWarn, "connection from %s refused: accept rate exceeded", rw.RemoteAddr())
+			s.refuse(rw, "accept rate exceeded")
+			continue
+		}
+		if limits.MaxConnections != 0 && int(atomic.LoadInt64(&s.connections)) >= limits.MaxConnections {
+			s.logAt(LogLevelWarn, "connection from %s refused: too many connections", rw.RemoteAddr())
+			s.refuse(rw, "too many connections")
+			continue
+		}
+
+		if limits.ReadTimeout != 0 {
+			rw.SetReadDeadline(time.Now().Add(limits.ReadTimeout))
+		}
+		if limits.WriteTimeout != 0 {
+			rw.SetWriteDeadline(time.Now().Add(limits.WriteTimeout))
 		}
 
-		cli := s.newClient(rw)
+		cli := s.newClient(rw, limits)
+		cli.handler = handler
 
-		i = i + 1
-		cli.numero = i
-		log.Printf("client [%d]: accepted connection from %s", cli.numero, cli.rwc.RemoteAddr().String())
+		cli.numero = int(atomic.AddInt64(&s.numero, 1))
+		cli.logAt(LogLevelInfo, "accepted connection from %s", cli.rwc.RemoteAddr().String())
+		atomic.AddInt64(&s.connections, 1)
+		s.events.emit(ConnAccepted{Numero: cli.numero, RemoteAddr: cli.rwc.RemoteAddr()})
 		s.wg.Add(1)
+		s.trackClient(cli, true)
 		go cli.serve()
 	}
 }
 
+// refuse closes the connection rw refused by the limits, after sending it
+// a Notice of Disconnection with NoticeOnRefusal
+func (s *Server) refuse(rw net.Conn, reason string) {
+	atomic.AddInt64(&s.refusedConns, 1)
+	if !s.NoticeOnRefusal {
+		rw.Close()
+		return
+	}
+	notice := berConstructedTLV(berClassApplication|berConstructed|ApplicationExtendedResponse,
+		append(encodeLDAPResult(LDAPResultBusy, "", reason),
+			berOctetString(berClassContext|10, []byte(NoticeOfDisconnection)))...)
+	// the accept loop must not wait for slow clients, nor for the TLS
+	// handshake of the LDAPS ones, the deadline bounds the write
+	go func() {
+		rw.SetDeadline(time.Now().Add(100 * time.Millisecond))
+		rw.Write(encodeRawMessage(0, notice, nil))
+		rw.Close()
+	}()
+}
+
 // Return a new session with the connection
 // client has a writer and reader buffer
-func (s *Server) newClient(rwc net.Conn) (c *client) {
+func (s *Server) newClient(rwc net.Conn, limits Limits) (c *client) {
 	c = &client{
-		srv: s,
-		rwc: rwc,
-		br:  bufio.NewReader(rwc),
-		bw:  bufio.NewWriter(rwc),
+		srv:     s,
+		rwc:     rwc,
+		br:      bufio.NewReader(rwc),
+		bw:      bufio.NewWriter(rwc),
+		noticed: make(chan struct{}),
+		settings: ConnSettings{
+			ReadTimeout:             limits.ReadTimeout,
+			WriteTimeout:            limits.WriteTimeout,
+			IdleTimeout:             limits.IdleTimeout,
+			MaxConnectionOperations: limits.MaxConnectionOperations,
+			RequireTLS:              s.RequireTLS,
+		},
+	}
+	if s.JournalSize > 0 {
+		c.journal = newOpJournal(s.JournalSize)
 	}
+	c.touch()
 	return c
 }
 
+// checkRequest runs the server checks on a request before it is passed to
+// handler, it returns the response to answer with when one fails, or
+// a function to call once the Handler returns
+func (s *Server) checkRequest(handler Handler, m *Message) (ldap.ProtocolOp, func()) {
+	limits := s.limits()
+	if res := checkMaintenance(handler, m, limits); res != nil {
+		return res, nil
+	}
+	if limits.ReadOnly && isWriteRequest(m.ProtocolOp()) && !routesToConfigBackend(handler, m) {
+		return NewResponseForRequest(m.ProtocolOp(), LDAPResultUnwillingToPerform, "server is read-only"), nil
+	}
+	if res := checkComplexity(m.ProtocolOp(), limits); res != nil {
+		return res, nil
+	}
+	if res := checkDontUseCopy(m); res != nil {
+		return res, nil
+	}
+	if res := checkAdminControls(handler, m); res != nil {
+		return res, nil
+	}
+	if res := s.checkProxiedAuthz(m); res != nil {
+		return res, nil
+	}
+	if s.StrictDN {
+		if res := checkDNSyntax(m.ProtocolOp()); res != nil {
+			return res, nil
+		}
+	}
+	if s.StrictUTF8 {
+		if res := checkValuesUTF8(m.ProtocolOp()); res != nil {
+			return res, nil
+		}
+		if err := normalizeValues(m); err != nil {
+			return NewResponseForRequest(m.ProtocolOp(), LDAPResultOperationsError, err.Error()), nil
+		}
+	}
+	if res := s.checkValueSizes(m.ProtocolOp()); res != nil {
+		return res, nil
+	}
+	if res := s.checkCompareAccess(m); res != nil {
+		return res, nil
+	}
+	if _, ok := m.ProtocolOp().(ldap.SearchRequest); ok && s.FilterCost != nil {
+		return s.FilterCost.admit(m)
+	}
+	return nil, func() {}
+}
+
+// baseContext returns the parent of the client contexts
+func (s *Server) baseContext() context.Context {
+	if s.BaseContext != nil {
+		if ctx := s.BaseContext(); ctx != nil {
+			return ctx
+		}
+	}
+	return context.Background()
+}
+
+// NumConnections returns the number of connections being served
+func (s *Server) NumConnections() int {
+	return int(atomic.LoadInt64(&s.connections))
+}
+
+// Stats is a snapshot of the server activity
+type Stats struct {
+	Connections      int       `json:"connections"`      // connections being served
+	TotalConnections int       `json:"totalConnections"` // connections accepted since start
+	Started          time.Time `json:"started"`          // time the server started serving
+
+	// Terminations counts the operations signaled to stop before
+	// completing, by protocol operation name
+	Terminations map[string]TerminationCounts `json:"terminations"`
+
+	// DecodeFailures counts the PDUs received which could not be decoded
+	DecodeFailures DecodeFailureCounts `json:"decodeFailures"`
+
+	// MessageIDReuses counts the requests refused because they reused the
+	// message ID of a request in flight
+	MessageIDReuses int64 `json:"messageIDReuses"`
+
+	// AbandonsNotFound counts the AbandonRequests for no request in
+	// flight, the abandoned requests are counted in Terminations
+	AbandonsNotFound int64 `json:"abandonsNotFound"`
+
+	// BusyResponses counts the requests answered with busy because their
+	// connection had MaxClientRequests requests in flight
+	BusyResponses int64 `json:"busyResponses"`
+
+	// RefusedConnections counts the connections closed on accept because
+	// of MaxConnections or AcceptRate
+	RefusedConnections int64 `json:"refusedConnections"`
+
+	// IdleReaped counts the connections disconnected for being idle
+	// beyond IdleTimeout, see IdleReapInterval
+	IdleReaped int64 `json:"idleReaped"`
+
+	// DroppedResponses counts the search entries, or batches of entries
+	// written by WriteEntries, references and intermediate responses not
+	// encoded nor written because their request was abandoned, canceled or
+	// timed out
+	DroppedResponses int64 `json:"droppedResponses"`
+}
+
+// Stats returns a snapshot of the server activity
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	started := s.started
+	s.mu.Unlock()
+
+	return Stats{
+		Connections:        s.NumConnections(),
+		TotalConnections:   int(atomic.LoadInt64(&s.numero)),
+		Started:            started,
+		Terminations:       s.terminations.snapshot(),
+		DecodeFailures:     s.decodeFailures.snapshot(),
+		MessageIDReuses:    atomic.LoadInt64(&s.messageIDReuses),
+		AbandonsNotFound:   atomic.LoadInt64(&s.abandonsNotFound),
+		BusyResponses:      atomic.LoadInt64(&s.busyResponses),
+		RefusedConnections: atomic.LoadInt64(&s.refusedConns),
+		IdleReaped:         atomic.LoadInt64(&s.idleReaped),
+		DroppedResponses:   atomic.LoadInt64(&s.droppedResponses),
+	}
+}
+
 // Termination of the LDAP session is initiated by the server sending a
 // Notice of Disconnection.  In this case, each
 // protocol peer gracefully terminates the LDAP session by ceasing
@@ -162,8 +904,107 @@ func (s *Server) newClient(rwc net.Conn) (c *client) {
 // transport connection.
 // In either case, when the LDAP session is terminated.
 func (s *Server) Stop() {
-	close(s.chDone)
-	log.Print("gracefully closing client connections")
+	s.drain(context.Background())
+	s.stop(context.Background())
+	s.shutdownPhase(ShutdownDrain)
+	s.logf("gracefully closing client connections")
 	s.wg.Wait()
-	log.Print("all client connections closed")
+	s.logf("all client connections closed")
+	s.shutdownPhase(ShutdownClosed)
+	s.setReadiness(ReadinessStopped)
+}
+
+// Shutdown stops the server as Stop does, but waits for the clients to
+// finish until ctx is done only. The remaining connections are then
+// closed, their requests abandoned, and Shutdown returns ctx.Err()
+// without waiting for their handlers to return.
+func (s *Server) Shutdown(ctx context.Context) error {
+	defer s.setReadiness(ReadinessStopped)
+	defer s.shutdownPhase(ShutdownClosed)
+	s.drain(ctx)
+	s.stop(ctx)
+	s.shutdownPhase(ShutdownDrain)
+	s.logf("gracefully closing client connections")
+
+	closed := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		s.logf("all client connections closed")
+		return nil
+	case <-ctx.Done():
+		s.logAt(LogLevelWarn, "shutdown deadline exceeded, closing remaining client connections")
+		s.closeClients()
+		return ctx.Err()
+	}
+}
+
+// Close stops the server immediately: the listeners and all the client
+// connections are closed and their requests abandoned, without any Notice
+// of Disconnection. Close does not wait for the handlers to return.
+func (s *Server) Close() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.stop(ctx)
+	s.closeClients()
+	s.shutdownPhase(ShutdownDrain)
+	s.shutdownPhase(ShutdownClosed)
+	s.setReadiness(ReadinessStopped)
+	return nil
+}
+
+// stop stops accepting connections, then signals the clients to
+// disconnect and waits until their Notice of Disconnection is queued or
+// ctx is done, once
+func (s *Server) stop(ctx context.Context) {
+	s.stopOnce.Do(func() {
+		s.setReadiness(ReadinessDraining)
+		s.events.emit(ServerStopping{})
+
+		// unblock listeners waiting for a new connection
+		atomic.StoreInt32(&s.stopping, 1)
+		s.mu.Lock()
+		for _, l := range s.listeners {
+			l.Close()
+		}
+		s.mu.Unlock()
+		s.shutdownPhase(ShutdownStopAccepting)
+
+		close(s.chDone)
+		s.waitNotices(ctx)
+	})
+	s.shutdownPhase(ShutdownNotice)
+}
+
+// closeClients closes the client connections and abandons their requests
+func (s *Server) closeClients() {
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range clients {
+		c.lost()
+		c.abandonRequests(TerminationDisconnected, func(*Message) bool { return true })
+		c.GetConn().Close()
+	}
+}
+
+// trackClient adds c to the connections being served, or removes it
+func (s *Server) trackClient(c *client, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.clients, c)
+		return
+	}
+	if s.clients == nil {
+		s.clients = make(map[*client]bool)
+	}
+	s.clients[c] = true
 }
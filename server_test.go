@@ -0,0 +1,86 @@
+package ldapserver
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListenAndServeAddrsError(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	tests := []struct {
+		name     string
+		addrs    []string
+		tlsAddrs []string
+		addr     string
+	}{
+		{"none", nil, nil, ""},
+		{"busy", []string{"127.0.0.1:0", busy.Addr().String()}, nil, busy.Addr().String()},
+		{"no TLSConfig", []string{"127.0.0.1:0"}, []string{"127.0.0.1:0"}, "127.0.0.1:0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer()
+			s.Handle(NewRouteMux())
+			ch := make(chan error, 1)
+			go s.ListenAndServeAddrs(tt.addrs, tt.tlsAddrs, ch)
+
+			var le *ListenError
+			select {
+			case err := <-ch:
+				if !errors.As(err, &le) || le.Addr != tt.addr {
+					t.Fatalf("error = %v, want a ListenError on %q", err, tt.addr)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no error")
+			}
+			select {
+			case _, ok := <-ch:
+				if ok {
+					t.Fatal("ch received a second value")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("ch not closed")
+			}
+			if s.Listener != nil {
+				t.Errorf("Listener = %v, want nil", s.Listener)
+			}
+		})
+	}
+}
+
+func TestListenAndServeAllNoAddress(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	s := NewServer()
+	s.Handle(NewRouteMux())
+	var failed []ListenFailed
+	s.Events().Subscribe(func(e Event) {
+		if f, ok := e.(ListenFailed); ok {
+			failed = append(failed, f)
+		}
+	})
+	ch := make(chan error, 1)
+	go s.ListenAndServeAll(busy.Addr().String(), ch)
+
+	var le *ListenError
+	if err := <-ch; !errors.As(err, &le) {
+		t.Fatalf("error = %v, want a ListenError", err)
+	}
+	if _, ok := <-ch; ok {
+		t.Fatal("ch not closed")
+	}
+	if len(failed) != 1 || failed[0].Addr != busy.Addr().String() {
+		t.Errorf("ListenFailed events = %v", failed)
+	}
+}
@@ -0,0 +1,196 @@
+package ldapserver
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LogLevel is the minimum severity of the messages logged by the server
+type LogLevel int
+
+// Log levels, from the most to the least verbose
+const (
+	LogLevelDebug LogLevel = iota - 1
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+	LogLevelOff
+)
+
+var logLevelNames = map[LogLevel]string{
+	LogLevelDebug: "debug",
+	LogLevelInfo:  "info",
+	LogLevelWarn:  "warn",
+	LogLevelError: "error",
+	LogLevelOff:   "off",
+}
+
+func (l LogLevel) String() string {
+	if name, ok := logLevelNames[l]; ok {
+		return name
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+func (l LogLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *LogLevel) UnmarshalText(text []byte) error {
+	for level, name := range logLevelNames {
+		if strings.EqualFold(name, string(text)) {
+			*l = level
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown log level %q", text)
+}
+
+// Limits are the server tunables which can be changed while it runs.
+// Changes apply to connections accepted and operations started afterwards.
+// A zero value disables the corresponding limit.
+type Limits struct {
+	ReadTimeout             time.Duration // read timeout
+	WriteTimeout            time.Duration // write timeout
+	HandshakeTimeout        time.Duration // TLS handshake timeout
+	IdleTimeout             time.Duration // read timeout while a client has no request in flight
+	MaxConnectionOperations int           // operations accepted over a connection lifetime
+	MaxConnections          int           // connections served at the same time
+	AcceptRate              float64       // connections accepted per second
+	AcceptBurst             int           // connections accepted at once above AcceptRate
+	LogLevel                LogLevel      // minimum level of logged messages
+
+	MaxFilterDepth         int // nesting depth of search filters
+	MaxFilterTerms         int // terms of search filters
+	MaxRequestedAttributes int // attributes requested by a search
+	MaxModifyChanges       int // changes of a modify request
+
+	ReadOnly bool // refuse write operations
+
+	Maintenance           bool          // refuse all operations but binds with unavailable
+	MaintenanceMessage    string        // diagnostic message of refused operations
+	MaintenanceDisconnect time.Duration // delay before clients refused in maintenance are disconnected
+}
+
+// Settings holds the current Limits of a Server, it is safe for concurrent
+// use. Readers never block, writers are serialized.
+type Settings struct {
+	mu     sync.Mutex
+	limits atomic.Value // *Limits
+}
+
+func newSettings(l Limits) *Settings {
+	st := &Settings{}
+	st.limits.Store(&l)
+	return st
+}
+
+// Limits returns a copy of the current limits
+func (st *Settings) Limits() Limits {
+	return *st.limits.Load().(*Limits)
+}
+
+// SetLimits replaces all the current limits
+func (st *Settings) SetLimits(l Limits) {
+	st.mu.Lock()
+	st.limits.Store(&l)
+	st.mu.Unlock()
+}
+
+// Update applies f to a copy of the current limits, then makes it current
+func (st *Settings) Update(f func(l *Limits)) {
+	st.mu.Lock()
+	l := st.Limits()
+	f(&l)
+	st.limits.Store(&l)
+	st.mu.Unlock()
+}
+
+// Settings returns the runtime settings of the server. They are initialized
+// from the Server fields when it starts serving, or when Settings is called
+// before. Afterwards changing the fields has no effect and limits must be
+// tuned through the returned Settings.
+func (s *Server) Settings() *Settings {
+	s.settingsOnce.Do(func() {
+		s.settings = newSettings(s.fieldLimits())
+		atomic.StoreInt32(&s.settingsSet, 1)
+	})
+	return s.settings
+}
+
+// fieldLimits returns the Limits set in the Server fields
+func (s *Server) fieldLimits() Limits {
+	return Limits{
+		ReadTimeout:             s.ReadTimeout,
+		WriteTimeout:            s.WriteTimeout,
+		HandshakeTimeout:        s.HandshakeTimeout,
+		IdleTimeout:             s.IdleTimeout,
+		MaxConnectionOperations: s.MaxConnectionOperations,
+		MaxConnections:          s.MaxConnections,
+		AcceptRate:              s.AcceptRate,
+		AcceptBurst:             s.AcceptBurst,
+		LogLevel:                s.LogLevel,
+
+		MaxFilterDepth:         s.MaxFilterDepth,
+		MaxFilterTerms:         s.MaxFilterTerms,
+		MaxRequestedAttributes: s.MaxRequestedAttributes,
+		MaxModifyChanges:       s.MaxModifyChanges,
+
+		ReadOnly: s.ReadOnly,
+
+		Maintenance:           s.Maintenance,
+		MaintenanceMessage:    s.MaintenanceMessage,
+		MaintenanceDisconnect: s.MaintenanceDisconnect,
+	}
+}
+
+// limits returns the current limits, those of the Server fields until the
+// Settings are initialized, so logging before the server serves does not
+// freeze them
+func (s *Server) limits() Limits {
+	if atomic.LoadInt32(&s.settingsSet) == 0 {
+		return s.fieldLimits()
+	}
+	return s.settings.Limits()
+}
+
+// rateLimiter is a token bucket whose rate and burst are read on each call,
+// so they follow the server Settings
+type rateLimiter struct {
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// allow reports whether an event may happen now, given rate events per
+// second and bursts of burst events. A zero rate allows everything.
+func (r *rateLimiter) allow(rate float64, burst int) bool {
+	if rate <= 0 {
+		return true
+	}
+	if burst < 1 {
+		burst = 1
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if r.last.IsZero() {
+		r.tokens = float64(burst)
+	} else {
+		r.tokens += now.Sub(r.last).Seconds() * rate
+	}
+	r.last = now
+	if r.tokens > float64(burst) {
+		r.tokens = float64(burst)
+	}
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
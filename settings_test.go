@@ -0,0 +1,21 @@
+package ldapserver
+
+import "testing"
+
+func TestSettingsInitializedWhenServing(t *testing.T) {
+	s := NewServer()
+	s.Handle(successHandler{})
+	s.LogLevel = LogLevelOff
+	s.logAt(LogLevelError, "logged before serving")
+	s.ReadOnly = true
+	serveTest(t, s)
+	defer s.Stop()
+
+	if !s.Settings().Limits().ReadOnly {
+		t.Error("field set before serving ignored by the Settings")
+	}
+	s.Settings().Update(func(l *Limits) { l.ReadOnly = false })
+	if s.limits().ReadOnly {
+		t.Error("Settings update ignored")
+	}
+}
@@ -0,0 +1,99 @@
+package ldapserver
+
+import (
+	"context"
+	"fmt"
+)
+
+// ShutdownPhase is a step of the shutdown sequence of Stop, Shutdown and
+// Close. The phases are reached once, in this order, and the hooks
+// registered with OnShutdown for a phase have all returned before the next
+// phase starts:
+//
+//   - ShutdownStopAccepting: the listeners are closed, no connection is
+//     accepted anymore, Serve and ListenAndServe return nil
+//   - ShutdownNotice: the Notice of Disconnection of every connection
+//     accepted before is queued, ahead of the responses of the requests
+//     received afterwards. Close does not wait for them.
+//   - ShutdownDrain: the server waits for the clients to disconnect, and
+//     their requests to complete, as configured by Drain
+//   - ShutdownClosed: all the connections are closed, those remaining when
+//     the Shutdown context is done or Close is called included
+//
+// The server is draining (see Readiness) before the first phase, for
+// DrainDelay with Stop and Shutdown, and stopped after the last one.
+type ShutdownPhase int
+
+const (
+	ShutdownStopAccepting ShutdownPhase = iota
+	ShutdownNotice
+	ShutdownDrain
+	ShutdownClosed
+)
+
+func (p ShutdownPhase) String() string {
+	switch p {
+	case ShutdownStopAccepting:
+		return "stop accepting"
+	case ShutdownNotice:
+		return "notice"
+	case ShutdownDrain:
+		return "drain"
+	case ShutdownClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("ShutdownPhase(%d)", int(p))
+}
+
+// OnShutdown registers hook to be called when the shutdown sequence
+// reaches phase, from the goroutine stopping the server, after the hooks
+// registered before. The hooks of a phase must return for the shutdown to
+// proceed.
+func (s *Server) OnShutdown(phase ShutdownPhase, hook func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdownHooks == nil {
+		s.shutdownHooks = make(map[ShutdownPhase][]func())
+	}
+	s.shutdownHooks[phase] = append(s.shutdownHooks[phase], hook)
+}
+
+// shutdownPhase runs the hooks of phase and emits ShutdownPhaseReached,
+// once. The phases are serialized so concurrent Stop, Shutdown or Close
+// calls can't interleave them.
+func (s *Server) shutdownPhase(phase ShutdownPhase) {
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	if s.shutdownReached&(1<<uint(phase)) != 0 {
+		return
+	}
+	s.shutdownReached |= 1 << uint(phase)
+
+	s.mu.Lock()
+	hooks := s.shutdownHooks[phase]
+	s.mu.Unlock()
+	s.logAt(LogLevelDebug, "shutdown phase: %s", phase)
+	for _, hook := range hooks {
+		hook()
+	}
+	s.events.emit(ShutdownPhaseReached{Phase: phase})
+}
+
+// waitNotices waits until the connections served have queued their Notice
+// of Disconnection, or are closing, or ctx is done
+func (s *Server) waitNotices(ctx context.Context) {
+	s.mu.Lock()
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range clients {
+		select {
+		case <-c.noticed:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
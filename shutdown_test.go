@@ -0,0 +1,113 @@
+package ldapserver
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startTestServer returns a server serving NewRouteMux on a loopback
+// listener, once it accepts connections
+func startTestServer(t *testing.T) (*Server, net.Addr) {
+	t.Helper()
+	s := NewServer()
+	s.Handle(NewRouteMux())
+	return s, serveTest(t, s)
+}
+
+// serveTest serves s on a loopback listener and returns its address once
+// it accepts connections
+func serveTest(t *testing.T, s *Server) net.Addr {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	unsubscribe := s.Events().Subscribe(func(e Event) {
+		if _, ok := e.(ListenerStarted); ok {
+			close(started)
+		}
+	})
+	defer unsubscribe()
+	go s.Serve(l)
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("listener not started")
+	}
+	return l.Addr()
+}
+
+func TestShutdownPhases(t *testing.T) {
+	stops := []struct {
+		name string
+		stop func(s *Server)
+	}{
+		{"Stop", func(s *Server) { s.Stop() }},
+		{"Shutdown", func(s *Server) { s.Shutdown(context.Background()) }},
+		{"Close", func(s *Server) { s.Close() }},
+	}
+	want := []ShutdownPhase{ShutdownStopAccepting, ShutdownNotice, ShutdownDrain, ShutdownClosed}
+	for _, tt := range stops {
+		t.Run(tt.name, func(t *testing.T) {
+			s, addr := startTestServer(t)
+
+			var mu sync.Mutex
+			var hooks, events []ShutdownPhase
+			for _, phase := range want {
+				phase := phase
+				s.OnShutdown(phase, func() {
+					mu.Lock()
+					hooks = append(hooks, phase)
+					mu.Unlock()
+				})
+			}
+			s.Events().Subscribe(func(e Event) {
+				if e, ok := e.(ShutdownPhaseReached); ok {
+					mu.Lock()
+					events = append(events, e.Phase)
+					mu.Unlock()
+				}
+			})
+
+			tt.stop(s)
+			// the phases are reached once
+			tt.stop(s)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(hooks, want) {
+				t.Errorf("hooks called for %v, want %v", hooks, want)
+			}
+			if !reflect.DeepEqual(events, want) {
+				t.Errorf("events emitted for %v, want %v", events, want)
+			}
+			if conn, err := net.DialTimeout("tcp", addr.String(), time.Second); err == nil {
+				conn.Close()
+				t.Error("connection accepted once stopped")
+			}
+		})
+	}
+}
+
+func TestShutdownPhaseString(t *testing.T) {
+	tests := []struct {
+		phase ShutdownPhase
+		want  string
+	}{
+		{ShutdownStopAccepting, "stop accepting"},
+		{ShutdownNotice, "notice"},
+		{ShutdownDrain, "drain"},
+		{ShutdownClosed, "closed"},
+		{ShutdownPhase(42), "ShutdownPhase(42)"},
+	}
+	for _, tt := range tests {
+		if s := tt.phase.String(); s != tt.want {
+			t.Errorf("ShutdownPhase(%d).String() = %q, want %q", int(tt.phase), s, tt.want)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package ldapserver
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SearchSnapshots serves paged searches from read-only snapshots of their
+// result: the entries are fetched once, with the first page, and the next
+// pages iterate this stable view even while concurrent writes occur, so no
+// entry is duplicated or skipped across pages. Snapshots are kept per
+// connection until their last page is sent, the search is abandoned with a
+// zero page size, they are unused for TTL, the connection binds again or
+// closes.
+type SearchSnapshots struct {
+	TTL          time.Duration // time an unused snapshot is kept, 10 minutes if zero
+	MaxPerClient int           // snapshots kept per connection, the oldest is dropped beyond, 8 if zero
+	MaxTotal     int           // snapshots kept for all the connections, the oldest is dropped beyond, 1024 if zero
+
+	// MaxEntries is the number of entries a snapshot may hold, 100000 if
+	// zero. Larger results are answered with adminLimitExceeded, unless
+	// they fit in one page.
+	MaxEntries int
+
+	mu        sync.Mutex
+	snapshots map[*client]map[string]*searchSnapshot
+}
+
+// searchSnapshot is the result of a paged search
+type searchSnapshot struct {
+	request string // DescribeRequest of the search, the next pages must match it
+	entries []Entry
+	created time.Time
+	used    time.Time
+}
+
+// snapshotIDLen is the length of the snapshot identifier at the start of
+// the cookies, followed by the offset of the next page
+const snapshotIDLen = 16
+
+// WritePage answers the search request m with a page of its result, as
+// WritePagedEntries. fetch returns the whole result, it is called for the
+// first page only, the next pages are read from the snapshot taken then.
+// Searches without the Simple Paged Results control are answered with all
+// the entries fetched.
+func (s *SearchSnapshots) WritePage(w ResponseWriter, m *Message, fetch func() ([]Entry, error)) error {
+	paged, err := m.PagedResults()
+	if err == nil && paged == nil {
+		entries, err := fetch()
+		if err != nil {
+			return err
+		}
+		return WritePagedEntries(w, m, entries)
+	}
+	if err != nil {
+		resultCode, diagnosticMessage := DefaultErrorMapper(err)
+		w.Write(NewResponseForRequest(m.ProtocolOp(), resultCode, diagnosticMessage))
+		return err
+	}
+
+	var id string
+	var snapshot *searchSnapshot
+	var offset uint64
+	if len(paged.Cookie) > 0 {
+		if len(paged.Cookie) == snapshotIDLen+8 {
+			id = string(paged.Cookie[:snapshotIDLen])
+			offset = binary.BigEndian.Uint64(paged.Cookie[snapshotIDLen:])
+			snapshot = s.get(m.Client, id)
+		}
+		if snapshot == nil || snapshot.request != DescribeRequest(m) || offset > uint64(len(snapshot.entries)) {
+			WritePagedResultsDone(w, LDAPResultUnwillingToPerform, "invalid or expired paged results cookie", 0, nil)
+			return nil
+		}
+	}
+	// a zero size abandons the paged search
+	if paged.Size == 0 {
+		size := 0
+		if snapshot != nil {
+			size = len(snapshot.entries)
+			s.drop(m.Client, id)
+		}
+		WritePagedResultsDone(w, LDAPResultSuccess, "", size, nil)
+		return nil
+	}
+	if snapshot == nil {
+		entries, err := fetch()
+		if err != nil {
+			return err
+		}
+		if max := s.maxEntries(); len(entries) > paged.Size && len(entries) > max {
+			WritePagedResultsDone(w, LDAPResultAdminLimitExceeded, fmt.Sprintf("the result exceeds the %d entries of a paged search", max), 0, nil)
+			return nil
+		}
+		snapshot = &searchSnapshot{request: DescribeRequest(m), entries: entries, created: time.Now()}
+	}
+
+	start, end := int(offset), len(snapshot.entries)
+	if paged.Size < len(snapshot.entries)-start {
+		end = start + paged.Size
+	}
+	if err := WriteEntries(w, snapshot.entries[start:end]); err != nil {
+		return err
+	}
+	var cookie []byte
+	if end < len(snapshot.entries) {
+		if id == "" {
+			if id, err = s.put(m.Client, snapshot); err != nil {
+				return err
+			}
+		}
+		cookie = make([]byte, snapshotIDLen+8)
+		copy(cookie, id)
+		binary.BigEndian.PutUint64(cookie[snapshotIDLen:], uint64(end))
+	} else if id != "" {
+		s.drop(m.Client, id)
+	}
+	WritePagedResultsDone(w, LDAPResultSuccess, "", len(snapshot.entries), cookie)
+	return nil
+}
+
+func (s *SearchSnapshots) ttl() time.Duration {
+	if s.TTL > 0 {
+		return s.TTL
+	}
+	return 10 * time.Minute
+}
+
+func (s *SearchSnapshots) maxEntries() int {
+	if s.MaxEntries > 0 {
+		return s.MaxEntries
+	}
+	return 100000
+}
+
+// get returns the snapshot id of the client c, nil if it expired
+func (s *SearchSnapshots) get(c *client, id string) *searchSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snapshot := s.snapshots[c][id]
+	if snapshot == nil {
+		return nil
+	}
+	now := time.Now()
+	if now.Sub(snapshot.used) > s.ttl() {
+		delete(s.snapshots[c], id)
+		return nil
+	}
+	snapshot.used = now
+	return snapshot
+}
+
+// put stores the snapshot of the client c and returns its identifier
+func (s *SearchSnapshots) put(c *client, snapshot *searchSnapshot) (string, error) {
+	b := make([]byte, snapshotIDLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := string(b)
+	snapshot.used = time.Now()
+	if c != nil {
+		// the snapshots were fetched with the current identity
+		c.onBind(s, func() { s.dropClient(c) })
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.snapshots == nil {
+		s.snapshots = make(map[*client]map[string]*searchSnapshot)
+	}
+	if _, ok := s.snapshots[c]; !ok {
+		s.snapshots[c] = make(map[string]*searchSnapshot)
+		if c != nil && c.closing != nil {
+			go func() {
+				<-c.closing
+				s.mu.Lock()
+				delete(s.snapshots, c)
+				s.mu.Unlock()
+			}()
+		}
+	}
+	snapshots := s.snapshots[c]
+	for key, other := range snapshots {
+		if snapshot.used.Sub(other.used) > s.ttl() {
+			delete(snapshots, key)
+		}
+	}
+	max := s.MaxPerClient
+	if max <= 0 {
+		max = 8
+	}
+	for len(snapshots) >= max {
+		delete(snapshots, oldestSnapshot(snapshots))
+	}
+	maxTotal := s.MaxTotal
+	if maxTotal <= 0 {
+		maxTotal = 1024
+	}
+	for s.count() >= maxTotal {
+		var oldest map[string]*searchSnapshot
+		var oldestID string
+		for _, others := range s.snapshots {
+			if key := oldestSnapshot(others); key != "" && (oldest == nil || others[key].created.Before(oldest[oldestID].created)) {
+				oldest, oldestID = others, key
+			}
+		}
+		delete(oldest, oldestID)
+	}
+	snapshots[id] = snapshot
+	return id, nil
+}
+
+// count returns the number of snapshots kept, s.mu is held
+func (s *SearchSnapshots) count() int {
+	n := 0
+	for _, snapshots := range s.snapshots {
+		n += len(snapshots)
+	}
+	return n
+}
+
+// oldestSnapshot returns the identifier of the oldest of snapshots, empty
+// when there is none
+func oldestSnapshot(snapshots map[string]*searchSnapshot) string {
+	var oldest string
+	for key, other := range snapshots {
+		if oldest == "" || other.created.Before(snapshots[oldest].created) {
+			oldest = key
+		}
+	}
+	return oldest
+}
+
+// dropClient removes the snapshots of the client c
+func (s *SearchSnapshots) dropClient(c *client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.snapshots[c]; ok {
+		s.snapshots[c] = make(map[string]*searchSnapshot)
+	}
+}
+
+// drop removes the snapshot id of the client c
+func (s *SearchSnapshots) drop(c *client, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.snapshots[c], id)
+}
@@ -0,0 +1,35 @@
+package ldapserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSearchSnapshotsLimits(t *testing.T) {
+	s := &SearchSnapshots{MaxTotal: 2}
+	c1, c2 := &client{}, &client{}
+	created := time.Now()
+	put := func(c *client) string {
+		created = created.Add(time.Second)
+		id, err := s.put(c, &searchSnapshot{created: created})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	id1, id2, id3 := put(c1), put(c2), put(c2)
+	if s.get(c1, id1) != nil {
+		t.Error("oldest snapshot kept beyond MaxTotal")
+	}
+	if s.get(c2, id2) == nil || s.get(c2, id3) == nil {
+		t.Fatal("snapshot dropped within MaxTotal")
+	}
+
+	c2.startBind()
+	if s.get(c2, id2) != nil || s.get(c2, id3) != nil {
+		t.Error("snapshots kept after a bind")
+	}
+	if id := put(c2); s.get(c2, id) == nil {
+		t.Error("snapshot not kept after a bind")
+	}
+}
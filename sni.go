@@ -0,0 +1,40 @@
+package ldapserver
+
+import (
+	"crypto/tls"
+	"strings"
+)
+
+// GetCertificate selects the certificate presented to a TLS client from
+// the SNI name it sent: an exact match in Server.SNICertificates first,
+// then a wildcard entry such as "*.example.com". It returns nil, so the
+// default certificate of the tls.Config is used, when none matches. It is
+// set up on the configurations loaded by the ListenAndServe functions, and
+// may be used as the GetCertificate of a custom Server.TLSConfig.
+func (s *Server) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name == "" || len(s.SNICertificates) == 0 {
+		return nil, nil
+	}
+	if cert, ok := s.SNICertificates[name]; ok {
+		return cert, nil
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := s.SNICertificates["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	return nil, nil
+}
+
+// ServerName returns the SNI name sent by the client during the TLS
+// handshake, empty when the connection does not use TLS or no name was sent
+func (c *client) ServerName() string {
+	if c == nil {
+		return ""
+	}
+	if tlsConn, ok := c.rwc.(*tls.Conn); ok {
+		return tlsConn.ConnectionState().ServerName
+	}
+	return ""
+}
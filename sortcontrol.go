@@ -0,0 +1,281 @@
+package ldapserver
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// SortKey is a key of the Server Side Sort control (RFC 2891)
+type SortKey struct {
+	Attribute    string
+	OrderingRule string // matching rule name or OID, empty for the attribute ordering
+	Reverse      bool
+}
+
+// SortControl is the Server Side Sort control of a search request
+type SortControl struct {
+	Keys        []SortKey
+	Criticality bool
+}
+
+// SortError is returned by SortEntries when the entries can't be sorted,
+// it is answered with the SortResultControl of its result code and
+// attribute
+type SortError struct {
+	ResultCode int
+	Attribute  string
+}
+
+func (e *SortError) Error() string {
+	return fmt.Sprintf("can not sort by %s: result code %d", e.Attribute, e.ResultCode)
+}
+
+// orderingRules are the ordering matching rules SortEntries supports, by
+// lowercased name and OID
+var orderingRules = map[string]func(a, b []byte) int{
+	"caseignoreorderingmatch": compareCaseIgnore, "2.5.13.3": compareCaseIgnore,
+	"caseexactorderingmatch": compareCaseExact, "2.5.13.5": compareCaseExact,
+	"integerorderingmatch": compareInteger, "2.5.13.15": compareInteger,
+	"generalizedtimeorderingmatch": compareGeneralizedTime, "2.5.13.28": compareGeneralizedTime,
+}
+
+// compare compares two values of the attribute of k with its ordering
+// rule, which must be supported, or as CompareValues without one
+func (k SortKey) compare(a, b []byte) int {
+	if k.OrderingRule == "" {
+		return CompareValues(k.Attribute, a, b)
+	}
+	return orderingRules[strings.ToLower(k.OrderingRule)](a, b)
+}
+
+func compareCaseIgnore(a, b []byte) int {
+	return strings.Compare(strings.ToLower(string(a)), strings.ToLower(string(b)))
+}
+
+func compareCaseExact(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+// compareInteger compares integers numerically, the values which are not
+// integers come after the others
+func compareInteger(a, b []byte) int {
+	x, errA := strconv.ParseInt(string(a), 10, 64)
+	y, errB := strconv.ParseInt(string(b), 10, 64)
+	switch {
+	case errA != nil || errB != nil:
+		return compareInvalid(errA, errB, a, b)
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// compareGeneralizedTime compares GeneralizedTime values chronologically,
+// the values which are not GeneralizedTime come after the others
+func compareGeneralizedTime(a, b []byte) int {
+	x, errA := ParseGeneralizedTime(string(a))
+	y, errB := ParseGeneralizedTime(string(b))
+	switch {
+	case errA != nil || errB != nil:
+		return compareInvalid(errA, errB, a, b)
+	case x.Before(y):
+		return -1
+	case x.After(y):
+		return 1
+	}
+	return 0
+}
+
+// compareInvalid orders the values a and b an ordering rule failed to
+// parse with errA and errB: invalid values after valid ones, and byte by
+// byte between them
+func compareInvalid(errA, errB error, a, b []byte) int {
+	switch {
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return bytes.Compare(a, b)
+}
+
+// ServerSideSort returns the Server Side Sort control of the search
+// request m, nil when it has none. The error is a *ResultError with the
+// protocolError code when the control value is malformed.
+func (m *Message) ServerSideSort() (*SortControl, error) {
+	if _, ok := m.ProtocolOp().(ldap.SearchRequest); !ok {
+		return nil, nil
+	}
+	c, ok := requestControl(m, ControlSortRequest)
+	if !ok {
+		return nil, nil
+	}
+	return decodeSortControl(c.Criticality(), controlValue(c))
+}
+
+// decodeSortControl decodes the value of a Server Side Sort control
+func decodeSortControl(criticality bool, value []byte) (*SortControl, error) {
+	malformed := NewResultError(LDAPResultProtocolError, "malformed sort control")
+	if value == nil {
+		return nil, malformed
+	}
+
+	// SortKeyList ::= SEQUENCE OF SEQUENCE { attributeType
+	// AttributeDescription, orderingRule [0] MatchingRuleId OPTIONAL,
+	// reverseOrder [1] BOOLEAN DEFAULT FALSE }
+	list, _, err := berRead(value)
+	if err != nil || list.tag != berTagSequence {
+		return nil, malformed
+	}
+	keys, err := berChildren(list.data)
+	if err != nil || len(keys) == 0 {
+		return nil, malformed
+	}
+	control := &SortControl{Criticality: criticality}
+	for _, k := range keys {
+		fields, err := berChildren(k.data)
+		if err != nil || k.tag != berTagSequence || len(fields) == 0 || fields[0].tag != berTagOctetString {
+			return nil, malformed
+		}
+		key := SortKey{Attribute: string(fields[0].data)}
+		for _, f := range fields[1:] {
+			switch f.tag {
+			case berClassContext | 0:
+				key.OrderingRule = string(f.data)
+			case berClassContext | 1:
+				if key.Reverse, err = berParseBoolean(f.data); err != nil {
+					return nil, malformed
+				}
+			default:
+				return nil, malformed
+			}
+		}
+		control.Keys = append(control.Keys, key)
+	}
+	return control, nil
+}
+
+// SortResultControl returns the BER encoded Server Side Sort response
+// control, with the result of the sort and the attribute which caused a
+// failure, if any
+func SortResultControl(resultCode int, attribute string) []byte {
+	elements := [][]byte{berInteger(berTagEnumerated, int64(resultCode))}
+	if attribute != "" {
+		elements = append(elements, berOctetString(berClassContext|0, []byte(attribute)))
+	}
+	return berSequence(
+		berOctetString(berTagOctetString, []byte(ControlSortResponse)),
+		berOctetString(berTagOctetString, berSequence(elements...)),
+	)
+}
+
+// SortEntries sorts entries in place, stably, by keys. Values are
+// compared with the ordering rule of the key, or without one as by search
+// filters: numerically for integers, ignoring case otherwise. The least value of multi-valued attributes is used, and the
+// entries missing an attribute come after the others. It returns a
+// *SortError with the inappropriateMatching code when an ordering rule is
+// not supported.
+func SortEntries(entries []Entry, keys []SortKey) error {
+	for _, key := range keys {
+		if _, ok := orderingRules[strings.ToLower(key.OrderingRule)]; key.OrderingRule != "" && !ok {
+			return &SortError{ResultCode: LDAPResultInappropriateMatching, Attribute: key.Attribute}
+		}
+	}
+
+	// the sort values are computed once per entry
+	least := make([][][]byte, len(entries))
+	for i := range entries {
+		least[i] = make([][]byte, len(keys))
+		for k, key := range keys {
+			for _, v := range entries[i].values(key.Attribute) {
+				if least[i][k] == nil || key.compare(v, least[i][k]) < 0 {
+					least[i][k] = v
+				}
+			}
+		}
+	}
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		va, vb := least[order[a]], least[order[b]]
+		for k, key := range keys {
+			switch {
+			case va[k] == nil && vb[k] == nil:
+				continue
+			case va[k] == nil:
+				return false
+			case vb[k] == nil:
+				return true
+			}
+			c := key.compare(va[k], vb[k])
+			if key.Reverse {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	sorted := make([]Entry, len(entries))
+	for i, j := range order {
+		sorted[i] = entries[j]
+	}
+	copy(entries, sorted)
+	return nil
+}
+
+// WriteSortedEntries answers the search request m with entries, sorted
+// when m has the Server Side Sort control, in which case the
+// SearchResultDone carries the sort response control. A critical sort
+// control which can't be honored fails the search with
+// unavailableCriticalExtension, otherwise the entries are sent unsorted.
+func WriteSortedEntries(w ResponseWriter, m *Message, entries []Entry) error {
+	control, err := m.ServerSideSort()
+	if err != nil {
+		resultCode, diagnosticMessage := DefaultErrorMapper(err)
+		w.Write(NewResponseForRequest(m.ProtocolOp(), resultCode, diagnosticMessage))
+		return err
+	}
+	if control == nil {
+		if err := WriteEntries(w, entries); err != nil {
+			return err
+		}
+		w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+		return nil
+	}
+
+	sortResult, attribute := LDAPResultSuccess, ""
+	if err := SortEntries(entries, control.Keys); err != nil {
+		sortErr := err.(*SortError)
+		sortResult, attribute = sortErr.ResultCode, sortErr.Attribute
+		if control.Criticality {
+			WriteSearchResultDone(w, LDAPResultUnavailableCriticalExtension, err.Error(), SortResultControl(sortResult, attribute))
+			return nil
+		}
+	}
+	if err := WriteEntries(w, entries); err != nil {
+		return err
+	}
+	WriteSearchResultDone(w, LDAPResultSuccess, "", SortResultControl(sortResult, attribute))
+	return nil
+}
+
+// WriteSearchResultDone ends a search with a SearchResultDone carrying the
+// BER encoded response controls, such as SortResultControl or
+// PagedResultsResponseControl
+func WriteSearchResultDone(w ResponseWriter, resultCode int, diagnosticMessage string, controls ...[]byte) {
+	protocolOp := berConstructedTLV(berClassApplication|berConstructed|ApplicationSearchResultDone,
+		encodeLDAPResult(resultCode, "", diagnosticMessage)...)
+	WriteRawWithControls(w, protocolOp, controls)
+}
@@ -0,0 +1,47 @@
+package ldapserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSortEntriesOrderingRule(t *testing.T) {
+	entries := func() []Entry {
+		return []Entry{
+			*NewEntry("cn=1").Add("cn", "b").Add("uidNumber", "10").Add("modifyTimestamp", "20240101000000+0100"),
+			*NewEntry("cn=2").Add("cn", "B").Add("uidNumber", "9").Add("modifyTimestamp", "20231231235959Z"),
+			*NewEntry("cn=3").Add("cn", "a").Add("uidNumber", "x").Add("modifyTimestamp", "20240101000000Z"),
+		}
+	}
+	tests := []struct {
+		key  SortKey
+		want string
+	}{
+		{SortKey{Attribute: "cn"}, "cn=3 cn=1 cn=2"},
+		{SortKey{Attribute: "cn", OrderingRule: "caseIgnoreOrderingMatch"}, "cn=3 cn=1 cn=2"},
+		{SortKey{Attribute: "cn", OrderingRule: "caseExactOrderingMatch"}, "cn=2 cn=3 cn=1"},
+		{SortKey{Attribute: "cn", OrderingRule: "2.5.13.5", Reverse: true}, "cn=1 cn=3 cn=2"},
+		{SortKey{Attribute: "uidNumber", OrderingRule: "integerOrderingMatch"}, "cn=2 cn=1 cn=3"},
+		{SortKey{Attribute: "modifyTimestamp", OrderingRule: "generalizedTimeOrderingMatch"}, "cn=1 cn=2 cn=3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key.OrderingRule, func(t *testing.T) {
+			e := entries()
+			if err := SortEntries(e, []SortKey{tt.key}); err != nil {
+				t.Fatal(err)
+			}
+			var order []string
+			for _, entry := range e {
+				order = append(order, entry.DN)
+			}
+			if got := strings.Join(order, " "); got != tt.want {
+				t.Errorf("sorted %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	err := SortEntries(entries(), []SortKey{{Attribute: "cn", OrderingRule: "octetStringOrderingMatch"}})
+	if sortErr, ok := err.(*SortError); !ok || sortErr.ResultCode != LDAPResultInappropriateMatching {
+		t.Errorf("unsupported ordering rule error %v, want inappropriateMatching", err)
+	}
+}
@@ -0,0 +1,82 @@
+package ldapserver
+
+import (
+	"crypto/tls"
+	"errors"
+)
+
+// StartTLS answers the StartTLS request m (RFC 4511 section 4.14) and
+// upgrades the connection to TLS with config, or the server TLSConfig when
+// nil. The success response is written before the handshake starts, and
+// no request is read until it completes. The connection is closed when
+// the handshake fails.
+func (c *client) StartTLS(w ResponseWriter, m *Message, config *tls.Config) error {
+	if config == nil {
+		config = c.srv.TLSConfig
+	}
+	var err error
+	res := NewExtendedResponse(LDAPResultOperationsError)
+	res.SetResponseName(NoticeOfStartTLS)
+	switch {
+	case config == nil:
+		err = errors.New("StartTLS is not available")
+		res.SetResultCode(LDAPResultProtocolError)
+	case c.isTLS():
+		err = errors.New("TLS is already established")
+	case c.requestsInFlight() > 1:
+		err = errors.New("operations are outstanding")
+	}
+	if err != nil {
+		res.SetDiagnosticMessage(err.Error())
+		w.Write(res)
+		return err
+	}
+
+	res.SetResultCode(LDAPResultSuccess)
+	w.Write(res)
+	c.flush()
+
+	// bytes of the ClientHello already buffered are handed over
+	tlsConn := tls.Server(&bufferedConn{Conn: c.rwc, r: c.br}, config)
+	if err := c.TLSHandshake(tlsConn); err != nil {
+		c.rwc.Close()
+		return err
+	}
+	c.SetConn(tlsConn)
+	return nil
+}
+
+// flush returns once the responses queued so far are written
+func (c *client) flush() {
+	flushed := make(chan struct{})
+	c.chanOut <- &outMessage{flushed: flushed}
+	<-flushed
+}
+
+// requestsInFlight returns the number of requests of c being served
+func (c *client) requestsInFlight() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.requestList)
+}
+
+// HandleStartTLS answers a StartTLS request and upgrades the connection
+// with the server TLSConfig. It serves the StartTLS requests no route
+// serves when the server has a TLSConfig.
+func HandleStartTLS(w ResponseWriter, m *Message) {
+	if m.Client == nil {
+		w.Write(NewResponseForRequest(m.ProtocolOp(), LDAPResultUnwillingToPerform, "StartTLS is not available"))
+		return
+	}
+	if err := m.Client.StartTLS(w, m, nil); err != nil {
+		m.logAt(LogLevelWarn, "StartTLS error: %s", err)
+		return
+	}
+	m.logAt(LogLevelInfo, "StartTLS established")
+}
+
+// canStartTLS reports whether m is a StartTLS request the server can serve
+// by default
+func canStartTLS(m *Message) bool {
+	return isStartTLS(m.LDAPMessage) && m.Client != nil && m.Client.srv.TLSConfig != nil
+}
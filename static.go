@@ -0,0 +1,336 @@
+package ldapserver
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// StaticEntry is an entry served by a StaticResponder. Its DN and values
+// are text/template templates executed with a StaticTemplateData, and the
+// env function returning an environment variable:
+//
+//	dn: cn=health,ou=probes,dc=example,dc=com
+//	description: {{ env "HOSTNAME" }} serving {{ .BindDN }}
+type StaticEntry struct {
+	DN         string            `json:"dn" yaml:"dn"`
+	Attributes []StaticAttribute `json:"attributes" yaml:"attributes"`
+}
+
+// StaticAttribute is an attribute of a StaticEntry
+type StaticAttribute struct {
+	Name   string   `json:"name" yaml:"name"`
+	Values []string `json:"values" yaml:"values"`
+}
+
+// StaticTemplateData holds the request fields the templates of a
+// StaticResponder are executed with
+type StaticTemplateData struct {
+	BindDN     string    // DN the client is bound as
+	RemoteAddr string    // address of the client
+	MessageID  int       // message ID of the request
+	BaseDN     string    // search base, or compared entry
+	Time       time.Time // time the request was received
+}
+
+// StaticResponder is a Handler serving fixed subtrees, application
+// metadata or health probe entries for instance, from templated entries.
+// It answers searches and compares, other requests are refused with
+// unwillingToPerform. Ancestors of the entries which are not entries
+// themselves may be used as search bases.
+type StaticResponder struct {
+	entries []staticEntry
+}
+
+type staticEntry struct {
+	dn         staticValue
+	attributes []staticAttribute
+}
+
+type staticAttribute struct {
+	name   string
+	values []staticValue
+}
+
+// staticValue is a template, or a literal when tmpl is nil
+type staticValue struct {
+	text string
+	tmpl *template.Template
+}
+
+// NewStaticResponder returns a StaticResponder serving entries, it fails
+// when a template does not parse
+func NewStaticResponder(entries []StaticEntry) (*StaticResponder, error) {
+	s := &StaticResponder{}
+	for _, e := range entries {
+		dn, err := parseStaticValue(e.DN)
+		if err != nil {
+			return nil, fmt.Errorf("entry %q: %s", e.DN, err)
+		}
+		entry := staticEntry{dn: dn}
+		for _, a := range e.Attributes {
+			attribute := staticAttribute{name: a.Name}
+			for _, v := range a.Values {
+				value, err := parseStaticValue(v)
+				if err != nil {
+					return nil, fmt.Errorf("entry %q, attribute %s: %s", e.DN, a.Name, err)
+				}
+				attribute.values = append(attribute.values, value)
+			}
+			entry.attributes = append(entry.attributes, attribute)
+		}
+		s.entries = append(s.entries, entry)
+	}
+	return s, nil
+}
+
+func parseStaticValue(text string) (staticValue, error) {
+	if !strings.Contains(text, "{{") {
+		return staticValue{text: text}, nil
+	}
+	tmpl, err := template.New("").Option("missingkey=error").Funcs(template.FuncMap{"env": os.Getenv}).Parse(text)
+	if err != nil {
+		return staticValue{}, err
+	}
+	return staticValue{text: text, tmpl: tmpl}, nil
+}
+
+func (v staticValue) render(data *StaticTemplateData) (string, error) {
+	if v.tmpl == nil {
+		return v.text, nil
+	}
+	var b strings.Builder
+	if err := v.tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// render returns the entries for the request m
+func (s *StaticResponder) render(m *Message, baseDN string) ([]Entry, error) {
+	data := &StaticTemplateData{
+		MessageID: m.MessageID().Int(),
+		BaseDN:    baseDN,
+		Time:      m.received,
+	}
+	if m.Client != nil {
+		data.BindDN = m.Client.ACL().BindEntry
+		data.RemoteAddr = m.Client.Addr().String()
+	}
+
+	entries := make([]Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		dn, err := e.dn.render(data)
+		if err != nil {
+			return nil, err
+		}
+		entry := Entry{DN: dn}
+		for _, a := range e.attributes {
+			attribute := EntryAttribute{Name: a.name}
+			for _, v := range a.values {
+				value, err := v.render(data)
+				if err != nil {
+					return nil, err
+				}
+				attribute.Values = append(attribute.Values, []byte(value))
+			}
+			entry.Attributes = append(entry.Attributes, attribute)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ServeLDAP answers the searches and compares of the static entries
+func (s *StaticResponder) ServeLDAP(w ResponseWriter, m *Message) {
+	switch r := m.ProtocolOp().(type) {
+	case ldap.SearchRequest:
+		s.search(w, m, r)
+	case ldap.CompareRequest:
+		s.compare(w, m, r)
+	default:
+		if res := NewResponseForRequest(r, LDAPResultUnwillingToPerform, "static entries are read-only"); res != nil {
+			w.Write(res)
+		}
+	}
+}
+
+func (s *StaticResponder) search(w ResponseWriter, m *Message, r ldap.SearchRequest) {
+	base, err := ParseDN(string(r.BaseObject()))
+	if err != nil {
+		w.Write(NewSearchResultDoneResponse(LDAPResultInvalidDNSyntax))
+		return
+	}
+	entries, err := s.render(m, string(r.BaseObject()))
+	if err != nil {
+		m.logAt(LogLevelError, "static entry template error: %s", err)
+		w.Write(NewSearchResultDoneResponse(LDAPResultOperationsError))
+		return
+	}
+
+	var matches []Entry
+	baseFound := false
+	for i := range entries {
+		dn, err := ParseDN(entries[i].DN)
+		if err != nil {
+			m.logAt(LogLevelError, "static entry with invalid DN %q: %s", entries[i].DN, err)
+			continue
+		}
+		if !dn.IsDescendantOf(base, true) {
+			continue
+		}
+		baseFound = true
+
+		var inScope bool
+		switch int(r.Scope()) {
+		case SearchRequestScopeBaseObject:
+			inScope = dn.Equal(base)
+		case SearchRequestSingleLevel:
+			inScope = len(dn) == len(base)+1
+		default:
+			inScope = true
+		}
+		if inScope && matchFilter(&entries[i], r.Filter()) {
+			matches = append(matches, SelectAttributes(entries[i], r))
+		}
+	}
+	if !baseFound {
+		w.Write(NewSearchResultDoneResponse(LDAPResultNoSuchObject))
+		return
+	}
+
+	if err := WriteEntries(w, matches); err != nil {
+		return
+	}
+	w.Write(NewSearchResultDoneResponse(LDAPResultSuccess))
+}
+
+func (s *StaticResponder) compare(w ResponseWriter, m *Message, r ldap.CompareRequest) {
+	dn, err := ParseDN(string(r.Entry()))
+	if err != nil {
+		w.Write(NewCompareResponse(LDAPResultInvalidDNSyntax))
+		return
+	}
+	entries, err := s.render(m, string(r.Entry()))
+	if err != nil {
+		m.logAt(LogLevelError, "static entry template error: %s", err)
+		w.Write(NewCompareResponse(LDAPResultOperationsError))
+		return
+	}
+
+	for i := range entries {
+		if NormalizeDN(entries[i].DN) != dn.Normalize() {
+			continue
+		}
+		attribute := string(r.Ava().AttributeDesc())
+		values := entries[i].values(attribute)
+		switch {
+		case len(values) == 0:
+			w.Write(NewCompareResponse(LDAPResultNoSuchAttribute))
+		case entries[i].anyValue(attribute, func(v []byte) bool {
+			return CompareValues(attribute, v, []byte(r.Ava().AssertionValue())) == 0
+		}):
+			w.Write(NewCompareResponse(LDAPResultCompareTrue))
+		default:
+			w.Write(NewCompareResponse(LDAPResultCompareFalse))
+		}
+		return
+	}
+	w.Write(NewCompareResponse(LDAPResultNoSuchObject))
+}
+
+// ParseStaticLDIF parses the content records of an LDIF file (RFC 2849)
+// into static entries. Base64 values are decoded, change records and
+// URL values are not supported.
+func ParseStaticLDIF(r io.Reader) ([]StaticEntry, error) {
+	var (
+		entries []StaticEntry
+		entry   *StaticEntry
+		lines   []string
+		number  int
+	)
+	addLine := func(line string) error {
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return fmt.Errorf("line %d: missing attribute separator", number)
+		}
+		name, value := line[:i], line[i+1:]
+		switch {
+		case strings.HasPrefix(value, ":"):
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value[1:]))
+			if err != nil {
+				return fmt.Errorf("line %d: %s", number, err)
+			}
+			value = string(decoded)
+		case strings.HasPrefix(value, "<"):
+			return fmt.Errorf("line %d: URL values are not supported", number)
+		default:
+			value = strings.TrimLeft(value, " ")
+		}
+
+		switch {
+		case entry == nil && strings.EqualFold(name, "version"):
+			return nil
+		case entry == nil && strings.EqualFold(name, "dn"):
+			entries = append(entries, StaticEntry{DN: value})
+			entry = &entries[len(entries)-1]
+			return nil
+		case entry == nil:
+			return fmt.Errorf("line %d: entry without dn", number)
+		case strings.EqualFold(name, "changetype"):
+			return fmt.Errorf("line %d: change records are not supported", number)
+		}
+		for i := range entry.Attributes {
+			if strings.EqualFold(entry.Attributes[i].Name, name) {
+				entry.Attributes[i].Values = append(entry.Attributes[i].Values, value)
+				return nil
+			}
+		}
+		entry.Attributes = append(entry.Attributes, StaticAttribute{Name: name, Values: []string{value}})
+		return nil
+	}
+	flush := func() error {
+		if len(lines) == 0 {
+			return nil
+		}
+		err := addLine(strings.Join(lines, ""))
+		lines = lines[:0]
+		return err
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		number++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, " ") && len(lines) > 0:
+			// folded line
+			lines = append(lines, line[1:])
+			continue
+		case strings.HasPrefix(line, "#"):
+			continue
+		}
+		if err := flush(); err != nil {
+			return nil, err
+		}
+		if line == "" {
+			entry = nil
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
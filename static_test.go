@@ -0,0 +1,129 @@
+package ldapserver
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testCompareRequest returns a compare request of the value of attribute
+// in the entry dn
+func testCompareRequest(dn string, attribute string, value string) []byte {
+	return berConstructedTLV(berClassApplication|berConstructed|ApplicationCompareRequest,
+		berOctetString(berTagOctetString, []byte(dn)),
+		berSequence(
+			berOctetString(berTagOctetString, []byte(attribute)),
+			berOctetString(berTagOctetString, []byte(value)),
+		),
+	)
+}
+
+func TestParseStaticLDIF(t *testing.T) {
+	tests := []struct {
+		name    string
+		ldif    string
+		entries []StaticEntry
+		err     string
+	}{
+		{
+			"entries",
+			"version: 1\n# probes\ndn: cn=health,dc=example\ncn: health\ndescription: up\ndescription:: c2VydmluZw==\n\ndn: cn=lo\n ng,dc=example\r\ncn: long\n",
+			[]StaticEntry{
+				{DN: "cn=health,dc=example", Attributes: []StaticAttribute{{"cn", []string{"health"}}, {"description", []string{"up", "serving"}}}},
+				{DN: "cn=long,dc=example", Attributes: []StaticAttribute{{"cn", []string{"long"}}}},
+			},
+			"",
+		},
+		{"no dn", "cn: health\n", nil, "entry without dn"},
+		{"no separator", "dn: cn=health\ncn\n", nil, "missing attribute separator"},
+		{"change record", "dn: cn=health\nchangetype: delete\n", nil, "change records are not supported"},
+		{"URL value", "dn: cn=health\njpegPhoto:< file:///photo.jpg\n", nil, "URL values are not supported"},
+		{"invalid base64", "dn: cn=health\ncn:: !!\n", nil, "illegal base64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := ParseStaticLDIF(strings.NewReader(tt.ldif))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(entries, tt.entries) {
+				t.Errorf("entries %+v, want %+v", entries, tt.entries)
+			}
+		})
+	}
+}
+
+func TestNewStaticResponderInvalidTemplate(t *testing.T) {
+	if _, err := NewStaticResponder([]StaticEntry{{DN: "cn={{ .BaseDN"}}); err == nil {
+		t.Error("unparsed template accepted")
+	}
+}
+
+func TestStaticResponder(t *testing.T) {
+	t.Setenv("STATIC_TEST_HOST", "ldap1")
+	s, err := NewStaticResponder([]StaticEntry{
+		{DN: "cn=health,ou=probes,dc=example", Attributes: []StaticAttribute{
+			{"objectClass", []string{"device"}},
+			{"description", []string{`{{ env "STATIC_TEST_HOST" }} for {{ .BaseDN }}`}},
+		}},
+		{DN: "cn=version,ou=probes,dc=example", Attributes: []StaticAttribute{{"objectClass", []string{"device"}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	searches := []struct {
+		base    string
+		code    int
+		entries []string
+	}{
+		{"ou=probes,dc=example", LDAPResultSuccess, []string{"cn=health,ou=probes,dc=example", "cn=version,ou=probes,dc=example"}},
+		{"cn=health,ou=probes,dc=example", LDAPResultSuccess, []string{"cn=health,ou=probes,dc=example"}},
+		{"ou=other,dc=example", LDAPResultNoSuchObject, nil},
+		{"cn", LDAPResultInvalidDNSyntax, nil},
+	}
+	for _, tt := range searches {
+		w := NewResponseRecorder()
+		s.ServeLDAP(w, testMessage(t, testSearchRequest(tt.base, 0, 0)))
+		if code := w.ResultCode(); code != tt.code {
+			t.Errorf("search of %s: result code %d, want %d", tt.base, code, tt.code)
+		}
+		var dns []string
+		for _, e := range w.Entries() {
+			dns = append(dns, e.DN)
+		}
+		if !reflect.DeepEqual(dns, tt.entries) {
+			t.Errorf("search of %s: entries %v, want %v", tt.base, dns, tt.entries)
+		}
+	}
+
+	compares := []struct {
+		dn, attribute, value string
+		code                 int
+	}{
+		{"cn=health,ou=probes,dc=example", "description", "ldap1 for cn=health,ou=probes,dc=example", LDAPResultCompareTrue},
+		{"CN=Health,ou=probes,dc=example", "objectClass", "DEVICE", LDAPResultCompareTrue},
+		{"cn=health,ou=probes,dc=example", "description", "ldap2", LDAPResultCompareFalse},
+		{"cn=health,ou=probes,dc=example", "cn", "health", LDAPResultNoSuchAttribute},
+		{"cn=other,ou=probes,dc=example", "cn", "other", LDAPResultNoSuchObject},
+	}
+	for _, tt := range compares {
+		w := NewResponseRecorder()
+		s.ServeLDAP(w, testMessage(t, testCompareRequest(tt.dn, tt.attribute, tt.value)))
+		if code := w.ResultCode(); code != tt.code {
+			t.Errorf("compare of %s %s: result code %d, want %d", tt.dn, tt.attribute, code, tt.code)
+		}
+	}
+
+	w := NewResponseRecorder()
+	s.ServeLDAP(w, testMessage(t, berOctetString(berClassApplication|ApplicationDelRequest, []byte("cn=health,ou=probes,dc=example"))))
+	if code := w.ResultCode(); code != LDAPResultUnwillingToPerform {
+		t.Errorf("delete: result code %d, want %d", code, LDAPResultUnwillingToPerform)
+	}
+}
@@ -0,0 +1,165 @@
+package ldapserver
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// NamingContext returns the suffix of the ContextMux naming context serving
+// m, empty when m is not served by one. Logs, events and audit records
+// about m are tagged with it, for per-tenant accounting.
+func (m *Message) NamingContext() string {
+	if suffix, ok := m.namingContext.Load().(string); ok {
+		return suffix
+	}
+	return ""
+}
+
+// TenantStats are the counters of a naming context, see ContextMux.Stats
+type TenantStats struct {
+	Operations map[string]int64 `json:"operations"` // requests served, by protocol operation name
+	Errors     int64            `json:"errors"`     // requests answered with a result code other than success, compareTrue or compareFalse
+	Entries    int64            `json:"entries"`    // search result entries sent
+	Duration   time.Duration    `json:"duration"`   // total time spent by the handler
+}
+
+// tenantCounters accumulates the TenantStats of a naming context
+type tenantCounters struct {
+	mu         sync.Mutex
+	operations map[string]int64
+	errors     int64
+	entries    int64
+	duration   int64
+}
+
+func (t *tenantCounters) add(operation string, resultCode int, entries int64, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.operations == nil {
+		t.operations = make(map[string]int64)
+	}
+	t.operations[operation]++
+	switch resultCode {
+	case -1, LDAPResultSuccess, LDAPResultCompareTrue, LDAPResultCompareFalse:
+	default:
+		t.errors++
+	}
+	t.entries += entries
+	t.duration += int64(d)
+}
+
+func (t *tenantCounters) snapshot() TenantStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := TenantStats{
+		Operations: make(map[string]int64, len(t.operations)),
+		Errors:     t.errors,
+		Entries:    t.entries,
+		Duration:   time.Duration(t.duration),
+	}
+	for operation, n := range t.operations {
+		stats.Operations[operation] = n
+	}
+	return stats
+}
+
+// Stats returns the counters of the mounted naming contexts, by suffix
+func (mux *ContextMux) Stats() map[string]TenantStats {
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+	stats := make(map[string]TenantStats, len(mux.contexts))
+	for _, nc := range mux.contexts {
+		stats[nc.Suffix] = nc.counters.snapshot()
+	}
+	return stats
+}
+
+// serveCounted passes m to serve, tagged with the naming context nc, and
+// counts it in the context stats
+func (nc *NamingContext) serveCounted(w ResponseWriter, m *Message, serve func(w ResponseWriter, m *Message)) {
+	m.namingContext.Store(nc.Suffix)
+	cw := &countingWriter{ResponseWriter: w, resultCode: -1}
+	start := time.Now()
+	serve(cw, m)
+	nc.counters.add(m.ProtocolOpName(), int(atomic.LoadInt32(&cw.resultCode)), atomic.LoadInt64(&cw.entries), time.Since(start))
+}
+
+// countingWriter is a ResponseWriter counting the entries written and
+// keeping the result code of the terminal response
+type countingWriter struct {
+	ResponseWriter
+	entries    int64
+	resultCode int32
+}
+
+func (cw *countingWriter) Write(po ldap.ProtocolOp) {
+	cw.WriteMessage(ldap.NewLDAPMessageWithProtocolOp(po))
+}
+
+func (cw *countingWriter) WriteMessage(m *ldap.LDAPMessage) {
+	switch m.ProtocolOp().(type) {
+	case ldap.SearchResultEntry:
+		atomic.AddInt64(&cw.entries, 1)
+	case ldap.SearchResultReference, ldap.IntermediateResponse:
+	default:
+		if code, err := resultCodeOf(m); err == nil {
+			atomic.StoreInt32(&cw.resultCode, int32(code))
+		}
+	}
+	cw.ResponseWriter.WriteMessage(m)
+}
+
+func (cw *countingWriter) WriteRaw(protocolOp []byte) {
+	cw.count(protocolOp)
+	WriteRaw(cw.ResponseWriter, protocolOp)
+}
+
+func (cw *countingWriter) WriteRawWithControls(protocolOp []byte, controls [][]byte) {
+	cw.count(protocolOp)
+	WriteRawWithControls(cw.ResponseWriter, protocolOp, controls)
+}
+
+func (cw *countingWriter) WriteEntries(entries []Entry) error {
+	atomic.AddInt64(&cw.entries, int64(len(entries)))
+	return WriteEntries(cw.ResponseWriter, entries)
+}
+
+func (cw *countingWriter) responded() bool {
+	if rw, ok := cw.ResponseWriter.(interface{ responded() bool }); ok {
+		return rw.responded()
+	}
+	return false
+}
+
+func (cw *countingWriter) count(protocolOp []byte) {
+	if len(protocolOp) > 0 && protocolOp[0] == berClassApplication|berConstructed|ApplicationSearchResultEntry {
+		atomic.AddInt64(&cw.entries, 1)
+		return
+	}
+	if isTerminalRaw(protocolOp) {
+		if code, ok := rawResultCode(protocolOp); ok {
+			atomic.StoreInt32(&cw.resultCode, int32(code))
+		}
+	}
+}
+
+// rawResultCode returns the result code of the BER encoded LDAPResult based
+// protocolOp
+func rawResultCode(protocolOp []byte) (int, bool) {
+	element, _, err := berRead(protocolOp)
+	if err != nil {
+		return 0, false
+	}
+	result, err := berChildren(element.data)
+	if err != nil || len(result) == 0 || result[0].tag != berTagEnumerated {
+		return 0, false
+	}
+	code, err := berParseInteger(result[0].data)
+	if err != nil {
+		return 0, false
+	}
+	return int(code), true
+}
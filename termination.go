@@ -0,0 +1,214 @@
+package ldapserver
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// TerminationReason tells why an operation was signaled to stop before
+// completing
+type TerminationReason int
+
+const (
+	TerminationAbandoned    TerminationReason = iota // AbandonRequest from the client
+	TerminationCancelled                             // Cancel extended operation from the client
+	TerminationTimedOut                              // a server timeout expired
+	TerminationDisconnected                          // the client disconnected or the server stopped
+)
+
+func (r TerminationReason) String() string {
+	switch r {
+	case TerminationAbandoned:
+		return "abandoned"
+	case TerminationCancelled:
+		return "cancelled"
+	case TerminationTimedOut:
+		return "timedOut"
+	case TerminationDisconnected:
+		return "disconnected"
+	}
+	return fmt.Sprintf("TerminationReason(%d)", int(r))
+}
+
+// TerminationCounts counts the operations of a type signaled to stop,
+// by reason
+type TerminationCounts struct {
+	Abandoned    int64 `json:"abandoned"`
+	Cancelled    int64 `json:"cancelled"`
+	TimedOut     int64 `json:"timedOut"`
+	Disconnected int64 `json:"disconnected"`
+}
+
+func (t *TerminationCounts) add(reason TerminationReason) {
+	switch reason {
+	case TerminationAbandoned:
+		t.Abandoned++
+	case TerminationCancelled:
+		t.Cancelled++
+	case TerminationTimedOut:
+		t.TimedOut++
+	case TerminationDisconnected:
+		t.Disconnected++
+	}
+}
+
+// terminations counts the terminated operations by protocol operation name
+type terminations struct {
+	mu     sync.Mutex
+	counts map[string]*TerminationCounts
+}
+
+func (t *terminations) add(operation string, reason TerminationReason) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.counts == nil {
+		t.counts = make(map[string]*TerminationCounts)
+	}
+	counts, ok := t.counts[operation]
+	if !ok {
+		counts = &TerminationCounts{}
+		t.counts[operation] = counts
+	}
+	counts.add(reason)
+}
+
+func (t *terminations) snapshot() map[string]TerminationCounts {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	snapshot := make(map[string]TerminationCounts, len(t.counts))
+	for operation, counts := range t.counts {
+		snapshot[operation] = *counts
+	}
+	return snapshot
+}
+
+// terminate signals the request to stop for reason. Only the first
+// termination of a request is signaled and counted, Done is sent at most
+// once so it never blocks.
+func (m *Message) terminate(reason TerminationReason) {
+	if !atomic.CompareAndSwapInt32(&m.terminated, 0, 1) {
+		return
+	}
+	if m.Client != nil {
+		c := m.Client
+		operation := m.ProtocolOpName()
+		c.srv.terminations.add(operation, reason)
+		c.srv.events.emit(OpTerminated{Numero: c.numero, MessageID: m.MessageID().Int(), Operation: operation, Reason: reason, NamingContext: m.NamingContext()})
+		m.logAt(LogLevelDebug, "%s %s", operation, reason)
+	}
+	if m.cancel != nil {
+		m.cancel()
+	}
+	m.Abandon()
+}
+
+// CancelRequest signals the running request messageID to stop, as asked by
+// a Cancel extended operation. It reports whether the request was found.
+// If the request stops without writing its response, it is answered with
+// the canceled result code.
+func (c *client) CancelRequest(messageID int) bool {
+	m, ok := c.GetMessageByID(messageID)
+	if ok {
+		atomic.StoreInt32(&m.cancelRequested, 1)
+		m.terminate(TerminationCancelled)
+	}
+	return ok
+}
+
+// cancelable reports whether the request m may be cancelled: binds,
+// StartTLS and Cancel operations may not (RFC 3909)
+func cancelable(m *Message) bool {
+	switch r := m.ProtocolOp().(type) {
+	case ldap.BindRequest, ldap.AbandonRequest, ldap.UnbindRequest:
+		return false
+	case ldap.ExtendedRequest:
+		return r.RequestName() != NoticeOfStartTLS && r.RequestName() != NoticeOfCancel
+	}
+	return true
+}
+
+// AbandonRequest signals the running request messageID to stop, as asked
+// by an AbandonRequest. It reports whether the request was found.
+func (c *client) AbandonRequest(messageID int) bool {
+	c.mutex.Lock()
+	m, ok := c.requestList[messageID]
+	c.mutex.Unlock()
+	if ok {
+		m.terminate(TerminationAbandoned)
+	} else {
+		atomic.AddInt64(&c.srv.abandonsNotFound, 1)
+	}
+	return ok
+}
+
+// HandleAbandon is the handler of AbandonRequest used when no route serves
+// them: it signals the abandoned request to stop, which cancels its
+// Context and is counted in the server Stats. Routes serving AbandonRequest
+// may use it, or call it before their own processing:
+//
+//	routes.Abandon(ldap.HandleAbandon)
+func HandleAbandon(w ResponseWriter, m *Message) {
+	if m.Client != nil {
+		m.Client.AbandonRequest(int(m.GetAbandonRequest()))
+	}
+}
+
+// handleCancel answers a Cancel extended operation (RFC 3909) not handled by
+// the user routes, by signaling the cancelled request to stop. The answer
+// is sent once the cancelled request completed: success when it was
+// answered with canceled, tooLate when it completed anyway.
+func handleCancel(w ResponseWriter, m *Message) {
+	r := m.GetExtendedRequest()
+	res := NewExtendedResponse(LDAPResultSuccess)
+
+	cancelID, err := parseCancelRequestValue(r.RequestValue())
+	if err != nil {
+		res.SetResultCode(LDAPResultProtocolError)
+		res.SetDiagnosticMessage(err.Error())
+		w.Write(res)
+		return
+	}
+	target, ok := m.Client.GetMessageByID(cancelID)
+	switch {
+	case !ok:
+		res.SetResultCode(LDAPResultNoSuchOperation)
+	case target == m || !cancelable(target):
+		res.SetResultCode(LDAPResultCannotCancel)
+		res.SetDiagnosticMessage(target.ProtocolOpName() + " can not be cancelled")
+	default:
+		m.Client.CancelRequest(cancelID)
+		select {
+		case <-target.finished:
+			if atomic.LoadInt32(&target.canceled) == 0 {
+				res.SetResultCode(LDAPResultTooLate)
+			}
+		case <-m.Context().Done():
+			return
+		}
+	}
+	w.Write(res)
+}
+
+// parseCancelRequestValue decodes cancelRequestValue ::= SEQUENCE {
+// cancelID MessageID }
+func parseCancelRequestValue(value *ldap.OCTETSTRING) (int, error) {
+	if value == nil {
+		return 0, fmt.Errorf("missing cancel request value")
+	}
+	seq, _, err := berRead([]byte(*value))
+	if err != nil || seq.tag != berTagSequence|berConstructed {
+		return 0, fmt.Errorf("malformed cancel request value")
+	}
+	children, err := berChildren(seq.data)
+	if err != nil || len(children) != 1 || children[0].tag != berTagInteger {
+		return 0, fmt.Errorf("malformed cancel request value")
+	}
+	cancelID, err := berParseInteger(children[0].data)
+	if err != nil {
+		return 0, fmt.Errorf("malformed cancel request value")
+	}
+	return int(cancelID), nil
+}
@@ -0,0 +1,20 @@
+package ldapserver
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTerminateOnce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Message{Done: make(chan bool, 2), ctx: ctx, cancel: cancel}
+	for _, reason := range []TerminationReason{TerminationAbandoned, TerminationCancelled, TerminationDisconnected} {
+		m.terminate(reason)
+	}
+	if n := len(m.Done); n != 1 {
+		t.Errorf("Done signaled %d times, want once", n)
+	}
+	if ctx.Err() == nil {
+		t.Error("context not canceled")
+	}
+}
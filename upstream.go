@@ -0,0 +1,58 @@
+package ldapserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// ErrDeadlineExceeded is returned by UpstreamTimeout when the operation has
+// no time left for an upstream call
+var ErrDeadlineExceeded = errors.New("operation deadline exceeded")
+
+// UpstreamTimeout returns the time given to an upstream call made to serve
+// m: the time left before the deadline of m, see Message.Deadline, capped
+// to max when non-zero. Zero means no limit.
+func UpstreamTimeout(m *Message, max time.Duration) (time.Duration, error) {
+	deadline, ok := m.Deadline()
+	if !ok {
+		return max, nil
+	}
+	left := time.Until(deadline)
+	if left <= 0 {
+		return 0, ErrDeadlineExceeded
+	}
+	if max != 0 && max < left {
+		return max, nil
+	}
+	return left, nil
+}
+
+// UpstreamError translates the error of an upstream call, a *ResultError
+// answered by the upstream directory or a transport error, into the result
+// code and diagnostic message answered downstream. It is an ErrorMapper,
+// so proxy handlers registered with RouteMux.HandleErrors may return
+// upstream errors as is.
+func UpstreamError(err error) (int, string) {
+	var re *ResultError
+	if errors.As(err, &re) {
+		switch re.ResultCode {
+		case LDAPResultProtocolError:
+			// the request was decoded, the upstream directory disagrees
+			return LDAPResultOther, "upstream directory protocol error: " + re.DiagnosticMessage
+		}
+		return re.ResultCode, re.DiagnosticMessage
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return LDAPResultTimeLimitExceeded, "operation deadline exceeded"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return LDAPResultTimeLimitExceeded, "upstream directory did not answer in time"
+	case errors.Is(err, context.Canceled):
+		return LDAPResultCanceled, "operation canceled"
+	}
+	return LDAPResultUnavailable, "upstream directory error: " + err.Error()
+}
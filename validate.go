@@ -0,0 +1,223 @@
+package ldapserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FindingSeverity is the severity of a Finding
+type FindingSeverity int
+
+const (
+	FindingWarning FindingSeverity = iota // the server works, but probably not as intended
+	FindingError                          // the server can not work
+)
+
+func (s FindingSeverity) String() string {
+	if s == FindingError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Finding is a problem of the server configuration reported by Validate
+type Finding struct {
+	Severity FindingSeverity
+	Check    string // what is checked: "handler", "tls", "listen", "limits", "schema"
+	Message  string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.Severity, f.Check, f.Message)
+}
+
+// ValidationError is returned by Validate when a Finding is an error
+type ValidationError struct {
+	Findings []Finding
+}
+
+func (e *ValidationError) Error() string {
+	var errs []string
+	for _, f := range e.Findings {
+		if f.Severity == FindingError {
+			errs = append(errs, f.Check+": "+f.Message)
+		}
+	}
+	return "invalid server configuration: " + strings.Join(errs, "; ")
+}
+
+// certificateExpiryWarning is the validity left below which Validate warns
+// about a certificate
+const certificateExpiryWarning = 30 * 24 * time.Hour
+
+// Validate checks the server configuration before it serves: a handler is
+// registered, the TLS certificates are parseable and valid, the Config
+// listen addresses parse, the limits are sane and the definitions of the
+// schema served refer to defined ones. It returns all the
+// findings, and a *ValidationError when one of them is an error, so
+// misconfigurations fail at startup instead of at the first client.
+func (s *Server) Validate() ([]Finding, error) {
+	var findings []Finding
+	add := func(severity FindingSeverity, check string, format string, args ...interface{}) {
+		findings = append(findings, Finding{Severity: severity, Check: check, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// handler
+	s.mu.Lock()
+	addrHandlers := len(s.addrHandlers)
+	s.mu.Unlock()
+	if s.Handler == nil && addrHandlers == 0 {
+		add(FindingError, "handler", "no handler registered")
+	}
+
+	// tls
+	now := time.Now()
+	checkCertificate := func(name string, cert *tls.Certificate) {
+		if cert == nil || len(cert.Certificate) == 0 {
+			add(FindingError, "tls", "%s: empty certificate", name)
+			return
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			add(FindingError, "tls", "%s: %s", name, err)
+			return
+		}
+		switch {
+		case now.After(leaf.NotAfter):
+			add(FindingError, "tls", "%s: certificate %q expired on %s", name, leaf.Subject, leaf.NotAfter.Format(time.RFC3339))
+		case now.Before(leaf.NotBefore):
+			add(FindingError, "tls", "%s: certificate %q is not valid before %s", name, leaf.Subject, leaf.NotBefore.Format(time.RFC3339))
+		case leaf.NotAfter.Sub(now) < certificateExpiryWarning:
+			add(FindingWarning, "tls", "%s: certificate %q expires on %s", name, leaf.Subject, leaf.NotAfter.Format(time.RFC3339))
+		}
+	}
+	if s.TLSConfig != nil {
+		for i := range s.TLSConfig.Certificates {
+			checkCertificate(fmt.Sprintf("certificate %d", i), &s.TLSConfig.Certificates[i])
+		}
+		if len(s.TLSConfig.Certificates) == 0 && s.TLSConfig.GetCertificate == nil && len(s.SNICertificates) == 0 {
+			add(FindingError, "tls", "TLSConfig has no certificate")
+		}
+	}
+	for name, cert := range s.SNICertificates {
+		checkCertificate("SNI "+name, cert)
+	}
+	if s.TLSConfig == nil {
+		switch {
+		case s.RequireTLS:
+			add(FindingError, "tls", "RequireTLS is set without TLSConfig, clients can not use StartTLS")
+		case s.DetectTLS:
+			add(FindingError, "tls", "DetectTLS is set without TLSConfig")
+		case len(s.config.ListenTLS) > 0:
+			add(FindingError, "tls", "LDAPS addresses are configured without TLSConfig")
+		}
+	}
+
+	// listen
+	for _, addr := range append(append([]string{}, s.config.Listen...), s.config.ListenTLS...) {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			add(FindingError, "listen", "%q: %s", addr, err)
+			continue
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			if _, err := net.LookupPort("tcp", port); err != nil {
+				add(FindingError, "listen", "%q: invalid port", addr)
+			}
+		}
+	}
+
+	// limits, as the Settings are initialized from them
+	l := s.fieldLimits()
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"ReadTimeout", l.ReadTimeout},
+		{"WriteTimeout", l.WriteTimeout},
+		{"HandshakeTimeout", l.HandshakeTimeout},
+		{"IdleTimeout", l.IdleTimeout},
+		{"IdleReapInterval", s.IdleReapInterval},
+		{"AdmissionTimeout", s.AdmissionTimeout},
+		{"GreetingDelay", s.GreetingDelay},
+		{"DrainTimeout", s.DrainTimeout},
+	} {
+		if d.value < 0 {
+			add(FindingError, "limits", "%s is negative", d.name)
+		}
+	}
+	for _, n := range []struct {
+		name  string
+		value int
+	}{
+		{"MaxConnectionOperations", l.MaxConnectionOperations},
+		{"MaxConnections", l.MaxConnections},
+		{"AcceptBurst", l.AcceptBurst},
+		{"MaxClientRequests", s.MaxClientRequests},
+	} {
+		if n.value < 0 {
+			add(FindingError, "limits", "%s is negative", n.name)
+		}
+	}
+	if l.AcceptRate < 0 {
+		add(FindingError, "limits", "AcceptRate is negative")
+	}
+	if l.AcceptRate > 0 && l.AcceptBurst == 0 {
+		add(FindingWarning, "limits", "AcceptRate is set without AcceptBurst, a single connection is accepted at once")
+	}
+	if s.IdleReapInterval > 0 && l.IdleTimeout == 0 {
+		add(FindingWarning, "limits", "IdleReapInterval is set without IdleTimeout, no connection is reaped")
+	}
+	if s.GreetingDelay > 0 && l.ReadTimeout > 0 && s.GreetingDelay >= l.ReadTimeout {
+		add(FindingWarning, "limits", "GreetingDelay is not shorter than ReadTimeout")
+	}
+
+	// schema
+	if schema, _ := s.subschema(); schema != nil {
+		for _, m := range schemaInconsistencies(schema) {
+			add(FindingError, "schema", "%s", m)
+		}
+	}
+
+	for _, f := range findings {
+		if f.Severity == FindingError {
+			return findings, &ValidationError{Findings: findings}
+		}
+	}
+	return findings, nil
+}
+
+// schemaInconsistencies returns the references of the definitions of
+// schema to attribute types or object classes which are not defined
+func schemaInconsistencies(schema *Schema) []string {
+	var problems []string
+	name := func(oid string, names []string) string {
+		if len(names) > 0 {
+			return names[0]
+		}
+		return oid
+	}
+	for _, t := range schema.AttributeTypes() {
+		if _, ok := schema.AttributeType(t.Superior); t.Superior != "" && !ok {
+			problems = append(problems, fmt.Sprintf("attribute type %s: undefined superior %s", name(t.OID, t.Names), t.Superior))
+		}
+	}
+	for _, c := range schema.ObjectClasses() {
+		for _, sup := range c.Superiors {
+			if _, ok := schema.ObjectClass(sup); !ok {
+				problems = append(problems, fmt.Sprintf("object class %s: undefined superior %s", name(c.OID, c.Names), sup))
+			}
+		}
+		for _, a := range append(append([]string{}, c.Must...), c.May...) {
+			if _, ok := schema.AttributeType(a); !ok {
+				problems = append(problems, fmt.Sprintf("object class %s: undefined attribute type %s", name(c.OID, c.Names), a))
+			}
+		}
+	}
+	return problems
+}
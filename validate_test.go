@@ -0,0 +1,52 @@
+package ldapserver
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateSchema(t *testing.T) {
+	schema := NewSchema()
+	for _, def := range []string{
+		"( 2.5.4.41 NAME 'name' SYNTAX 1.3.6.1.4.1.1466.115.121.1.15 )",
+		"( 2.5.4.3 NAME 'cn' SUP name )",
+		"( 2.5.4.4 NAME 'sn' SUP surname )",
+	} {
+		if err := schema.AddAttributeType(def); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, def := range []string{
+		"( 2.5.6.0 NAME 'top' ABSTRACT )",
+		"( 2.5.6.6 NAME 'person' SUP top STRUCTURAL MUST ( sn $ cn ) MAY telephoneNumber )",
+		"( 2.5.6.7 NAME 'organizationalPerson' SUP persons STRUCTURAL )",
+	} {
+		if err := schema.AddObjectClass(def); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := NewServer()
+	s.Handle(successHandler{})
+	if err := s.SetSchema(schema, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	findings, err := s.Validate()
+	if err == nil {
+		t.Fatal("inconsistent schema validated")
+	}
+	var problems []string
+	for _, f := range findings {
+		if f.Check == "schema" {
+			problems = append(problems, f.Message)
+		}
+	}
+	want := []string{
+		"attribute type sn: undefined superior surname",
+		"object class person: undefined attribute type telephoneNumber",
+		"object class organizationalPerson: undefined superior persons",
+	}
+	if got := strings.Join(problems, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("schema findings:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+}
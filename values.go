@@ -0,0 +1,153 @@
+package ldapserver
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	ldap "github.com/ps78674/goldap/message"
+	"golang.org/x/text/unicode/norm"
+)
+
+// binaryAttributes lists the common attributes whose values are not
+// DirectoryStrings, and must not be checked nor normalized
+var binaryAttributes = map[string]bool{
+	"userpassword":              true,
+	"jpegphoto":                 true,
+	"photo":                     true,
+	"audio":                     true,
+	"usercertificate":           true,
+	"cacertificate":             true,
+	"crosscertificatepair":      true,
+	"certificaterevocationlist": true,
+	"authorityrevocationlist":   true,
+	"usersmimecertificate":      true,
+	"userpkcs12":                true,
+	"objectguid":                true,
+	"objectsid":                 true,
+	"thumbnailphoto":            true,
+}
+
+// IsBinaryAttribute reports whether the values of the attribute described
+// by name are binary: it has the ;binary option or is a well known binary
+// attribute
+func IsBinaryAttribute(name string) bool {
+	name = strings.ToLower(name)
+	if i := strings.IndexByte(name, ';'); i >= 0 {
+		for _, option := range strings.Split(name[i+1:], ";") {
+			if option == "binary" {
+				return true
+			}
+		}
+		name = name[:i]
+	}
+	return binaryAttributes[name]
+}
+
+// NormalizeValue checks that the value v of the attribute name is valid
+// UTF-8 and returns its NFC normalized form. Binary attribute values are
+// returned unchanged. The error is a *ResultError with the
+// invalidAttributeSyntax code.
+func NormalizeValue(name string, v []byte) ([]byte, error) {
+	if IsBinaryAttribute(name) {
+		return v, nil
+	}
+	if !utf8.Valid(v) {
+		return nil, NewResultError(LDAPResultInvalidAttributeSyntax,
+			fmt.Sprintf("%s: value is not valid UTF-8", name))
+	}
+	if norm.NFC.IsNormal(v) {
+		return v, nil
+	}
+	return norm.NFC.Bytes(v), nil
+}
+
+// checkValuesUTF8 returns an invalidAttributeSyntax response when a value
+// of an Add or Modify request is not valid UTF-8
+func checkValuesUTF8(po ldap.ProtocolOp) ldap.ProtocolOp {
+	check := func(name ldap.AttributeDescription, vals []ldap.AttributeValue) ldap.ProtocolOp {
+		for _, v := range vals {
+			if _, err := NormalizeValue(string(name), []byte(v)); err != nil {
+				diag := err.(*ResultError).DiagnosticMessage
+				return NewResponseForRequest(po, LDAPResultInvalidAttributeSyntax, diag)
+			}
+		}
+		return nil
+	}
+
+	switch r := po.(type) {
+	case ldap.AddRequest:
+		for _, a := range r.Attributes() {
+			if res := check(a.Type_(), a.Vals()); res != nil {
+				return res
+			}
+		}
+	case ldap.ModifyRequest:
+		for _, change := range r.Changes() {
+			modification := change.Modification()
+			if res := check(modification.Type_(), modification.Vals()); res != nil {
+				return res
+			}
+		}
+	}
+	return nil
+}
+
+// normalizeValues replaces the Add or Modify request of m with one whose
+// values are NFC normalized, when some are not. Values which are not valid
+// UTF-8 are left as is, see checkValuesUTF8.
+func normalizeValues(m *Message) error {
+	switch r := m.ProtocolOp().(type) {
+	case ldap.AddRequest:
+		changed := false
+		attributes := make([][]byte, len(r.Attributes()))
+		for i, a := range r.Attributes() {
+			values, normalized := normalizedValues(a.Type_(), a.Vals())
+			changed = changed || normalized
+			for j, v := range values {
+				values[j] = berOctetString(berTagOctetString, v)
+			}
+			attributes[i] = berSequence(
+				berOctetString(berTagOctetString, []byte(a.Type_())),
+				berConstructedTLV(berTagSet, values...),
+			)
+		}
+		if !changed {
+			return nil
+		}
+		return replaceRequest(m, berConstructedTLV(berClassApplication|berConstructed|ApplicationAddRequest,
+			berOctetString(berTagOctetString, []byte(r.Entry())),
+			berSequence(attributes...),
+		))
+	case ldap.ModifyRequest:
+		changed := false
+		changes := make([]modifyChange, len(r.Changes()))
+		for i, change := range r.Changes() {
+			modification := change.Modification()
+			values, normalized := normalizedValues(modification.Type_(), modification.Vals())
+			changed = changed || normalized
+			changes[i] = modifyChange{operation: int(change.Operation()), attribute: string(modification.Type_()), values: values}
+		}
+		if !changed {
+			return nil
+		}
+		return replaceModifyChanges(m, string(r.Object()), changes)
+	}
+	return nil
+}
+
+// normalizedValues returns the values vals of the attribute name NFC
+// normalized, and whether one of them changed
+func normalizedValues(name ldap.AttributeDescription, vals []ldap.AttributeValue) ([][]byte, bool) {
+	changed := false
+	values := make([][]byte, len(vals))
+	for i, v := range vals {
+		values[i] = []byte(v)
+		if normalized, err := NormalizeValue(string(name), values[i]); err == nil && !bytes.Equal(normalized, values[i]) {
+			values[i] = normalized
+			changed = true
+		}
+	}
+	return values, changed
+}
@@ -0,0 +1,91 @@
+package ldapserver
+
+import (
+	"bytes"
+	"testing"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+func TestNormalizeValues(t *testing.T) {
+	const decomposed, composed = "cafe\u0301", "caf\u00e9"
+	attribute := func(name string, values ...string) []byte {
+		encoded := make([][]byte, len(values))
+		for i, v := range values {
+			encoded[i] = berOctetString(berTagOctetString, []byte(v))
+		}
+		return berSequence(berOctetString(berTagOctetString, []byte(name)), berConstructedTLV(berTagSet, encoded...))
+	}
+	add := func(attributes ...[]byte) []byte {
+		return berConstructedTLV(berClassApplication|berConstructed|ApplicationAddRequest,
+			berOctetString(berTagOctetString, []byte("cn=cafe,dc=example,dc=com")),
+			berSequence(attributes...),
+		)
+	}
+	modify := func(attributes ...[]byte) []byte {
+		changes := make([][]byte, len(attributes))
+		for i, a := range attributes {
+			changes[i] = berSequence(berInteger(berTagEnumerated, ModifyRequestChangeOperationReplace), a)
+		}
+		return berConstructedTLV(berClassApplication|berConstructed|ApplicationModifyRequest,
+			berOctetString(berTagOctetString, []byte("cn=cafe,dc=example,dc=com")),
+			berSequence(changes...),
+		)
+	}
+	// values returns the values of the attribute name of an Add or
+	// Modify request
+	values := func(po ldap.ProtocolOp, name string) [][]byte {
+		var values [][]byte
+		switch r := po.(type) {
+		case ldap.AddRequest:
+			for _, a := range r.Attributes() {
+				if string(a.Type_()) == name {
+					for _, v := range a.Vals() {
+						values = append(values, []byte(v))
+					}
+				}
+			}
+		case ldap.ModifyRequest:
+			for _, change := range r.Changes() {
+				if string(change.Modification().Type_()) == name {
+					for _, v := range change.Modification().Vals() {
+						values = append(values, []byte(v))
+					}
+				}
+			}
+		}
+		return values
+	}
+
+	tests := []struct {
+		name    string
+		request []byte
+	}{
+		{"add", add(attribute("cn", "cafe", decomposed), attribute("userPassword", decomposed))},
+		{"modify", modify(attribute("cn", "cafe", decomposed), attribute("userPassword", decomposed))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := testMessage(t, tt.request)
+			if err := normalizeValues(m); err != nil {
+				t.Fatal(err)
+			}
+			if id := m.MessageID().Int(); id != 1 {
+				t.Errorf("message ID %d, want 1", id)
+			}
+			if cn := values(m.ProtocolOp(), "cn"); len(cn) != 2 || string(cn[0]) != "cafe" || string(cn[1]) != composed {
+				t.Errorf("cn %q, want [cafe %s]", cn, composed)
+			}
+			if password := values(m.ProtocolOp(), "userPassword"); len(password) != 1 || !bytes.Equal(password[0], []byte(decomposed)) {
+				t.Errorf("binary userPassword normalized to %q", password)
+			}
+		})
+	}
+
+	// requests already normalized are kept
+	m := testMessage(t, add(attribute("cn", composed)))
+	message := m.LDAPMessage
+	if err := normalizeValues(m); err != nil || m.LDAPMessage != message {
+		t.Errorf("normalized request replaced, %v", err)
+	}
+}
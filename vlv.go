@@ -0,0 +1,237 @@
+package ldapserver
+
+import (
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// VLVControl is the Virtual List View control (draft-ietf-ldapext-ldapv3-vlv)
+// of a search request: the client asks for a window of the sorted result,
+// BeforeCount entries before the target entry and AfterCount after it. The
+// target is given either by its Offset in a list of ContentCount entries,
+// as estimated by the client, or, when GreaterThanOrEqual is set, as the
+// first entry whose primary sort key is greater than or equal to it.
+type VLVControl struct {
+	BeforeCount        int
+	AfterCount         int
+	Offset             int
+	ContentCount       int
+	GreaterThanOrEqual []byte
+	ContextID          []byte
+	Criticality        bool
+}
+
+// VirtualListView returns the Virtual List View control of the search
+// request m, nil when it has none. The error is a *ResultError with the
+// protocolError code when the control value is malformed.
+func (m *Message) VirtualListView() (*VLVControl, error) {
+	if _, ok := m.ProtocolOp().(ldap.SearchRequest); !ok {
+		return nil, nil
+	}
+	c, ok := requestControl(m, ControlVLVRequest)
+	if !ok {
+		return nil, nil
+	}
+	return decodeVLVControl(c.Criticality(), controlValue(c))
+}
+
+// decodeVLVControl decodes the value of a Virtual List View control
+func decodeVLVControl(criticality bool, value []byte) (*VLVControl, error) {
+	malformed := NewResultError(LDAPResultProtocolError, "malformed virtual list view control")
+	if value == nil {
+		return nil, malformed
+	}
+
+	// VirtualListViewRequest ::= SEQUENCE { beforeCount INTEGER, afterCount
+	// INTEGER, target CHOICE { byOffset [0] SEQUENCE { offset INTEGER,
+	// contentCount INTEGER }, greaterThanOrEqual [1] AssertionValue },
+	// contextID OCTET STRING OPTIONAL }
+	sequence, _, err := berRead(value)
+	if err != nil || sequence.tag != berTagSequence {
+		return nil, malformed
+	}
+	fields, err := berChildren(sequence.data)
+	if err != nil || len(fields) < 3 || len(fields) > 4 || fields[0].tag != berTagInteger || fields[1].tag != berTagInteger {
+		return nil, malformed
+	}
+	control := &VLVControl{Criticality: criticality}
+	before, err := berParseInteger(fields[0].data)
+	if err != nil || before < 0 || before > maxInt {
+		return nil, malformed
+	}
+	after, err := berParseInteger(fields[1].data)
+	if err != nil || after < 0 || after > maxInt {
+		return nil, malformed
+	}
+	control.BeforeCount, control.AfterCount = int(before), int(after)
+
+	switch target := fields[2]; target.tag {
+	case berClassContext | berConstructed | 0:
+		byOffset, err := berChildren(target.data)
+		if err != nil || len(byOffset) != 2 || byOffset[0].tag != berTagInteger || byOffset[1].tag != berTagInteger {
+			return nil, malformed
+		}
+		offset, err := berParseInteger(byOffset[0].data)
+		if err != nil || offset < 0 || offset > maxInt {
+			return nil, malformed
+		}
+		contentCount, err := berParseInteger(byOffset[1].data)
+		if err != nil || contentCount < 0 || contentCount > maxInt {
+			return nil, malformed
+		}
+		control.Offset, control.ContentCount = int(offset), int(contentCount)
+	case berClassContext | 1:
+		control.GreaterThanOrEqual = target.data
+		if control.GreaterThanOrEqual == nil {
+			control.GreaterThanOrEqual = []byte{}
+		}
+	default:
+		return nil, malformed
+	}
+
+	if len(fields) == 4 {
+		if fields[3].tag != berTagOctetString {
+			return nil, malformed
+		}
+		control.ContextID = fields[3].data
+	}
+	return control, nil
+}
+
+// VLVResponseControl returns the BER encoded Virtual List View response
+// control, with the 1-based position of the target entry, the number of
+// entries of the list, the result of the view and an optional contextID
+func VLVResponseControl(targetPosition, contentCount, resultCode int, contextID []byte) []byte {
+	elements := [][]byte{
+		berInteger(berTagInteger, int64(targetPosition)),
+		berInteger(berTagInteger, int64(contentCount)),
+		berInteger(berTagEnumerated, int64(resultCode)),
+	}
+	if contextID != nil {
+		elements = append(elements, berOctetString(berTagOctetString, contextID))
+	}
+	return berSequence(
+		berOctetString(berTagOctetString, []byte(ControlVLVResponse)),
+		berOctetString(berTagOctetString, berSequence(elements...)),
+	)
+}
+
+// Window returns the bounds of the window of the sorted entries selected
+// by v, entries[start:end], and the 1-based position of the target entry.
+// keys are the sort keys of the search, the first one is compared with
+// GreaterThanOrEqual. The error is a *ResultError with the
+// offsetRangeError code when the offset is zero.
+func (v *VLVControl) Window(entries []Entry, keys []SortKey) (start, end, target int, err error) {
+	count := len(entries)
+	if v.GreaterThanOrEqual != nil {
+		// the target is past the end when no entry matches
+		target = count + 1
+		if len(keys) > 0 {
+			key := keys[0]
+			for i := range entries {
+				if vlvAtLeast(&entries[i], key, v.GreaterThanOrEqual) {
+					target = i + 1
+					break
+				}
+			}
+		}
+	} else {
+		if v.Offset < 1 {
+			return 0, 0, 0, NewResultError(LDAPResultOffsetRangeError, "virtual list view offset must be positive")
+		}
+		// the offset is scaled from the client estimate of the list size
+		target = v.Offset
+		if v.ContentCount > 0 {
+			switch {
+			case v.Offset == 1:
+				target = 1
+			case v.Offset >= v.ContentCount:
+				target = count
+			default:
+				target = (v.Offset*count + v.ContentCount/2) / v.ContentCount
+			}
+		}
+		if target > count {
+			target = count
+		}
+		if target < 1 && count > 0 {
+			target = 1
+		}
+	}
+
+	start = 0
+	if v.BeforeCount < target-1 {
+		start = target - 1 - v.BeforeCount
+	}
+	end = count
+	if v.AfterCount < count-target {
+		end = target + v.AfterCount
+	}
+	if start > end {
+		start = end
+	}
+	return start, end, target, nil
+}
+
+// vlvAtLeast reports whether the least value of the sort key attribute of
+// e is ordered at or after value, as SortEntries orders the entries
+func vlvAtLeast(e *Entry, key SortKey, value []byte) bool {
+	for _, v := range e.values(key.Attribute) {
+		c := key.compare(v, value)
+		if key.Reverse {
+			c = -c
+		}
+		if c >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteVLVEntries answers the search request m with entries, sorted with
+// its Server Side Sort control, and when it has the Virtual List View
+// control, the window of entries it selects only. The SearchResultDone
+// carries the sort and the view response controls. A view without a sort
+// control fails the search with sortControlMissing.
+func WriteVLVEntries(w ResponseWriter, m *Message, entries []Entry) error {
+	view, err := m.VirtualListView()
+	if err == nil && view == nil {
+		return WriteSortedEntries(w, m, entries)
+	}
+	var sort *SortControl
+	if err == nil {
+		sort, err = m.ServerSideSort()
+	}
+	if err != nil {
+		resultCode, diagnosticMessage := DefaultErrorMapper(err)
+		w.Write(NewResponseForRequest(m.ProtocolOp(), resultCode, diagnosticMessage))
+		return err
+	}
+	if sort == nil {
+		WriteSearchResultDone(w, LDAPResultSortControlMissing, "virtual list view requires a sort control",
+			VLVResponseControl(0, 0, LDAPResultSortControlMissing, view.ContextID))
+		return nil
+	}
+
+	if err := SortEntries(entries, sort.Keys); err != nil {
+		sortErr := err.(*SortError)
+		WriteSearchResultDone(w, LDAPResultUnavailableCriticalExtension, err.Error(),
+			SortResultControl(sortErr.ResultCode, sortErr.Attribute),
+			VLVResponseControl(0, len(entries), LDAPResultUnwillingToPerform, view.ContextID))
+		return nil
+	}
+	start, end, target, err := view.Window(entries, sort.Keys)
+	if err != nil {
+		resultCode, diagnosticMessage := DefaultErrorMapper(err)
+		WriteSearchResultDone(w, resultCode, diagnosticMessage,
+			SortResultControl(LDAPResultSuccess, ""),
+			VLVResponseControl(0, len(entries), resultCode, view.ContextID))
+		return nil
+	}
+	if err := WriteEntries(w, entries[start:end]); err != nil {
+		return err
+	}
+	WriteSearchResultDone(w, LDAPResultSuccess, "",
+		SortResultControl(LDAPResultSuccess, ""),
+		VLVResponseControl(target, len(entries), LDAPResultSuccess, view.ContextID))
+	return nil
+}
@@ -0,0 +1,155 @@
+package ldapserver
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// vlvValue returns the value of a Virtual List View control
+func vlvValue(before, after int64, target []byte, contextID ...[]byte) []byte {
+	elements := [][]byte{berInteger(berTagInteger, before), berInteger(berTagInteger, after), target}
+	for _, id := range contextID {
+		elements = append(elements, berOctetString(berTagOctetString, id))
+	}
+	return berSequence(elements...)
+}
+
+// vlvByOffset returns the byOffset target of a Virtual List View control
+func vlvByOffset(offset, contentCount int64) []byte {
+	return berConstructedTLV(berClassContext|berConstructed|0, berInteger(berTagInteger, offset), berInteger(berTagInteger, contentCount))
+}
+
+func TestDecodeVLVControl(t *testing.T) {
+	tests := []struct {
+		name  string
+		value []byte
+		want  *VLVControl
+	}{
+		{"by offset", vlvValue(1, 2, vlvByOffset(3, 10)), &VLVControl{BeforeCount: 1, AfterCount: 2, Offset: 3, ContentCount: 10}},
+		{"greater than or equal", vlvValue(0, 5, berOctetString(berClassContext|1, []byte("c")), []byte("ctx")), &VLVControl{AfterCount: 5, GreaterThanOrEqual: []byte("c"), ContextID: []byte("ctx")}},
+		{"empty assertion", vlvValue(0, 0, berOctetString(berClassContext|1, nil)), &VLVControl{GreaterThanOrEqual: []byte{}}},
+		{"no value", nil, nil},
+		{"negative count", vlvValue(-1, 2, vlvByOffset(3, 10)), nil},
+		{"negative offset", vlvValue(1, 2, vlvByOffset(-3, 10)), nil},
+		{"no target", berSequence(berInteger(berTagInteger, 1), berInteger(berTagInteger, 2)), nil},
+		{"unknown target", vlvValue(1, 2, berOctetString(berClassContext|2, []byte("c"))), nil},
+		{"invalid context ID", berSequence(berInteger(berTagInteger, 1), berInteger(berTagInteger, 2), vlvByOffset(3, 10), berInteger(berTagInteger, 1)), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			control, err := decodeVLVControl(false, tt.value)
+			if tt.want == nil {
+				var resultErr *ResultError
+				if !errors.As(err, &resultErr) || resultErr.ResultCode != LDAPResultProtocolError {
+					t.Errorf("decoded %+v, %v, want protocolError", control, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(control, tt.want) {
+				t.Errorf("decoded %+v, want %+v", control, tt.want)
+			}
+		})
+	}
+}
+
+func TestVLVWindow(t *testing.T) {
+	var entries []Entry
+	for _, cn := range "abcdefghij" {
+		entries = append(entries, *NewEntry("cn="+string(cn)).Add("cn", string(cn)))
+	}
+	keys := []SortKey{{Attribute: "cn"}}
+	tests := []struct {
+		name               string
+		view               VLVControl
+		entries            []Entry
+		start, end, target int
+	}{
+		{"first", VLVControl{AfterCount: 2, Offset: 1}, entries, 0, 3, 1},
+		{"around", VLVControl{BeforeCount: 1, AfterCount: 1, Offset: 5}, entries, 3, 6, 5},
+		{"past the end", VLVControl{BeforeCount: 2, AfterCount: 2, Offset: 20}, entries, 7, 10, 10},
+		{"scaled", VLVControl{Offset: 50, ContentCount: 100}, entries, 4, 5, 5},
+		{"scaled last", VLVControl{Offset: 100, ContentCount: 100}, entries, 9, 10, 10},
+		{"greater than or equal", VLVControl{BeforeCount: 1, AfterCount: 1, GreaterThanOrEqual: []byte("C")}, entries, 1, 4, 3},
+		{"none greater", VLVControl{BeforeCount: 1, AfterCount: 1, GreaterThanOrEqual: []byte("z")}, entries, 9, 10, 11},
+		{"empty list", VLVControl{AfterCount: 1, Offset: 1}, nil, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end, target, err := tt.view.Window(tt.entries, keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if start != tt.start || end != tt.end || target != tt.target {
+				t.Errorf("window [%d:%d] target %d, want [%d:%d] target %d", start, end, target, tt.start, tt.end, tt.target)
+			}
+		})
+	}
+
+	view := VLVControl{Offset: 0}
+	var resultErr *ResultError
+	if _, _, _, err := view.Window(entries, keys); !errors.As(err, &resultErr) || resultErr.ResultCode != LDAPResultOffsetRangeError {
+		t.Errorf("zero offset: %v, want offsetRangeError", err)
+	}
+}
+
+func TestWriteVLVEntries(t *testing.T) {
+	var entries []Entry
+	for _, cn := range "jihgfedcba" {
+		entries = append(entries, *NewEntry("cn="+string(cn)).Add("cn", string(cn)))
+	}
+	sortControl := berSequence(
+		berOctetString(berTagOctetString, []byte(ControlSortRequest)),
+		berOctetString(berTagOctetString, berSequence(berSequence(berOctetString(berTagOctetString, []byte("cn"))))),
+	)
+	vlvControl := berSequence(
+		berOctetString(berTagOctetString, []byte(ControlVLVRequest)),
+		berOctetString(berTagOctetString, vlvValue(1, 1, vlvByOffset(5, 0), []byte("ctx"))),
+	)
+
+	tests := []struct {
+		name     string
+		controls [][]byte
+		code     int
+		entries  string
+	}{
+		{"sorted", [][]byte{sortControl}, LDAPResultSuccess, "cn=a cn=b cn=c cn=d cn=e cn=f cn=g cn=h cn=i cn=j"},
+		{"window", [][]byte{sortControl, vlvControl}, LDAPResultSuccess, "cn=d cn=e cn=f"},
+		{"no sort", [][]byte{vlvControl}, LDAPResultSortControlMissing, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := decodeMessage(encodeRawMessage(1, testSearchRequest("dc=example", 0, 0), tt.controls))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := NewResponseRecorder()
+			if err := WriteVLVEntries(w, &Message{LDAPMessage: &msg}, append([]Entry(nil), entries...)); err != nil {
+				t.Fatal(err)
+			}
+			if code := w.ResultCode(); code != tt.code {
+				t.Errorf("result code %d, want %d", code, tt.code)
+			}
+			var dns []string
+			for _, e := range w.Entries() {
+				dns = append(dns, e.DN)
+			}
+			if got := strings.Join(dns, " "); got != tt.entries {
+				t.Errorf("entries %s, want %s", got, tt.entries)
+			}
+			var types []string
+			for _, c := range w.Controls() {
+				types = append(types, string(c.ControlType()))
+			}
+			if len(tt.controls) > 1 || tt.code != LDAPResultSuccess {
+				if !strings.Contains(strings.Join(types, " "), string(ControlVLVResponse)) {
+					t.Errorf("response controls %v, want the view response", types)
+				}
+			}
+		})
+	}
+}
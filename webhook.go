@@ -0,0 +1,281 @@
+package ldapserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Webhook is an overlay POSTing a JSON description of the write requests
+// answered with success to HTTP endpoints, for provisioning integrations:
+//
+//	hook := &ldap.Webhook{URLs: []string{"https://example.com/ldap"}, Secret: secret}
+//	routes.Use(hook.Middleware())
+//
+// Notifications are delivered in the background, in the order of the
+// responses, and retried on errors and non 2xx responses, until Close is
+// called or the server is stopped.
+type Webhook struct {
+	// URLs are the endpoints every notification is POSTed to
+	URLs []string
+
+	// Secret, if non-nil, signs the notifications: the X-Ldap-Signature
+	// header is "sha256=" followed by the hex HMAC-SHA256 of the body
+	Secret []byte
+
+	// Client sends the notifications, http.DefaultClient if nil
+	Client *http.Client
+
+	// MaxRetries is the number of times a failed delivery is retried,
+	// with a delay doubling from RetryDelay (1s if zero)
+	MaxRetries int
+	RetryDelay time.Duration
+
+	// QueueSize is the number of notifications waiting for delivery,
+	// notifications are dropped when it is full, 1000 if zero
+	QueueSize int
+
+	// OnError, if non-nil, is called when a notification could not be
+	// delivered to url after the retries
+	OnError func(url string, n WebhookNotification, err error)
+
+	// RedactAttributes are the attributes whose values are left out of
+	// the notifications, userPassword if nil
+	RedactAttributes []string
+
+	once    sync.Once
+	mu      sync.Mutex // protects queue and closed
+	queue   chan WebhookNotification
+	closed  bool
+	closing chan struct{} // closed by Close, stops the retries
+	done    chan struct{} // closed once the queue is delivered
+	dropped int64
+}
+
+// defaultRedactedAttributes are the attributes redacted when
+// Webhook.RedactAttributes is nil
+var defaultRedactedAttributes = []string{"userPassword"}
+
+// WebhookNotification is the JSON body POSTed by Webhook
+type WebhookNotification struct {
+	Time         time.Time       `json:"time"`
+	Type         string          `json:"type"` // add, delete, modify or modrdn
+	DN           string          `json:"dn"`
+	BindDN       string          `json:"bindDN"`
+	Changes      []WebhookChange `json:"changes,omitempty"`
+	NewRDN       string          `json:"newRDN,omitempty"`
+	DeleteOldRDN bool            `json:"deleteOldRDN,omitempty"`
+	NewSuperior  string          `json:"newSuperior,omitempty"`
+}
+
+// WebhookChange is a change of a WebhookNotification
+type WebhookChange struct {
+	Operation string   `json:"operation"` // add, delete or replace
+	Attribute string   `json:"attribute"`
+	Values    []string `json:"values,omitempty"`
+}
+
+// Middleware returns a middleware queuing a notification for the write
+// requests answered with success
+func (h *Webhook) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w ResponseWriter, m *Message) {
+			if !isWriteRequest(m.ProtocolOp()) {
+				next(w, m)
+				return
+			}
+			tee := &teeResponseWriter{w: w, rec: NewResponseRecorder()}
+			next(tee, m)
+			if tee.rec.ResultCode() != LDAPResultSuccess {
+				return
+			}
+			if e, ok := ChangeEventOf(m.ProtocolOp()); ok {
+				n := newWebhookNotification(e, h.redacted)
+				if m.Client != nil {
+					n.BindDN = m.Client.ACL().BindEntry
+				}
+				h.notify(m, n)
+			}
+		}
+	}
+}
+
+// Dropped returns the number of notifications dropped because the queue
+// was full, or the webhook closed
+func (h *Webhook) Dropped() int64 {
+	return atomic.LoadInt64(&h.dropped)
+}
+
+// Close delivers the notifications queued, trying each endpoint once, and
+// stops the delivery goroutine. The notifications of the requests served
+// afterwards are dropped. It is called when the server serving the first
+// notified request is stopped.
+func (h *Webhook) Close() {
+	h.once.Do(h.start)
+	h.mu.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.closing)
+		close(h.queue)
+	}
+	h.mu.Unlock()
+	<-h.done
+}
+
+// start creates the queue and starts the delivery goroutine
+func (h *Webhook) start() {
+	size := h.QueueSize
+	if size <= 0 {
+		size = 1000
+	}
+	h.queue = make(chan WebhookNotification, size)
+	h.closing = make(chan struct{})
+	h.done = make(chan struct{})
+	go h.deliverAll()
+}
+
+// notify queues n for delivery, starting the delivery goroutine on first
+// use, stopped with the server serving m
+func (h *Webhook) notify(m *Message, n WebhookNotification) {
+	h.once.Do(func() {
+		h.start()
+		if m.Client != nil {
+			m.Client.srv.OnShutdown(ShutdownClosed, h.Close)
+		}
+	})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		atomic.AddInt64(&h.dropped, 1)
+		return
+	}
+	select {
+	case h.queue <- n:
+	default:
+		atomic.AddInt64(&h.dropped, 1)
+	}
+}
+
+// redacted reports whether the values of attribute are left out of the
+// notifications
+func (h *Webhook) redacted(attribute string) bool {
+	redact := h.RedactAttributes
+	if redact == nil {
+		redact = defaultRedactedAttributes
+	}
+	for _, a := range redact {
+		if strings.EqualFold(a, attributeType(attribute)) {
+			return true
+		}
+	}
+	return false
+}
+
+// deliverAll delivers the queued notifications, one at a time so the
+// endpoints receive them in order
+func (h *Webhook) deliverAll() {
+	defer close(h.done)
+	for n := range h.queue {
+		body, err := json.Marshal(n)
+		if err != nil {
+			h.failed("", n, err)
+			continue
+		}
+		for _, url := range h.URLs {
+			if err := h.deliver(url, body); err != nil {
+				h.failed(url, n, err)
+			}
+		}
+	}
+}
+
+// deliver POSTs body to url, with retries until the webhook is closed
+func (h *Webhook) deliver(url string, body []byte) error {
+	delay := h.RetryDelay
+	if delay <= 0 {
+		delay = time.Second
+	}
+	var err error
+	for attempt := 0; attempt <= h.MaxRetries; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-h.closing:
+				timer.Stop()
+				return err
+			}
+			delay *= 2
+		}
+		if err = h.post(url, body); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (h *Webhook) post(url string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Secret != nil {
+		mac := hmac.New(sha256.New, h.Secret)
+		mac.Write(body)
+		req.Header.Set("X-Ldap-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook %s answered %s", url, res.Status)
+	}
+	return nil
+}
+
+func (h *Webhook) failed(url string, n WebhookNotification, err error) {
+	if h.OnError != nil {
+		h.OnError(url, n, err)
+	}
+}
+
+// newWebhookNotification returns the notification describing e, without
+// the values of the attributes for which redacted returns true
+func newWebhookNotification(e ChangeEvent, redacted func(attribute string) bool) WebhookNotification {
+	n := WebhookNotification{
+		Time:         time.Now().UTC(),
+		Type:         e.Type.String(),
+		DN:           e.DN,
+		NewRDN:       e.NewRDN,
+		DeleteOldRDN: e.DeleteOldRDN,
+		NewSuperior:  e.NewSuperior,
+	}
+	for _, c := range e.Changes {
+		wc := WebhookChange{Operation: modifyOperationName(c.Operation), Attribute: c.Attribute}
+		if redacted(c.Attribute) {
+			n.Changes = append(n.Changes, wc)
+			continue
+		}
+		for _, v := range c.Values {
+			wc.Values = append(wc.Values, string(v))
+		}
+		n.Changes = append(n.Changes, wc)
+	}
+	return n
+}
@@ -0,0 +1,76 @@
+package ldapserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestWebhook(t *testing.T) {
+	var mu sync.Mutex
+	var received []WebhookNotification
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n WebhookNotification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		received = append(received, n)
+		mu.Unlock()
+	}))
+	defer endpoint.Close()
+
+	add := func(dn string) []byte {
+		attribute := func(name, value string) []byte {
+			return berSequence(
+				berOctetString(berTagOctetString, []byte(name)),
+				berConstructedTLV(berTagSet, berOctetString(berTagOctetString, []byte(value))),
+			)
+		}
+		return berConstructedTLV(berClassApplication|berConstructed|ApplicationAddRequest,
+			berOctetString(berTagOctetString, []byte(dn)),
+			berSequence(attribute("cn", "alice"), attribute("userPassword", "secret"), attribute("description", "private")),
+		)
+	}
+	tests := []struct {
+		name     string
+		redact   []string
+		redacted map[string]bool
+	}{
+		{"default", nil, map[string]bool{"userPassword": true}},
+		{"configured", []string{"description", "USERPASSWORD"}, map[string]bool{"userPassword": true, "description": true}},
+		{"none", []string{}, map[string]bool{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mu.Lock()
+			received = nil
+			mu.Unlock()
+
+			hook := &Webhook{URLs: []string{endpoint.URL}, RedactAttributes: tt.redact}
+			handler := hook.Middleware()(func(w ResponseWriter, m *Message) {
+				w.Write(NewResponseForRequest(m.ProtocolOp(), LDAPResultSuccess, ""))
+			})
+			handler(NewResponseRecorder(), testMessage(t, add("cn=alice,dc=example,dc=com")))
+			hook.Close()
+			// dropped once closed
+			handler(NewResponseRecorder(), testMessage(t, add("cn=bob,dc=example,dc=com")))
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(received) != 1 {
+				t.Fatalf("%d notifications delivered, want 1", len(received))
+			}
+			if n := hook.Dropped(); n != 1 {
+				t.Errorf("%d notifications dropped, want 1", n)
+			}
+			for _, c := range received[0].Changes {
+				if redacted := len(c.Values) == 0; redacted != tt.redacted[c.Attribute] {
+					t.Errorf("%s values %q, redacted %t", c.Attribute, c.Values, tt.redacted[c.Attribute])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package ldapserver
+
+// NewWhoAmIResponse returns the response to a Who am I? extended request
+// (RFC 4532), carrying authzID, the authorization identity of the client
+// in the RFC 4513 authzId form, empty for anonymous
+func NewWhoAmIResponse(authzID string) *ExtendedValueResponse {
+	return NewExtendedValueResponse(LDAPResultSuccess, []byte(authzID))
+}
+
+// HandleWhoAmI answers a Who am I? extended request with the
+// authorization identity of the request, see Message.AuthzID: the bind DN
+// stored on the connection, or the identity of its ProxiedAuthorization
+// control. It serves the requests not handled by the user routes.
+func HandleWhoAmI(w ResponseWriter, m *Message) {
+	WriteRaw(w, NewWhoAmIResponse(m.AuthzID()).Bytes())
+}
+
+// WhoAmI routes the Who am I? extended requests (RFC 4532) to handler,
+// which answers with NewWhoAmIResponse. Without such a route, they are
+// answered by HandleWhoAmI.
+func (h *RouteMux) WhoAmI(handler HandlerFunc) *route {
+	return h.Extended(handler).RequestName(NoticeOfWhoAmI)
+}
@@ -0,0 +1,172 @@
+package ldapserver
+
+import (
+	"fmt"
+	"strings"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// WriteAccess reports whether the client sending m may apply the modify
+// operation (ModifyRequestChangeOperationAdd, Delete or Replace) to the
+// value of the attribute of the entry dn. value is nil for the changes
+// applying to all the values of the attribute.
+type WriteAccess func(m *Message, dn string, operation int, attribute string, value []byte) bool
+
+// DeniedChange is a change of a Modify request refused by ModifyAccess
+type DeniedChange struct {
+	Operation int
+	Attribute string
+	Value     []byte // nil when the change applies to all the values
+}
+
+// ModifyAccess checks the changes of Modify requests against per-value
+// write permissions before the modify handler runs. Each refusal emits a
+// WriteDenied event on the server EventBus, for auditing.
+type ModifyAccess struct {
+	// Allow reports whether a change is allowed
+	Allow WriteAccess
+
+	// Strip removes the refused changes and passes the others to the
+	// handler. Otherwise the request is answered with
+	// insufficientAccessRights when any change is refused. A replace is
+	// removed whole when one of its values is refused, as replacing with
+	// the remaining values would delete the others.
+	Strip bool
+}
+
+// modifyChange is a change of a Modify request
+type modifyChange struct {
+	operation int
+	attribute string
+	values    [][]byte
+}
+
+// Middleware returns a middleware checking the Modify requests
+func (a *ModifyAccess) Middleware() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(w ResponseWriter, m *Message) {
+			r, ok := m.ProtocolOp().(ldap.ModifyRequest)
+			if !ok {
+				next(w, m)
+				return
+			}
+
+			allowed, denied := a.check(m, r)
+			if len(denied) == 0 {
+				next(w, m)
+				return
+			}
+			stripped := a.Strip && len(allowed) > 0
+			a.audit(m, string(r.Object()), denied, stripped)
+			if !stripped {
+				w.Write(NewResponseForRequest(r, LDAPResultInsufficientAccessRights, "modification of "+denied[0].Attribute+" is not allowed"))
+				return
+			}
+
+			if err := replaceModifyChanges(m, string(r.Object()), allowed); err != nil {
+				w.Write(NewResponseForRequest(r, LDAPResultOperationsError, err.Error()))
+				return
+			}
+			next(w, m)
+		}
+	}
+}
+
+// check returns the changes of r allowed, and those refused
+func (a *ModifyAccess) check(m *Message, r ldap.ModifyRequest) (allowed []modifyChange, denied []DeniedChange) {
+	dn := string(r.Object())
+	for _, change := range r.Changes() {
+		modification := change.Modification()
+		c := modifyChange{
+			operation: int(change.Operation()),
+			attribute: string(modification.Type_()),
+		}
+		var refused []DeniedChange
+		if len(modification.Vals()) == 0 {
+			if !a.Allow(m, dn, c.operation, c.attribute, nil) {
+				refused = append(refused, DeniedChange{Operation: c.operation, Attribute: c.attribute})
+			}
+		}
+		for _, v := range modification.Vals() {
+			if a.Allow(m, dn, c.operation, c.attribute, []byte(v)) {
+				c.values = append(c.values, []byte(v))
+			} else {
+				refused = append(refused, DeniedChange{Operation: c.operation, Attribute: c.attribute, Value: []byte(v)})
+			}
+		}
+		denied = append(denied, refused...)
+
+		switch {
+		case len(refused) == 0:
+			allowed = append(allowed, c)
+		case c.operation == ModifyRequestChangeOperationReplace:
+			// the whole replace is refused
+		case len(c.values) > 0:
+			allowed = append(allowed, c)
+		}
+	}
+	return allowed, denied
+}
+
+// audit emits a WriteDenied event for the changes of m refused
+func (a *ModifyAccess) audit(m *Message, dn string, denied []DeniedChange, stripped bool) {
+	c := m.Client
+	if c == nil {
+		return
+	}
+	attributes := make([]string, len(denied))
+	for i, d := range denied {
+		attributes[i] = d.Attribute
+	}
+	m.logAt(LogLevelWarn, "modification of %s by %q denied: %s", dn, m.AuthzID(), strings.Join(attributes, ", "))
+	c.srv.events.emit(WriteDenied{
+		Numero:    c.numero,
+		MessageID: m.MessageID().Int(),
+		BindDN:    authzDN(m),
+		DN:        dn,
+		Changes:   denied,
+		Stripped:  stripped,
+
+		NamingContext: m.NamingContext(),
+	})
+}
+
+// replaceModifyChanges replaces the Modify request of m with one applying
+// changes to the entry dn, with the same message ID and controls
+func replaceModifyChanges(m *Message, dn string, changes []modifyChange) error {
+	encoded := make([][]byte, len(changes))
+	for i, c := range changes {
+		values := make([][]byte, len(c.values))
+		for j, v := range c.values {
+			values[j] = berOctetString(berTagOctetString, v)
+		}
+		encoded[i] = berSequence(
+			berInteger(berTagEnumerated, int64(c.operation)),
+			berSequence(
+				berOctetString(berTagOctetString, []byte(c.attribute)),
+				berConstructedTLV(berTagSet, values...),
+			),
+		)
+	}
+	protocolOp := berConstructedTLV(berClassApplication|berConstructed|ApplicationModifyRequest,
+		berOctetString(berTagOctetString, []byte(dn)),
+		berSequence(encoded...),
+	)
+	return replaceRequest(m, protocolOp)
+}
+
+// replaceRequest replaces the request of m with the BER encoded
+// protocolOp, keeping its message ID and controls
+func replaceRequest(m *Message, protocolOp []byte) error {
+	_, controls, err := encodeRequest(m)
+	if err != nil {
+		return err
+	}
+	message, err := decodeMessage(encodeRawMessage(m.MessageID().Int(), protocolOp, controls))
+	if err != nil {
+		return fmt.Errorf("re-encoding %s: %s", m.ProtocolOpName(), err)
+	}
+	m.LDAPMessage = &message
+	return nil
+}
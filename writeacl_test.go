@@ -0,0 +1,94 @@
+package ldapserver
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	ldap "github.com/ps78674/goldap/message"
+)
+
+// testModifyChange returns a change of a modify request
+func testModifyChange(operation int, attribute string, values ...string) []byte {
+	encoded := make([][]byte, len(values))
+	for i, v := range values {
+		encoded[i] = berOctetString(berTagOctetString, []byte(v))
+	}
+	return berSequence(
+		berInteger(berTagEnumerated, int64(operation)),
+		berSequence(berOctetString(berTagOctetString, []byte(attribute)), berConstructedTLV(berTagSet, encoded...)),
+	)
+}
+
+// testModifyRequest returns a modify request of the entry dn
+func testModifyRequest(dn string, changes ...[]byte) []byte {
+	return berConstructedTLV(berClassApplication|berConstructed|ApplicationModifyRequest,
+		berOctetString(berTagOctetString, []byte(dn)),
+		berSequence(changes...),
+	)
+}
+
+// modifyChanges describes the changes of the modify request m
+func modifyChanges(m *Message) []string {
+	var changes []string
+	for _, c := range m.ProtocolOp().(ldap.ModifyRequest).Changes() {
+		var values []string
+		for _, v := range c.Modification().Vals() {
+			values = append(values, string(v))
+		}
+		changes = append(changes, fmt.Sprintf("%d %s %s", c.Operation(), c.Modification().Type_(), strings.Join(values, ",")))
+	}
+	return changes
+}
+
+func TestModifyAccess(t *testing.T) {
+	allow := func(m *Message, dn string, operation int, attribute string, value []byte) bool {
+		switch strings.ToLower(attribute) {
+		case "userpassword":
+			return false
+		case "memberof":
+			return string(value) != "cn=admins"
+		case "mail":
+			return value != nil
+		}
+		return true
+	}
+	add, del, replace := ModifyRequestChangeOperationAdd, ModifyRequestChangeOperationDelete, ModifyRequestChangeOperationReplace
+
+	tests := []struct {
+		name    string
+		changes [][]byte
+		strip   bool
+		code    int
+		passed  []string // changes passed to the handler
+	}{
+		{"allowed", [][]byte{testModifyChange(replace, "cn", "alice")}, false, LDAPResultSuccess, []string{"2 cn alice"}},
+		{"refused", [][]byte{testModifyChange(replace, "cn", "alice"), testModifyChange(replace, "userPassword", "secret")}, false, LDAPResultInsufficientAccessRights, nil},
+		{"refused value", [][]byte{testModifyChange(add, "memberOf", "cn=users", "cn=admins")}, false, LDAPResultInsufficientAccessRights, nil},
+		{"refused delete of all values", [][]byte{testModifyChange(del, "mail")}, false, LDAPResultInsufficientAccessRights, nil},
+		{"value deleted", [][]byte{testModifyChange(del, "mail", "alice@example.com")}, false, LDAPResultSuccess, []string{"1 mail alice@example.com"}},
+		{"stripped", [][]byte{testModifyChange(replace, "cn", "alice"), testModifyChange(replace, "userPassword", "secret")}, true, LDAPResultSuccess, []string{"2 cn alice"}},
+		{"value stripped", [][]byte{testModifyChange(add, "memberOf", "cn=users", "cn=admins")}, true, LDAPResultSuccess, []string{"0 memberOf cn=users"}},
+		{"replace stripped whole", [][]byte{testModifyChange(add, "cn", "alice"), testModifyChange(replace, "memberOf", "cn=users", "cn=admins")}, true, LDAPResultSuccess, []string{"0 cn alice"}},
+		{"nothing left", [][]byte{testModifyChange(replace, "userPassword", "secret")}, true, LDAPResultInsufficientAccessRights, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var passed []string
+			a := &ModifyAccess{Allow: allow, Strip: tt.strip}
+			handler := a.Middleware()(func(w ResponseWriter, m *Message) {
+				passed = modifyChanges(m)
+				w.Write(NewResponseForRequest(m.ProtocolOp(), LDAPResultSuccess, ""))
+			})
+			w := NewResponseRecorder()
+			handler(w, testMessage(t, testModifyRequest("uid=alice,dc=example,dc=com", tt.changes...)))
+			if code := w.ResultCode(); code != tt.code {
+				t.Errorf("result code %d, want %d", code, tt.code)
+			}
+			if !reflect.DeepEqual(passed, tt.passed) {
+				t.Errorf("changes passed %q, want %q", passed, tt.passed)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package ldapserver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// WriteBatcher is a Handler grouping the write requests (add, delete,
+// modify, modify DN) of each connection into batches applied at once, in
+// a single backend transaction for instance, to speed up provisioning
+// bursts. A batch is applied once it holds MaxSize requests, or MaxDelay
+// after its first request. The other requests are passed to Next.
+type WriteBatcher struct {
+	// Apply applies the write requests, in the order they were read from
+	// the connection, and returns the error of each, nil for a success.
+	// Errors are answered as with DefaultErrorMapper, in the same order.
+	// Batches of a connection are applied one at a time.
+	Apply func(requests []*Message) []error
+
+	// MaxDelay is the time the first request of a batch waits for others,
+	// 10ms if zero
+	MaxDelay time.Duration
+
+	// MaxSize is the number of requests applying a batch immediately, 100
+	// if zero
+	MaxSize int
+
+	// Next serves the requests which are not writes, they are answered
+	// with unwillingToPerform if nil
+	Next Handler
+
+	mu      sync.Mutex
+	batches map[*client]*writeBatch
+}
+
+// writeBatch holds the write requests of a connection waiting to be applied
+type writeBatch struct {
+	pending []*batchedWrite
+	timer   *time.Timer
+	apply   sync.Mutex // serializes the batches of the connection
+}
+
+type batchedWrite struct {
+	w    ResponseWriter
+	m    *Message
+	done chan struct{} // closed once answered
+}
+
+// ServeLDAP queues the write request m in the batch of its connection, and
+// answers it once the batch is applied
+func (b *WriteBatcher) ServeLDAP(w ResponseWriter, m *Message) {
+	po := m.ProtocolOp()
+	if !isWriteRequest(po) {
+		if b.Next != nil {
+			b.Next.ServeLDAP(w, m)
+		} else if res := NewResponseForRequest(po, LDAPResultUnwillingToPerform, "operation not implemented by server"); res != nil {
+			w.Write(res)
+		}
+		return
+	}
+
+	op := &batchedWrite{w: w, m: m, done: make(chan struct{})}
+	b.enqueue(m.Client, op)
+	select {
+	case <-op.done:
+	case <-m.Context().Done():
+		// a terminated request still pending leaves its batch, one being
+		// applied is answered with it
+		if !b.dequeue(m.Client, op) {
+			<-op.done
+		}
+	}
+}
+
+// enqueue adds op to the batch of c, and applies it when full
+func (b *WriteBatcher) enqueue(c *client, op *batchedWrite) {
+	maxSize, maxDelay := b.MaxSize, b.MaxDelay
+	if maxSize <= 0 {
+		maxSize = 100
+	}
+	if maxDelay <= 0 {
+		maxDelay = 10 * time.Millisecond
+	}
+
+	b.mu.Lock()
+	batch, ok := b.batches[c]
+	if !ok {
+		if b.batches == nil {
+			b.batches = make(map[*client]*writeBatch)
+		}
+		batch = &writeBatch{}
+		b.batches[c] = batch
+		if c != nil && c.closing != nil {
+			go func() {
+				<-c.closing
+				b.mu.Lock()
+				delete(b.batches, c)
+				b.mu.Unlock()
+			}()
+		}
+	}
+	// the handlers run concurrently, the requests are queued in the order
+	// they were read
+	i := len(batch.pending)
+	for i > 0 && batch.pending[i-1].m.sequence > op.m.sequence {
+		i--
+	}
+	batch.pending = append(batch.pending, nil)
+	copy(batch.pending[i+1:], batch.pending[i:])
+	batch.pending[i] = op
+	full := len(batch.pending) >= maxSize
+	if len(batch.pending) == 1 && !full {
+		batch.timer = time.AfterFunc(maxDelay, func() { b.flush(batch) })
+	}
+	b.mu.Unlock()
+
+	if full {
+		b.flush(batch)
+	}
+}
+
+// dequeue removes op from the batch of c, it returns false when op is not
+// pending anymore
+func (b *WriteBatcher) dequeue(c *client, op *batchedWrite) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	batch, ok := b.batches[c]
+	if !ok {
+		return false
+	}
+	for i, pending := range batch.pending {
+		if pending != op {
+			continue
+		}
+		batch.pending = append(batch.pending[:i], batch.pending[i+1:]...)
+		if len(batch.pending) == 0 && batch.timer != nil {
+			batch.timer.Stop()
+			batch.timer = nil
+		}
+		return true
+	}
+	return false
+}
+
+// flush applies the requests pending in batch, and answers them in order
+func (b *WriteBatcher) flush(batch *writeBatch) {
+	// the apply lock is taken first, so batches are applied in order
+	batch.apply.Lock()
+	defer batch.apply.Unlock()
+
+	b.mu.Lock()
+	ops := batch.pending
+	batch.pending = nil
+	if batch.timer != nil {
+		batch.timer.Stop()
+		batch.timer = nil
+	}
+	b.mu.Unlock()
+	if len(ops) == 0 {
+		return
+	}
+
+	// a panic of Apply, which may run on the timer goroutine, answers the
+	// requests left with operationsError
+	answered := 0
+	defer func() {
+		if r := recover(); r != nil {
+			ops[0].m.logAt(LogLevelError, "write batch of %d requests panicked: %v", len(ops), r)
+		}
+		for _, op := range ops[answered:] {
+			op.w.Write(NewResponseForRequest(op.m.ProtocolOp(), LDAPResultOperationsError, "batch not applied"))
+			close(op.done)
+		}
+	}()
+
+	requests := make([]*Message, len(ops))
+	for i, op := range ops {
+		requests[i] = op.m
+	}
+	errs := b.Apply(requests)
+	for i, op := range ops {
+		err := fmt.Errorf("no result for %s in batch of %d", op.m.ProtocolOpName(), len(ops))
+		if i < len(errs) {
+			err = errs[i]
+		}
+		resultCode, diagnosticMessage := LDAPResultSuccess, ""
+		if err != nil {
+			resultCode, diagnosticMessage = DefaultErrorMapper(err)
+		}
+		op.w.Write(NewResponseForRequest(op.m.ProtocolOp(), resultCode, diagnosticMessage))
+		close(op.done)
+		answered++
+	}
+}
@@ -0,0 +1,114 @@
+package ldapserver
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteBatcherOrder(t *testing.T) {
+	codes := map[string]int{
+		"cn=1": LDAPResultSuccess,
+		"cn=2": LDAPResultNoSuchObject,
+		"cn=3": LDAPResultUnwillingToPerform,
+	}
+	var applied []string
+	b := &WriteBatcher{
+		MaxSize: len(codes),
+		Apply: func(requests []*Message) []error {
+			errs := make([]error, len(requests))
+			for i, m := range requests {
+				dn := string(m.GetDeleteRequest())
+				applied = append(applied, dn)
+				if codes[dn] != LDAPResultSuccess {
+					errs[i] = NewResultError(codes[dn], dn)
+				}
+			}
+			return errs
+		},
+	}
+
+	// the handlers are run out of the order the requests were read
+	w := NewResponseRecorder()
+	var wg sync.WaitGroup
+	for _, sequence := range []int{3, 1, 2} {
+		m := testMessage(t, berOctetString(berClassApplication|ApplicationDelRequest, []byte("cn="+strconv.Itoa(sequence))))
+		m.sequence = sequence
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.ServeLDAP(w, m)
+		}()
+	}
+	wg.Wait()
+
+	if got := strings.Join(applied, " "); got != "cn=1 cn=2 cn=3" {
+		t.Errorf("applied %s, want cn=1 cn=2 cn=3", got)
+	}
+	messages := w.Messages()
+	if len(messages) != len(codes) {
+		t.Fatalf("%d responses, want %d", len(messages), len(codes))
+	}
+	for i, m := range messages {
+		code, err := resultCodeOf(m)
+		if want := codes["cn="+strconv.Itoa(i+1)]; err != nil || code != want {
+			t.Errorf("response %d: result code %d (%v), want %d", i, code, err, want)
+		}
+	}
+}
+
+func TestWriteBatcherApplyPanic(t *testing.T) {
+	b := &WriteBatcher{
+		MaxSize: 2,
+		Apply:   func(requests []*Message) []error { panic("backend failure") },
+	}
+	w := NewResponseRecorder()
+	var wg sync.WaitGroup
+	for sequence := 1; sequence <= 2; sequence++ {
+		m := testMessage(t, berOctetString(berClassApplication|ApplicationDelRequest, []byte("cn="+strconv.Itoa(sequence))))
+		m.sequence = sequence
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.ServeLDAP(w, m)
+		}()
+	}
+	wg.Wait()
+
+	messages := w.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("%d responses, want 2", len(messages))
+	}
+	for i, m := range messages {
+		if code, err := resultCodeOf(m); err != nil || code != LDAPResultOperationsError {
+			t.Errorf("response %d: result code %d (%v), want operationsError", i, code, err)
+		}
+	}
+}
+
+func TestWriteBatcherTerminated(t *testing.T) {
+	applied := false
+	b := &WriteBatcher{
+		MaxDelay: time.Hour,
+		Apply: func(requests []*Message) []error {
+			applied = true
+			return make([]error, len(requests))
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m := testMessage(t, berOctetString(berClassApplication|ApplicationDelRequest, []byte("cn=1")))
+	m.ctx = ctx
+	w := NewResponseRecorder()
+	b.ServeLDAP(w, m)
+
+	if len(w.Messages()) != 0 || applied {
+		t.Error("terminated request applied")
+	}
+	if pending := len(b.batches[nil].pending); pending != 0 {
+		t.Errorf("%d requests left in the batch", pending)
+	}
+}